@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/lupppig/dbackup/internal/backup"
+	compresspkg "github.com/lupppig/dbackup/internal/compress"
+	"github.com/lupppig/dbackup/internal/logger"
+	"github.com/spf13/cobra"
+)
+
+var (
+	walAlgo          string
+	walEncryptChunks bool
+)
+
+var archiveWALCmd = &cobra.Command{
+	Use:   "archive-wal postgres <segment>",
+	Short: "Upload one Postgres WAL segment, for use as archive_command",
+	Long: `Upload a single WAL segment to wal/<db>/ on the target, compressed, encrypted
+and deduplicated like a backup. Together with a physical base backup this
+keeps enough WAL for point-in-time recovery. --db names the cluster, so
+clusters sharing a target keep their segments apart.
+
+Set it as the server's archive_command, e.g.
+  archive_command = 'dbackup archive-wal postgres %p --db main --to s3://bucket/pg'
+
+Postgres retries a segment until the command succeeds, so a segment that is
+already on the target with the same content is skipped rather than uploaded
+again. One archived with different content fails the command.`,
+	Args:          cobra.ExactArgs(2),
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		l := logger.FromContext(cmd.Context())
+
+		switch strings.ToLower(args[0]) {
+		case "postgres", "postgresql":
+		default:
+			return fmt.Errorf("archive-wal only supports postgres, got %q", args[0])
+		}
+		if target == "" {
+			return fmt.Errorf("--to is required")
+		}
+		if err := compresspkg.ValidateAlgorithm(walAlgo); err != nil {
+			return err
+		}
+
+		kdf, iterations := kdfOptions()
+		mgr, err := backup.NewBackupManager(backup.BackupOptions{
+			DBType:               "postgres",
+			DBName:               dbName,
+			StorageURI:           target,
+			Compress:             walAlgo != string(compresspkg.None),
+			Algorithm:            walAlgo,
+			AllowInsecure:        AllowInsecure,
+			TmpDir:               tmpDir,
+			Dedupe:               dedupe,
+			Audit:                Audit,
+			Encrypt:              encrypt,
+			EncryptChunks:        walEncryptChunks,
+			EncryptionKeyFile:    encryptionKeyFile,
+			EncryptionPassphrase: encryptionPassphrase,
+			KDF:                  kdf,
+			KDFIterations:        iterations,
+			Logger:               l,
+		})
+		if err != nil {
+			return err
+		}
+		defer mgr.GetStorage().Close()
+
+		_, err = mgr.ArchiveWAL(cmd.Context(), args[1])
+		return err
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(archiveWALCmd)
+	archiveWALCmd.Flags().StringVar(&walAlgo, "compression-algo", "lz4", "compression algorithm of the segment (gzip, zstd, lz4, none)")
+	archiveWALCmd.Flags().BoolVar(&walEncryptChunks, "encrypt-chunks", false, "encrypt each dedupe chunk (convergent encryption) instead of the whole segment")
+}
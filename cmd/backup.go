@@ -1,22 +1,57 @@
 package cmd
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"os"
 	"strings"
 	"time"
 
 	"sync"
 
 	"github.com/lupppig/dbackup/internal/backup"
-	"github.com/lupppig/dbackup/internal/config"
+	compresspkg "github.com/lupppig/dbackup/internal/compress"
 	database "github.com/lupppig/dbackup/internal/db"
+	apperrors "github.com/lupppig/dbackup/internal/errors"
 	"github.com/lupppig/dbackup/internal/logger"
+	"github.com/lupppig/dbackup/internal/manifest"
 	"github.com/lupppig/dbackup/internal/notify"
 	storagepkg "github.com/lupppig/dbackup/internal/storage"
 	"github.com/spf13/cobra"
 )
 
 var mysqlPhysical bool
+var (
+	checksumAlgo    string
+	manifestFormat  string
+	encryptChunks   bool
+	labelLatest     bool
+	zstdDict        bool
+	allDatabases    bool
+	includeSystem   bool
+	allowPruneAll   bool
+	retentionDryRun bool
+	maxDuration     time.Duration
+	backupNote      string
+	ifChanged       bool
+	dumpExtraArgs   []string
+	backupExcludes  []string
+	dedupeIndex     bool
+	dedupeAlgo      string
+	rebuildIndex    bool
+)
+var manifestRetention string
+var backupLock bool
+
+var printManifest bool
+
+var compressionBenchmark bool
+var benchmarkSampleMB int
+
+var nameTemplate string
+var outputDir string
 var keepDaily, keepWeekly, keepMonthly, keepYearly int
 
 var backupCmd = &cobra.Command{
@@ -38,7 +73,7 @@ process fails, dbackup exits with a non-zero status code.`,
 			firstArg := strings.ToLower(args[0])
 			isEngine := false
 			switch firstArg {
-			case "postgres", "postgresql", "mysql", "sqlite":
+			case "postgres", "postgresql", "mysql", "sqlite", "cassandra", "scylla":
 				isEngine = true
 			}
 
@@ -52,39 +87,56 @@ process fails, dbackup exits with a non-zero status code.`,
 			uris = []string{dbURI}
 		}
 
-		if len(uris) == 0 && dbName == "" {
+		if len(uris) == 0 && dbName == "" && !allDatabases {
 			return fmt.Errorf("database name or URI is required")
 		}
+		if allDatabases && fileName != "" {
+			return fmt.Errorf("--name cannot be combined with --all-databases")
+		}
+		if fileName != "" && nameTemplate != "" {
+			return fmt.Errorf("--name cannot be combined with --name-template")
+		}
+		if outputDir != "" {
+			if cmd.Flags().Changed("to") {
+				return fmt.Errorf("--out cannot be combined with --to")
+			}
+			target = "local://" + outputDir
+		}
+		if err := backup.ValidateNameTemplate(nameTemplate); err != nil {
+			return err
+		}
+		if err := database.ValidateExcludes(backupExcludes); err != nil {
+			return err
+		}
+		if err := compresspkg.ValidateAlgorithm(compressionAlgo); err != nil {
+			return err
+		}
+		if err := storagepkg.ValidateChunker(dedupeAlgo); err != nil {
+			return err
+		}
 
 		if dbType == "" {
 			return fmt.Errorf("database engine is required (e.g. backup sqlite ...)")
 		}
 
-		var notifier notify.Notifier = notify.BuildNotifier(config.GetConfig())
-		if SlackWebhook != "" {
-			sn := notify.NewSlackNotifier(SlackWebhook, "")
-			if notifier != nil {
-				if mn, ok := notifier.(*notify.MultiNotifier); ok {
-					mn.Notifiers = append(mn.Notifiers, sn)
-				} else {
-					notifier = &notify.MultiNotifier{Notifiers: []notify.Notifier{notifier, sn}}
-				}
-			} else {
-				notifier = sn
-			}
+		pass, err := dbPassword(cmd)
+		if err != nil {
+			return err
 		}
 
+		notifier := buildNotifier()
+
 		if target == "" {
 			target = "."
 		}
 
-		if len(uris) == 0 {
+		if len(uris) == 0 && !allDatabases {
 			connParams := database.ConnectionParams{
 				DBType:   dbType,
 				Host:     host,
 				Port:     port,
 				User:     user,
-				Password: password,
+				Password: pass,
 				DBName:   dbName,
 				TLS: database.TLSConfig{
 					Enabled:    tlsEnabled,
@@ -97,42 +149,61 @@ process fails, dbackup exits with a non-zero status code.`,
 			}
 			return doBackup(cmd, l, connParams, notifier)
 		}
+
+		if len(uris) == 0 {
+			uris = []string{""} // --all-databases from the connection flags
+		}
+		var conns []database.ConnectionParams
+		for _, u := range uris {
+			conns = append(conns, database.ConnectionParams{
+				DBType:   dbType,
+				Host:     host,
+				Port:     port,
+				User:     user,
+				Password: pass,
+				DBName:   dbName,
+				DBUri:    u,
+				TLS: database.TLSConfig{
+					Enabled:    tlsEnabled,
+					Mode:       tlsMode,
+					CACert:     tlsCACert,
+					ClientCert: tlsClientCert,
+					ClientKey:  tlsClientKey,
+				},
+				IsPhysical: mysqlPhysical,
+			})
+		}
+		if allDatabases {
+			var err error
+			if conns, err = expandDatabases(cmd.Context(), l, conns, includeSystem); err != nil {
+				return err
+			}
+		}
+
 		var wg sync.WaitGroup
 		sem := make(chan struct{}, Parallelism)
-		errChan := make(chan string, len(uris))
+		errChan := make(chan string, len(conns))
 
-		for _, uri := range uris {
+		for _, c := range conns {
 			wg.Add(1)
-			go func(u string) {
+			go func(connParams database.ConnectionParams) {
 				defer wg.Done()
 				sem <- struct{}{}
 				defer func() { <-sem }()
 
 				// Create a sub-logger for this database to avoid mixed logs
-				subL := l.With("uri", storagepkg.Scrub(u))
-
-				connParams := database.ConnectionParams{
-					DBType:   dbType,
-					Host:     host,
-					Port:     port,
-					User:     user,
-					Password: password,
-					DBName:   dbName,
-					DBUri:    u,
-					TLS: database.TLSConfig{
-						Enabled:    tlsEnabled,
-						Mode:       tlsMode,
-						CACert:     tlsCACert,
-						ClientCert: tlsClientCert,
-						ClientKey:  tlsClientKey,
-					},
-					IsPhysical: mysqlPhysical,
+				label := connParams.DBName
+				subL := l.With("database", label)
+				if connParams.DBUri != "" {
+					label = storagepkg.Scrub(connParams.DBUri)
+					subL = l.With("uri", label)
 				}
+
 				if err := doBackup(cmd, subL, connParams, notifier); err != nil {
 					subL.Error("Backup failed", "error", err)
-					errChan <- fmt.Sprintf("%s: %v", u, err)
+					errChan <- fmt.Sprintf("%s: %v", label, err)
 				}
-			}(uri)
+			}(c)
 		}
 
 		wg.Wait()
@@ -155,11 +226,24 @@ func doBackup(cmd *cobra.Command, l *logger.Logger, connParams database.Connecti
 	if err := connParams.ParseURI(); err != nil {
 		return fmt.Errorf("failed to parse URI: %w", err)
 	}
+	connParams.ExtraArgs = dumpExtraArgs
+	connParams.Exclude = backupExcludes
 
 	if connParams.DBType == "" {
 		return fmt.Errorf("database type could not be determined for %s", connParams.DBUri)
 	}
 
+	var indexPath string
+	if dedupeIndex || rebuildIndex {
+		p, err := chunkIndexPath()
+		if err != nil {
+			return err
+		}
+		indexPath = p
+	}
+
+	startPing, startAfter := notifyStartOptions()
+	kdf, iterations := kdfOptions()
 	mgr, err := backup.NewBackupManager(backup.BackupOptions{
 		DBType:               connParams.DBType,
 		DBName:               connParams.DBName,
@@ -167,22 +251,44 @@ func doBackup(cmd *cobra.Command, l *logger.Logger, connParams database.Connecti
 		Compress:             compress,
 		Algorithm:            compressionAlgo,
 		FileName:             fileName,
+		NameTemplate:         nameTemplate,
 		RemoteExec:           remoteExec,
 		AllowInsecure:        AllowInsecure,
+		TmpDir:               tmpDir,
+		ProgressRefresh:      progressRefresh,
 		Encrypt:              encrypt,
+		EncryptChunks:        encryptChunks,
 		EncryptionKeyFile:    encryptionKeyFile,
 		EncryptionPassphrase: encryptionPassphrase,
+		KDF:                  kdf,
+		KDFIterations:        iterations,
 		Retention:            parseRetention(retention),
 		Keep:                 keep,
+		AllowPruneAll:        allowPruneAll,
+		RetentionDryRun:      retentionDryRun,
+		ManifestRetention:    parseRetention(manifestRetention),
+		MaxDuration:          maxDuration,
 		RetentionPolicy: backup.RetentionPolicy{
 			KeepDaily:   keepDaily,
 			KeepWeekly:  keepWeekly,
 			KeepMonthly: keepMonthly,
 			KeepYearly:  keepYearly,
 		},
-		Audit:    Audit,
-		Logger:   l,
-		Notifier: notifier,
+		Audit:          Audit,
+		ChecksumAlgo:   checksumAlgo,
+		ManifestFormat: manifestFormat,
+		NoLatest:       !labelLatest,
+		ZstdDict:       zstdDict,
+		Note:           backupNote,
+		IfChanged:      ifChanged,
+		Lock:           backupLock,
+		DedupeIndex:    indexPath,
+		DedupeAlgo:     dedupeAlgo,
+		Logger:         l,
+		Notifier:       notifier,
+
+		NotifyOnStart:    startPing,
+		NotifyStartAfter: startAfter,
 	})
 	if err != nil {
 		return err
@@ -192,24 +298,38 @@ func doBackup(cmd *cobra.Command, l *logger.Logger, connParams database.Connecti
 		dedupe = true // Default to true
 	}
 
-	if dedupe {
-		mgr.SetStorage(storagepkg.NewDedupeStorage(mgr.GetStorage()))
-		l.Info("Deduplication (CAS) active")
+	if encryptChunks && !dedupe {
+		return fmt.Errorf("--encrypt-chunks requires --dedupe")
+	}
+	if indexPath != "" && !dedupe {
+		return fmt.Errorf("--dedupe-index requires --dedupe")
 	}
 
-	var adapter database.DBAdapter
-	switch strings.ToLower(connParams.DBType) {
-	case "postgres", "postgresql":
-		adapter = &database.PostgresAdapter{}
-	case "mysql":
-		adapter = &database.MysqlAdapter{}
-	case "sqlite":
-		adapter = &database.SqliteAdapter{}
-	default:
-		return fmt.Errorf("unsupported database type: %s", connParams.DBType)
+	if dedupe {
+		s, err := backup.WrapDedupe(mgr.GetStorage(), mgr.Options)
+		if err != nil {
+			return err
+		}
+		mgr.SetStorage(s)
+		l.Info("Deduplication (CAS) active", "encrypt_chunks", encryptChunks, "index", indexPath != "")
+
+		if rebuildIndex {
+			n, err := s.(*storagepkg.DedupeStorage).RebuildIndex(cmd.Context())
+			if err != nil {
+				return fmt.Errorf("failed to rebuild the dedupe index: %w", err)
+			}
+			l.Info("Dedupe index rebuilt", "chunks", n)
+		}
 	}
 
+	adapter, err := newAdapter(connParams.DBType)
+	if err != nil {
+		return err
+	}
 	adapter.SetLogger(l)
+	if err := checkCapabilities(adapter, connParams, remoteExec); err != nil {
+		return err
+	}
 
 	var runner database.Runner = &database.LocalRunner{}
 	if remoteExec {
@@ -218,9 +338,12 @@ func doBackup(cmd *cobra.Command, l *logger.Logger, connParams database.Connecti
 		}
 	}
 
-	if err := adapter.TestConnection(cmd.Context(), connParams, runner); err != nil {
+	if err := testConnection(cmd.Context(), l, adapter, connParams, runner); err != nil {
 		return err
 	}
+	if compressionBenchmark {
+		return runCompressionBenchmark(cmd, adapter, connParams, runner)
+	}
 
 	l.Info("Backup started", "engine", connParams.DBType, "database", connParams.DBName, "target", storagepkg.Scrub(target), "dedupe", dedupe)
 	start := time.Now()
@@ -234,18 +357,172 @@ func doBackup(cmd *cobra.Command, l *logger.Logger, connParams database.Connecti
 		"duration", time.Since(start).String(),
 	)
 
+	if printManifest {
+		return writeManifest(cmd, mgr)
+	}
+	return nil
+}
+
+// runCompressionBenchmark dumps a sample of the database and prints how each
+// compression algorithm does on it, instead of taking a backup.
+func runCompressionBenchmark(cmd *cobra.Command, adapter database.DBAdapter, connParams database.ConnectionParams, runner database.Runner) error {
+	results, n, err := backup.BenchmarkCompression(cmd.Context(), adapter, connParams, runner, benchmarkSampleMB*1024*1024)
+	if err != nil {
+		return err
+	}
+
+	w := cmd.OutOrStdout()
+	fmt.Fprintf(w, "Compression of a %s sample of %s (the start of the dump, not the full backup):\n", formatBytes(int64(n)), connParams.DBName)
+	fmt.Fprintf(w, "%-10s %12s %8s %12s\n", "ALGORITHM", "SIZE", "RATIO", "SPEED")
+	for _, r := range results {
+		fmt.Fprintf(w, "%-10s %12s %7.2fx %7.1f MB/s\n", r.Algorithm, formatBytes(r.Size), r.Ratio, r.Throughput)
+	}
+	return nil
+}
+
+// writeManifest prints the manifest the backup just wrote to stdout as
+// indented JSON, whatever its stored encoding, for CI to inspect.
+func writeManifest(cmd *cobra.Command, mgr *backup.BackupManager) error {
+	name := mgr.ManifestName()
+	if name == "" {
+		return nil // Skipped by --if-changed, or the manifest failed to save (logged)
+	}
+	data, err := mgr.GetStorage().GetMetadata(cmd.Context(), name)
+	if err != nil {
+		return apperrors.Wrap(err, apperrors.TypeResource, "failed to read back manifest "+name, "The backup is stored; inspect the manifest on the target.")
+	}
+	man, err := manifest.Deserialize(data)
+	if err != nil {
+		return apperrors.Wrap(err, apperrors.TypeIntegrity, "invalid manifest "+name, "The backup is stored; inspect the manifest on the target.")
+	}
+	out, err := json.MarshalIndent(man, "", "  ")
+	if err != nil {
+		return err
+	}
+	// One write, so manifests of parallel backups don't interleave
+	_, err = cmd.OutOrStdout().Write(append(out, '\n'))
+	return err
+}
+
+func newAdapter(engine string) (database.DBAdapter, error) {
+	switch strings.ToLower(engine) {
+	case "postgres", "postgresql":
+		return &database.PostgresAdapter{}, nil
+	case "mysql":
+		return &database.MysqlAdapter{}, nil
+	case "sqlite":
+		return &database.SqliteAdapter{}, nil
+	case "cassandra", "scylla":
+		return &database.CassandraAdapter{}, nil
+	}
+	return nil, fmt.Errorf("unsupported database type: %s", engine)
+}
+
+// checkCapabilities rejects a backup the engine can't take before any tool
+// runs: excludes for an engine whose backups aren't file archives, a
+// physical backup the engine has no mode for, or a data directory copy of a
+// database on another host.
+func checkCapabilities(adapter database.DBAdapter, conn database.ConnectionParams, remote bool) error {
+	caps := adapter.Capabilities()
+	if len(conn.Exclude) > 0 && !caps.ExcludeFiles {
+		return apperrors.New(apperrors.TypeConfig, adapter.Name()+" backups are not file archives, so --exclude has nothing to drop", "Drop --exclude; see 'dbackup engines'.")
+	}
+	if !conn.IsPhysical {
+		return nil
+	}
+	if !caps.SupportsPhysical {
+		return apperrors.New(apperrors.TypeConfig, adapter.Name()+" has no physical backup mode", "Drop --mysql-physical; see 'dbackup engines'.")
+	}
+	if caps.RequiresLocalDatadir && !remote && !isLocalHost(conn.Host) {
+		return apperrors.New(apperrors.TypeConfig,
+			fmt.Sprintf("physical %s backups copy the data directory and can't reach it on %s", adapter.Name(), conn.Host),
+			"Run dbackup on the database host, or use --remote-exec with an sftp target on that host.")
+	}
 	return nil
 }
 
+// isLocalHost reports whether host is this machine.
+func isLocalHost(host string) bool {
+	switch host {
+	case "", "localhost", "127.0.0.1", "::1":
+		return true
+	}
+	name, err := os.Hostname()
+	return err == nil && strings.EqualFold(host, name)
+}
+
+// expandDatabases replaces each server connection with one connection per
+// database found on it. System databases are dropped unless includeSystem.
+func expandDatabases(ctx context.Context, l *logger.Logger, conns []database.ConnectionParams, includeSystem bool) ([]database.ConnectionParams, error) {
+	var out []database.ConnectionParams
+	for _, c := range conns {
+		if err := c.ParseURI(); err != nil {
+			return nil, fmt.Errorf("failed to parse URI: %w", err)
+		}
+		adapter, err := newAdapter(c.DBType)
+		if err != nil {
+			return nil, err
+		}
+		adapter.SetLogger(l)
+
+		names, err := adapter.ListDatabases(ctx, c)
+		if err != nil {
+			return nil, err
+		}
+		if names == nil {
+			return nil, apperrors.New(apperrors.TypeConfig, "--all-databases is not supported for "+c.DBType, "List the databases to back up as separate URIs instead.")
+		}
+
+		var picked []string
+		for _, name := range names {
+			if !includeSystem && database.IsSystemDatabase(c.DBType, name) {
+				continue
+			}
+			picked = append(picked, name)
+			out = append(out, c.WithDatabase(name))
+		}
+		l.Info("Expanded --all-databases", "engine", c.DBType, "host", c.Host, "databases", strings.Join(picked, ","))
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("no databases to back up")
+	}
+	return out, nil
+}
+
 func init() {
 	rootCmd.AddCommand(backupCmd)
 
 	backupCmd.Flags().BoolVar(&compress, "compress", true, "compress backup output (default true)")
 	backupCmd.Flags().StringVar(&compressionAlgo, "compression-algo", "lz4", "compression algorithm (gzip, zstd, lz4, none, defaults to lz4). All are wrapped in a tar archive unless 'none' is specified.")
 	backupCmd.Flags().StringVar(&fileName, "name", "", "custom backup file name")
+	backupCmd.Flags().StringVar(&outputDir, "out", "", "local directory to write the backup to (shorthand for --to local://<dir>)")
+	backupCmd.Flags().StringVar(&outputDir, "output-dir", "", "same as --out")
+	backupCmd.Flags().StringVar(&nameTemplate, "name-template", "", "backup name pattern with {engine}, {db}, {host}, {date[:layout]} and {time[:layout]}, e.g. {db}/{date:2006/01/02}/{engine}-{time}.sql")
+	backupCmd.Flags().StringVar(&backupNote, "note", "", "free-form comment stored in the manifest (e.g. \"before v2 migration\")")
+	backupCmd.Flags().BoolVar(&ifChanged, "if-changed", false, "skip the backup when the database hasn't changed since the latest one (postgres, mysql with binlog, sqlite)")
+	backupCmd.Flags().StringArrayVar(&dumpExtraArgs, "dump-extra-args", nil, "extra argument for the engine's dump tool (pg_dump, pg_basebackup, mysqldump, xtrabackup), repeatable; passed through unchecked")
+	backupCmd.Flags().StringArrayVar(&backupExcludes, "exclude", nil, "glob of files to leave out of file-archive backups (physical postgres, cassandra), repeatable; without a slash it matches any path element")
+	backupCmd.Flags().BoolVar(&printManifest, "print-manifest", false, "print the manifest of the new backup as JSON once it is written")
+	backupCmd.Flags().BoolVar(&compressionBenchmark, "compression-benchmark", false, "compress a sample of the dump with each algorithm and print ratio and speed, without taking a backup")
+	backupCmd.Flags().IntVar(&benchmarkSampleMB, "benchmark-sample-mb", 64, "MB from the start of the dump that --compression-benchmark compresses")
+	backupCmd.Flags().BoolVar(&backupLock, "lock", false, "refuse to start while another backup of the same database to the same target is running")
+	backupCmd.Flags().BoolVar(&dedupeIndex, "dedupe-index", false, "keep a local index of the target's chunks to skip most remote existence checks")
+	backupCmd.Flags().StringVar(&dedupeAlgo, "dedupe-algo", storagepkg.ChunkerGear, "chunking algorithm for deduplication: gear, or fastcdc for more even chunk sizes (chunks only match others cut the same way)")
+	backupCmd.Flags().BoolVar(&rebuildIndex, "dedupe-index-rebuild", false, "refill the dedupe index from a listing of the target's chunks before backing up (implies --dedupe-index)")
+	backupCmd.Flags().BoolVar(&encryptChunks, "encrypt-chunks", false, "encrypt each dedupe chunk (convergent encryption) instead of the whole stream")
+	backupCmd.Flags().BoolVar(&labelLatest, "label-latest", true, "point latest.manifest and the per-database latest pointer at this backup")
+	backupCmd.Flags().BoolVar(&zstdDict, "zstd-dict", false, "with zstd, compress using a dictionary trained on this target's first dump (dict.zstd)")
+	backupCmd.Flags().StringVar(&checksumAlgo, "checksum-algo", "sha256", "manifest checksum algorithm (sha256, sha512, blake3)")
+	backupCmd.Flags().StringVar(&manifestFormat, "manifest-format", "json", "manifest encoding (json, or binary for faster parsing of large dedupe manifests)")
 	backupCmd.Flags().StringVar(&retention, "retention", "", "retention period (e.g. 7d, 24h)")
 	backupCmd.Flags().IntVar(&keep, "keep", 0, "number of backups to keep")
+	backupCmd.Flags().BoolVar(&allowPruneAll, "allow-prune-all", false, "let retention delete every backup of a database (by default the newest is always kept)")
+	backupCmd.Flags().DurationVar(&maxDuration, "max-duration", 0, "abort the backup and send a failure notification if it runs longer than this (e.g. 2h); 0 means no limit")
+	backupCmd.Flags().StringVar(&manifestRetention, "manifest-retention", "", "keep the manifests of pruned backups as tombstones for this long (e.g. 90d), so 'backups' still lists them")
+	backupCmd.Flags().BoolVar(&retentionDryRun, "retention-dry-run", false, "report which backups retention would prune without deleting them")
 	backupCmd.Flags().BoolVar(&mysqlPhysical, "mysql-physical", false, "use physical backup mode for MySQL (default false/logical)")
+	backupCmd.Flags().BoolVar(&allDatabases, "all-databases", false, "back up every database on the server, one backup each (postgres, mysql)")
+	backupCmd.Flags().BoolVar(&includeSystem, "include-system", false, "with --all-databases, also back up system databases (template1, mysql, ...)")
 	backupCmd.Flags().IntVar(&keepDaily, "keep-daily", 0, "number of daily backups to keep")
 	backupCmd.Flags().IntVar(&keepWeekly, "keep-weekly", 0, "number of weekly backups to keep")
 	backupCmd.Flags().IntVar(&keepMonthly, "keep-monthly", 0, "number of monthly backups to keep")
@@ -265,3 +542,28 @@ func parseRetention(s string) time.Duration {
 	}
 	return dur
 }
+
+// testConnection runs adapter.TestConnection, retrying with exponential
+// backoff up to --connect-retries times so a database that is still starting
+// up doesn't fail the run. Missing tools and bad configuration fail at once.
+func testConnection(ctx context.Context, l *logger.Logger, adapter database.DBAdapter, conn database.ConnectionParams, runner database.Runner) error {
+	delay := connectRetryDelay
+	for attempt := 0; ; attempt++ {
+		err := adapter.TestConnection(ctx, conn, runner)
+		if err == nil || attempt >= connectRetries {
+			return err
+		}
+		var appErr *apperrors.AppError
+		if errors.As(err, &appErr) && (appErr.Type == apperrors.TypeDependency || appErr.Type == apperrors.TypeConfig) {
+			return err
+		}
+
+		l.Warn("Database connection failed, retrying", "attempt", attempt+1, "of", connectRetries, "delay", delay, "error", err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, maxConnectRetryDelay)
+	}
+}
@@ -2,10 +2,24 @@ package cmd
 
 import (
 	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
 	"testing"
+	"time"
 
+	"github.com/lupppig/dbackup/internal/config"
+	database "github.com/lupppig/dbackup/internal/db"
+	apperrors "github.com/lupppig/dbackup/internal/errors"
+	"github.com/lupppig/dbackup/internal/logger"
+	"github.com/lupppig/dbackup/internal/manifest"
 	"github.com/spf13/cobra"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func executeCommand(root *cobra.Command, args ...string) (output string, err error) {
@@ -62,3 +76,216 @@ func TestBackupCommand_Flags(t *testing.T) {
 		})
 	}
 }
+
+func TestCompressionAlgo_ValidatedUpfront(t *testing.T) {
+	dir := t.TempDir()
+	defer backupCmd.Flags().Set("compression-algo", "lz4")
+	defer restoreCmd.Flags().Set("compression-algo", "")
+
+	// The host doesn't resolve: reaching it would fail with a connection error
+	for _, args := range [][]string{
+		{"backup", "postgres", "--host", "db.invalid", "--user", "u", "--db", "d", "--to", dir, "--compression-algo", "brotli"},
+		{"restore", "postgres", "--host", "db.invalid", "--user", "u", "--db", "d", "--from", dir, "--no-manifest", "--name", "x.sql", "--compression-algo", "brotli"},
+	} {
+		_, err := executeCommand(rootCmd, args...)
+		var appErr *apperrors.AppError
+		if assert.ErrorAs(t, err, &appErr, args[0]) {
+			assert.Equal(t, apperrors.TypeConfig, appErr.Type)
+			assert.Equal(t, "Use one of: gzip, lz4, zstd, none, tar.", appErr.Hint)
+		}
+	}
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Empty(t, entries, "nothing may be written before validation")
+}
+
+type flakyAdapter struct {
+	database.DBAdapter
+	failures int
+	calls    int
+	err      error
+}
+
+func (f *flakyAdapter) TestConnection(ctx context.Context, conn database.ConnectionParams, runner database.Runner) error {
+	f.calls++
+	if f.calls <= f.failures {
+		return f.err
+	}
+	return nil
+}
+
+func TestTestConnection_Retries(t *testing.T) {
+	defer func(r int, d time.Duration) { connectRetries, connectRetryDelay = r, d }(connectRetries, connectRetryDelay)
+	connectRetryDelay = time.Millisecond
+	l := logger.New(logger.Config{Quiet: true})
+	ctx := context.Background()
+	warmingUp := errors.New("connection refused")
+
+	// Default: a single attempt, as before
+	connectRetries = 0
+	a := &flakyAdapter{failures: 1, err: warmingUp}
+	assert.Error(t, testConnection(ctx, l, a, database.ConnectionParams{}, nil))
+	assert.Equal(t, 1, a.calls)
+
+	connectRetries = 3
+	a = &flakyAdapter{failures: 2, err: warmingUp}
+	assert.NoError(t, testConnection(ctx, l, a, database.ConnectionParams{}, nil))
+	assert.Equal(t, 3, a.calls)
+
+	a = &flakyAdapter{failures: 10, err: warmingUp}
+	assert.Error(t, testConnection(ctx, l, a, database.ConnectionParams{}, nil))
+	assert.Equal(t, 4, a.calls)
+
+	// A missing tool won't fix itself
+	a = &flakyAdapter{failures: 10, err: apperrors.New(apperrors.TypeDependency, "pg_dump not found", "")}
+	assert.Error(t, testConnection(ctx, l, a, database.ConnectionParams{}, nil))
+	assert.Equal(t, 1, a.calls)
+}
+
+func TestConvertToBackupOptions_Retention(t *testing.T) {
+	opts := convertToBackupOptions(config.TaskConfig{Retention: "7d", Keep: 2, KeepDaily: 3, KeepMonthly: 1, Note: "nightly"}, nil, nil, nil, config.Config{})
+	assert.Equal(t, 7*24*time.Hour, opts.Retention)
+	assert.Equal(t, "nightly", opts.Note)
+	assert.Equal(t, 2, opts.Keep)
+	assert.Equal(t, 3, opts.RetentionPolicy.KeepDaily)
+	assert.Equal(t, 1, opts.RetentionPolicy.KeepMonthly)
+}
+
+func TestExpandDatabases_Unsupported(t *testing.T) {
+	l := logger.New(logger.Config{})
+	_, err := expandDatabases(context.Background(), l, []database.ConnectionParams{{DBType: "sqlite", DBName: "app.db"}}, false)
+
+	var appErr *apperrors.AppError
+	if assert.True(t, errors.As(err, &appErr)) {
+		assert.Equal(t, apperrors.TypeConfig, appErr.Type)
+	}
+}
+
+func TestCheckOnConflict(t *testing.T) {
+	defer func() { confirmRestore = false }()
+
+	assert.NoError(t, checkOnConflict(""))
+	assert.NoError(t, checkOnConflict(database.ConflictFail))
+	assert.Error(t, checkOnConflict("overwrite"))
+
+	confirmRestore = false
+	assert.Error(t, checkOnConflict(database.ConflictRecreate), "recreate drops the database")
+	confirmRestore = true
+	assert.NoError(t, checkOnConflict(database.ConflictRecreate))
+}
+
+func TestCheckCapabilities(t *testing.T) {
+	physical := database.ConnectionParams{Host: "db.internal", IsPhysical: true}
+
+	// Logical backups work anywhere
+	assert.NoError(t, checkCapabilities(&database.SqliteAdapter{}, database.ConnectionParams{}, false))
+
+	// SQLite has no physical mode
+	assert.Error(t, checkCapabilities(&database.SqliteAdapter{}, physical, false))
+
+	// xtrabackup needs the data directory; pg_basebackup works remotely
+	assert.Error(t, checkCapabilities(&database.MysqlAdapter{}, physical, false))
+	assert.NoError(t, checkCapabilities(&database.MysqlAdapter{}, physical, true))
+	assert.NoError(t, checkCapabilities(&database.MysqlAdapter{}, database.ConnectionParams{Host: "localhost", IsPhysical: true}, false))
+	assert.NoError(t, checkCapabilities(&database.PostgresAdapter{}, physical, false))
+
+	// Only file-archive backups have files to exclude
+	exclude := database.ConnectionParams{Host: "localhost", Exclude: []string{"pg_log"}}
+	assert.Error(t, checkCapabilities(&database.MysqlAdapter{}, exclude, false))
+	assert.NoError(t, checkCapabilities(&database.CassandraAdapter{}, exclude, false))
+}
+
+func TestRootPreRun_InjectsLogger(t *testing.T) {
+	cmd := &cobra.Command{}
+	cmd.SetContext(context.Background())
+	assert.NoError(t, rootCmd.PersistentPreRunE(cmd, nil))
+
+	// The fallback builds a new logger on every call; an injected one is shared
+	assert.Same(t, logger.FromContext(cmd.Context()), logger.FromContext(cmd.Context()))
+}
+
+func TestNewContext_CancelledBySignal(t *testing.T) {
+	ctx, stop := NewContext(context.Background())
+	defer stop()
+
+	assert.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGINT))
+	select {
+	case <-ctx.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("context not cancelled by SIGINT")
+	}
+}
+
+func TestBackup_PrintManifest(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "app.db")
+	db, err := sql.Open("sqlite3", dbPath)
+	require.NoError(t, err)
+	_, err = db.Exec("CREATE TABLE t (id INTEGER PRIMARY KEY)")
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	defer backupCmd.Flags().Set("print-manifest", "false")
+	defer backupCmd.Flags().Set("name", "")
+	defer backupCmd.Flags().Set("manifest-format", "json")
+
+	// Binary manifests behind the dedupe wrapper still print as JSON
+	out, err := executeCommand(rootCmd, "backup", "sqlite", "--db", dbPath, "--to", filepath.Join(dir, "backups"), "--dedupe", "--name", "app.sql", "--manifest-format", "binary", "--print-manifest")
+	require.NoError(t, err)
+
+	var man manifest.Manifest
+	require.NoError(t, json.Unmarshal([]byte(out), &man), out)
+	assert.Equal(t, "sqlite", man.Engine)
+	assert.Equal(t, "app.sql.lz4", man.FileName)
+	assert.NotEmpty(t, man.Checksum)
+	assert.NotEmpty(t, man.Chunks)
+	assert.Positive(t, man.Size)
+}
+
+func TestBackup_OutputDir(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	db, err := sql.Open("sqlite3", "app.db")
+	require.NoError(t, err)
+	_, err = db.Exec("CREATE TABLE t (id INTEGER PRIMARY KEY)")
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	// --to is sticky across executeCommand calls; start as if never given
+	rootCmd.PersistentFlags().Lookup("to").Changed = false
+	defer backupCmd.Flags().Set("out", "")
+	defer backupCmd.Flags().Set("name", "")
+
+	_, err = executeCommand(rootCmd, "backup", "sqlite", "--db", "app.db", "--out", "./x", "--dedupe=false", "--name", "app.sql")
+	require.NoError(t, err)
+	assert.FileExists(t, filepath.Join(dir, "x", "app.sql.lz4"))
+	assert.FileExists(t, filepath.Join(dir, "x", "app.sql.lz4.manifest"))
+
+	_, err = executeCommand(rootCmd, "backup", "sqlite", "--db", "app.db", "--out", "./x", "--to", "./y")
+	assert.ErrorContains(t, err, "--out cannot be combined with --to")
+}
+
+func TestBackup_CompressionBenchmark(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "app.db")
+	db, err := sql.Open("sqlite3", dbPath)
+	require.NoError(t, err)
+	_, err = db.Exec("CREATE TABLE t (id INTEGER PRIMARY KEY, name TEXT); INSERT INTO t (name) VALUES ('a'), ('b')")
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	defer backupCmd.Flags().Set("compression-benchmark", "false")
+	defer backupCmd.Flags().Set("benchmark-sample-mb", "64")
+
+	to := filepath.Join(dir, "backups")
+	out, err := executeCommand(rootCmd, "backup", "sqlite", "--db", dbPath, "--to", to, "--dedupe=false", "--compression-benchmark", "--benchmark-sample-mb", "1")
+	require.NoError(t, err)
+	assert.Contains(t, out, "not the full backup")
+	for _, algo := range []string{"lz4", "zstd", "gzip"} {
+		assert.Regexp(t, `(?m)^`+algo+` .*x .* MB/s$`, out)
+	}
+
+	// Nothing is written to the target
+	entries, _ := os.ReadDir(to)
+	assert.Empty(t, entries)
+}
@@ -43,11 +43,11 @@ You can filter by engine and database name.`,
 		}
 
 		count := 0
-		fmt.Printf("\n%-30s %-10s %-15s %-10s %-10s\n", "CREATED AT", "ENGINE", "DATABASE", "SIZE", "FILE")
-		fmt.Println(strings.Repeat("-", 85))
+		fmt.Printf("\n%-30s %-10s %-15s %-10s %-8s %-30s %-30s %s\n", "CREATED AT", "ENGINE", "DATABASE", "SIZE", "STATUS", "FILE", "ORIGIN", "NOTE")
+		fmt.Println(strings.Repeat("-", 159))
 
 		for _, file := range files {
-			if !strings.HasSuffix(file, ".manifest") {
+			if !strings.HasSuffix(file, ".manifest") || manifest.IsLatest(file) || manifest.IsWAL(file) {
 				continue
 			}
 
@@ -71,17 +71,35 @@ You can filter by engine and database name.`,
 				continue
 			}
 
-			sizeStr := fmt.Sprintf("%.2f MB", float64(m.Size)/(1024*1024))
-			if m.Size < 1024*1024 {
-				sizeStr = fmt.Sprintf("%.2f KB", float64(m.Size)/1024)
+			// Manifests from before sizes were recorded show the stored size
+			size := m.Size
+			if size == 0 && !m.Pruned {
+				if info, err := s.Stat(cmd.Context(), strings.TrimSuffix(file, ".manifest")); err == nil && info.Size > 0 {
+					size = info.Size
+				}
 			}
+			sizeStr := formatBytes(size)
 
-			fmt.Printf("%-30s %-10s %-15s %-10s %-10s\n",
+			// Tombstones left by --manifest-retention list the backup as pruned
+			status := "ok"
+			if m.Pruned {
+				status = "pruned"
+			}
+
+			origin := m.Origin
+			if n := len(m.Migrations); n > 0 {
+				origin += fmt.Sprintf(" (migrated %dx)", n)
+			}
+
+			fmt.Printf("%-30s %-10s %-15s %-10s %-8s %-30s %-30s %s\n",
 				m.CreatedAt.Format("2006-01-02 15:04:05"),
 				m.Engine,
 				m.DBName,
 				sizeStr,
+				status,
 				m.FileName,
+				origin,
+				m.Note,
 			)
 			count++
 		}
@@ -1,10 +1,11 @@
 package cmd
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"os/exec"
 	"runtime"
-
 	"time"
 
 	"github.com/lupppig/dbackup/internal/config"
@@ -28,6 +29,7 @@ var doctorCmd = &cobra.Command{
 			{"Global & Core", []string{"docker", "ssh", "scp", "tar"}},
 			{"PostgreSQL", []string{"psql", "pg_dump"}},
 			{"MySQL", []string{"mysql", "mysqldump", "xtrabackup"}},
+			{"Cassandra", []string{"cqlsh", "nodetool", "sstableloader"}},
 		}
 
 		allOk := true
@@ -77,17 +79,12 @@ var doctorCmd = &cobra.Command{
 					fmt.Printf("    [ ] Connection: FAILED (%v)\n", err)
 					continue
 				}
-
-				// Attempt a tiny metadata write/read for permission check
-				err = s.PutMetadata(cmd.Context(), ".doctor_check", []byte("ok"))
-				latency := time.Since(start)
-
-				if err != nil {
-					fmt.Printf("    [ ] Permissions: FAILED (Write failed: %v)\n", err)
-				} else {
-					fmt.Printf("    [x] Latency: %s\n", latency.Truncate(time.Millisecond))
-					fmt.Printf("    [x] Permissions: READ/WRITE OK\n")
-					_ = s.Delete(cmd.Context(), ".doctor_check")
+				for _, c := range checkStorage(cmd.Context(), s, start) {
+					if c.ok {
+						fmt.Printf("    [x] %s: %s\n", c.name, c.detail)
+					} else {
+						fmt.Printf("    [ ] %s: FAILED (%s)\n", c.name, c.detail)
+					}
 				}
 				s.Close() // #nosec G104
 			}
@@ -95,6 +92,71 @@ var doctorCmd = &cobra.Command{
 	},
 }
 
+// maxClockSkew is how far a target's clock may drift before doctor warns.
+// S3 rejects signatures more than 15 minutes off; object-lock retention
+// dates drift with the clock long before that.
+const maxClockSkew = time.Minute
+
+type doctorCheck struct {
+	name   string
+	ok     bool
+	detail string
+}
+
+// checkStorage writes a probe object, reads it back, compares it and
+// deletes it, then compares the target's clock with ours where the target
+// can report one. start is when connecting began, for the latency line.
+func checkStorage(ctx context.Context, s storage.Storage, start time.Time) []doctorCheck {
+	const probe = ".doctor_check"
+	payload := []byte(fmt.Sprintf("dbackup doctor %d", time.Now().UnixNano()))
+
+	if err := s.PutMetadata(ctx, probe, payload); err != nil {
+		return []doctorCheck{{"Write", false, err.Error()}}
+	}
+	checks := []doctorCheck{
+		{"Latency", true, time.Since(start).Truncate(time.Millisecond).String()},
+		{"Write", true, "OK"},
+	}
+
+	got, err := s.GetMetadata(ctx, probe)
+	switch {
+	case err != nil:
+		checks = append(checks, doctorCheck{"Read", false, err.Error()})
+	case !bytes.Equal(got, payload):
+		checks = append(checks, doctorCheck{"Read", false, fmt.Sprintf("read back %d bytes that differ from the %d written", len(got), len(payload))})
+	default:
+		checks = append(checks, doctorCheck{"Read", true, "OK, content matches"})
+	}
+
+	if err := s.Delete(ctx, probe); err != nil {
+		checks = append(checks, doctorCheck{"Delete", false, err.Error()})
+	} else {
+		checks = append(checks, doctorCheck{"Delete", true, "OK"})
+	}
+
+	if clock, ok := s.(interface {
+		ServerTime(ctx context.Context) (time.Time, error)
+	}); ok {
+		checks = append(checks, checkClock(ctx, clock.ServerTime))
+	}
+	return checks
+}
+
+func checkClock(ctx context.Context, serverTime func(context.Context) (time.Time, error)) doctorCheck {
+	before := time.Now()
+	server, err := serverTime(ctx)
+	if err != nil {
+		return doctorCheck{"Clock", false, err.Error()}
+	}
+	// The Date header has whole seconds and was stamped mid-request
+	local := before.Add(time.Since(before) / 2)
+	skew := server.Sub(local).Round(time.Second)
+	if skew.Abs() > maxClockSkew {
+		return doctorCheck{"Clock", false, fmt.Sprintf("target is %s off local time, over the %s limit; sync this host's clock (NTP)", skew, maxClockSkew)}
+	}
+	return doctorCheck{"Clock", true, fmt.Sprintf("%s off local time", skew)}
+}
+
 func init() {
 	rootCmd.AddCommand(doctorCmd)
 }
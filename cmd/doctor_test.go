@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/lupppig/dbackup/internal/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+// corruptingStorage returns different bytes than were written.
+type corruptingStorage struct {
+	storage.Storage
+}
+
+func (c corruptingStorage) GetMetadata(ctx context.Context, name string) ([]byte, error) {
+	data, err := c.Storage.GetMetadata(ctx, name)
+	if len(data) > 0 {
+		data[0] ^= 0xff
+	}
+	return data, err
+}
+
+func checkResults(checks []doctorCheck) map[string]bool {
+	res := make(map[string]bool)
+	for _, c := range checks {
+		res[c.name] = c.ok
+	}
+	return res
+}
+
+func TestCheckStorage_RoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	s := storage.NewLocalStorage(dir)
+	ctx := context.Background()
+
+	res := checkResults(checkStorage(ctx, s, time.Now()))
+	assert.Equal(t, map[string]bool{"Latency": true, "Write": true, "Read": true, "Delete": true}, res)
+	exists, err := s.Exists(ctx, ".doctor_check")
+	assert.NoError(t, err)
+	assert.False(t, exists, "the probe must be deleted")
+
+	res = checkResults(checkStorage(ctx, corruptingStorage{s}, time.Now()))
+	assert.False(t, res["Read"], "a corrupted read must fail")
+	assert.True(t, res["Delete"])
+}
+
+func TestCheckClock(t *testing.T) {
+	ctx := context.Background()
+	at := func(offset time.Duration) func(context.Context) (time.Time, error) {
+		return func(context.Context) (time.Time, error) { return time.Now().Add(offset), nil }
+	}
+
+	assert.True(t, checkClock(ctx, at(2*time.Second)).ok)
+	assert.False(t, checkClock(ctx, at(5*time.Minute)).ok)
+	assert.False(t, checkClock(ctx, at(-5*time.Minute)).ok)
+}
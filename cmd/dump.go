@@ -7,16 +7,14 @@ import (
 	"sync"
 	"time"
 
-	"os"
-	"os/signal"
-	"syscall"
-
 	"github.com/lupppig/dbackup/internal/backup"
+	compresspkg "github.com/lupppig/dbackup/internal/compress"
 	"github.com/lupppig/dbackup/internal/config"
 	"github.com/lupppig/dbackup/internal/db"
 	"github.com/lupppig/dbackup/internal/logger"
 	"github.com/lupppig/dbackup/internal/notify"
 	"github.com/lupppig/dbackup/internal/scheduler"
+	"github.com/lupppig/dbackup/internal/storage"
 	"github.com/spf13/cobra"
 	"github.com/vbauerster/mpb/v8"
 )
@@ -24,21 +22,28 @@ import (
 var dumpCmd = &cobra.Command{
 	Use:   "dump",
 	Short: "Execute all backups and restores defined in the config file",
-	Long:  `Reads the configuration file and executes all defined backup and restore tasks. Backups run in parallel, followed by sequential restores.`,
+	Long:  `Reads the configuration file and executes all defined backup and restore tasks. Backups run in parallel, followed by the restores: one at a time by default, or in parallel with restores_parallel. A task listing ids in depends_on starts only after those tasks succeeded.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		conf := config.GetConfig()
 		if len(conf.Backups) == 0 && len(conf.Restores) == 0 {
 			return fmt.Errorf("no backups or restores defined in config")
 		}
+		if err := conf.CheckDependencies(); err != nil {
+			return err
+		}
 
-		l := logger.New(logger.Config{
-			JSON:    conf.LogJSON,
-			NoColor: conf.NoColor,
-		})
-		var notifier notify.Notifier = notify.BuildNotifier(conf)
+		logCfg := logConfig()
+		logCfg.JSON = logCfg.JSON || conf.LogJSON
+		logCfg.NoColor = logCfg.NoColor || conf.NoColor
+		if logCfg.File == "" {
+			logCfg.File = conf.LogFile
+		}
+		l := logger.New(logCfg)
+		cmd.SetContext(logger.WithContext(cmd.Context(), l))
+		notifier := buildNotifier()
 
 		// Setup global signal handling
-		sigCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		sigCtx, stop := NewContext(cmd.Context())
 		defer stop()
 
 		ctx := sigCtx
@@ -96,6 +101,7 @@ var dumpCmd = &cobra.Command{
 						EncryptionPassphrase: b.EncryptionPassphrase,
 						Retention:            b.Retention,
 						Keep:                 b.Keep,
+						ExtraArgs:            b.ExtraArgs,
 					},
 				}
 				if err := s.AddTask(st); err != nil {
@@ -116,9 +122,13 @@ var dumpCmd = &cobra.Command{
 				if sched == "" {
 					sched = r.Interval
 				}
+				taskType := scheduler.RestoreTask
+				if r.VerifyOnly {
+					taskType = scheduler.DrillTask
+				}
 				st := &scheduler.ScheduledTask{
 					ID:        taskID,
-					Type:      scheduler.RestoreTask,
+					Type:      taskType,
 					Engine:    r.Engine,
 					SourceURI: r.From,
 					TargetURI: r.To,
@@ -131,6 +141,9 @@ var dumpCmd = &cobra.Command{
 						EncryptionKeyFile:    r.EncryptionKeyFile,
 						EncryptionPassphrase: r.EncryptionPassphrase,
 						ConfirmRestore:       r.ConfirmRestore,
+						OnConflict:           r.OnConflict,
+						OverwriteExisting:    r.OverwriteExisting,
+						ExtraArgs:            r.ExtraArgs,
 					},
 				}
 				if err := s.AddTask(st); err != nil {
@@ -157,79 +170,138 @@ var dumpCmd = &cobra.Command{
 
 		l.Info("Executing immediate tasks", "parallelism", conf.Parallelism)
 
+		var batch *notify.BatchNotifier
+		if conf.Notifications.Batch && notifier != nil {
+			batch = notify.NewBatchNotifier(notifier)
+			notifier = batch
+		}
+
 		var p *mpb.Progress
 		if !conf.LogJSON {
-			p = backup.NewProgressContainer()
+			refresh := conf.ProgressRefresh
+			if refresh == 0 {
+				refresh = progressRefresh
+			}
+			p = backup.NewProgressContainer(refresh)
 		}
 
-		sem := make(chan struct{}, conf.Parallelism)
-		var wg sync.WaitGroup
+		// Backups pruned by retention, per deduplicated target, for the GC sweep
+		var prunedMu sync.Mutex
+		pruned := make(map[string]int)
 
-		// Execute Backups in Parallel
-		backupCount := 0
-		for _, b := range conf.Backups {
-			if b.Schedule != "" || b.Interval != "" {
-				continue
+		runBackup := func(b config.TaskConfig) error {
+			l.Info("Starting backup task", "id", b.ID)
+			if err := db.ValidateExcludes(b.Exclude); err != nil {
+				l.Error("Invalid backup task", "id", b.ID, "error", err)
+				return err
+			}
+			if err := compresspkg.ValidateAlgorithm(b.Algorithm); err != nil {
+				l.Error("Invalid backup task", "id", b.ID, "error", err)
+				return err
+			}
+			if err := storage.ValidateChunker(b.DedupeAlgo); err != nil {
+				l.Error("Invalid backup task", "id", b.ID, "error", err)
+				return err
+			}
+			if err := validateMaxDuration(b.MaxDuration); err != nil {
+				l.Error("Invalid backup task", "id", b.ID, "error", err)
+				return err
+			}
+			if err := backup.ValidateNameTemplate(b.NameTemplate); err != nil {
+				l.Error("Invalid backup task", "id", b.ID, "error", err)
+				return err
+			}
+			if err := validateDedupeIndex(b); err != nil {
+				l.Error("Invalid backup task", "id", b.ID, "error", err)
+				return err
+			}
+			opts := convertToBackupOptions(b, l, notifier, p, *conf)
+			adapter, err := db.GetAdapter(opts.DBType)
+			if err != nil {
+				l.Error("Invalid engine", "id", b.ID, "engine", b.Engine)
+				return err
 			}
-			backupCount++
-			wg.Add(1)
-			go func(b config.TaskConfig) {
-				defer wg.Done()
-				sem <- struct{}{}
-				defer func() { <-sem }()
-
-				l.Info("Starting backup task", "id", b.ID)
-				opts := convertToBackupOptions(b, l, notifier, p, *conf)
-				adapter, err := db.GetAdapter(opts.DBType)
-				if err != nil {
-					l.Error("Invalid engine", "id", b.ID, "engine", b.Engine)
-					return
-				}
 
-				bm, err := backup.NewBackupManager(opts)
-				if err != nil {
-					l.Error("Failed to initialize backup", "id", b.ID, "error", err)
-					return
-				}
+			bm, err := backup.NewBackupManager(opts)
+			if err != nil {
+				l.Error("Failed to initialize backup", "id", b.ID, "error", err)
+				return err
+			}
 
-				conn := db.ConnectionParams{
-					DBType:   opts.DBType,
-					DBName:   opts.DBName,
-					DBUri:    b.URI,
-					Host:     b.Host,
-					User:     b.User,
-					Password: b.Pass,
-					Port:     b.Port,
-				}
+			conn := db.ConnectionParams{
+				DBType:    opts.DBType,
+				DBName:    opts.DBName,
+				DBUri:     b.URI,
+				Host:      b.Host,
+				User:      b.User,
+				Password:  b.Pass,
+				Port:      b.Port,
+				ExtraArgs: b.ExtraArgs,
+				Exclude:   b.Exclude,
+			}
 
-				if err := bm.Run(ctx, adapter, conn); err != nil {
-					l.Error("Backup failed", "id", b.ID, "error", err)
+			err = bm.Run(ctx, adapter, conn)
+			if err != nil {
+				l.Error("Backup failed", "id", b.ID, "error", err)
+			}
+			if n := len(bm.Pruned()); n > 0 {
+				l.Info("Pruned old backups", "id", b.ID, "count", n)
+				if opts.Dedupe {
+					prunedMu.Lock()
+					pruned[opts.StorageURI] += n
+					prunedMu.Unlock()
 				}
-			}(b)
+			}
+			return err
 		}
 
-		// Wait for all backups to finish
-		wg.Wait()
-		l.Info("All backups completed. Starting sequential restores if any.")
+		// Outcome of every task run so far, by id, for depends_on
+		results := make(map[string]bool)
 
-		// Execute Restores Sequentially
-		for _, r := range conf.Restores {
-			if r.Schedule != "" || r.Interval != "" {
-				continue
+		// Execute Backups in Parallel, each after the backups it depends on
+		var backups []config.TaskConfig
+		for _, b := range conf.Backups {
+			if b.Schedule == "" && b.Interval == "" {
+				backups = append(backups, b)
 			}
+		}
+		if failed := runTasks(l, backups, conf.Parallelism, results, runBackup); failed > 0 {
+			l.Warn("Some backups did not complete", "failed", failed, "total", len(backups))
+		}
 
-			l.Info("Starting sequential restore task", "id", r.ID)
+		sweepChunks(ctx, l, pruned, conf.AllowInsecure)
+		if batch != nil {
+			if err := batch.Flush(ctx); err != nil {
+				l.Warn("Failed to send batch notification", "error", err)
+			}
+		}
+		mode := "sequential"
+		if conf.RestoresParallel {
+			mode = "parallel"
+		}
+		l.Info("All backups completed. Starting restores if any.", "mode", mode)
+
+		runRestore := func(r config.TaskConfig) error {
+			l.Info("Starting restore task", "id", r.ID)
+			if err := db.ValidateOnConflict(r.OnConflict); err != nil {
+				l.Error("Invalid restore task", "id", r.ID, "error", err)
+				return err
+			}
+			if err := compresspkg.ValidateAlgorithm(r.Algorithm); err != nil {
+				l.Error("Invalid restore task", "id", r.ID, "error", err)
+				return err
+			}
 			opts := convertToBackupOptions(r, l, notifier, p, *conf)
 			adapter, err := db.GetAdapter(opts.DBType)
 			if err != nil {
 				l.Error("Invalid engine", "id", r.ID, "engine", r.Engine)
-				continue
+				return err
 			}
 
 			rm, err := backup.NewRestoreManager(opts)
 			if err != nil {
 				l.Error("Failed to initialize restore", "id", r.ID, "error", err)
-				continue
+				return err
 			}
 
 			dbUri := r.URI
@@ -252,10 +324,39 @@ var dumpCmd = &cobra.Command{
 					ClientCert: r.TLS.ClientCert,
 					ClientKey:  r.TLS.ClientKey,
 				},
+				OnConflict:        r.OnConflict,
+				OverwriteExisting: r.OverwriteExisting,
+				ExtraArgs:         r.ExtraArgs,
+
+				PostRestoreCheck:  r.PostRestoreCheck,
+				PostRestoreExpect: r.PostRestoreExpect,
+				IntoNewDB:         r.IntoNewDB,
 			}
 
 			if err := rm.Run(ctx, adapter, conn); err != nil {
 				l.Error("Restore failed", "id", r.ID, "error", err)
+				return err
+			}
+			return nil
+		}
+
+		var restores []config.TaskConfig
+		for _, r := range conf.Restores {
+			if r.Schedule == "" && r.Interval == "" {
+				restores = append(restores, r)
+			}
+		}
+		workers := 1
+		if conf.RestoresParallel {
+			workers = conf.Parallelism
+		}
+		if failed := runTasks(l, restores, workers, results, runRestore); failed > 0 {
+			l.Warn("Some restores did not complete", "failed", failed, "total", len(restores))
+		}
+
+		if batch != nil {
+			if err := batch.Flush(ctx); err != nil {
+				l.Warn("Failed to send batch notification", "error", err)
 			}
 		}
 
@@ -267,9 +368,161 @@ var dumpCmd = &cobra.Command{
 	},
 }
 
-func convertToBackupOptions(tc config.TaskConfig, l *logger.Logger, n notify.Notifier, p *mpb.Progress, global config.Config) backup.BackupOptions {
-	retention, _ := time.ParseDuration(tc.Retention)
+// taskOrder orders tasks so each comes after the tasks of the same list
+// named in its depends_on, keeping file order otherwise. deps holds those
+// dependencies by index. Config.CheckDependencies has ruled out cycles.
+func taskOrder(tasks []config.TaskConfig) (order []int, deps [][]int) {
+	byID := make(map[string]int)
+	for i, t := range tasks {
+		if t.ID != "" {
+			byID[t.ID] = i
+		}
+	}
+	deps = make([][]int, len(tasks))
+	for i, t := range tasks {
+		for _, id := range t.DependsOn {
+			if d, ok := byID[id]; ok && d != i {
+				deps[i] = append(deps[i], d)
+			}
+		}
+	}
+
+	placed := make([]bool, len(tasks))
+	for len(order) < len(tasks) {
+		next := -1
+		for i := range tasks {
+			if placed[i] {
+				continue
+			}
+			ready := true
+			for _, d := range deps[i] {
+				ready = ready && placed[d]
+			}
+			if ready {
+				next = i
+				break
+			}
+		}
+		if next < 0 {
+			break
+		}
+		placed[next] = true
+		order = append(order, next)
+	}
+	return order, deps
+}
+
+// runTasks runs tasks through run, at most workers at once, starting each
+// only after its dependencies succeeded. results holds the outcome of the
+// tasks of earlier lists by id, which this list may depend on too, and gains
+// this list's. A task whose dependency failed is skipped and counts as
+// failed. It returns the number of tasks that failed.
+func runTasks(l *logger.Logger, tasks []config.TaskConfig, workers int, results map[string]bool, run func(config.TaskConfig) error) int {
+	order, deps := taskOrder(tasks)
+	failed := make([]bool, len(tasks))
+	done := make([]chan struct{}, len(tasks))
+	for i := range tasks {
+		done[i] = make(chan struct{})
+	}
+
+	step := func(i int) {
+		defer close(done[i])
+		for _, d := range deps[i] {
+			if failed[d] {
+				l.Error("Skipping task, a dependency failed", "id", tasks[i].ID, "dependency", tasks[d].ID)
+				failed[i] = true
+				return
+			}
+		}
+		for _, id := range tasks[i].DependsOn {
+			if ok, ran := results[id]; ran && !ok {
+				l.Error("Skipping task, a dependency failed", "id", tasks[i].ID, "dependency", id)
+				failed[i] = true
+				return
+			}
+		}
+		failed[i] = run(tasks[i]) != nil
+	}
+
+	if workers <= 1 {
+		for _, i := range order {
+			step(i)
+		}
+	} else {
+		sem := make(chan struct{}, workers)
+		var wg sync.WaitGroup
+		for _, i := range order {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for _, d := range deps[i] {
+					<-done[d]
+				}
+				sem <- struct{}{}
+				defer func() { <-sem }()
+				step(i)
+			}()
+		}
+		wg.Wait()
+	}
+
+	var n int
+	for i, f := range failed {
+		if f {
+			n++
+		}
+		if tasks[i].ID != "" {
+			results[tasks[i].ID] = !f
+		}
+	}
+	return n
+}
+
+// sweepChunks runs one chunk GC per deduplicated target that had backups
+// pruned, rather than one per task, and logs what was reclaimed.
+func sweepChunks(ctx context.Context, l *logger.Logger, pruned map[string]int, allowInsecure bool) {
+	totalBackups, totalChunks := 0, 0
+	for target, n := range pruned {
+		s, err := storage.FromURI(target, storage.StorageOptions{AllowInsecure: allowInsecure})
+		if err != nil {
+			l.Warn("Skipping chunk GC", "target", storage.Scrub(target), "error", err)
+			continue
+		}
+		removed, err := storage.NewDedupeStorage(s).GC(ctx)
+		s.Close() // #nosec G104
+		if err != nil {
+			l.Warn("Chunk GC failed", "target", storage.Scrub(target), "error", err)
+			continue
+		}
+		totalBackups += n
+		totalChunks += removed
+	}
+	if len(pruned) > 0 {
+		l.Info("Retention summary", "pruned_backups", totalBackups, "reclaimed_chunks", totalChunks, "targets", len(pruned))
+	}
+}
+
+// validateMaxDuration checks a task's max_duration; empty means no limit.
+func validateMaxDuration(s string) error {
+	if s == "" {
+		return nil
+	}
+	if d, err := time.ParseDuration(s); err != nil || d <= 0 {
+		return fmt.Errorf("invalid max_duration %q: use a positive duration such as 2h", s)
+	}
+	return nil
+}
 
+// validateDedupeIndex rejects dedupe_index on a task with dedupe turned off,
+// as --dedupe-index requires --dedupe.
+func validateDedupeIndex(tc config.TaskConfig) error {
+	if tc.DedupeIndex && tc.Dedupe != nil && !*tc.Dedupe {
+		return fmt.Errorf("dedupe_index requires dedupe")
+	}
+	return nil
+}
+
+func convertToBackupOptions(tc config.TaskConfig, l *logger.Logger, n notify.Notifier, p *mpb.Progress, global config.Config) backup.BackupOptions {
 	dedupe := true
 	if tc.Dedupe != nil {
 		dedupe = *tc.Dedupe
@@ -293,6 +546,8 @@ func convertToBackupOptions(tc config.TaskConfig, l *logger.Logger, n notify.Not
 		}
 	}
 
+	startPing, startAfter := notifyStartOptions()
+
 	passphrase := tc.EncryptionPassphrase
 	if passphrase == "" {
 		passphrase = global.EncryptionPassphrase
@@ -302,25 +557,69 @@ func convertToBackupOptions(tc config.TaskConfig, l *logger.Logger, n notify.Not
 		keyFile = global.EncryptionKeyFile
 	}
 
+	tmp := tmpDir
+	if tmp == "" {
+		tmp = global.TmpDir
+	}
+	kdf, iterations := kdfOptions()
+
+	maxDur, _ := time.ParseDuration(tc.MaxDuration) // Checked with the task
+
+	var indexPath string
+	if tc.DedupeIndex && dedupe {
+		if p, err := chunkIndexPath(); err != nil {
+			l.Warn("Dedupe index unavailable, checking chunks remotely", "error", err)
+		} else {
+			indexPath = p
+		}
+	}
+
 	return backup.BackupOptions{
 		DBType:               tc.Engine,
 		DBName:               tc.DB,
 		StorageURI:           storageURI,
 		Compress:             tc.Compress,
 		Algorithm:            tc.Algorithm,
+		ChecksumAlgo:         tc.ChecksumAlgo,
+		ManifestFormat:       tc.ManifestFormat,
 		FileName:             fileName,
+		NameTemplate:         tc.NameTemplate,
 		Encrypt:              tc.Encrypt,
+		EncryptChunks:        tc.EncryptChunks,
+		ZstdDict:             tc.ZstdDict,
 		EncryptionPassphrase: passphrase,
 		EncryptionKeyFile:    keyFile,
+		KDF:                  kdf,
+		KDFIterations:        iterations,
 		RemoteExec:           tc.RemoteExec,
 		Dedupe:               dedupe,
-		Retention:            retention,
+		Retention:            parseRetention(tc.Retention),
 		Keep:                 tc.Keep,
+		AllowPruneAll:        tc.AllowPruneAll,
+		RetentionDryRun:      tc.RetentionDryRun,
+		ManifestRetention:    parseRetention(tc.ManifestRetention),
+		MaxDuration:          maxDur,
 		ConfirmRestore:       tc.ConfirmRestore,
 		DryRun:               tc.DryRun,
+		VerifyOnly:           tc.VerifyOnly,
+		TmpDir:               tmp,
+		Note:                 tc.Note,
+		IfChanged:            tc.IfChanged,
+		Lock:                 tc.Lock,
+		DedupeIndex:          indexPath,
+		DedupeAlgo:           tc.DedupeAlgo,
 		Logger:               l,
 		Notifier:             n,
+		NotifyOnStart:        startPing,
+		NotifyStartAfter:     startAfter,
 		Progress:             p,
+		ProgressRefresh:      global.ProgressRefresh,
+		RetentionPolicy: backup.RetentionPolicy{
+			KeepDaily:   tc.KeepDaily,
+			KeepWeekly:  tc.KeepWeekly,
+			KeepMonthly: tc.KeepMonthly,
+			KeepYearly:  tc.KeepYearly,
+		},
 	}
 }
 
@@ -0,0 +1,107 @@
+package cmd
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/lupppig/dbackup/internal/config"
+	"github.com/lupppig/dbackup/internal/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTaskOrder(t *testing.T) {
+	tasks := []config.TaskConfig{
+		{ID: "app", DependsOn: []string{"users"}},
+		{ID: "users", DependsOn: []string{"schema"}},
+		{ID: "logs", DependsOn: []string{"nightly"}}, // A backup, run earlier
+		{ID: "schema"},
+	}
+	order, deps := taskOrder(tasks)
+
+	assert.Equal(t, []int{2, 3, 1, 0}, order, "a chain runs dependencies first, file order otherwise")
+	assert.Equal(t, []int{1}, deps[0])
+	assert.Empty(t, deps[2])
+}
+
+func TestRunTasks(t *testing.T) {
+	l := logger.New(logger.Config{Writer: io.Discard})
+
+	t.Run("sequential", func(t *testing.T) {
+		tasks := []config.TaskConfig{{ID: "app", DependsOn: []string{"users"}}, {ID: "users"}, {ID: "logs"}}
+		var ran []string
+		results := make(map[string]bool)
+		failed := runTasks(l, tasks, 1, results, func(r config.TaskConfig) error {
+			ran = append(ran, r.ID)
+			return nil
+		})
+		assert.Zero(t, failed)
+		assert.Equal(t, []string{"users", "app", "logs"}, ran)
+		assert.Equal(t, map[string]bool{"app": true, "users": true, "logs": true}, results)
+	})
+
+	t.Run("parallel", func(t *testing.T) {
+		tasks := []config.TaskConfig{{ID: "a"}, {ID: "b"}, {ID: "c"}, {ID: "after", DependsOn: []string{"a", "b", "c"}}}
+		var running, peak atomic.Int32
+		var mu sync.Mutex
+		finished := make(map[string]bool)
+		failed := runTasks(l, tasks, 3, map[string]bool{}, func(r config.TaskConfig) error {
+			if r.ID == "after" {
+				mu.Lock()
+				assert.Len(t, finished, 3, "runs once its dependencies are done")
+				mu.Unlock()
+			}
+			n := running.Add(1)
+			for p := peak.Load(); n > p && !peak.CompareAndSwap(p, n); p = peak.Load() {
+			}
+			time.Sleep(20 * time.Millisecond)
+			running.Add(-1)
+			mu.Lock()
+			finished[r.ID] = true
+			mu.Unlock()
+			return nil
+		})
+		assert.Zero(t, failed)
+		assert.Equal(t, int32(3), peak.Load(), "independent restores overlap up to the worker count")
+	})
+
+	t.Run("failed dependency", func(t *testing.T) {
+		tasks := []config.TaskConfig{
+			{ID: "users"},
+			{ID: "app", DependsOn: []string{"users"}},
+			{ID: "report", DependsOn: []string{"app"}},
+			{ID: "logs"},
+			{ID: "audit", DependsOn: []string{"nightly"}},
+		}
+		for _, workers := range []int{1, 4} {
+			var mu sync.Mutex
+			var ran []string
+			// nightly is a backup that failed before the restores
+			results := map[string]bool{"nightly": false}
+			failed := runTasks(l, tasks, workers, results, func(r config.TaskConfig) error {
+				mu.Lock()
+				ran = append(ran, r.ID)
+				mu.Unlock()
+				if r.ID == "users" {
+					return errors.New("boom")
+				}
+				return nil
+			})
+			assert.Equal(t, 4, failed, "workers=%d", workers)
+			assert.False(t, results["report"])
+			assert.True(t, results["logs"])
+			assert.ElementsMatch(t, []string{"users", "logs"}, ran, "workers=%d", workers)
+		}
+	})
+}
+
+func TestValidateDedupeIndex(t *testing.T) {
+	off, on := false, true
+	assert.NoError(t, validateDedupeIndex(config.TaskConfig{DedupeIndex: true}), "dedupe defaults to on")
+	assert.NoError(t, validateDedupeIndex(config.TaskConfig{DedupeIndex: true, Dedupe: &on}))
+	assert.NoError(t, validateDedupeIndex(config.TaskConfig{Dedupe: &off}))
+	assert.ErrorContains(t, validateDedupeIndex(config.TaskConfig{DedupeIndex: true, Dedupe: &off}), "requires dedupe")
+}
@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	database "github.com/lupppig/dbackup/internal/db"
+	"github.com/spf13/cobra"
+)
+
+var enginesCmd = &cobra.Command{
+	Use:   "engines",
+	Short: "List supported database engines and their capabilities",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		yesNo := func(b bool) string {
+			if b {
+				return "yes"
+			}
+			return "no"
+		}
+
+		fmt.Printf("\n%-12s %-10s %-13s %-11s %-15s %s\n", "ENGINE", "PHYSICAL", "INCREMENTAL", "STREAMING", "LOCAL DATADIR", "EXCLUDE")
+		fmt.Println(strings.Repeat("-", 74))
+		for _, a := range database.Adapters() {
+			caps := a.Capabilities()
+			fmt.Printf("%-12s %-10s %-13s %-11s %-15s %s\n",
+				a.Name(),
+				yesNo(caps.SupportsPhysical),
+				yesNo(caps.SupportsIncremental),
+				yesNo(caps.SupportsStreaming),
+				yesNo(caps.RequiresLocalDatadir),
+				yesNo(caps.ExcludeFiles),
+			)
+		}
+		fmt.Println()
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(enginesCmd)
+}
@@ -1,7 +1,6 @@
 package cmd
 
 import (
-	"context"
 	"fmt"
 
 	"github.com/lupppig/dbackup/internal/logger"
@@ -9,6 +8,8 @@ import (
 	"github.com/spf13/cobra"
 )
 
+var gcParity bool
+
 var gcCmd = &cobra.Command{
 	Use:   "gc",
 	Short: "Collect and remove orphaned chunks from deduplicated storage",
@@ -30,12 +31,20 @@ var gcCmd = &cobra.Command{
 		}
 
 		l.Info("Running garbage collection...", "target", target)
-		count, err := ds.GC(context.Background())
+		count, err := ds.GC(cmd.Context())
 		if err != nil {
 			return fmt.Errorf("GC failed: %w", err)
 		}
 
-		l.Info("Garbage collection complete", "removed_chunks", count)
+		if !gcParity {
+			l.Info("Garbage collection complete", "removed_chunks", count)
+			return nil
+		}
+		parity, err := ds.PruneParity(cmd.Context())
+		if err != nil {
+			return fmt.Errorf("GC failed: %w", err)
+		}
+		l.Info("Garbage collection complete", "removed_chunks", count, "removed_parity", parity)
 		return nil
 	},
 }
@@ -43,4 +52,5 @@ var gcCmd = &cobra.Command{
 func init() {
 	rootCmd.AddCommand(gcCmd)
 	gcCmd.Flags().String("to", "", "Storage target (e.g. dedupe://local://./backups)")
+	gcCmd.Flags().BoolVar(&gcParity, "parity", false, "also delete parity objects of stripes no backup uses any more")
 }
@@ -0,0 +1,126 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	apperrors "github.com/lupppig/dbackup/internal/errors"
+	"github.com/lupppig/dbackup/internal/manifest"
+	"github.com/lupppig/dbackup/internal/storage"
+	"github.com/spf13/cobra"
+)
+
+var infoChunks bool
+var infoJSON bool
+
+var infoCmd = &cobra.Command{
+	Use:   "info <backup-file>",
+	Short: "Show the details of one backup",
+	Long: `Show the manifest of one backup in a storage location.
+
+With --chunks, a deduplicated backup also reports its chunk count, how many
+of them are distinct, their average size, and how many it shares with the
+previous backup of the same database, to judge whether dedupe pays off.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if target == "" {
+			target = "."
+		}
+
+		s, err := storage.FromURI(target, storage.StorageOptions{AllowInsecure: AllowInsecure})
+		if err != nil {
+			return err
+		}
+		if dedupe {
+			s = storage.NewDedupeStorage(s)
+		}
+		defer s.Close()
+
+		name := strings.TrimSuffix(args[0], ".manifest") + ".manifest"
+		data, err := s.GetMetadata(cmd.Context(), name)
+		if err != nil {
+			return apperrors.Wrap(err, apperrors.TypeResource, "failed to read manifest "+name, "Run 'dbackup backups' to list the backups on the target.")
+		}
+		m, err := manifest.Deserialize(data)
+		if err != nil {
+			return apperrors.Wrap(err, apperrors.TypeIntegrity, "invalid manifest "+name, "The manifest could not be parsed; it may be damaged or from a newer dbackup.")
+		}
+
+		var stats *storage.ChunkStats
+		if infoChunks {
+			ds, ok := s.(*storage.DedupeStorage)
+			if !ok || len(m.Chunks) == 0 {
+				return apperrors.New(apperrors.TypeConfig, m.FileName+" is not a deduplicated backup", "--chunks needs a backup taken with --dedupe.")
+			}
+			st, err := ds.ChunkStats(cmd.Context(), m)
+			if err != nil {
+				return apperrors.Wrap(err, apperrors.TypeResource, "failed to compare chunks", "Check that the target is reachable.")
+			}
+			stats = &st
+		}
+
+		if infoJSON {
+			out, err := json.MarshalIndent(struct {
+				*manifest.Manifest
+				ChunkStats *storage.ChunkStats `json:"chunk_stats,omitempty"`
+			}{m, stats}, "", "  ")
+			if err != nil {
+				return err
+			}
+			_, err = cmd.OutOrStdout().Write(append(out, '\n'))
+			return err
+		}
+		printInfo(cmd.OutOrStdout(), m, stats)
+		return nil
+	},
+}
+
+// printInfo writes the human-readable form of a backup's details.
+func printInfo(w io.Writer, m *manifest.Manifest, stats *storage.ChunkStats) {
+	row := func(k, v string) {
+		if v != "" {
+			fmt.Fprintf(w, "%-16s %s\n", k+":", v)
+		}
+	}
+	row("File", m.FileName)
+	row("Engine", m.Engine)
+	row("Database", m.DBName)
+	row("Created", m.CreatedAt.Format("2006-01-02 15:04:05"))
+	row("Size", formatBytes(m.Size))
+	row("Compression", m.Compression)
+	row("Encryption", m.Encryption)
+	row("Checksum", m.Checksum)
+	row("Note", m.Note)
+	if m.Pruned {
+		row("Status", "pruned "+m.PrunedAt.Format("2006-01-02 15:04:05"))
+	}
+	if stats == nil {
+		return
+	}
+
+	fmt.Fprintln(w)
+	row("Chunks", fmt.Sprintf("%d (%d unique)", stats.Chunks, stats.Unique))
+	row("Avg chunk size", formatBytes(stats.AvgChunkSize))
+	if stats.Previous == "" {
+		row("Previous", "none, every chunk is new")
+		return
+	}
+	row("Previous", stats.Previous)
+	row("Shared", fmt.Sprintf("%d chunks (%.1f%%)", stats.Shared, 100*float64(stats.Shared)/float64(max(stats.Unique, 1))))
+	row("New", fmt.Sprintf("%d chunks, about %s", stats.New, formatBytes(stats.NewBytes)))
+}
+
+func formatBytes(n int64) string {
+	if n < 1024*1024 {
+		return fmt.Sprintf("%.2f KB", float64(n)/1024)
+	}
+	return fmt.Sprintf("%.2f MB", float64(n)/(1024*1024))
+}
+
+func init() {
+	rootCmd.AddCommand(infoCmd)
+	infoCmd.Flags().BoolVar(&infoChunks, "chunks", false, "report chunk counts and sharing with the previous backup of the database (dedupe targets)")
+	infoCmd.Flags().BoolVar(&infoJSON, "json", false, "print the details as JSON")
+}
@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"database/sql"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	"github.com/lupppig/dbackup/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInfo_Chunks(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "app.db")
+	db, err := sql.Open("sqlite3", dbPath)
+	require.NoError(t, err)
+	_, err = db.Exec("CREATE TABLE t (id INTEGER PRIMARY KEY)")
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+	backups := filepath.Join(dir, "backups")
+
+	defer backupCmd.Flags().Set("name", "")
+	defer infoCmd.Flags().Set("chunks", "false")
+	defer infoCmd.Flags().Set("json", "false")
+
+	for _, name := range []string{"first.sql", "second.sql"} {
+		_, err := executeCommand(rootCmd, "backup", "sqlite", "--db", dbPath, "--to", backups, "--dedupe", "--name", name)
+		require.NoError(t, err)
+	}
+
+	out, err := executeCommand(rootCmd, "info", "second.sql.lz4", "--to", backups, "--chunks", "--json")
+	require.NoError(t, err)
+	var got struct {
+		FileName   string              `json:"file_name"`
+		ChunkStats *storage.ChunkStats `json:"chunk_stats"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(out), &got), out)
+	assert.Equal(t, "second.sql.lz4", got.FileName)
+	require.NotNil(t, got.ChunkStats)
+	assert.Equal(t, "first.sql.lz4", got.ChunkStats.Previous)
+	assert.Positive(t, got.ChunkStats.Chunks)
+	assert.Equal(t, got.ChunkStats.Unique, got.ChunkStats.Shared, "an unchanged database shares every chunk")
+	assert.Zero(t, got.ChunkStats.New)
+
+	require.NoError(t, infoCmd.Flags().Set("json", "false"))
+	out, err = executeCommand(rootCmd, "info", "second.sql.lz4", "--to", backups, "--chunks")
+	require.NoError(t, err)
+	assert.Contains(t, out, "Previous:        first.sql.lz4")
+	assert.Contains(t, out, "(100.0%)")
+}
@@ -1,10 +1,18 @@
 package cmd
 
 import (
+	"context"
+	"encoding/hex"
 	"fmt"
+	"io"
 	"strings"
 
+	"github.com/lupppig/dbackup/internal/backup"
+	compresspkg "github.com/lupppig/dbackup/internal/compress"
+	"github.com/lupppig/dbackup/internal/crypto"
+	apperrors "github.com/lupppig/dbackup/internal/errors"
 	"github.com/lupppig/dbackup/internal/logger"
+	"github.com/lupppig/dbackup/internal/manifest"
 	storagepkg "github.com/lupppig/dbackup/internal/storage"
 	"github.com/spf13/cobra"
 )
@@ -14,6 +22,10 @@ var (
 	migrateTo   string
 )
 
+var migrateRecompress string
+
+var migrateDecrypt bool
+
 var migrateCmd = &cobra.Command{
 	Use:   "migrate",
 	Short: "Migrate backups between storage backends",
@@ -25,14 +37,25 @@ Example: dbackup migrate --from ./local-backups --to s3://my-bucket/backups`,
 		if migrateFrom == "" || migrateTo == "" {
 			return fmt.Errorf("--from and --to are required")
 		}
+		if encrypt && migrateDecrypt {
+			return apperrors.New(apperrors.TypeConfig, "--encrypt and --decrypt are mutually exclusive", "Pass one of them.")
+		}
+		if migrateRecompress != "" {
+			if err := compresspkg.ValidateAlgorithm(migrateRecompress); err != nil {
+				return err
+			}
+			if compresspkg.Algorithm(migrateRecompress) == compresspkg.Tar {
+				return apperrors.New(apperrors.TypeConfig, "--recompress tar is not supported", "Use gzip, lz4, zstd or none; tar can't be written as a stream.")
+			}
+		}
 
-		src, err := storagepkg.FromURI(migrateFrom, storagepkg.StorageOptions{})
+		src, err := storagepkg.FromURI(migrateFrom, storagepkg.StorageOptions{AllowInsecure: AllowInsecure, TmpDir: tmpDir})
 		if err != nil {
 			return fmt.Errorf("failed to open source storage: %w", err)
 		}
 		defer src.Close()
 
-		dst, err := storagepkg.FromURI(migrateTo, storagepkg.StorageOptions{})
+		dst, err := storagepkg.FromURI(migrateTo, storagepkg.StorageOptions{AllowInsecure: AllowInsecure, TmpDir: tmpDir})
 		if err != nil {
 			return fmt.Errorf("failed to open destination storage: %w", err)
 		}
@@ -52,11 +75,34 @@ Example: dbackup migrate --from ./local-backups --to s3://my-bucket/backups`,
 			return fmt.Errorf("failed to list source manifests: %w", err)
 		}
 
+		// Backups compressed with a zstd dictionary can't be read without it
+		dict, dictErr := src.GetMetadata(cmd.Context(), compresspkg.DictFile)
+
+		tf := migrateTransform{algo: compresspkg.Algorithm(migrateRecompress), encrypt: encrypt, decrypt: migrateDecrypt}
+		if dictErr == nil {
+			tf.dict = dict
+		}
+		if encryptionPassphrase != "" || encryptionKeyFile != "" {
+			if tf.km, err = crypto.NewKeyManager(encryptionPassphrase, encryptionKeyFile); err != nil {
+				return err
+			}
+			if err := setKDF(tf.km); err != nil {
+				return err
+			}
+		}
+
 		migratedCount := 0
+		var latest []string
+		renamed := make(map[string]string)
 		for _, file := range files {
 			if !strings.HasSuffix(file, ".manifest") {
 				continue
 			}
+			if manifest.IsLatest(file) {
+				// Written once the backups they name are across
+				latest = append(latest, file)
+				continue
+			}
 
 			l.Info("Migrating backup", "manifest", file)
 
@@ -66,6 +112,25 @@ Example: dbackup migrate --from ./local-backups --to s3://my-bucket/backups`,
 				continue
 			}
 
+			// Keep the provenance trail; manifests from a newer dbackup are
+			// copied verbatim so fields this version doesn't know survive
+			man, err := manifest.Deserialize(data)
+			if err == nil && !man.Newer() {
+				man.RecordMigration(storagepkg.Scrub(src.Location()), storagepkg.Scrub(dst.Location()))
+				if updated, err := man.Serialize(); err == nil {
+					data = updated
+				}
+			}
+
+			// A pruned backup's tombstone has no data left to copy
+			if man != nil && man.Pruned {
+				if err := dst.PutMetadata(cmd.Context(), file, data); err != nil {
+					return fmt.Errorf("failed to save manifest to destination: %w", err)
+				}
+				migratedCount++
+				continue
+			}
+
 			// Open source backup data
 			backupName := strings.TrimSuffix(file, ".manifest")
 			r, err := src.Open(cmd.Context(), backupName)
@@ -76,6 +141,24 @@ Example: dbackup migrate --from ./local-backups --to s3://my-bucket/backups`,
 				continue
 			}
 
+			if man != nil && !man.Newer() && tf.changes(man, backupName) {
+				newName, err := tf.apply(cmd.Context(), dst, r, man, backupName)
+				r.Close() // #nosec G104
+				if err != nil {
+					return fmt.Errorf("failed to transform %s: %w", backupName, err)
+				}
+				if data, err = man.Serialize(); err != nil {
+					return err
+				}
+				if err := dst.PutMetadata(cmd.Context(), newName+".manifest", data); err != nil {
+					return fmt.Errorf("failed to save manifest to destination: %w", err)
+				}
+				renamed[file] = newName + ".manifest"
+				l.Info("Transformed backup", "from", backupName, "to", newName, "compression", man.Compression, "encryption", man.Encryption)
+				migratedCount++
+				continue
+			}
+
 			// Save to destination
 			_, err = dst.Save(cmd.Context(), backupName, r)
 			r.Close() // #nosec G104
@@ -91,6 +174,26 @@ Example: dbackup migrate --from ./local-backups --to s3://my-bucket/backups`,
 			migratedCount++
 		}
 
+		for _, file := range latest {
+			target, err := migrateLatest(cmd.Context(), src, file)
+			if err != nil {
+				l.Warn("Failed to read latest pointer", "file", file, "error", err)
+				continue
+			}
+			if newName, ok := renamed[target]; ok {
+				target = newName
+			}
+			if err := dst.PutMetadata(cmd.Context(), file, manifest.NewPointer(target)); err != nil {
+				return fmt.Errorf("failed to save latest pointer to destination: %w", err)
+			}
+		}
+
+		if dictErr == nil {
+			if err := dst.PutMetadata(cmd.Context(), compresspkg.DictFile, dict); err != nil {
+				return fmt.Errorf("failed to copy zstd dictionary: %w", err)
+			}
+		}
+
 		l.Info("Migration finished", "count", migratedCount)
 		return nil
 	},
@@ -101,4 +204,153 @@ func init() {
 	migrateCmd.Flags().StringVar(&migrateFrom, "from", "", "Source storage URI")
 	migrateCmd.Flags().StringVar(&migrateTo, "to", "", "Destination storage URI")
 	migrateCmd.Flags().BoolVar(&dedupe, "dedupe", true, "Enable deduplication at destination")
+	migrateCmd.Flags().StringVar(&migrateRecompress, "recompress", "", "re-compress backups with this algorithm (gzip, lz4, zstd, none) while copying")
+	migrateCmd.Flags().BoolVar(&migrateDecrypt, "decrypt", false, "store encrypted backups decrypted at the destination")
+}
+
+// migrateLatest returns the manifest the latest pointer file on src names.
+// A legacy pointer, a full copy of the manifest, names its backup's own.
+func migrateLatest(ctx context.Context, src storagepkg.Storage, file string) (string, error) {
+	data, err := src.GetMetadata(ctx, file)
+	if err != nil {
+		return "", err
+	}
+	if target, ok := manifest.ParsePointer(data); ok {
+		return target, nil
+	}
+	man, err := manifest.Deserialize(data)
+	if err != nil {
+		return "", err
+	}
+	if man.FileName == "" {
+		return "", fmt.Errorf("latest pointer %s names no backup", file)
+	}
+	return man.FileName + ".manifest", nil
+}
+
+// migrateTransform re-encodes backups on their way to the destination of a
+// migrate: --recompress changes the compression, --encrypt and --decrypt
+// the stream encryption. One key serves for reading and writing.
+type migrateTransform struct {
+	algo    compresspkg.Algorithm // Empty keeps each backup's own compression
+	encrypt bool
+	decrypt bool
+	km      *crypto.KeyManager
+	dict    []byte // The source's zstd dictionary, if it has one
+}
+
+// source returns how the backup name described by man is stored now.
+func (t migrateTransform) source(man *manifest.Manifest, name string) (compresspkg.Algorithm, bool) {
+	algo := compresspkg.Algorithm(man.Compression)
+	if algo == "" {
+		algo = compresspkg.DetectAlgorithm(name)
+	}
+	return algo, man.Encryption != "" && man.Encryption != "none"
+}
+
+// target returns how the backup is to be stored at the destination.
+func (t migrateTransform) target(man *manifest.Manifest, name string) (compresspkg.Algorithm, bool) {
+	algo, enc := t.source(man, name)
+	if t.algo != "" {
+		algo = t.algo
+	}
+	return algo, t.encrypt || enc && !t.decrypt
+}
+
+// changes reports whether the backup must be re-encoded rather than copied.
+func (t migrateTransform) changes(man *manifest.Manifest, name string) bool {
+	fromAlgo, fromEnc := t.source(man, name)
+	toAlgo, toEnc := t.target(man, name)
+	return fromAlgo != toAlgo || fromEnc != toEnc
+}
+
+// apply streams the backup r through decryption, decompression,
+// recompression and encryption as needed into dst, and updates man to
+// describe the new copy. The copy is renamed to match its compression; its
+// new name is returned.
+func (t migrateTransform) apply(ctx context.Context, dst storagepkg.Storage, r io.Reader, man *manifest.Manifest, name string) (string, error) {
+	if man.ChunkEncryption != "" {
+		return "", apperrors.New(apperrors.TypeConfig, "backups with encrypted chunks can't be transformed", "Migrate them without --recompress, --encrypt or --decrypt.")
+	}
+	fromAlgo, fromEnc := t.source(man, name)
+	toAlgo, toEnc := t.target(man, name)
+	if (fromEnc || toEnc) && t.km == nil {
+		return "", apperrors.New(apperrors.TypeSecurity, "transforming encrypted backups needs a key", "Set the DBACKUP_KEY environment variable or use --encryption-passphrase.")
+	}
+
+	hasher, err := manifest.NewHasher(man.ChecksumAlgo)
+	if err != nil {
+		return "", err
+	}
+	counter := &backup.ByteCounter{}
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(t.copy(pw, r, fromAlgo, fromEnc, toAlgo, toEnc, man.CompressionDict != 0)) // #nosec G104
+	}()
+
+	newName := strings.TrimSuffix(name, compresspkg.Extension(fromAlgo)) + compresspkg.Extension(toAlgo)
+	_, err = dst.Save(ctx, newName, io.TeeReader(pr, io.MultiWriter(hasher, counter)))
+	pr.CloseWithError(err) // #nosec G104 -- unblocks the copy if Save gave up
+	if err != nil {
+		return "", err
+	}
+
+	man.FileName = newName
+	man.Compression = string(toAlgo)
+	man.Encryption = "none"
+	if toEnc {
+		man.Encryption = "aes-256-gcm"
+	}
+	man.CompressionDict = 0
+	man.Checksum = hex.EncodeToString(hasher.Sum(nil))
+	man.Size = counter.Count
+	man.Chunks = nil
+	if cs, ok := dst.(storagepkg.ChunkedStorage); ok {
+		man.Chunks = cs.LastChunks()
+	}
+	return newName, nil
+}
+
+// copy writes the re-encoded backup r to w.
+func (t migrateTransform) copy(w io.Writer, r io.Reader, fromAlgo compresspkg.Algorithm, fromEnc bool, toAlgo compresspkg.Algorithm, toEnc, useDict bool) error {
+	if fromEnc {
+		r = crypto.NewDecryptReader(r, t.km)
+	}
+	var dict []byte
+	if useDict {
+		if t.dict == nil {
+			return apperrors.New(apperrors.TypeIntegrity, "backup needs the zstd dictionary "+compresspkg.DictFile+", which the source doesn't have", "Restore the dictionary file to the source first.")
+		}
+		dict = t.dict
+	}
+	dr, err := compresspkg.NewReaderWithDict(r, fromAlgo, dict)
+	if err != nil {
+		return err
+	}
+	defer dr.Close()
+
+	// Neither layer may close the pipe; the caller ends it with the
+	// error, if any
+	var ew *crypto.EncryptWriter
+	if toEnc {
+		if ew, err = crypto.NewEncryptWriter(struct{ io.Writer }{w}, t.km); err != nil {
+			return err
+		}
+		w = ew
+	}
+	c, err := compresspkg.New(struct{ io.Writer }{w}, toAlgo)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(c, dr); err != nil {
+		return err
+	}
+	if err := c.Close(); err != nil {
+		return err
+	}
+	if ew != nil {
+		return ew.Close()
+	}
+	return nil
 }
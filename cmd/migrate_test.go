@@ -0,0 +1,133 @@
+package cmd
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lupppig/dbackup/internal/backup"
+	database "github.com/lupppig/dbackup/internal/db"
+	"github.com/lupppig/dbackup/internal/manifest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrate_Transform(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "app.db")
+	db, err := sql.Open("sqlite3", dbPath)
+	require.NoError(t, err)
+	_, err = db.Exec("CREATE TABLE t (id INTEGER PRIMARY KEY, name TEXT); INSERT INTO t (name) VALUES ('migrated')")
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	from := filepath.Join(dir, "hot")
+	mgr, err := backup.NewBackupManager(backup.BackupOptions{StorageURI: from, FileName: "app.db", Compress: true, Algorithm: "gzip", NoLatest: true})
+	require.NoError(t, err)
+	require.NoError(t, mgr.Run(ctx, &database.SqliteAdapter{}, database.ConnectionParams{DBType: "sqlite", DBUri: dbPath}))
+
+	defer migrateCmd.Flags().Set("recompress", "")
+	defer migrateCmd.Flags().Set("dedupe", "true")
+	defer rootCmd.PersistentFlags().Set("encrypt", "false")
+	defer rootCmd.PersistentFlags().Set("encryption-passphrase", "")
+
+	restore := func(target, name string) string {
+		t.Helper()
+		out := filepath.Join(t.TempDir(), "restored.db")
+		rm, err := backup.NewRestoreManager(backup.BackupOptions{StorageURI: target, FileName: name, ConfirmRestore: true, EncryptionPassphrase: "cold-key"})
+		require.NoError(t, err)
+		require.NoError(t, rm.Run(ctx, &database.SqliteAdapter{}, database.ConnectionParams{DBType: "sqlite", DBUri: out}))
+
+		restored, err := sql.Open("sqlite3", out)
+		require.NoError(t, err)
+		defer restored.Close()
+		var got string
+		require.NoError(t, restored.QueryRow("SELECT name FROM t").Scan(&got))
+		return got
+	}
+
+	t.Run("recompress", func(t *testing.T) {
+		to := filepath.Join(dir, "cold")
+		_, err := executeCommand(rootCmd, "migrate", "--from", from, "--to", to, "--dedupe=false", "--recompress", "zstd")
+		require.NoError(t, err)
+
+		_, err = os.Stat(filepath.Join(to, "app.db.gz"))
+		assert.True(t, os.IsNotExist(err), "the gzip copy is replaced, not kept")
+		data, err := os.ReadFile(filepath.Join(to, "app.db.zst.manifest"))
+		require.NoError(t, err)
+		man, err := manifest.Deserialize(data)
+		require.NoError(t, err)
+		assert.Equal(t, "zstd", man.Compression)
+		assert.Equal(t, "app.db.zst", man.FileName)
+		assert.Len(t, man.Migrations, 1)
+
+		assert.Equal(t, "migrated", restore(to, "app.db.zst"))
+	})
+
+	t.Run("encrypt", func(t *testing.T) {
+		to := filepath.Join(dir, "vault")
+		_, err := executeCommand(rootCmd, "migrate", "--from", from, "--to", to, "--dedupe=false", "--recompress", "", "--encrypt", "--encryption-passphrase", "cold-key")
+		require.NoError(t, err)
+
+		data, err := os.ReadFile(filepath.Join(to, "app.db.gz.manifest"))
+		require.NoError(t, err)
+		man, err := manifest.Deserialize(data)
+		require.NoError(t, err)
+		assert.Equal(t, "aes-256-gcm", man.Encryption)
+		assert.Equal(t, "gzip", man.Compression)
+
+		assert.Equal(t, "migrated", restore(to, "app.db.gz"))
+	})
+
+	_, err = executeCommand(rootCmd, "migrate", "--from", from, "--to", filepath.Join(dir, "x"), "--encrypt", "--decrypt")
+	assert.Error(t, err)
+	_, err = executeCommand(rootCmd, "migrate", "--from", from, "--to", filepath.Join(dir, "x"), "--encrypt=false", "--decrypt=false", "--recompress", "tar")
+	assert.Error(t, err)
+}
+
+func TestMigrate_LatestPointers(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "app.db")
+	db, err := sql.Open("sqlite3", dbPath)
+	require.NoError(t, err)
+	_, err = db.Exec("CREATE TABLE t (id INTEGER PRIMARY KEY)")
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	from := filepath.Join(dir, "hot")
+	mgr, err := backup.NewBackupManager(backup.BackupOptions{StorageURI: from, FileName: "app.db", Compress: true, Algorithm: "gzip"})
+	require.NoError(t, err)
+	require.NoError(t, mgr.Run(ctx, &database.SqliteAdapter{}, database.ConnectionParams{DBType: "sqlite", DBUri: dbPath}))
+
+	// One pointer as an older dbackup wrote it: a full copy of the manifest
+	data, err := os.ReadFile(filepath.Join(from, "app.db.gz.manifest"))
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(from, manifest.LatestName), data, 0644))
+
+	defer migrateCmd.Flags().Set("recompress", "")
+	defer migrateCmd.Flags().Set("dedupe", "true")
+	to := filepath.Join(dir, "cold")
+	_, err = executeCommand(rootCmd, "migrate", "--from", from, "--to", to, "--dedupe=false", "--recompress", "zstd")
+	require.NoError(t, err)
+
+	// Both follow the backup to its new name, as current pointers
+	entries, err := os.ReadDir(to)
+	require.NoError(t, err)
+	var pointers int
+	for _, e := range entries {
+		if !manifest.IsLatest(e.Name()) {
+			continue
+		}
+		pointers++
+		data, err := os.ReadFile(filepath.Join(to, e.Name()))
+		require.NoError(t, err)
+		target, ok := manifest.ParsePointer(data)
+		assert.True(t, ok, e.Name())
+		assert.Equal(t, "app.db.zst.manifest", target, e.Name())
+	}
+	assert.Equal(t, 2, pointers)
+}
@@ -48,10 +48,13 @@ This will update both the backup data (chunks if deduped) and the manifests.`,
 
 		oldKM, _ := crypto.NewKeyManager(oldPassphrase, "")
 		newKM, _ := crypto.NewKeyManager(newPassphrase, "")
+		if err := setKDF(newKM); err != nil {
+			return err
+		}
 
 		rekeyedCount := 0
 		for _, file := range files {
-			if !strings.HasSuffix(file, ".manifest") || file == "latest.manifest" {
+			if !strings.HasSuffix(file, ".manifest") || manifest.IsLatest(file) {
 				continue
 			}
 
@@ -69,6 +72,11 @@ This will update both the backup data (chunks if deduped) and the manifests.`,
 				continue
 			}
 
+			if man.Pruned {
+				l.Info("Skipping pruned backup", "file", file)
+				continue
+			}
+
 			if man.Encryption == "none" {
 				l.Info("Skipping unencrypted backup", "file", file)
 				continue
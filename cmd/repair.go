@@ -0,0 +1,98 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/lupppig/dbackup/internal/crypto"
+	"github.com/lupppig/dbackup/internal/logger"
+	"github.com/lupppig/dbackup/internal/storage"
+	"github.com/spf13/cobra"
+)
+
+var repairCmd = &cobra.Command{
+	Use:   "repair",
+	Short: "Restore missing chunks of a deduplicated target from a healthy replica",
+	Long: `Verify the damaged target, copy every missing chunk from the healthy one,
+then verify again. Only the missing chunks are transferred, unlike migrate.
+Each chunk is checked against its ID before it is written; chunks encrypted
+with --encrypt-chunks need the passphrase or key file for that.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		source, _ := cmd.Flags().GetString("from")
+		target, _ := cmd.Flags().GetString("to")
+		allowInsecure, _ := cmd.Flags().GetBool("allow-insecure")
+		if source == "" || target == "" {
+			return fmt.Errorf("both --from (healthy) and --to (damaged) targets are required")
+		}
+
+		healthy, err := openDedupe(source, allowInsecure)
+		if err != nil {
+			return err
+		}
+		defer healthy.Close()
+
+		damaged, err := openDedupe(target, allowInsecure)
+		if err != nil {
+			return err
+		}
+		defer damaged.Close()
+
+		// Encrypted chunks can only be checked against their IDs with the key
+		if encryptionPassphrase != "" || encryptionKeyFile != "" {
+			km, err := crypto.NewKeyManager(encryptionPassphrase, encryptionKeyFile)
+			if err != nil {
+				return err
+			}
+			damaged.SetKeyManager(km)
+		}
+
+		l := logger.FromContext(cmd.Context())
+		ctx := cmd.Context()
+
+		l.Info("Verifying integrity...", "target", storage.Scrub(target))
+		missing, err := damaged.Verify(ctx)
+		if err != nil {
+			return fmt.Errorf("verify failed: %w", err)
+		}
+		if len(missing) == 0 {
+			l.Info("Integrity check passed. Nothing to repair.")
+			return nil
+		}
+
+		l.Info("Copying missing chunks", "from", storage.Scrub(source), "missing_chunks", len(missing))
+		repaired, repairErr := damaged.Repair(ctx, healthy, missing)
+		if repairErr != nil {
+			l.Warn("Some chunks could not be copied", "error", repairErr)
+		}
+
+		still, err := damaged.Verify(ctx)
+		if err != nil {
+			return fmt.Errorf("verify after repair failed: %w", err)
+		}
+		if len(still) > 0 {
+			return fmt.Errorf("repair incomplete: %d of %d chunks still missing", len(still), len(missing))
+		}
+
+		l.Info("Repair complete. All chunks are present.", "repaired_chunks", repaired)
+		return nil
+	},
+}
+
+// openDedupe opens uri and requires it to be a deduplicated target.
+func openDedupe(uri string, allowInsecure bool) (*storage.DedupeStorage, error) {
+	s, err := storage.FromURI(uri, storage.StorageOptions{AllowInsecure: allowInsecure})
+	if err != nil {
+		return nil, err
+	}
+	ds, ok := s.(*storage.DedupeStorage)
+	if !ok {
+		s.Close()
+		return nil, fmt.Errorf("%s is not a deduplicated target (use dedupe://?target=...)", storage.Scrub(uri))
+	}
+	return ds, nil
+}
+
+func init() {
+	rootCmd.AddCommand(repairCmd)
+	repairCmd.Flags().String("from", "", "Healthy storage target to copy chunks from (e.g. dedupe://?target=s3://bucket/backups)")
+	repairCmd.Flags().String("to", "", "Damaged storage target to repair (e.g. dedupe://?target=./backups)")
+}
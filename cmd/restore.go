@@ -8,7 +8,7 @@ import (
 	"sync"
 
 	"github.com/lupppig/dbackup/internal/backup"
-	"github.com/lupppig/dbackup/internal/config"
+	compresspkg "github.com/lupppig/dbackup/internal/compress"
 	database "github.com/lupppig/dbackup/internal/db"
 	"github.com/lupppig/dbackup/internal/logger"
 	"github.com/lupppig/dbackup/internal/manifest"
@@ -18,8 +18,20 @@ import (
 )
 
 var (
-	restoreAuto   bool
-	restoreDryRun bool
+	restoreAuto       bool
+	restoreDryRun     bool
+	restoreVerifyOnly bool
+	restoreOnConflict string
+	restoreOverwrite  bool
+	restoreCombine    bool
+	restoreNoManifest bool
+	restoreAlgo       string
+	restoreManifest   string
+	restoreExtraArgs  []string
+	restoreDataDir    string
+	postRestoreCheck  string
+	postRestoreExpect string
+	intoNewDB         string
 )
 
 var restoreCmd = &cobra.Command{
@@ -34,6 +46,25 @@ and streams it directly into the database engine.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		l := logger.FromContext(cmd.Context())
 
+		if err := checkOnConflict(restoreOnConflict); err != nil {
+			return err
+		}
+		if err := compresspkg.ValidateAlgorithm(restoreAlgo); err != nil {
+			return err
+		}
+		if restoreDataDir != "" && !confirmRestore {
+			return fmt.Errorf("--datadir overwrites the MySQL data directory and requires --confirm-restore")
+		}
+		if postRestoreExpect != "" && postRestoreCheck == "" {
+			return fmt.Errorf("--post-restore-expect requires --post-restore-check")
+		}
+		if postRestoreCheck != "" && mysqlPhysical {
+			return fmt.Errorf("--post-restore-check runs after logical restores only; a physical restore leaves the server stopped")
+		}
+		if intoNewDB != "" && mysqlPhysical {
+			return fmt.Errorf("--into-new-db needs a logical backup; a physical restore replaces the whole server")
+		}
+
 		if from != "" {
 			target = from
 		}
@@ -42,30 +73,61 @@ and streams it directly into the database engine.`,
 			target = "."
 		}
 
-		var notifier notify.Notifier = notify.BuildNotifier(config.GetConfig())
-		if SlackWebhook != "" {
-			sn := notify.NewSlackNotifier(SlackWebhook, "")
-			if notifier != nil {
-				if mn, ok := notifier.(*notify.MultiNotifier); ok {
-					mn.Notifiers = append(mn.Notifiers, sn)
-				} else {
-					notifier = &notify.MultiNotifier{Notifiers: []notify.Notifier{notifier, sn}}
-				}
-			} else {
-				notifier = sn
-			}
+		pass, err := dbPassword(cmd)
+		if err != nil {
+			return err
 		}
 
+		notifier := buildNotifier()
+
 		// Handle positional engine for restore
 		if len(args) > 0 {
 			firstArg := strings.ToLower(args[0])
 			switch firstArg {
-			case "postgres", "postgresql", "mysql", "sqlite":
+			case "postgres", "postgresql", "mysql", "sqlite", "cassandra", "scylla":
 				dbType = firstArg
 				args = args[1:]
 			}
 		}
 
+		if restoreManifest != "" {
+			if restoreAuto || len(args) > 0 {
+				return fmt.Errorf("--manifest restores a single backup; pass the database with --db-uri or connection flags")
+			}
+			if restoreNoManifest {
+				return fmt.Errorf("--no-manifest and --manifest are mutually exclusive")
+			}
+			man, err := backup.ReadManifestFile(restoreManifest)
+			if err != nil {
+				return err
+			}
+			connParams := database.ConnectionParams{
+				DBType:   dbType,
+				Host:     host,
+				User:     user,
+				Port:     port,
+				Password: pass,
+				DBName:   dbName,
+				DBUri:    dbURI,
+				TLS: database.TLSConfig{
+					Enabled:    tlsEnabled,
+					Mode:       tlsMode,
+					CACert:     tlsCACert,
+					ClientCert: tlsClientCert,
+					ClientKey:  tlsClientKey,
+				},
+				IsPhysical: mysqlPhysical,
+			}
+			if connParams.DBType == "" && dbURI == "" {
+				connParams.DBType = man.Engine
+			}
+			return doRestore(cmd, l, connParams, restoreManifest, notifier)
+		}
+
+		if restoreNoManifest && (restoreAuto || (len(args) == 0 && fileName == "")) {
+			return fmt.Errorf("--no-manifest needs the raw backup file to restore (--name)")
+		}
+
 		if restoreAuto || (len(args) == 0 && fileName == "") {
 			if len(args) > 0 {
 				return fmt.Errorf("extra arguments provided with auto-restore: %v", args)
@@ -96,7 +158,7 @@ and streams it directly into the database engine.`,
 			})
 
 			for _, file := range files {
-				if !strings.HasSuffix(file, ".manifest") {
+				if !strings.HasSuffix(file, ".manifest") || manifest.IsLatest(file) || manifest.IsWAL(file) {
 					continue
 				}
 
@@ -112,6 +174,11 @@ and streams it directly into the database engine.`,
 					continue
 				}
 
+				// Pruned backups only have a tombstone manifest left
+				if m.Pruned {
+					continue
+				}
+
 				// Engine Filter
 				if dbType != "" && !strings.EqualFold(m.Engine, dbType) {
 					continue
@@ -130,6 +197,9 @@ and streams it directly into the database engine.`,
 				l.Info("No applicable manifests found in storage")
 				return nil
 			}
+			if intoNewDB != "" && len(latestBackups) > 1 {
+				return fmt.Errorf("--into-new-db restores a single backup, but %d databases were found; pick one with --name or --db-type", len(latestBackups))
+			}
 
 			l.Info(fmt.Sprintf("Found %d unique database(s) to restore", len(latestBackups)))
 
@@ -152,7 +222,7 @@ and streams it directly into the database engine.`,
 						Host:     host,
 						Port:     port,
 						User:     user,
-						Password: password,
+						Password: pass,
 						TLS: database.TLSConfig{
 							Enabled:    tlsEnabled,
 							Mode:       tlsMode,
@@ -195,7 +265,7 @@ and streams it directly into the database engine.`,
 				Host:     host,
 				User:     user,
 				Port:     port,
-				Password: password,
+				Password: pass,
 				DBName:   dbName,
 				DBUri:    dbURI,
 				TLS: database.TLSConfig{
@@ -246,7 +316,7 @@ and streams it directly into the database engine.`,
 					Host:     host,
 					Port:     port,
 					User:     user,
-					Password: password,
+					Password: pass,
 					DBName:   dbName,
 					DBUri:    mURI,
 					TLS: database.TLSConfig{
@@ -294,6 +364,14 @@ func doRestore(cmd *cobra.Command, l *logger.Logger, connParams database.Connect
 	if err := connParams.ParseURI(); err != nil {
 		return fmt.Errorf("failed to parse URI: %w", err)
 	}
+	connParams.OnConflict = restoreOnConflict
+	connParams.OverwriteExisting = restoreOverwrite
+	connParams.ExtraArgs = restoreExtraArgs
+	connParams.StagingDir = tmpDir
+	connParams.DataDir = restoreDataDir
+	connParams.PostRestoreCheck = postRestoreCheck
+	connParams.PostRestoreExpect = postRestoreExpect
+	connParams.IntoNewDB = intoNewDB
 
 	if connParams.DBType == "" {
 		// Try to infer from manifest name? risky. let's require it via flags or URI.
@@ -304,29 +382,43 @@ func doRestore(cmd *cobra.Command, l *logger.Logger, connParams database.Connect
 		}
 	}
 
+	algo := "lz4" // Default to lz4
+	if restoreNoManifest {
+		algo = restoreAlgo // Empty means sniff the file's content
+	}
+
+	startPing, startAfter := notifyStartOptions()
 	mgr, err := backup.NewRestoreManager(backup.BackupOptions{
 		DBType:               connParams.DBType,
 		DBName:               connParams.DBName,
 		StorageURI:           target,
-		Compress:             true,  // Default to true during restore
-		Algorithm:            "lz4", // Default to lz4
+		Compress:             true, // Default to true during restore
+		Algorithm:            algo,
 		FileName:             mName,
 		AllowInsecure:        AllowInsecure,
+		TmpDir:               tmpDir,
+		ProgressRefresh:      progressRefresh,
 		Encrypt:              encrypt,
 		EncryptionKeyFile:    encryptionKeyFile,
 		EncryptionPassphrase: encryptionPassphrase,
 		ConfirmRestore:       confirmRestore,
 		DryRun:               restoreDryRun,
+		VerifyOnly:           restoreVerifyOnly,
+		NoManifest:           restoreNoManifest,
+		ManifestFile:         restoreManifest,
+		CombineBackup:        restoreCombine,
 		Audit:                Audit,
 		Logger:               l,
 		Notifier:             notifier,
+		NotifyOnStart:        startPing,
+		NotifyStartAfter:     startAfter,
 	})
 	if err != nil {
 		return err
 	}
 
 	if !cmd.Flags().Changed("dedupe") {
-		dedupe = true // Default to true
+		dedupe = !restoreNoManifest // Default to true; raw files were never chunked
 	}
 
 	if dedupe {
@@ -342,6 +434,8 @@ func doRestore(cmd *cobra.Command, l *logger.Logger, connParams database.Connect
 		adapter = &database.MysqlAdapter{}
 	case "sqlite":
 		adapter = &database.SqliteAdapter{}
+	case "cassandra", "scylla":
+		adapter = &database.CassandraAdapter{}
 	default:
 		return fmt.Errorf("unsupported database type: %s", connParams.DBType)
 	}
@@ -359,7 +453,17 @@ func doRestore(cmd *cobra.Command, l *logger.Logger, connParams database.Connect
 		runner = database.NewDryRunRunner(l)
 	}
 
-	if err := adapter.TestConnection(cmd.Context(), connParams, runner); err != nil {
+	if restoreVerifyOnly {
+		l.Info("Verifying restorability (no changes will be applied)", "engine", connParams.DBType, "file", mName)
+		return mgr.Run(cmd.Context(), adapter, connParams)
+	}
+	if restoreCombine {
+		// The server is stopped while its data directory is rebuilt
+		l.Info("Restoring incremental chain", "file", mName)
+		return mgr.Run(cmd.Context(), adapter, connParams)
+	}
+
+	if err := testConnection(cmd.Context(), l, adapter, connParams, runner); err != nil {
 		return err
 	}
 
@@ -378,6 +482,18 @@ func doRestore(cmd *cobra.Command, l *logger.Logger, connParams database.Connect
 	return nil
 }
 
+// checkOnConflict validates --on-conflict. Dropping the target database is
+// refused without --confirm-restore even before any backup is fetched.
+func checkOnConflict(strategy string) error {
+	if err := database.ValidateOnConflict(strategy); err != nil {
+		return err
+	}
+	if strategy == database.ConflictRecreate && !confirmRestore {
+		return fmt.Errorf("--on-conflict recreate drops the target database and requires --confirm-restore")
+	}
+	return nil
+}
+
 func init() {
 	rootCmd.AddCommand(restoreCmd)
 
@@ -385,5 +501,17 @@ func init() {
 	restoreCmd.Flags().StringVarP(&from, "from", "f", "", "unified source URI for restore (alias for --to)")
 	restoreCmd.Flags().BoolVarP(&restoreAuto, "auto", "a", false, "automatically restore latest backups (default if no manifest is specified)")
 	restoreCmd.Flags().BoolVar(&restoreDryRun, "dry-run", false, "simulation mode (don't actually run restore)")
+	restoreCmd.Flags().BoolVar(&restoreVerifyOnly, "verify-only", false, "download, verify, decrypt and decompress the backup without applying it")
 	restoreCmd.Flags().BoolVar(&mysqlPhysical, "mysql-physical", false, "use physical backup mode for MySQL restores")
+	restoreCmd.Flags().StringVar(&restoreDataDir, "datadir", "", "with --mysql-physical, copy the prepared backup into this (empty) MySQL data directory; requires --confirm-restore")
+	restoreCmd.Flags().BoolVar(&restoreNoManifest, "no-manifest", false, "restore a raw file (--name) without looking up its manifest; integrity verification is skipped")
+	restoreCmd.Flags().StringVar(&restoreManifest, "manifest", "", "restore using this local manifest file instead of the one on the target (e.g. a saved copy after the target lost it)")
+	restoreCmd.Flags().StringVar(&restoreAlgo, "compression-algo", "", "with --no-manifest, the file's compression (gzip, zstd, lz4, tar, none); detected from its content when unset")
+	restoreCmd.Flags().StringArrayVar(&restoreExtraArgs, "restore-extra-args", nil, "extra argument for the engine's restore client (psql, mysql), repeatable; passed through unchecked")
+	restoreCmd.Flags().StringVar(&postRestoreCheck, "post-restore-check", "", "SQL query run against the database after a logical restore, e.g. \"SELECT count(*) FROM users\"; the restore fails if it errors")
+	restoreCmd.Flags().StringVar(&postRestoreExpect, "post-restore-expect", "", "with --post-restore-check, the value the first column of the first row must have")
+	restoreCmd.Flags().StringVar(&intoNewDB, "into-new-db", "", "restore a logical backup into this database instead of the one it was taken from, creating it if missing (a file path for sqlite)")
+	restoreCmd.Flags().StringVar(&restoreOnConflict, "on-conflict", "", "what to do when the target database has data: clean, recreate or fail (default: restore on top)")
+	restoreCmd.Flags().BoolVar(&restoreOverwrite, "overwrite-existing", false, "let a SQLite restore replace a database file that already has data")
+	restoreCmd.Flags().BoolVar(&restoreCombine, "combine-backup", false, "restore a PostgreSQL 17+ incremental backup: unpack it and every backup it builds on, and combine them into $PGDATA with pg_combinebackup")
 }
@@ -2,10 +2,21 @@ package cmd
 
 import (
 	"context"
+	"io"
+	"log/slog"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/lupppig/dbackup/internal/config"
+	"github.com/lupppig/dbackup/internal/crypto"
+	apperrors "github.com/lupppig/dbackup/internal/errors"
 	"github.com/lupppig/dbackup/internal/logger"
+	"github.com/lupppig/dbackup/internal/notify"
+	"github.com/lupppig/dbackup/internal/scheduler"
 	"github.com/spf13/cobra"
 )
 
@@ -22,17 +33,20 @@ var rootCmd = &cobra.Command{
 		return cmd.Help()
 	},
 	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		if _, err := logger.ParseLevel(LogLevel); err != nil {
+			return apperrors.Wrap(err, apperrors.TypeConfig, "invalid --log-level", "Use debug, info, warn or error.")
+		}
 		if encryptionPassphrase == "" {
 			encryptionPassphrase = os.Getenv("DBACKUP_KEY")
 		}
+		if tmpDir == "" {
+			tmpDir = os.Getenv("DBACKUP_TMP")
+		}
 		if err := config.Initialize(configFile); err != nil {
 			return err
 		}
 
-		l := logger.New(logger.Config{
-			JSON:    LogJSON,
-			NoColor: NoColor,
-		})
+		l := logger.New(logConfig())
 		cmd.SetContext(logger.WithContext(cmd.Context(), l))
 		return nil
 	},
@@ -45,6 +59,104 @@ var rootCmd = &cobra.Command{
 	dbackup version`,
 }
 
+// logConfig builds the logger configuration from the global logging flags.
+// --verbose wins over --log-level; an invalid level was rejected before.
+func logConfig() logger.Config {
+	level, _ := logger.ParseLevel(LogLevel)
+	if Verbose {
+		level = slog.LevelDebug
+	}
+	return logger.Config{
+		JSON:    LogJSON,
+		NoColor: NoColor,
+		File:    LogFile,
+		Quiet:   Quiet,
+		Level:   level,
+	}
+}
+
+// buildNotifier combines the notifiers from the config file with
+// --slack-webhook and --slack-template.
+func buildNotifier() notify.Notifier {
+	return notify.Build(config.GetConfig(), SlackWebhook, SlackTemplate)
+}
+
+// notifyStartOptions combines --notify-on-start and --notify-start-after
+// with the config file's notifications settings. A start delay implies the
+// started notification.
+func notifyStartOptions() (bool, time.Duration) {
+	n := config.GetConfig().Notifications
+	after := n.NotifyStartAfter
+	if notifyStartAfter > 0 {
+		after = notifyStartAfter
+	}
+	return notifyOnStart || n.NotifyOnStart || after > 0, after
+}
+
+// dbPassword returns the database password given by --password,
+// --password-file or --password-stdin; they are mutually exclusive. The
+// trailing newline of a file or stdin is trimmed.
+func dbPassword(cmd *cobra.Command) (string, error) {
+	given := 0
+	for _, set := range []bool{password != "", passwordFile != "", passwordStdin} {
+		if set {
+			given++
+		}
+	}
+	if given > 1 {
+		return "", apperrors.New(apperrors.TypeConfig, "--password, --password-file and --password-stdin are mutually exclusive", "Pass the password one way.")
+	}
+
+	var data []byte
+	var err error
+	switch {
+	case passwordFile != "":
+		if data, err = os.ReadFile(passwordFile); err != nil {
+			return "", apperrors.Wrap(err, apperrors.TypeConfig, "failed to read --password-file", "Check that "+passwordFile+" exists and is readable.")
+		}
+	case passwordStdin:
+		if data, err = io.ReadAll(cmd.InOrStdin()); err != nil {
+			return "", apperrors.Wrap(err, apperrors.TypeConfig, "failed to read the password from stdin", "Pipe the password in, e.g. 'cat pw.txt | dbackup ... --password-stdin'.")
+		}
+	default:
+		return password, nil
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// kdfOptions combines --kdf and --kdf-iterations with the config file's kdf
+// and kdf_iterations; flags win.
+func kdfOptions() (string, int) {
+	conf := config.GetConfig()
+	name, iterations := conf.KDF, conf.KDFIterations
+	if kdfName != "" {
+		name = kdfName
+	}
+	if kdfIterations != 0 {
+		iterations = kdfIterations
+	}
+	return name, iterations
+}
+
+// setKDF makes km derive the keys of the streams it encrypts with the KDF
+// kdfOptions selects.
+func setKDF(km *crypto.KeyManager) error {
+	kdf, err := crypto.ParseKDF(kdfOptions())
+	if err != nil {
+		return apperrors.Wrap(err, apperrors.TypeConfig, "invalid key derivation settings", "Use --kdf pbkdf2, scrypt or argon2id, and a --kdf-iterations the KDF accepts.")
+	}
+	return km.SetKDF(kdf)
+}
+
+// chunkIndexPath returns the file --dedupe-index keeps its chunk index in.
+func chunkIndexPath() (string, error) {
+	dir, err := scheduler.StateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "chunk-index.db"), nil
+}
+
 func ExecuteContext(ctx context.Context) error {
 	return rootCmd.ExecuteContext(ctx)
 }
@@ -52,6 +164,11 @@ func ExecuteContext(ctx context.Context) error {
 var (
 	LogJSON bool
 	NoColor bool
+	LogFile string
+	Quiet   bool
+	Verbose bool
+	// LogLevel is the minimum level logged: debug, info, warn or error
+	LogLevel string
 
 	configFile string
 	dbType     string
@@ -78,6 +195,7 @@ var (
 	dedupe     bool
 
 	SlackWebhook         string
+	SlackTemplate        string
 	Parallelism          int
 	AllowInsecure        bool
 	encrypt              bool
@@ -88,21 +206,46 @@ var (
 	retention string
 	keep      int
 	Audit     bool
+
+	connectRetries    int
+	connectRetryDelay time.Duration
+	tmpDir            string
+	progressRefresh   time.Duration
 )
 
+const maxConnectRetryDelay = 30 * time.Second
+
+var notifyOnStart bool
+var notifyStartAfter time.Duration
+
+var kdfName string
+var kdfIterations int
+
+var passwordFile string
+var passwordStdin bool
+
 func init() {
 	rootCmd.Version = DBACKUP_VERSION
 	rootCmd.SetVersionTemplate("dbackup version {{ .Version }}\n")
 
 	rootCmd.PersistentFlags().BoolVar(&LogJSON, "log-json", false, "output logs in JSON format")
 	rootCmd.PersistentFlags().BoolVar(&NoColor, "no-color", false, "disable colored terminal output")
+	rootCmd.PersistentFlags().StringVar(&LogFile, "log-file", "", "also write logs to this file (rotated by size)")
+	rootCmd.PersistentFlags().BoolVarP(&Quiet, "quiet", "q", false, "only print errors to the terminal (--log-file still receives all logs)")
+	rootCmd.PersistentFlags().BoolVarP(&Verbose, "verbose", "v", false, "log debug messages (same as --log-level debug)")
+	rootCmd.PersistentFlags().StringVar(&LogLevel, "log-level", "info", "minimum log level: debug, info, warn or error")
 	rootCmd.PersistentFlags().StringVar(&configFile, "config", "", "path to config file (default is $HOME/.dbackup/backup.yaml)")
 	rootCmd.PersistentFlags().StringVar(&SlackWebhook, "slack-webhook", "", "Slack Incoming Webhook URL for notifications")
+	rootCmd.PersistentFlags().StringVar(&SlackTemplate, "slack-template", "", "Go template for Slack messages, e.g. '{{.Operation}} of {{.Database}}: {{.Status}}' (overrides notifications.slack.template)")
+	rootCmd.PersistentFlags().BoolVar(&notifyOnStart, "notify-on-start", false, "also notify when a backup or restore starts (notifications.notify_on_start)")
+	rootCmd.PersistentFlags().DurationVar(&notifyStartAfter, "notify-start-after", 0, "send the start notification only once a run has lasted this long, e.g. 1m (implies --notify-on-start)")
 	rootCmd.PersistentFlags().IntVar(&Parallelism, "parallelism", 4, "Number of databases to back up/restore simultaneously")
 	rootCmd.PersistentFlags().BoolVar(&AllowInsecure, "allow-insecure", false, "Allow insecure protocols (like plain FTP)")
 	rootCmd.PersistentFlags().BoolVar(&encrypt, "encrypt", false, "Enable client-side encryption (AES-256-GCM)")
 	rootCmd.PersistentFlags().StringVar(&encryptionKeyFile, "encryption-key-file", "", "Path to the encryption key file")
 	rootCmd.PersistentFlags().StringVar(&encryptionPassphrase, "encryption-passphrase", "", "Passphrase for encryption key derivation")
+	rootCmd.PersistentFlags().StringVar(&kdfName, "kdf", "", "how new encrypted backups derive their key from the passphrase: pbkdf2 (default), scrypt or argon2id")
+	rootCmd.PersistentFlags().IntVar(&kdfIterations, "kdf-iterations", 0, "PBKDF2 iterations (default 600000) or argon2id passes (default 3)")
 	rootCmd.PersistentFlags().BoolVar(&confirmRestore, "confirm-restore", false, "Confirm destructive restore operations")
 	rootCmd.PersistentFlags().BoolVar(&Audit, "audit", false, "Enable tamper-evident audit logging for storage operations")
 
@@ -112,9 +255,15 @@ func init() {
 	rootCmd.PersistentFlags().StringVar(&host, "host", "", "database host")
 	rootCmd.PersistentFlags().StringVar(&user, "user", "", "database username")
 	rootCmd.PersistentFlags().StringVar(&password, "password", "", "database password")
+	rootCmd.PersistentFlags().StringVar(&passwordFile, "password-file", "", "read the database password from this file, keeping it out of shell history and ps")
+	rootCmd.PersistentFlags().BoolVar(&passwordStdin, "password-stdin", false, "read the database password from stdin")
 	rootCmd.PersistentFlags().IntVar(&port, "port", 0, "database port")
 	rootCmd.PersistentFlags().StringVar(&dbURI, "db-uri", "", "full database connection URI (overrides individual flags)")
-	rootCmd.PersistentFlags().StringVarP(&target, "to", "t", "", "unified targeting URI (e.g. ./local/path, sftp://user@host/path)")
+	rootCmd.PersistentFlags().StringVarP(&target, "to", "t", "", "unified targeting URI (e.g. ./local/path, sftp://user@host/path); comma-separate several to write to all of them")
+	rootCmd.PersistentFlags().IntVar(&connectRetries, "connect-retries", 0, "retry the database connection test this many times before giving up")
+	rootCmd.PersistentFlags().DurationVar(&connectRetryDelay, "connect-retry-delay", 2*time.Second, "initial delay between connection retries (doubles each attempt, max 30s)")
+	rootCmd.PersistentFlags().DurationVar(&progressRefresh, "progress-refresh", 0, "how often progress bars redraw, e.g. 500ms on slow terminals (default 150ms)")
+	rootCmd.PersistentFlags().StringVar(&tmpDir, "tmp-dir", "", "directory for restore workspaces and upload buffers (default $DBACKUP_TMP, then the system temp dir)")
 	rootCmd.PersistentFlags().BoolVar(&remoteExec, "remote-exec", false, "execute backup/restore tools on the remote storage host")
 	rootCmd.PersistentFlags().BoolVar(&dedupe, "dedupe", true, "Enable storage-level deduplication (CAS, default true)")
 
@@ -125,6 +274,14 @@ func init() {
 	rootCmd.PersistentFlags().StringVar(&tlsClientKey, "tls-client-key", "", "path to client private key for mutual TLS (mTLS)")
 }
 
+// NewContext returns a context cancelled on SIGINT or SIGTERM, so an
+// interrupted command stops its dump, upload and child processes.
+func NewContext(parent context.Context) (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(parent, os.Interrupt, syscall.SIGTERM)
+}
+
 func Execute() error {
-	return rootCmd.Execute()
+	ctx, stop := NewContext(context.Background())
+	defer stop()
+	return rootCmd.ExecuteContext(ctx)
 }
@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	database "github.com/lupppig/dbackup/internal/db"
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDBPassword(t *testing.T) {
+	t.Cleanup(func() { password, passwordFile, passwordStdin = "", "", false })
+	secret := "p@ss:w/rd"
+	conn := func(pass string) string {
+		t.Helper()
+		dsn, err := (&database.PostgresAdapter{}).BuildConnection(context.Background(), database.ConnectionParams{
+			DBType: "postgres", Host: "db", User: "app", DBName: "shop", Password: pass,
+		})
+		require.NoError(t, err)
+		return dsn
+	}
+
+	password = secret
+	inline, err := dbPassword(&cobra.Command{})
+	require.NoError(t, err)
+	want := conn(inline)
+
+	// A file's trailing newline, as editors and echo leave it, is not part of the password
+	file := filepath.Join(t.TempDir(), "pw")
+	require.NoError(t, os.WriteFile(file, []byte(secret+"\n"), 0600))
+	password, passwordFile = "", file
+	got, err := dbPassword(&cobra.Command{})
+	require.NoError(t, err)
+	assert.Equal(t, want, conn(got))
+
+	passwordFile, passwordStdin = "", true
+	c := &cobra.Command{}
+	c.SetIn(strings.NewReader(secret + "\r\n"))
+	got, err = dbPassword(c)
+	require.NoError(t, err)
+	assert.Equal(t, want, conn(got))
+
+	password = secret
+	_, err = dbPassword(&cobra.Command{})
+	assert.ErrorContains(t, err, "mutually exclusive")
+
+	password, passwordStdin, passwordFile = "", false, filepath.Join(t.TempDir(), "missing")
+	_, err = dbPassword(&cobra.Command{})
+	assert.ErrorContains(t, err, "--password-file")
+}
+
+func TestDaemonLogArgs(t *testing.T) {
+	flags := rootCmd.PersistentFlags()
+	t.Cleanup(func() {
+		for _, name := range []string{"log-file", "log-json", "log-level"} {
+			f := flags.Lookup(name)
+			f.Value.Set(f.DefValue) // #nosec G104
+			f.Changed = false
+		}
+	})
+
+	args, file, err := daemonLogArgs()
+	require.NoError(t, err)
+	assert.Empty(t, args)
+	assert.Empty(t, file)
+
+	require.NoError(t, flags.Set("log-file", "logs/daemon.log"))
+	require.NoError(t, flags.Set("log-json", "true"))
+	require.NoError(t, flags.Set("log-level", "debug"))
+	args, file, err = daemonLogArgs()
+	require.NoError(t, err)
+	abs, err := filepath.Abs("logs/daemon.log")
+	require.NoError(t, err)
+	assert.Equal(t, abs, file)
+	assert.Equal(t, []string{"--log-file=" + abs, "--log-json=true", "--log-level=debug"}, args)
+}
@@ -1,8 +1,9 @@
 package cmd
 
 import (
-	"context"
+	"errors"
 	"fmt"
+	"io/fs"
 	"os"
 	"os/exec"
 	"os/signal"
@@ -34,7 +35,7 @@ var scheduleBackupCmd = &cobra.Command{
 	Short: "Schedule a recurring backup",
 	Args:  cobra.ExactArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
-		l := logger.New(logger.Config{JSON: LogJSON, NoColor: NoColor})
+		l := logger.FromContext(cmd.Context())
 		engine := args[0]
 		s, err := scheduler.NewScheduler()
 		if err != nil {
@@ -71,6 +72,7 @@ var scheduleBackupCmd = &cobra.Command{
 				RetryDelay:           retryDelay,
 				Retention:            retention,
 				Keep:                 keep,
+				ExtraArgs:            dumpExtraArgs,
 			},
 		}
 
@@ -93,7 +95,7 @@ var scheduleRestoreCmd = &cobra.Command{
 	Short: "Schedule a recurring restore",
 	Args:  cobra.ExactArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
-		l := logger.New(logger.Config{JSON: LogJSON, NoColor: NoColor})
+		l := logger.FromContext(cmd.Context())
 		engine := args[0]
 		s, err := scheduler.NewScheduler()
 		if err != nil {
@@ -111,9 +113,16 @@ var scheduleRestoreCmd = &cobra.Command{
 			return fmt.Errorf("either --cron or --interval is required")
 		}
 
+		taskType := scheduler.RestoreTask
+		if restoreVerifyOnly {
+			taskType = scheduler.DrillTask
+		} else if err := checkOnConflict(restoreOnConflict); err != nil {
+			return err
+		}
+
 		task := &scheduler.ScheduledTask{
 			ID:        uuid.New().String(),
-			Type:      scheduler.RestoreTask,
+			Type:      taskType,
 			Engine:    engine,
 			SourceURI: from,
 			TargetURI: target,
@@ -124,6 +133,74 @@ var scheduleRestoreCmd = &cobra.Command{
 				EncryptionKeyFile:    encryptionKeyFile,
 				EncryptionPassphrase: "", // Never store
 				ConfirmRestore:       confirmRestore,
+				OnConflict:           restoreOnConflict,
+				OverwriteExisting:    restoreOverwrite,
+				ExtraArgs:            restoreExtraArgs,
+				Retries:              retries,
+				RetryDelay:           retryDelay,
+			},
+		}
+
+		if err := s.AddTask(task); err != nil {
+			return err
+		}
+
+		l.Info("Scheduled restore task added", "type", task.Type, "schedule", sched, "id", task.ID)
+
+		// Spawn background daemon if not already in daemon mode
+		if !daemonMode {
+			return spawnDaemon(l)
+		}
+		return nil
+	},
+}
+
+var scheduleDrillCmd = &cobra.Command{
+	Use:   "drill [engine]",
+	Short: "Schedule a recurring restore drill of the latest backup",
+	Long: `Periodically download, verify, decrypt and decompress the latest backup
+without applying it, and notify on failure. This catches silent corruption or
+lost encryption keys before a real restore is needed.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		l := logger.FromContext(cmd.Context())
+		engine := args[0]
+		s, err := scheduler.NewScheduler()
+		if err != nil {
+			return err
+		}
+		if err := s.Load(); err != nil {
+			return err
+		}
+
+		sched := cronSpec
+		if interval != "" {
+			sched = interval
+		}
+		if sched == "" {
+			return fmt.Errorf("either --cron or --interval is required")
+		}
+
+		source := from
+		if source == "" {
+			source = target
+		}
+		if source == "" {
+			return fmt.Errorf("--from or --to is required")
+		}
+
+		task := &scheduler.ScheduledTask{
+			ID:        uuid.New().String(),
+			Type:      scheduler.DrillTask,
+			Engine:    engine,
+			SourceURI: source,
+			Schedule:  sched,
+			Options: scheduler.TaskOptions{
+				DBType:               engine,
+				DBName:               dbName,
+				FileName:             fileName,
+				EncryptionKeyFile:    encryptionKeyFile,
+				EncryptionPassphrase: "", // Never store
 				Retries:              retries,
 				RetryDelay:           retryDelay,
 			},
@@ -133,7 +210,7 @@ var scheduleRestoreCmd = &cobra.Command{
 			return err
 		}
 
-		l.Info("Scheduled restore task added", "schedule", sched, "id", task.ID)
+		l.Info("Scheduled restore drill added", "schedule", sched, "id", task.ID)
 
 		// Spawn background daemon if not already in daemon mode
 		if !daemonMode {
@@ -148,7 +225,7 @@ var scheduleRemoveCmd = &cobra.Command{
 	Short: "Remove a scheduled task",
 	Args:  cobra.ExactArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
-		l := logger.New(logger.Config{JSON: LogJSON, NoColor: NoColor})
+		l := logger.FromContext(cmd.Context())
 		id := args[0]
 		s, err := scheduler.NewScheduler()
 		if err != nil {
@@ -163,7 +240,7 @@ var scheduleRemoveCmd = &cobra.Command{
 		}
 
 		l.Info("Task removed successfully", "id", id)
-		return nil
+		return signalReload(l)
 	},
 }
 
@@ -171,11 +248,53 @@ var scheduleStartCmd = &cobra.Command{
 	Use:   "start",
 	Short: "Start the scheduler daemon (internal use)",
 	RunE: func(cmd *cobra.Command, args []string) error {
-		l := logger.New(logger.Config{JSON: LogJSON, NoColor: NoColor})
+		cfg := logConfig()
+		if daemonMode && cfg.File == "" {
+			// A detached daemon has no terminal; keep its logs in the state dir
+			dir, err := scheduler.StateDir()
+			if err != nil {
+				return err
+			}
+			cfg.File = filepath.Join(dir, scheduler.LogFileName)
+		}
+		l := logger.New(cfg)
+		cmd.SetContext(logger.WithContext(cmd.Context(), l))
+
+		pid, err := scheduler.RunningPID()
+		if err != nil {
+			return err
+		}
+		if pid != 0 && pid != os.Getpid() {
+			return fmt.Errorf("scheduler daemon already running (pid %d)", pid)
+		}
+		if pid == os.Getpid() {
+			// Left by an earlier process that had our pid
+			if err := scheduler.RemovePIDFile(); err != nil {
+				return err
+			}
+		}
+		// Once the pidfile exists we may receive SIGHUP before the reload
+		// handler is installed; that must not kill us
+		signal.Ignore(syscall.SIGHUP)
+		if err := scheduler.WritePIDFile(os.Getpid()); err != nil {
+			if errors.Is(err, fs.ErrExist) {
+				// Another daemon started since RunningPID looked
+				pid, _ := scheduler.ReadPIDFile()
+				return fmt.Errorf("scheduler daemon already running (pid %d)", pid)
+			}
+			return fmt.Errorf("failed to write pidfile: %w", err)
+		}
+		defer func() {
+			if err := scheduler.RemovePIDFile(); err != nil {
+				l.Warn("Failed to remove pidfile", "error", err)
+			}
+		}()
+
 		s, err := scheduler.NewScheduler()
 		if err != nil {
 			return err
 		}
+		s.SetLogger(l)
 		if err := s.Load(); err != nil {
 			return err
 		}
@@ -190,9 +309,12 @@ var scheduleStartCmd = &cobra.Command{
 			}
 		}
 
-		sigCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		sigCtx, stop := NewContext(cmd.Context())
 		defer stop()
 
+		// spawnDaemon signals SIGHUP after adding a task to schedules.json
+		s.ReloadOnSignal(sigCtx, syscall.SIGHUP)
+
 		s.Start()
 		l.Info("Scheduler active. Press Ctrl+C to stop.")
 
@@ -215,7 +337,7 @@ var scheduleListCmd = &cobra.Command{
 	Use:   "list",
 	Short: "List all active schedules",
 	RunE: func(cmd *cobra.Command, args []string) error {
-		l := logger.New(logger.Config{JSON: LogJSON, NoColor: NoColor})
+		l := logger.FromContext(cmd.Context())
 		s, err := scheduler.NewScheduler()
 		if err != nil {
 			return err
@@ -235,29 +357,109 @@ var scheduleListCmd = &cobra.Command{
 			if t.NextRun != nil {
 				next = t.NextRun.Format("2006-01-02 15:04:05")
 			}
-			l.Info("Scheduled Task",
+			attrs := []any{
 				"id", t.ID,
 				"type", t.Type,
 				"status", t.Status,
 				"schedule", t.Schedule,
 				"next_run", next,
-			)
+			}
+			if t.LastRun != nil {
+				attrs = append(attrs, "last_run", t.LastRun.Format("2006-01-02 15:04:05"), "last_result", t.LastResult)
+			}
+			if t.LastError != "" {
+				attrs = append(attrs, "last_error", t.LastError)
+			}
+			l.Info("Scheduled Task", attrs...)
 		}
 		return nil
 	},
 }
 
+var scheduleStopCmd = &cobra.Command{
+	Use:   "stop",
+	Short: "Stop the background scheduler daemon",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		l := logger.FromContext(cmd.Context())
+		pid, err := scheduler.RunningPID()
+		if err != nil {
+			return err
+		}
+		if pid == 0 {
+			l.Info("Scheduler daemon is not running")
+			return nil
+		}
+
+		proc, err := os.FindProcess(pid)
+		if err != nil {
+			return fmt.Errorf("failed to find daemon process %d: %w", pid, err)
+		}
+		if err := proc.Signal(syscall.SIGTERM); err != nil {
+			return fmt.Errorf("failed to signal daemon process %d: %w", pid, err)
+		}
+
+		// The daemon removes its own pidfile once running tasks have drained
+		l.Info("Stop signal sent to scheduler daemon", "pid", pid)
+		return nil
+	},
+}
+
+// signalReload asks a running daemon, if any, to reload schedules.json.
+func signalReload(l *logger.Logger) error {
+	pid, err := scheduler.RunningPID()
+	if err != nil || pid == 0 {
+		return err
+	}
+	proc, err := os.FindProcess(pid)
+	if err != nil {
+		return fmt.Errorf("failed to find daemon process %d: %w", pid, err)
+	}
+	if err := proc.Signal(syscall.SIGHUP); err != nil {
+		return fmt.Errorf("failed to signal daemon process %d: %w", pid, err)
+	}
+	l.Info("Signaled scheduler daemon to reload", "pid", pid)
+	return nil
+}
+
 func spawnDaemon(l *logger.Logger) error {
+	pid, err := scheduler.RunningPID()
+	if err != nil {
+		return err
+	}
+	if pid != 0 {
+		// Already running: ask it to pick up the updated schedules.json instead
+		return signalReload(l)
+	}
+
 	exe, err := os.Executable()
 	if err != nil {
 		return fmt.Errorf("failed to get executable path: %w", err)
 	}
 
+	stateDir, err := scheduler.StateDir()
+	if err != nil {
+		return err
+	}
+	outPath := filepath.Join(stateDir, scheduler.OutputFileName)
+	out, err := os.OpenFile(outPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open daemon output file: %w", err)
+	}
+	defer out.Close()
+
+	logArgs, logPath, err := daemonLogArgs()
+	if err != nil {
+		return err
+	}
+	if logPath == "" {
+		logPath = filepath.Join(stateDir, scheduler.LogFileName)
+	}
+
 	// Run `dbackup schedule start` in background
-	cmd := exec.Command(exe, "schedule", "start", "--daemon")
+	cmd := exec.Command(exe, append([]string{"schedule", "start", "--daemon"}, logArgs...)...)
 	cmd.Dir = filepath.Dir(exe)
-	cmd.Stdout = nil
-	cmd.Stderr = nil
+	cmd.Stdout = out
+	cmd.Stderr = out
 	cmd.Stdin = nil
 	cmd.SysProcAttr = &syscall.SysProcAttr{
 		Setsid: true, // Create a new session (detach from terminal)
@@ -267,24 +469,52 @@ func spawnDaemon(l *logger.Logger) error {
 		return fmt.Errorf("failed to start daemon: %w", err)
 	}
 
-	l.Info("Scheduler daemon started", "pid", cmd.Process.Pid)
+	l.Info("Scheduler daemon started",
+		"pid", cmd.Process.Pid,
+		"log", logPath,
+		"output", outPath,
+	)
 	return nil
 }
 
+// daemonLogArgs repeats the logging flags given to this command, so the
+// daemon logs at the level, in the format and to the file asked for. The
+// daemon runs from another directory, so --log-file is made absolute and
+// returned as well.
+func daemonLogArgs() (args []string, logFile string, err error) {
+	for _, name := range []string{"log-file", "log-json", "log-level", "verbose", "quiet", "no-color"} {
+		f := rootCmd.PersistentFlags().Lookup(name)
+		if f == nil || !f.Changed {
+			continue
+		}
+		v := f.Value.String()
+		if name == "log-file" && v != "" {
+			if v, err = filepath.Abs(v); err != nil {
+				return nil, "", err
+			}
+			logFile = v
+		}
+		args = append(args, "--"+name+"="+v)
+	}
+	return args, logFile, nil
+}
+
 func init() {
 	rootCmd.AddCommand(scheduleCmd)
 	scheduleCmd.AddCommand(scheduleBackupCmd)
 	scheduleCmd.AddCommand(scheduleRestoreCmd)
+	scheduleCmd.AddCommand(scheduleDrillCmd)
 	scheduleCmd.AddCommand(scheduleRemoveCmd)
 	scheduleCmd.AddCommand(scheduleStartCmd)
 	scheduleCmd.AddCommand(scheduleListCmd)
+	scheduleCmd.AddCommand(scheduleStopCmd)
 
 	// Hidden flag for daemon mode
 	scheduleStartCmd.Flags().BoolVar(&daemonMode, "daemon", false, "Run in daemon mode (internal)")
 	scheduleStartCmd.Flags().MarkHidden("daemon") // #nosec G104
 
-	for _, c := range []*cobra.Command{scheduleBackupCmd, scheduleRestoreCmd} {
-		c.Flags().StringVar(&cronSpec, "cron", "", "Cron schedule (e.g. \"0 2 * * *\")")
+	for _, c := range []*cobra.Command{scheduleBackupCmd, scheduleRestoreCmd, scheduleDrillCmd} {
+		c.Flags().StringVar(&cronSpec, "cron", "", "Cron schedule (e.g. \"0 2 * * *\", \"*/30 * * * * *\" with seconds, \"@reboot\")")
 		c.Flags().StringVar(&interval, "interval", "", "Interval schedule (e.g. \"1h\", \"30m\")")
 		c.Flags().IntVar(&retries, "retries", 3, "Number of retries on failure")
 		c.Flags().StringVar(&retryDelay, "retry-delay", "5m", "Delay between retries")
@@ -294,7 +524,16 @@ func init() {
 	scheduleBackupCmd.Flags().StringVar(&fileName, "name", "", "custom backup file name")
 	scheduleBackupCmd.Flags().StringVar(&retention, "retention", "", "retention period (e.g. 7d, 24h)")
 	scheduleBackupCmd.Flags().IntVar(&keep, "keep", 0, "number of backups to keep")
+	scheduleBackupCmd.Flags().StringArrayVar(&dumpExtraArgs, "dump-extra-args", nil, "extra argument for the engine's dump tool (pg_dump, pg_basebackup, mysqldump, xtrabackup), repeatable; passed through unchecked")
 
 	// Schedule Restore specific
 	scheduleRestoreCmd.Flags().StringVar(&fileName, "name", "", "custom backup file name to restore")
+	scheduleRestoreCmd.Flags().BoolVar(&restoreVerifyOnly, "verify-only", false, "schedule a restore drill instead (same as 'schedule drill')")
+	scheduleRestoreCmd.Flags().StringVar(&restoreOnConflict, "on-conflict", "", "what to do when the target database has data: clean, recreate or fail")
+	scheduleRestoreCmd.Flags().BoolVar(&restoreOverwrite, "overwrite-existing", false, "let a SQLite restore replace a database file that already has data")
+	scheduleRestoreCmd.Flags().StringArrayVar(&restoreExtraArgs, "restore-extra-args", nil, "extra argument for the engine's restore client (psql, mysql), repeatable; passed through unchecked")
+
+	// Schedule Drill specific
+	scheduleDrillCmd.Flags().StringVarP(&from, "from", "f", "", "storage URI holding the backups to verify (defaults to --to)")
+	scheduleDrillCmd.Flags().StringVar(&fileName, "name", "", "backup manifest to verify (default latest.manifest)")
 }
@@ -24,9 +24,7 @@ var uiCmd = &cobra.Command{
 	Use:   "ui",
 	Short: "Start the dbackup web UI and documentation server",
 	Run: func(cmd *cobra.Command, args []string) {
-		l := logger.New(logger.Config{
-			JSON: rootCmd.Flag("log-json").Value.String() == "true",
-		})
+		l := logger.New(logConfig())
 
 		uiSubFS, err := web.GetUIFS()
 		if err != nil {
@@ -1,7 +1,6 @@
 package cmd
 
 import (
-	"context"
 	"fmt"
 	"os"
 
@@ -16,6 +15,10 @@ var verifyCmd = &cobra.Command{
 	RunE: func(cmd *cobra.Command, args []string) error {
 		target, _ := cmd.Flags().GetString("to")
 		allowInsecure, _ := cmd.Flags().GetBool("allow-insecure")
+		sample, _ := cmd.Flags().GetFloat64("sample")
+		if sample < 0 || sample > 1 {
+			return fmt.Errorf("--sample must be between 0 and 1, e.g. 0.05 for 5%%")
+		}
 
 		s, err := storage.FromURI(target, storage.StorageOptions{AllowInsecure: allowInsecure})
 		if err != nil {
@@ -31,12 +34,23 @@ var verifyCmd = &cobra.Command{
 		}
 
 		l.Info("Verifying integrity...", "target", target)
-		missing, err := ds.Verify(context.Background())
+		var missing []string
+		if sample == 0 {
+			missing, err = ds.Verify(cmd.Context())
+		} else {
+			var report storage.VerifyReport
+			report, err = ds.VerifySample(cmd.Context(), sample)
+			missing = report.Missing
+			l.Info("Sampled chunks", "checked", report.Checked, "referenced", report.Referenced,
+				"coverage", fmt.Sprintf("%.1f%%", report.Coverage()*100), "latest_in_full", report.Latest)
+		}
 		if err != nil {
 			return fmt.Errorf("verify failed: %w", err)
 		}
 
-		if len(missing) == 0 {
+		if len(missing) == 0 && sample != 0 {
+			l.Info("Sampled integrity check passed. No missing chunks found; unchecked chunks may still be missing.")
+		} else if len(missing) == 0 {
 			l.Info("Integrity check passed. All chunks are present.")
 		} else {
 			l.Error("Integrity check failed!", "missing_chunks", len(missing))
@@ -57,4 +71,5 @@ var verifyCmd = &cobra.Command{
 func init() {
 	rootCmd.AddCommand(verifyCmd)
 	verifyCmd.Flags().String("to", "", "Storage target (e.g. dedupe://local://./backups)")
+	verifyCmd.Flags().Float64("sample", 0, "check only this fraction of chunks (e.g. 0.05), plus every chunk of the newest backup; 0 checks them all")
 }
@@ -23,6 +23,8 @@ require (
 	github.com/testcontainers/testcontainers-go v0.40.0
 	github.com/vbauerster/mpb/v8 v8.11.3
 	golang.org/x/crypto v0.47.0
+	gopkg.in/natefinch/lumberjack.v2 v2.2.1
+	lukechampine.com/blake3 v1.4.1
 )
 
 require (
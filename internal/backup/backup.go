@@ -1,11 +1,14 @@
 package backup
 
 import (
+	"bytes"
 	"context"
-	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"os"
+	"path/filepath"
 	"strings"
 	"time"
 
@@ -16,16 +19,23 @@ import (
 	"github.com/lupppig/dbackup/internal/manifest"
 	"github.com/lupppig/dbackup/internal/notify"
 	"github.com/lupppig/dbackup/internal/storage"
+	"github.com/vbauerster/mpb/v8"
 )
 
 type BackupManager struct {
-	Options BackupOptions
-	storage storage.Storage
+	Options  BackupOptions
+	storage  storage.Storage
+	prune    PruneSummary
+	manifest string
 }
 
 func NewBackupManager(opts BackupOptions) (*BackupManager, error) {
+	if _, err := opts.kdf(); err != nil {
+		return nil, err
+	}
 	s, err := storage.FromURI(opts.StorageURI, storage.StorageOptions{
 		AllowInsecure: opts.AllowInsecure,
+		TmpDir:        opts.TmpDir,
 	})
 	if err != nil {
 		return nil, err
@@ -33,7 +43,10 @@ func NewBackupManager(opts BackupOptions) (*BackupManager, error) {
 
 	// Wrap with dedupe storage for incremental backups
 	if opts.Dedupe {
-		s = storage.NewDedupeStorage(s)
+		s, err = WrapDedupe(s, opts)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	// Wrap with audit storage for tamper-evident logging
@@ -47,6 +60,73 @@ func NewBackupManager(opts BackupOptions) (*BackupManager, error) {
 	}, nil
 }
 
+// streamKeyManager returns the key manager that encrypts whole backup
+// streams, deriving passphrase keys with the configured KDF.
+func (o BackupOptions) streamKeyManager() (*crypto.KeyManager, error) {
+	km, err := crypto.NewKeyManager(o.EncryptionPassphrase, o.EncryptionKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	kdf, err := o.kdf()
+	if err != nil {
+		return nil, err
+	}
+	if err := km.SetKDF(kdf); err != nil {
+		return nil, err
+	}
+	return km, nil
+}
+
+func (o BackupOptions) kdf() (crypto.KDFParams, error) {
+	kdf, err := crypto.ParseKDF(o.KDF, o.KDFIterations)
+	if err != nil {
+		return kdf, apperrors.Wrap(err, apperrors.TypeConfig, "invalid key derivation settings", "Use --kdf pbkdf2, scrypt or argon2id, and a --kdf-iterations the KDF accepts.")
+	}
+	return kdf, nil
+}
+
+// WrapDedupe wraps s in a DedupeStorage, enabling per-chunk encryption when
+// opts.EncryptChunks is set and the local chunk index when opts.DedupeIndex is.
+// opts.DedupeAlgo picks the chunker.
+func WrapDedupe(s storage.Storage, opts BackupOptions) (storage.Storage, error) {
+	dopts := storage.DedupeOptions{Algorithm: opts.DedupeAlgo}
+	if opts.EncryptChunks {
+		km, err := crypto.NewKeyManager(opts.EncryptionPassphrase, opts.EncryptionKeyFile)
+		if err != nil {
+			return nil, apperrors.Wrap(err, apperrors.TypeConfig, "chunk encryption needs a key", "Use --encryption-passphrase or --encryption-key-file.")
+		}
+		dopts.Encrypt, dopts.KeyManager = true, km
+	}
+	if opts.DedupeIndex != "" {
+		idx, err := storage.OpenChunkIndex(opts.DedupeIndex)
+		if err != nil {
+			return nil, apperrors.Wrap(err, apperrors.TypeResource, "failed to open the dedupe index", "Check the permissions of "+opts.DedupeIndex+", or delete it to start a new one.")
+		}
+		dopts.Index = idx
+	}
+	return storage.NewDedupeStorageWithOptions(s, dopts)
+}
+
+// Pruned returns the old backups removed by retention after the last Run.
+func (m *BackupManager) Pruned() []string {
+	if m.prune.DryRun {
+		return nil
+	}
+	return m.prune.Pruned
+}
+
+// PruneSummary describes the retention pass of the last Run, including what
+// a dry run would have removed.
+func (m *BackupManager) PruneSummary() PruneSummary {
+	return m.prune
+}
+
+// ManifestName returns the manifest the last Run wrote, or "" if it wrote
+// none, such as when --if-changed skipped the backup.
+func (m *BackupManager) ManifestName() string {
+	return m.manifest
+}
+
 func (m *BackupManager) GetStorage() storage.Storage {
 	return m.storage
 }
@@ -55,8 +135,23 @@ func (m *BackupManager) SetStorage(s storage.Storage) {
 	m.storage = s
 }
 
+// errMaxDuration is the cause of a backup's context when MaxDuration ran out.
+var errMaxDuration = errors.New("exceeded max duration")
+
 func (m *BackupManager) Run(ctx context.Context, adapter database.DBAdapter, conn database.ConnectionParams) (err error) {
 	start := time.Now()
+	m.prune = PruneSummary{}
+	m.manifest = ""
+
+	// The deadline covers the whole run, dump and upload; notifications and
+	// cleanup after an overrun still need a live context
+	parent := ctx
+	if m.Options.MaxDuration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, m.Options.MaxDuration, errMaxDuration)
+		defer cancel()
+	}
+
 	if err := conn.ParseURI(); err != nil {
 		if m.Options.Logger != nil {
 			m.Options.Logger.Warn("Failed to parse DB URI", "error", err)
@@ -68,6 +163,12 @@ func (m *BackupManager) Run(ctx context.Context, adapter database.DBAdapter, con
 	}
 
 	name := m.Options.FileName
+	if name == "" && m.Options.NameTemplate != "" {
+		var err error
+		if name, err = expandNameTemplate(m.Options.NameTemplate, conn, time.Now()); err != nil {
+			return err
+		}
+	}
 	if name == "" {
 		prefix := strings.ToLower(conn.DBType)
 		if prefix == "" {
@@ -89,114 +190,269 @@ func (m *BackupManager) Run(ctx context.Context, adapter database.DBAdapter, con
 	}
 
 	finalName := name
-	if m.Options.Compress && algo != compress.None {
-		switch algo {
-		case compress.Gzip:
-			finalName += ".gz"
-		case compress.Lz4:
-			finalName += ".lz4"
-		case compress.Zstd:
-			finalName += ".zst"
-		case compress.Tar:
-			finalName += ".tar"
-		}
+	if m.Options.Compress {
+		finalName += compress.Extension(algo)
 	}
 
-	// Stats for notification
+	// Stats for notification, filled in once the backup has been stored
+	var logicalSize, storedSize int64
 	defer func() {
 		if m.Options.Notifier != nil {
 			status := notify.StatusSuccess
 			if err != nil {
 				status = notify.StatusError
 			}
-			m.Options.Notifier.Notify(ctx, notify.Stats{ // #nosec G104
-				Status:    status,
-				Operation: "Backup",
-				Engine:    conn.DBType,
-				Database:  conn.DBName,
-				FileName:  finalName,
-				Duration:  time.Since(start),
-				Error:     err,
+			m.Options.Notifier.Notify(parent, notify.Stats{ // #nosec G104
+				Status:      status,
+				Operation:   "Backup",
+				Engine:      conn.DBType,
+				Database:    conn.DBName,
+				FileName:    finalName,
+				Note:        manifest.SanitizeNote(m.Options.Note),
+				Size:        storedSize,
+				LogicalSize: logicalSize,
+				Duration:    time.Since(start),
+				Error:       err,
+
+				Pruned:          m.prune.Pruned,
+				ReclaimedBytes:  m.prune.ReclaimedBytes,
+				ReclaimedChunks: m.prune.ReclaimedChunks,
+				PruneDryRun:     m.prune.DryRun,
 			})
 		}
 	}()
 
-	pr, pw := io.Pipe()
+	stopStart := m.Options.notifyStart(parent, notify.Stats{
+		Operation: "Backup",
+		Engine:    conn.DBType,
+		Database:  conn.DBName,
+		FileName:  finalName,
+		Note:      manifest.SanitizeNote(m.Options.Note),
+	})
+	defer stopStart()
 
-	errChan := make(chan error, 1)
-	go func() {
-		defer pw.Close()
-		var w io.Writer = pw
+	// Runs before the notification, so it reports the overrun
+	defer func() {
+		if err == nil || !errors.Is(context.Cause(ctx), errMaxDuration) {
+			return
+		}
+		err = apperrors.Wrap(err, apperrors.TypeResource,
+			fmt.Sprintf("backup %s of %s", errMaxDuration, m.Options.MaxDuration),
+			"Raise --max-duration, or find out what slowed the dump down.")
+		// The dump was cut short, so whatever reached the target is unusable
+		if derr := m.storage.Delete(parent, finalName); derr != nil && m.Options.Logger != nil {
+			m.Options.Logger.Debug("No partial backup to remove", "file", finalName, "error", derr)
+		}
+	}()
 
-		if m.Options.Encrypt {
-			km, err := crypto.NewKeyManager(m.Options.EncryptionPassphrase, m.Options.EncryptionKeyFile)
-			if err != nil {
-				errChan <- err
-				return
-			}
-			ew, err := crypto.NewEncryptWriter(pw, km)
-			if err != nil {
-				errChan <- err
-				return
-			}
-			defer ew.Close()
-			w = ew
+	checksumAlgo := m.Options.ChecksumAlgo
+	if checksumAlgo == "" {
+		checksumAlgo = manifest.ChecksumSHA256
+	}
+	hasher, err := manifest.NewHasher(checksumAlgo)
+	if err != nil {
+		return apperrors.Wrap(err, apperrors.TypeConfig, "invalid checksum algorithm", "Use one of: sha256, sha512, blake3.")
+	}
+	if err := manifest.CheckFormat(m.Options.ManifestFormat); err != nil {
+		return apperrors.Wrap(err, apperrors.TypeConfig, "invalid manifest format", "Use json or binary.")
+	}
+
+	if m.Options.Lock {
+		staleAfter := staleLockAge
+		if m.Options.MaxDuration > 0 {
+			staleAfter = m.Options.MaxDuration
+		}
+		release, err := acquireLock(ctx, m.storage, lockName(conn.DBType, conn.DBName), staleAfter)
+		if err != nil {
+			return err
 		}
+		defer release()
+	}
 
-		if m.Options.Compress {
-			c, err := compress.New(w, algo)
-			if err != nil {
-				errChan <- err
-				return
-			}
-			if algo == compress.Tar {
-				c.SetTarBufferName(name)
-			}
-			defer c.Close()
-			w = c
+	changeSignal, unchanged := m.changeSignal(ctx, adapter, conn)
+	if unchanged != "" {
+		finalName = unchanged
+		if m.Options.Logger != nil {
+			m.Options.Logger.Info("No changes since the last backup, skipped", "latest", unchanged)
 		}
+		return nil
+	}
 
-		var r database.Runner = &database.LocalRunner{}
-		if m.Options.RemoteExec {
-			if runner, ok := m.storage.(database.Runner); ok {
-				if m.Options.Logger != nil {
-					m.Options.Logger.Info("Using remote runner from storage backend (remote-exec enabled)")
-				}
-				r = runner
+	// Chunks encrypted by the storage replace whole-stream encryption, which
+	// would otherwise randomise every chunk and defeat deduplication
+	var chunkEncryption string
+	if ce, ok := m.storage.(interface{ ChunkEncryption() string }); ok {
+		chunkEncryption = ce.ChunkEncryption()
+	}
+
+	if err := m.checkSpace(ctx, conn); err != nil {
+		return err
+	}
+
+	// A target's dictionary is trained once, from the first dump taken with
+	// --zstd-dict, and reused by every later backup
+	var dict []byte
+	var dictID uint32
+	var dictSample *sampleWriter
+	if m.Options.ZstdDict && m.Options.Compress && algo == compress.Zstd {
+		if d, err := m.storage.GetMetadata(ctx, compress.DictFile); err == nil {
+			if id, err := compress.DictID(d); err == nil {
+				dict, dictID = d, id
+			} else if m.Options.Logger != nil {
+				m.Options.Logger.Warn("Ignoring invalid zstd dictionary", "file", compress.DictFile, "error", err)
 			}
 		}
+		if dict == nil {
+			dictSample = &sampleWriter{limit: compress.DictSampleSize}
+		}
+	}
 
-		if err := adapter.RunBackup(ctx, conn, r, w); err != nil {
-			errChan <- err
-			return
+	// Without compression or stream encryption the dump is stored as is, and
+	// its logical and stored sizes are equal
+	passthrough := (!m.Options.Compress || algo == compress.None) && !(m.Options.Encrypt && chunkEncryption == "")
+
+	raw := &ByteCounter{} // Dump bytes before compression and encryption
+
+	// A pg_basebackup archive carries the WAL range it needs; read it off a
+	// copy of the stream for the manifest
+	var wal *walScanner
+	if conn.IsPhysical && strings.EqualFold(conn.DBType, "postgres") {
+		wal = newWALScanner()
+	}
+
+	var r database.Runner = &database.LocalRunner{}
+	if m.Options.RemoteExec {
+		if runner, ok := m.storage.(database.Runner); ok {
+			if m.Options.Logger != nil {
+				m.Options.Logger.Info("Using remote runner from storage backend (remote-exec enabled)")
+			}
+			r = runner
 		}
-		errChan <- nil
-	}()
+	}
 
 	// Integrity & Manifesting
-	hasher := sha256.New()
 	counter := &ByteCounter{}
 
 	p := m.Options.Progress
 	shouldWait := false
 	if p == nil {
-		p = NewProgressContainer()
+		p = NewProgressContainer(m.Options.ProgressRefresh)
 		shouldWait = true
 	}
 	bar := AddBackupBar(p, "Backup")
+	var chunkBar *mpb.Bar
+	if ds, ok := m.storage.(*storage.DedupeStorage); ok {
+		var onChunk func(uploaded, total int, bytes int64)
+		chunkBar, onChunk = AddChunkBar(p, "Chunks")
+		ds.SetOnChunk(onChunk)
+	}
 
-	tr1 := io.TeeReader(pr, hasher)
-	tr := io.TeeReader(tr1, counter)
+	// A passthrough dump goes straight into the target when it can be
+	// written to; anything else is streamed through a pipe to Save
+	var fw storage.FileWriter
+	direct := false
+	if passthrough {
+		if fw, direct, err = storage.CreateFile(ctx, m.storage, finalName); err != nil {
+			if p != nil {
+				p.Wait()
+			}
+			return apperrors.Wrap(err, apperrors.TypeResource, "storage save failed", "Check storage permissions and disk space.")
+		}
+	}
 
-	// need a writer that writes to storage AND updates the progress bar
-	// Actually, storage.Save takes a Reader.
-	// ProgressReader wraps the TeeReader.
-	sr := NewProgressReader(tr, bar)
+	var location string
+	var dumpErr error
+	if direct {
+		var w io.Writer = io.MultiWriter(fw, NewProgressWriter(io.MultiWriter(hasher, counter), bar))
+		if wal != nil {
+			w = io.MultiWriter(w, wal)
+		}
+		dumpErr = adapter.RunBackup(ctx, conn, r, w)
+		if wal != nil {
+			wal.close()
+		}
+		if dumpErr != nil {
+			fw.Abort()
+		} else {
+			location, err = fw.Commit()
+		}
+	} else {
+		pr, pw := io.Pipe()
+		errChan := make(chan error, 1)
+		go func() {
+			defer pw.Close()
+			var w io.Writer = pw
+
+			if m.Options.Encrypt && chunkEncryption == "" {
+				km, err := m.Options.streamKeyManager()
+				if err != nil {
+					errChan <- err
+					return
+				}
+				ew, err := crypto.NewEncryptWriter(pw, km)
+				if err != nil {
+					errChan <- err
+					return
+				}
+				defer ew.Close()
+				w = ew
+			}
+
+			if m.Options.Compress && algo != compress.None {
+				c, err := compress.NewWithDict(w, algo, dict)
+				if err != nil {
+					errChan <- err
+					return
+				}
+				if algo == compress.Tar {
+					c.SetTarBufferName(name)
+				}
+				defer c.Close()
+				w = c
+			}
+
+			if dictSample != nil {
+				w = io.MultiWriter(w, dictSample)
+			}
+			if !passthrough {
+				w = io.MultiWriter(w, raw)
+			}
+			if wal != nil {
+				defer wal.close()
+				w = io.MultiWriter(w, wal)
+			}
 
-	location, err := m.storage.Save(ctx, finalName, sr)
+			if err := adapter.RunBackup(ctx, conn, r, w); err != nil {
+				errChan <- err
+				return
+			}
+			errChan <- nil
+		}()
+
+		tr1 := io.TeeReader(pr, hasher)
+		tr := io.TeeReader(tr1, counter)
+		sr := NewProgressReader(tr, bar)
+
+		location, err = m.storage.Save(ctx, finalName, sr)
+		if err == nil || errors.As(err, new(*storage.PartialError)) {
+			dumpErr = <-errChan
+		}
+	}
 	if bar != nil {
 		bar.SetTotal(bar.Current(), true)
 	}
+	if chunkBar != nil {
+		chunkBar.SetTotal(chunkBar.Current(), true)
+	}
+
+	// With several targets, keep going as long as one of them has the backup
+	var partial *storage.PartialError
+	if errors.As(err, &partial) {
+		if m.Options.Logger != nil {
+			m.Options.Logger.Warn("Backup failed on some targets", "error", partial)
+		}
+		err = nil
+	}
 
 	if err != nil {
 		if p != nil {
@@ -209,15 +465,19 @@ func (m *BackupManager) Run(ctx context.Context, adapter database.DBAdapter, con
 		p.Wait()
 	}
 
-	if err := <-errChan; err != nil {
-		return err
+	if dumpErr != nil {
+		return dumpErr
 	}
 
 	checksum := hex.EncodeToString(hasher.Sum(nil))
 	totalSize := counter.Count
+	logicalSize, storedSize = raw.Count, totalSize
+	if passthrough {
+		logicalSize = totalSize
+	}
 
 	encryption := "none"
-	if m.Options.Encrypt {
+	if m.Options.Encrypt && chunkEncryption == "" {
 		encryption = "aes-256-gcm"
 	}
 
@@ -233,8 +493,26 @@ func (m *BackupManager) Run(ctx context.Context, adapter database.DBAdapter, con
 		man.Chunks = cs.LastChunks()
 	}
 	man.Checksum = checksum
+	man.ChecksumAlgo = checksumAlgo
+	man.ChunkEncryption = chunkEncryption
+	man.CompressionDict = dictID
+	man.Origin = origin(m.storage)
+	man.Note = manifest.SanitizeNote(m.Options.Note)
+	man.ChangeSignal = changeSignal
+	man.Excludes = conn.Exclude
+	if wal != nil {
+		if rng, ok := wal.result(); ok {
+			man.Timeline, man.StartLSN, man.EndLSN = rng.Timeline, rng.StartLSN, rng.EndLSN
+			if m.Options.Logger != nil {
+				m.Options.Logger.Info("Backup needs WAL range", "timeline", rng.Timeline, "start_lsn", rng.StartLSN, "end_lsn", rng.EndLSN)
+			}
+		} else if m.Options.Logger != nil {
+			m.Options.Logger.Warn("No WAL range in the pg_basebackup archive; the manifest won't record it")
+		}
+	}
 	man.Size = totalSize
 	man.Version = "0.1.0"
+	_ = man.SetFormat(m.Options.ManifestFormat) // Checked before the dump
 
 	manBytes, err := man.Serialize()
 	if err == nil {
@@ -242,37 +520,222 @@ func (m *BackupManager) Run(ctx context.Context, adapter database.DBAdapter, con
 			if m.Options.Logger != nil {
 				m.Options.Logger.Warn("Failed to save manifest", "error", err, "file", finalName+".manifest")
 			}
-		} else if m.Options.Logger != nil {
-			m.Options.Logger.Info("Manifest saved", "file", finalName+".manifest")
+		} else {
+			m.manifest = finalName + ".manifest"
+			if m.Options.Logger != nil {
+				m.Options.Logger.Info("Manifest saved", "file", m.manifest)
+			}
 		}
 
-		if err := m.storage.PutMetadata(ctx, "latest.manifest", manBytes); err != nil {
-			if m.Options.Logger != nil {
-				m.Options.Logger.Warn("Failed to update latest manifest", "error", err, "file", "latest.manifest")
+		// Pointers name the manifest, so they only move once it is saved
+		if !m.Options.NoLatest && m.manifest != "" {
+			for _, latest := range []string{manifest.LatestNameFor(conn.DBType, conn.DBName), manifest.LatestName} {
+				moved, err := m.updateLatest(ctx, latest, m.manifest, man)
+				if m.Options.Logger == nil {
+					continue
+				}
+				switch {
+				case err != nil:
+					m.Options.Logger.Warn("Failed to update latest manifest", "error", err, "file", latest)
+				case moved:
+					m.Options.Logger.Info("Latest manifest updated", "file", latest)
+				default:
+					m.Options.Logger.Info("Latest manifest already names a newer backup, left in place", "file", latest)
+				}
 			}
-		} else if m.Options.Logger != nil {
-			m.Options.Logger.Info("Latest manifest updated", "file", "latest.manifest")
 		}
 	}
 
+	if dictSample != nil {
+		m.saveDict(ctx, dictSample.buf.Bytes())
+	}
+
 	// Trigger pruning
 	pm := NewPruneManager(m.storage, PruneOptions{
-		Retention:       m.Options.Retention,
-		Keep:            m.Options.Keep,
-		RetentionPolicy: m.Options.RetentionPolicy,
-		DBType:          conn.DBType,
-		DBName:          conn.DBName,
-		Logger:          m.Options.Logger,
+		Retention:         m.Options.Retention,
+		Keep:              m.Options.Keep,
+		RetentionPolicy:   m.Options.RetentionPolicy,
+		DBType:            conn.DBType,
+		DBName:            conn.DBName,
+		AllowPruneAll:     m.Options.AllowPruneAll,
+		DryRun:            m.Options.RetentionDryRun,
+		ManifestRetention: m.Options.ManifestRetention,
+		Logger:            m.Options.Logger,
 	})
-	if pruneErr := pm.Prune(ctx); pruneErr != nil {
+	summary, pruneErr := pm.PruneWithResult(ctx)
+	if pruneErr != nil {
 		if m.Options.Logger != nil {
 			m.Options.Logger.Warn("Backup pruning failed", "error", pruneErr)
 		}
 	}
+	m.prune = summary
 
 	if m.Options.Logger != nil {
-		m.Options.Logger.Info("Backup saved successfully", "location", location)
+		stats := notify.Stats{Size: storedSize, LogicalSize: logicalSize, Duration: time.Since(start)}
+		m.Options.Logger.Info("Backup saved successfully",
+			"location", location,
+			"logical_bytes", logicalSize,
+			"stored_bytes", storedSize,
+			"ratio", fmt.Sprintf("%.2f", stats.Ratio()),
+			"mb_per_sec", fmt.Sprintf("%.1f", stats.Throughput()),
+		)
 	}
 
+	if partial != nil {
+		return apperrors.Wrap(partial, apperrors.TypeResource, "backup only reached some targets", "Check connectivity and permissions of the failed targets.")
+	}
 	return nil
 }
+
+// origin describes where a backup is written, without credentials. Local
+// paths are qualified with the hostname so they stay meaningful after the
+// backup is migrated elsewhere.
+func origin(s storage.Storage) string {
+	parts := strings.Split(storage.Scrub(s.Location()), ",")
+	for i, loc := range parts {
+		if strings.Contains(loc, "://") {
+			continue
+		}
+		if abs, err := filepath.Abs(loc); err == nil {
+			loc = abs
+		}
+		host, _ := os.Hostname()
+		parts[i] = host + ":" + loc
+	}
+	return strings.Join(parts, ",")
+}
+
+// changeSignal reads the engine's change marker before the dump starts, so
+// writes made during the dump count as changes next time. With --if-changed
+// it also returns the latest backup's file when the marker still matches it.
+func (m *BackupManager) changeSignal(ctx context.Context, adapter database.DBAdapter, conn database.ConnectionParams) (signal, unchanged string) {
+	// A remote SQLite path would be looked up on this host
+	if adapter == nil || (m.Options.RemoteExec && strings.EqualFold(conn.DBType, "sqlite")) {
+		return "", ""
+	}
+	signal, err := adapter.ChangeSignal(ctx, conn)
+	if err != nil {
+		if m.Options.Logger != nil {
+			if m.Options.IfChanged {
+				m.Options.Logger.Warn("Could not check for changes, backing up anyway", "error", err)
+			} else {
+				m.Options.Logger.Debug("Could not read change signal", "error", err)
+			}
+		}
+		return "", ""
+	}
+	if !m.Options.IfChanged || signal == "" {
+		return signal, ""
+	}
+	_, data, err := manifest.ReadLatest(ctx, m.storage, manifest.LatestNameFor(conn.DBType, conn.DBName))
+	if err != nil {
+		return signal, ""
+	}
+	prev, err := manifest.Deserialize(data)
+	if err != nil || prev.ChangeSignal != signal || prev.FileName == "" {
+		return signal, ""
+	}
+	return signal, prev.FileName
+}
+
+// checkSpace fails early when a local target clearly can't hold the backup,
+// instead of leaving a truncated file when the disk fills. Without a size
+// estimate, or for remote targets, the check is skipped.
+func (m *BackupManager) checkSpace(ctx context.Context, conn database.ConnectionParams) error {
+	need := m.estimateSize(ctx, conn)
+	if need <= 0 {
+		return nil
+	}
+	free, ok := storage.AvailableSpace(m.storage)
+	if !ok || free >= uint64(need) {
+		return nil
+	}
+	return apperrors.New(apperrors.TypeResource,
+		fmt.Sprintf("not enough disk space at %s: %d bytes free, about %d needed", m.storage.Location(), free, need),
+		"Free up space, prune old backups with --keep/--retention, or back up to another target.")
+}
+
+// estimateSize guesses the size of the next backup from the previous one of
+// the same database, or from the database file for SQLite. Deduplicated
+// targets only store the chunks that changed, which nothing predicts, so
+// they get no estimate.
+func (m *BackupManager) estimateSize(ctx context.Context, conn database.ConnectionParams) int64 {
+	if _, ok := m.storage.(storage.ChunkedStorage); ok {
+		return 0
+	}
+	if _, data, err := manifest.ReadLatest(ctx, m.storage, manifest.LatestNameFor(conn.DBType, conn.DBName)); err == nil {
+		if man, err := manifest.Deserialize(data); err == nil && man.Size > 0 {
+			return man.Size
+		}
+	}
+	if strings.EqualFold(conn.DBType, "sqlite") && !m.Options.RemoteExec {
+		if fi, err := os.Stat(conn.DBName); err == nil {
+			return fi.Size()
+		}
+	}
+	return 0
+}
+
+// saveDict trains a zstd dictionary from a dump and stores it on the target
+// for later backups. Failure only costs compression ratio, so it is logged.
+func (m *BackupManager) saveDict(ctx context.Context, sample []byte) {
+	dict, err := compress.TrainDict(sample)
+	if err == nil {
+		err = m.storage.PutMetadata(ctx, compress.DictFile, dict)
+	}
+	if m.Options.Logger == nil {
+		return
+	}
+	if err != nil {
+		m.Options.Logger.Warn("Failed to train zstd dictionary", "error", err)
+		return
+	}
+	m.Options.Logger.Info("Trained zstd dictionary for future backups", "file", compress.DictFile, "size", len(dict))
+}
+
+// sampleWriter keeps the first limit bytes written to it.
+type sampleWriter struct {
+	buf   bytes.Buffer
+	limit int
+}
+
+func (s *sampleWriter) Write(p []byte) (int, error) {
+	if room := s.limit - s.buf.Len(); room > 0 {
+		s.buf.Write(p[:min(room, len(p))])
+	}
+	return len(p), nil
+}
+
+// walScanner runs database.ScanWALRange over everything written to it.
+type walScanner struct {
+	pw   *io.PipeWriter
+	done chan struct{}
+	rng  database.WALRange
+	ok   bool
+}
+
+func newWALScanner() *walScanner {
+	pr, pw := io.Pipe()
+	w := &walScanner{pw: pw, done: make(chan struct{})}
+	go func() {
+		defer close(w.done)
+		w.rng, w.ok = database.ScanWALRange(pr)
+	}()
+	return w
+}
+
+func (w *walScanner) Write(p []byte) (int, error) {
+	return w.pw.Write(p)
+}
+
+// close ends the stream; it is safe to call more than once.
+func (w *walScanner) close() {
+	w.pw.Close()
+}
+
+// result waits for the scan to finish and returns what it found.
+func (w *walScanner) result() (database.WALRange, bool) {
+	w.close()
+	<-w.done
+	return w.rng, w.ok
+}
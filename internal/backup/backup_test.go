@@ -0,0 +1,438 @@
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	database "github.com/lupppig/dbackup/internal/db"
+	"github.com/lupppig/dbackup/internal/manifest"
+	"github.com/lupppig/dbackup/internal/notify"
+	"github.com/lupppig/dbackup/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sqliteFixture creates a SQLite database of roughly rows KB.
+func sqliteFixture(tb testing.TB, rows int) string {
+	tb.Helper()
+	path := filepath.Join(tb.TempDir(), "app.db")
+	db, err := sql.Open("sqlite3", path)
+	require.NoError(tb, err)
+	defer db.Close()
+	_, err = db.Exec("CREATE TABLE t (id INTEGER PRIMARY KEY, payload BLOB)")
+	require.NoError(tb, err)
+	payload := bytes.Repeat([]byte("dbackup "), 128)
+	for i := 0; i < rows; i++ {
+		_, err = db.Exec("INSERT INTO t (payload) VALUES (?)", payload)
+		require.NoError(tb, err)
+	}
+	return path
+}
+
+func TestBackupManager_UncompressedPassthrough(t *testing.T) {
+	ctx := context.Background()
+	src := sqliteFixture(t, 50)
+	dir := t.TempDir()
+
+	mgr, err := NewBackupManager(BackupOptions{StorageURI: dir, FileName: "plain.db", NoLatest: true})
+	require.NoError(t, err)
+	conn := database.ConnectionParams{DBType: "sqlite", DBName: src}
+	require.NoError(t, mgr.Run(ctx, &database.SqliteAdapter{}, conn))
+
+	stored, err := os.ReadFile(filepath.Join(dir, "plain.db"))
+	require.NoError(t, err)
+	data, err := os.ReadFile(filepath.Join(dir, "plain.db.manifest"))
+	require.NoError(t, err)
+	man, err := manifest.Deserialize(data)
+	require.NoError(t, err)
+
+	assert.Equal(t, int64(len(stored)), man.Size)
+	assert.Equal(t, "", man.Compression)
+	assert.Equal(t, "none", man.Encryption)
+	sum, err := manifest.CalculateChecksum(bytes.NewReader(stored))
+	require.NoError(t, err)
+	assert.Equal(t, sum, man.Checksum)
+
+	// The stored file is the database itself
+	db, err := sql.Open("sqlite3", filepath.Join(dir, "plain.db"))
+	require.NoError(t, err)
+	defer db.Close()
+	var n int
+	require.NoError(t, db.QueryRow("SELECT COUNT(*) FROM t").Scan(&n))
+	assert.Equal(t, 50, n)
+}
+
+func BenchmarkBackupManager_SQLite(b *testing.B) {
+	ctx := context.Background()
+	src := sqliteFixture(b, 4096)
+	fi, err := os.Stat(src)
+	require.NoError(b, err)
+	conn := database.ConnectionParams{DBType: "sqlite", DBName: src}
+
+	for _, tc := range []struct {
+		name     string
+		compress bool
+		algo     string
+	}{
+		{"none", false, ""},
+		{"lz4", true, "lz4"},
+	} {
+		b.Run(tc.name, func(b *testing.B) {
+			dir := b.TempDir()
+			b.SetBytes(fi.Size())
+			for i := 0; i < b.N; i++ {
+				mgr, err := NewBackupManager(BackupOptions{
+					StorageURI: dir,
+					FileName:   fmt.Sprintf("bench-%d.db", i),
+					Compress:   tc.compress,
+					Algorithm:  tc.algo,
+					NoLatest:   true,
+				})
+				if err != nil {
+					b.Fatal(err)
+				}
+				if err := mgr.Run(ctx, &database.SqliteAdapter{}, conn); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// saveOnly hides a target's writer, so passthrough dumps to it go through
+// the pipe to Save as every dump did before.
+type saveOnly struct {
+	storage.Storage
+}
+
+func BenchmarkBackupManager_Passthrough(b *testing.B) {
+	ctx := context.Background()
+	data := bytes.Repeat([]byte("INSERT INTO t VALUES (42, 'passthrough');\n"), 200000)
+
+	for _, tc := range []struct {
+		name string
+		pipe bool
+	}{
+		{"pipe", true},
+		{"direct", false},
+	} {
+		b.Run(tc.name, func(b *testing.B) {
+			dir := b.TempDir()
+			b.SetBytes(int64(len(data)))
+			for i := 0; i < b.N; i++ {
+				mgr, err := NewBackupManager(BackupOptions{
+					StorageURI: dir,
+					FileName:   fmt.Sprintf("bench-%d.sql", i),
+					NoLatest:   true,
+				})
+				if err != nil {
+					b.Fatal(err)
+				}
+				if tc.pipe {
+					mgr.storage = saveOnly{mgr.storage}
+				}
+				if err := mgr.Run(ctx, &streamAdapter{DBAdapter: &database.SqliteAdapter{}, data: data}, database.ConnectionParams{DBType: "sqlite"}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestBackupManager_IfChanged(t *testing.T) {
+	ctx := context.Background()
+	src := sqliteFixture(t, 10)
+	dir := t.TempDir()
+	conn := database.ConnectionParams{DBType: "sqlite", DBName: src}
+
+	backups := func() int {
+		files, err := filepath.Glob(filepath.Join(dir, "*.manifest"))
+		require.NoError(t, err)
+		n := 0
+		for _, f := range files {
+			if !manifest.IsLatest(filepath.Base(f)) {
+				n++
+			}
+		}
+		return n
+	}
+	run := func(name string) {
+		mgr, err := NewBackupManager(BackupOptions{StorageURI: dir, FileName: name, IfChanged: true})
+		require.NoError(t, err)
+		require.NoError(t, mgr.Run(ctx, &database.SqliteAdapter{}, conn))
+	}
+
+	run("first.db")
+	require.Equal(t, 1, backups())
+
+	run("second.db")
+	assert.Equal(t, 1, backups(), "an unchanged database should be skipped")
+
+	db, err := sql.Open("sqlite3", src)
+	require.NoError(t, err)
+	_, err = db.Exec("INSERT INTO t (payload) VALUES ('x')")
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	run("third.db")
+	assert.Equal(t, 2, backups())
+
+	data, err := os.ReadFile(filepath.Join(dir, "third.db.manifest"))
+	require.NoError(t, err)
+	man, err := manifest.Deserialize(data)
+	require.NoError(t, err)
+	assert.NotEmpty(t, man.ChangeSignal)
+}
+
+// endlessAdapter dumps until its context is cancelled.
+type endlessAdapter struct {
+	database.SqliteAdapter
+}
+
+func (endlessAdapter) RunBackup(ctx context.Context, conn database.ConnectionParams, runner database.Runner, w io.Writer) error {
+	buf := bytes.Repeat([]byte("x"), 32*1024)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if _, err := w.Write(buf); err != nil {
+			return err
+		}
+	}
+}
+
+func TestBackupManager_CancelAbortsPromptly(t *testing.T) {
+	dir := t.TempDir()
+	mgr, err := NewBackupManager(BackupOptions{StorageURI: dir, FileName: "endless.sql", NoLatest: true})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- mgr.Run(ctx, &endlessAdapter{}, database.ConnectionParams{DBType: "sqlite", DBName: "endless"})
+	}()
+	select {
+	case err := <-done:
+		assert.ErrorIs(t, err, context.Canceled)
+	case <-time.After(5 * time.Second):
+		t.Fatal("backup kept running after its context was cancelled")
+	}
+
+	_, err = os.Stat(filepath.Join(dir, "endless.sql.manifest"))
+	assert.True(t, os.IsNotExist(err), "a cancelled backup must not write a manifest")
+}
+
+// stalledAdapter writes the start of a dump, then hangs like a stuck pg_dump
+// until its context ends.
+type stalledAdapter struct {
+	database.SqliteAdapter
+}
+
+func (stalledAdapter) RunBackup(ctx context.Context, conn database.ConnectionParams, runner database.Runner, w io.Writer) error {
+	if _, err := w.Write([]byte("-- partial dump\n")); err != nil {
+		return err
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(time.Minute):
+		return nil
+	}
+}
+
+type recordingNotifier struct {
+	stats []notify.Stats
+}
+
+func (n *recordingNotifier) Notify(ctx context.Context, stats notify.Stats) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	n.stats = append(n.stats, stats)
+	return nil
+}
+
+func TestBackupManager_MaxDuration(t *testing.T) {
+	dir := t.TempDir()
+	n := &recordingNotifier{}
+	mgr, err := NewBackupManager(BackupOptions{
+		StorageURI:  dir,
+		FileName:    "stalled.sql",
+		NoLatest:    true,
+		MaxDuration: 100 * time.Millisecond,
+		Notifier:    n,
+	})
+	require.NoError(t, err)
+
+	start := time.Now()
+	err = mgr.Run(context.Background(), &stalledAdapter{}, database.ConnectionParams{DBType: "sqlite", DBName: "stalled"})
+	require.Error(t, err)
+	assert.Less(t, time.Since(start), 5*time.Second, "the deadline must stop the dump")
+	assert.Contains(t, err.Error(), "exceeded max duration of 100ms")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// The failure is still reported, with the reason
+	require.Len(t, n.stats, 1)
+	assert.Equal(t, notify.StatusError, n.stats[0].Status)
+	assert.Contains(t, n.stats[0].Error.Error(), "exceeded max duration")
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, entries, "the partial upload must be removed")
+}
+
+func TestBackupManager_NotifyOnStart(t *testing.T) {
+	conn := database.ConnectionParams{DBType: "sqlite", DBName: "stalled"}
+	app := database.ConnectionParams{DBType: "sqlite", DBName: sqliteFixture(t, 10)}
+
+	t.Run("immediately", func(t *testing.T) {
+		n := &recordingNotifier{}
+		mgr, err := NewBackupManager(BackupOptions{StorageURI: t.TempDir(), FileName: "app.sql", NoLatest: true, Notifier: n, NotifyOnStart: true})
+		require.NoError(t, err)
+		require.NoError(t, mgr.Run(context.Background(), &database.SqliteAdapter{}, app))
+
+		require.Len(t, n.stats, 2)
+		assert.Equal(t, notify.StatusStarted, n.stats[0].Status)
+		assert.Equal(t, "Backup", n.stats[0].Operation)
+		assert.Equal(t, "app.sql", n.stats[0].FileName)
+		assert.Zero(t, n.stats[0].Duration)
+		assert.Equal(t, notify.StatusSuccess, n.stats[1].Status)
+	})
+
+	t.Run("after a delay", func(t *testing.T) {
+		n := &recordingNotifier{}
+		mgr, err := NewBackupManager(BackupOptions{
+			StorageURI: t.TempDir(), FileName: "stalled.sql", NoLatest: true, Notifier: n,
+			NotifyOnStart: true, NotifyStartAfter: 50 * time.Millisecond, MaxDuration: 300 * time.Millisecond,
+		})
+		require.NoError(t, err)
+		require.Error(t, mgr.Run(context.Background(), &stalledAdapter{}, conn))
+
+		require.Len(t, n.stats, 2, "the start ping comes before the outcome")
+		assert.Equal(t, notify.StatusStarted, n.stats[0].Status)
+		assert.GreaterOrEqual(t, n.stats[0].Duration, 50*time.Millisecond)
+		assert.Equal(t, notify.StatusError, n.stats[1].Status)
+	})
+
+	t.Run("short run stays quiet", func(t *testing.T) {
+		n := &recordingNotifier{}
+		mgr, err := NewBackupManager(BackupOptions{StorageURI: t.TempDir(), FileName: "app.sql", NoLatest: true, Notifier: n, NotifyOnStart: true, NotifyStartAfter: time.Minute})
+		require.NoError(t, err)
+		require.NoError(t, mgr.Run(context.Background(), &database.SqliteAdapter{}, app))
+
+		require.Len(t, n.stats, 1)
+		assert.Equal(t, notify.StatusSuccess, n.stats[0].Status)
+	})
+}
+
+func TestBackupManager_SlackShowsSize(t *testing.T) {
+	fields := make(chan map[string]string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload struct {
+			Attachments []struct {
+				Fields []struct{ Title, Value string }
+			}
+		}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&payload))
+		got := make(map[string]string)
+		for _, a := range payload.Attachments {
+			for _, f := range a.Fields {
+				got[f.Title] = f.Value
+			}
+		}
+		fields <- got
+	}))
+	defer srv.Close()
+
+	mgr, err := NewBackupManager(BackupOptions{
+		StorageURI: t.TempDir(),
+		FileName:   "app.sql",
+		Compress:   true,
+		Algorithm:  "gzip",
+		NoLatest:   true,
+		Notifier:   notify.NewSlackNotifier(srv.URL, ""),
+	})
+	require.NoError(t, err)
+	require.NoError(t, mgr.Run(context.Background(), &database.SqliteAdapter{}, database.ConnectionParams{DBType: "sqlite", DBName: sqliteFixture(t, 20)}))
+
+	got := <-fields
+	assert.Regexp(t, `^[\d.]+ [KM]?B$`, got["Size"])
+	assert.Contains(t, got["Compression"], "x of ")
+	assert.Regexp(t, `^[\d.]+ MB/s$`, got["Throughput"])
+}
+
+// basebackupAdapter streams a pg_basebackup-like archive.
+type basebackupAdapter struct {
+	database.SqliteAdapter
+}
+
+func (basebackupAdapter) RunBackup(ctx context.Context, conn database.ConnectionParams, runner database.Runner, w io.Writer) error {
+	tw := tar.NewWriter(w)
+	for _, f := range [][2]string{
+		{"base/1/1259", string(bytes.Repeat([]byte("page"), 64*1024))},
+		{"backup_manifest", `{"WAL-Ranges": [{"Timeline": 1, "Start-LSN": "0/2000028", "End-LSN": "0/2000138"}]}`},
+	} {
+		if err := tw.WriteHeader(&tar.Header{Name: f[0], Mode: 0600, Size: int64(len(f[1]))}); err != nil {
+			return err
+		}
+		if _, err := tw.Write([]byte(f[1])); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+func TestBackupManager_RecordsWALRange(t *testing.T) {
+	dir := t.TempDir()
+	mgr, err := NewBackupManager(BackupOptions{StorageURI: dir, FileName: "base.tar", Compress: true, Algorithm: "gzip", NoLatest: true})
+	require.NoError(t, err)
+	require.NoError(t, mgr.Run(context.Background(), &basebackupAdapter{}, database.ConnectionParams{DBType: "postgres", DBName: "app", IsPhysical: true}))
+
+	data, err := os.ReadFile(filepath.Join(dir, "base.tar.gz.manifest"))
+	require.NoError(t, err)
+	man, err := manifest.Deserialize(data)
+	require.NoError(t, err)
+	assert.Equal(t, 1, man.Timeline)
+	assert.Equal(t, "0/2000028", man.StartLSN)
+	assert.Equal(t, "0/2000138", man.EndLSN)
+}
+
+func TestBackupManager_CheckSpace(t *testing.T) {
+	ctx := context.Background()
+	conn := database.ConnectionParams{DBType: "postgres", DBName: "app"}
+	for _, dedupe := range []bool{false, true} {
+		dir := t.TempDir()
+		mgr, err := NewBackupManager(BackupOptions{StorageURI: dir, Dedupe: dedupe})
+		require.NoError(t, err)
+
+		// The previous backup was far larger than any disk
+		man := manifest.New("1", "postgres", "none", "none")
+		man.FileName = "app.sql"
+		man.Size = 1 << 60
+		data, err := man.Serialize()
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "app.sql.manifest"), data, 0o600))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, manifest.LatestNameFor("postgres", "app")), manifest.NewPointer("app.sql.manifest"), 0o600))
+
+		err = mgr.checkSpace(ctx, conn)
+		if dedupe {
+			// Only changed chunks are written, so the old size says nothing
+			assert.NoError(t, err)
+		} else {
+			assert.ErrorContains(t, err, "not enough disk space")
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package backup
+
+import (
+	"context"
+
+	"github.com/lupppig/dbackup/internal/compress"
+	database "github.com/lupppig/dbackup/internal/db"
+	apperrors "github.com/lupppig/dbackup/internal/errors"
+)
+
+// BenchmarkCompression dumps the first sampleSize bytes of conn's database,
+// stops the dump, and compresses the sample with each benchmarked algorithm.
+// Nothing is stored. It returns the results and the sample size, which is
+// less than sampleSize for smaller dumps.
+func BenchmarkCompression(ctx context.Context, adapter database.DBAdapter, conn database.ConnectionParams, r database.Runner, sampleSize int) ([]compress.BenchmarkResult, int, error) {
+	if sampleSize <= 0 {
+		return nil, 0, apperrors.New(apperrors.TypeConfig, "the benchmark sample must be larger than 0", "Pass a positive --benchmark-sample-mb.")
+	}
+
+	// The sampler fails writes once full, which ends the dump early; the
+	// error that causes is expected
+	sampler := &compress.Sampler{Limit: sampleSize}
+	if err := adapter.RunBackup(ctx, conn, r, sampler); err != nil && !sampler.Full() {
+		return nil, 0, err
+	}
+	sample := sampler.Bytes()
+	if len(sample) == 0 {
+		return nil, 0, apperrors.New(apperrors.TypeResource, "the dump produced no data to sample", "Check that the database has data and the dump tool works.")
+	}
+
+	results, err := compress.Benchmark(sample, compress.BenchmarkAlgorithms)
+	if err != nil {
+		return nil, 0, err
+	}
+	return results, len(sample), nil
+}
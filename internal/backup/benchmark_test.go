@@ -0,0 +1,51 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	database "github.com/lupppig/dbackup/internal/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// streamAdapter dumps data in small writes, as dump tools do.
+type streamAdapter struct {
+	database.DBAdapter
+	data    []byte
+	written int
+}
+
+func (a *streamAdapter) RunBackup(ctx context.Context, conn database.ConnectionParams, r database.Runner, w io.Writer) error {
+	for off := 0; off < len(a.data); off += 4096 {
+		n, err := w.Write(a.data[off:min(off+4096, len(a.data))])
+		a.written += n
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestBenchmarkCompression(t *testing.T) {
+	data := bytes.Repeat([]byte("INSERT INTO t VALUES (42, 'sampled');\n"), 10000)
+
+	// The dump stops once the sample is full
+	a := &streamAdapter{data: data}
+	results, n, err := BenchmarkCompression(context.Background(), a, database.ConnectionParams{}, nil, 64*1024)
+	require.NoError(t, err)
+	assert.Equal(t, 64*1024, n)
+	assert.Equal(t, 64*1024, a.written)
+	assert.Len(t, results, 3)
+
+	// A dump smaller than the sample is benchmarked whole
+	a = &streamAdapter{data: data[:1000]}
+	_, n, err = BenchmarkCompression(context.Background(), a, database.ConnectionParams{}, nil, 64*1024)
+	require.NoError(t, err)
+	assert.Equal(t, 1000, n)
+
+	_, _, err = BenchmarkCompression(context.Background(), &streamAdapter{}, database.ConnectionParams{}, nil, 64*1024)
+	assert.Error(t, err)
+}
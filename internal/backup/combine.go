@@ -0,0 +1,231 @@
+package backup
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/lupppig/dbackup/internal/compress"
+	"github.com/lupppig/dbackup/internal/crypto"
+	database "github.com/lupppig/dbackup/internal/db"
+	apperrors "github.com/lupppig/dbackup/internal/errors"
+	"github.com/lupppig/dbackup/internal/manifest"
+)
+
+// restoreChain restores the Postgres incremental backup man: it follows
+// ParentID links back to the full backup, unpacks every backup of the chain
+// into the workspace, and has pg_combinebackup write them into $PGDATA as
+// one data directory.
+func (m *RestoreManager) restoreChain(ctx context.Context, conn database.ConnectionParams, man *manifest.Manifest) error {
+	if man == nil {
+		return apperrors.New(apperrors.TypeConfig, "--combine-backup needs the backup's manifest", "Restore by manifest, not with --no-manifest.")
+	}
+	if !strings.EqualFold(conn.DBType, "postgres") && !strings.EqualFold(conn.DBType, "postgresql") {
+		return apperrors.New(apperrors.TypeConfig, "--combine-backup only applies to PostgreSQL", "Drop --combine-backup for "+conn.DBType+" restores.")
+	}
+
+	chain, err := m.resolveChain(ctx, man)
+	if err != nil {
+		return err
+	}
+	if m.Options.Logger != nil {
+		names := make([]string, len(chain))
+		for i, link := range chain {
+			names[i] = link.FileName
+		}
+		m.Options.Logger.Info("Resolved incremental chain", "backups", len(chain), "chain", strings.Join(names, " -> "))
+	}
+	if m.Options.DryRun {
+		if m.Options.Logger != nil {
+			m.Options.Logger.Info("[DRY-RUN] Would combine the chain with pg_combinebackup", "pgdata", os.Getenv("PGDATA"))
+		}
+		return nil
+	}
+
+	runner := database.NewLocalRunner(m.Options.Logger)
+	pgdata := os.Getenv("PGDATA")
+	if !m.Options.VerifyOnly {
+		if pgdata == "" {
+			return apperrors.New(apperrors.TypeConfig, "combining a chain needs a data directory", "Set PGDATA to the (stopped, empty) data directory to restore into.")
+		}
+		if err := database.CheckCombineBackup(ctx, runner, pgdata); err != nil {
+			return err
+		}
+	}
+
+	tmpDir, err := os.MkdirTemp(m.Options.TmpDir, "dbackup-combine-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary workspace: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	dirs := make([]string, len(chain))
+	for i, link := range chain {
+		dirs[i] = filepath.Join(tmpDir, fmt.Sprintf("%03d", i))
+		if m.Options.Logger != nil {
+			m.Options.Logger.Info("Unpacking backup of the chain", "backup", link.FileName, "step", i+1, "of", len(chain))
+		}
+		if err := m.unpackLink(ctx, link, dirs[i]); err != nil {
+			return fmt.Errorf("backup %s: %w", link.FileName, err)
+		}
+	}
+
+	if m.Options.VerifyOnly {
+		if m.Options.Logger != nil {
+			m.Options.Logger.Info("Restore verification passed", "backups", len(chain))
+		}
+		return nil
+	}
+
+	if m.Options.Logger != nil {
+		m.Options.Logger.Info("Combining incremental chain", "pgdata", pgdata)
+	}
+	if err := database.CombineBackup(ctx, runner, dirs, pgdata); err != nil {
+		return err
+	}
+	if m.Options.Logger != nil {
+		m.Options.Logger.Info("Physical restore complete. Check ownership (chown -R postgres:postgres) and start the server.", "pgdata", pgdata)
+	}
+	return nil
+}
+
+// resolveChain returns the backups man was built on, full backup first and
+// man last. Every link is checked before anything is downloaded; all the
+// links that are missing, pruned or have lost data are reported together.
+func (m *RestoreManager) resolveChain(ctx context.Context, man *manifest.Manifest) ([]*manifest.Manifest, error) {
+	files, err := m.storage.ListMetadata(ctx, "")
+	if err != nil {
+		return nil, apperrors.Wrap(err, apperrors.TypeConnection, "failed to list manifests", "Check that the storage target is reachable.")
+	}
+	byID := make(map[string]*manifest.Manifest)
+	for _, f := range files {
+		if !strings.HasSuffix(f, ".manifest") || manifest.IsLatest(f) || manifest.IsWAL(f) {
+			continue
+		}
+		data, err := m.storage.GetMetadata(ctx, f)
+		if err != nil {
+			continue
+		}
+		if other, err := manifest.Deserialize(data); err == nil {
+			byID[other.ID] = other
+		}
+	}
+
+	var chain []*manifest.Manifest
+	var missing []string
+	seen := make(map[string]bool)
+	for link := man; link != nil; {
+		if seen[link.ID] {
+			return nil, apperrors.New(apperrors.TypeIntegrity, "incremental chain of "+man.FileName+" loops back to "+link.FileName, "The manifests were edited or corrupted; restore a full backup instead.")
+		}
+		seen[link.ID] = true
+		chain = append(chain, link)
+
+		switch {
+		case link.Pruned:
+			missing = append(missing, link.FileName+" (pruned)")
+		case len(link.Chunks) > 0:
+			if err := m.checkChunks(ctx, link.Chunks); err != nil {
+				if !apperrors.IsType(err, apperrors.TypeIntegrity) {
+					return nil, err
+				}
+				missing = append(missing, link.FileName+" (chunks lost)")
+			}
+		default:
+			if ok, err := m.storage.Exists(ctx, link.FileName); err != nil {
+				return nil, apperrors.Wrap(err, apperrors.TypeConnection, "failed to check "+link.FileName, "Check that the storage target is reachable.")
+			} else if !ok {
+				missing = append(missing, link.FileName+" (file missing)")
+			}
+		}
+
+		if link.ParentID == "" {
+			break
+		}
+		parent, ok := byID[link.ParentID]
+		if !ok {
+			missing = append(missing, fmt.Sprintf("backup %s, parent of %s (no manifest)", link.ParentID, link.FileName))
+		}
+		link = parent
+	}
+
+	if len(missing) > 0 {
+		return nil, apperrors.New(apperrors.TypeIntegrity,
+			fmt.Sprintf("incremental chain of %s is broken: %s", man.FileName, strings.Join(missing, "; ")),
+			"The database was not touched. Copy the missing backups back, or restore an older chain.")
+	}
+	slices.Reverse(chain)
+	return chain, nil
+}
+
+// unpackLink downloads one backup of a chain, decrypts and decompresses it
+// as Run does, and unpacks the pg_basebackup archive into dir. The checksum
+// is checked once the whole backup has been read.
+func (m *RestoreManager) unpackLink(ctx context.Context, man *manifest.Manifest, dir string) error {
+	if man.ChunkEncryption != "" {
+		ks, ok := m.storage.(interface{ SetKeyManager(*crypto.KeyManager) })
+		if !ok {
+			return apperrors.New(apperrors.TypeConfig, "backup chunks are encrypted but deduplication is disabled", "Restore with --dedupe enabled.")
+		}
+		km, err := m.keyManager()
+		if err != nil {
+			return err
+		}
+		ks.SetKeyManager(km)
+	}
+
+	hasher, err := manifest.NewHasher(man.ChecksumAlgo)
+	if err != nil {
+		return apperrors.Wrap(err, apperrors.TypeIntegrity, "cannot verify backup checksum", "Upgrade dbackup to a version that supports this manifest's checksum algorithm.")
+	}
+	rc, err := m.openBackup(ctx, man.FileName, man)
+	if err != nil {
+		return fmt.Errorf("failed to open backup for restore: %w", err)
+	}
+	defer rc.Close()
+	stored := io.TeeReader(rc, hasher)
+
+	var r io.Reader = stored
+	if man.Encryption != "" && man.Encryption != "none" {
+		km, err := m.keyManager()
+		if err != nil {
+			return err
+		}
+		r = crypto.NewDecryptReader(r, km)
+	}
+	algo := compress.Algorithm(man.Compression)
+	if algo == "" || algo == compress.None {
+		algo = compress.DetectAlgorithm(man.FileName)
+	}
+	if algo != compress.None {
+		var dict []byte
+		if man.CompressionDict != 0 {
+			if dict, err = m.loadDict(ctx, man.CompressionDict); err != nil {
+				return err
+			}
+		}
+		c, err := compress.NewReaderWithDict(r, algo, dict)
+		if err != nil {
+			return fmt.Errorf("failed to create decompression reader for %s: %w", algo, err)
+		}
+		defer c.Close()
+		r = c
+	}
+
+	if err := database.ExtractBaseBackup(r, dir); err != nil {
+		return err
+	}
+	// The tar ends before the stream does; hash the rest too
+	if _, err := io.Copy(io.Discard, stored); err != nil {
+		return apperrors.Wrap(err, apperrors.TypeResource, "failed to download backup", "Check storage connectivity.")
+	}
+	if man.Checksum != "" && hex.EncodeToString(hasher.Sum(nil)) != man.Checksum {
+		return apperrors.ErrIntegrityMismatch
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package backup
+
+import (
+	"archive/tar"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	database "github.com/lupppig/dbackup/internal/db"
+	apperrors "github.com/lupppig/dbackup/internal/errors"
+	"github.com/lupppig/dbackup/internal/manifest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// tarAdapter streams a tar of files, standing in for pg_basebackup.
+type tarAdapter struct {
+	database.SqliteAdapter
+	files [][2]string
+}
+
+func (a tarAdapter) RunBackup(ctx context.Context, conn database.ConnectionParams, runner database.Runner, w io.Writer) error {
+	tw := tar.NewWriter(w)
+	for _, f := range a.files {
+		if err := tw.WriteHeader(&tar.Header{Name: f[0], Mode: 0600, Size: int64(len(f[1]))}); err != nil {
+			return err
+		}
+		if _, err := tw.Write([]byte(f[1])); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// stubCombine puts a pg_combinebackup first in PATH that reports version
+// and, like the real one, writes a data directory to --output, here by
+// overlaying its inputs in order.
+func stubCombine(t *testing.T, version string) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("stub command is a shell script")
+	}
+	bin := t.TempDir()
+	script := `#!/bin/sh
+if [ "$1" = --version ]; then echo "pg_combinebackup (PostgreSQL) ` + version + `"; exit 0; fi
+out=$2; shift 2
+mkdir -p "$out"
+for d in "$@"; do cp -R "$d"/. "$out"/; done
+`
+	require.NoError(t, os.WriteFile(filepath.Join(bin, "pg_combinebackup"), []byte(script), 0o755)) // #nosec G306
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestRestoreManager_CombineBackup(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	conn := database.ConnectionParams{DBType: "postgres", DBName: "app", IsPhysical: true}
+
+	// A full backup and one incremental taken against it
+	var mans []*manifest.Manifest
+	for _, b := range []struct {
+		name  string
+		files [][2]string
+	}{
+		{"full", [][2]string{{"PG_VERSION", "17\n"}, {"base/1/1259", "full page"}, {"backup_label", "full"}}},
+		{"incr", [][2]string{{"base/1/INCREMENTAL.1259", "changed page"}, {"backup_label", "incremental"}}},
+	} {
+		mgr, err := NewBackupManager(BackupOptions{StorageURI: dir, FileName: b.name + ".tar", Dedupe: true, Compress: true, Algorithm: "lz4"})
+		require.NoError(t, err)
+		require.NoError(t, mgr.Run(ctx, &tarAdapter{files: b.files}, conn))
+		data, err := os.ReadFile(filepath.Join(dir, mgr.ManifestName()))
+		require.NoError(t, err)
+		man, err := manifest.Deserialize(data)
+		require.NoError(t, err)
+		mans = append(mans, man)
+	}
+	mans[1].ParentID = mans[0].ID
+	data, err := mans[1].Serialize()
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "incr.tar.lz4.manifest"), data, 0o600))
+
+	restore := func(pgdata string) error {
+		t.Setenv("PGDATA", pgdata)
+		mgr, err := NewRestoreManager(BackupOptions{StorageURI: dir, Dedupe: true, FileName: "incr.tar.lz4", ConfirmRestore: true, CombineBackup: true})
+		require.NoError(t, err)
+		return mgr.Run(ctx, &database.PostgresAdapter{}, conn)
+	}
+
+	stubCombine(t, "17.0")
+	pgdata := filepath.Join(t.TempDir(), "pgdata")
+	require.NoError(t, restore(pgdata))
+	for name, want := range map[string]string{
+		"PG_VERSION":              "17\n",
+		"base/1/1259":             "full page",
+		"base/1/INCREMENTAL.1259": "changed page",
+		"backup_label":            "incremental",
+	} {
+		got, err := os.ReadFile(filepath.Join(pgdata, name))
+		require.NoError(t, err, name)
+		assert.Equal(t, want, string(got), name)
+	}
+
+	// pg_combinebackup from before 17 can't combine anything
+	stubCombine(t, "16.4")
+	err = restore(filepath.Join(t.TempDir(), "pgdata"))
+	assert.True(t, apperrors.IsType(err, apperrors.TypeDependency), "got %v", err)
+
+	// A chain whose full backup is gone names the missing link
+	stubCombine(t, "17.0")
+	require.NoError(t, os.Remove(filepath.Join(dir, "full.tar.lz4.manifest")))
+	err = restore(filepath.Join(t.TempDir(), "pgdata"))
+	require.Error(t, err)
+	assert.True(t, apperrors.IsType(err, apperrors.TypeIntegrity), "got %v", err)
+	assert.Contains(t, err.Error(), mans[0].ID)
+	assert.Contains(t, err.Error(), "parent of incr.tar.lz4")
+}
@@ -0,0 +1,37 @@
+package backup
+
+import (
+	"context"
+	"sync"
+
+	"github.com/lupppig/dbackup/internal/manifest"
+)
+
+// latestLocks holds a mutex per target and latest pointer. Backups run in
+// parallel by dump (or any caller sharing the process) take it around the
+// read-compare-write of a pointer, so the newest backup wins rather than
+// whichever finished last. Separate processes are not covered; --lock
+// keeps them apart for one database.
+var latestLocks sync.Map // location + "\x00" + pointer name -> *sync.Mutex
+
+func latestLock(location, name string) *sync.Mutex {
+	mu, _ := latestLocks.LoadOrStore(location+"\x00"+name, &sync.Mutex{})
+	return mu.(*sync.Mutex)
+}
+
+// updateLatest points the latest pointer name at the backup described by
+// man, saved as manName, unless the pointer already names a newer backup.
+// It reports whether the pointer moved.
+func (m *BackupManager) updateLatest(ctx context.Context, name, manName string, man *manifest.Manifest) (bool, error) {
+	mu := latestLock(m.storage.Location(), name)
+	mu.Lock()
+	defer mu.Unlock()
+
+	// A pointer that can't be read or parsed is replaced
+	if current, data, err := manifest.ReadLatest(ctx, m.storage, name); err == nil && current != manName {
+		if other, err := manifest.Deserialize(data); err == nil && other.CreatedAt.After(man.CreatedAt) {
+			return false, nil
+		}
+	}
+	return true, m.storage.PutMetadata(ctx, name, manifest.NewPointer(manName))
+}
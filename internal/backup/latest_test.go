@@ -0,0 +1,93 @@
+package backup
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	database "github.com/lupppig/dbackup/internal/db"
+	"github.com/lupppig/dbackup/internal/manifest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readPointer(t *testing.T, dir, name string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(dir, name))
+	require.NoError(t, err)
+	target, ok := manifest.ParsePointer(data)
+	require.True(t, ok, name)
+	return target
+}
+
+func TestUpdateLatest_NewestWins(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	mgr, err := NewBackupManager(BackupOptions{StorageURI: dir})
+	require.NoError(t, err)
+
+	now := time.Now()
+	older := &manifest.Manifest{ID: "a", FileName: "a.sql", CreatedAt: now}
+	newer := &manifest.Manifest{ID: "b", FileName: "b.sql", CreatedAt: now.Add(time.Second)}
+	for _, man := range []*manifest.Manifest{older, newer} {
+		data, err := man.Serialize()
+		require.NoError(t, err)
+		require.NoError(t, mgr.storage.PutMetadata(ctx, man.FileName+".manifest", data))
+	}
+
+	// Whatever order they finish in, the newer backup is left as latest
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		for _, man := range []*manifest.Manifest{newer, older} {
+			wg.Add(1)
+			go func(man *manifest.Manifest) {
+				defer wg.Done()
+				_, err := mgr.updateLatest(ctx, manifest.LatestName, man.FileName+".manifest", man)
+				assert.NoError(t, err)
+			}(man)
+		}
+	}
+	wg.Wait()
+	assert.Equal(t, "b.sql.manifest", readPointer(t, dir, manifest.LatestName))
+
+	moved, err := mgr.updateLatest(ctx, manifest.LatestName, "a.sql.manifest", older)
+	require.NoError(t, err)
+	assert.False(t, moved)
+}
+
+func TestBackupManager_ParallelBackupsKeepNewestLatest(t *testing.T) {
+	ctx := context.Background()
+	src := sqliteFixture(t, 20)
+	dir := t.TempDir()
+	conn := database.ConnectionParams{DBType: "sqlite", DBName: src}
+
+	var wg sync.WaitGroup
+	for _, name := range []string{"first.db", "second.db"} {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			mgr, err := NewBackupManager(BackupOptions{StorageURI: dir, FileName: name})
+			if assert.NoError(t, err) {
+				assert.NoError(t, mgr.Run(ctx, &database.SqliteAdapter{}, conn))
+			}
+		}(name)
+	}
+	wg.Wait()
+
+	var newest *manifest.Manifest
+	for _, name := range []string{"first.db", "second.db"} {
+		data, err := os.ReadFile(filepath.Join(dir, name+".manifest"))
+		require.NoError(t, err)
+		man, err := manifest.Deserialize(data)
+		require.NoError(t, err)
+		if newest == nil || man.CreatedAt.After(newest.CreatedAt) {
+			newest = man
+		}
+	}
+	for _, ptr := range []string{manifest.LatestName, manifest.LatestNameFor("sqlite", src)} {
+		assert.Equal(t, newest.FileName+".manifest", readPointer(t, dir, ptr), ptr)
+	}
+}
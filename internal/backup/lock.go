@@ -0,0 +1,152 @@
+package backup
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	apperrors "github.com/lupppig/dbackup/internal/errors"
+	"github.com/lupppig/dbackup/internal/manifest"
+	"github.com/lupppig/dbackup/internal/storage"
+)
+
+// staleLockAge is how old a lock must be before another backup may take it
+// over, unless MaxDuration bounds how long its holder can run.
+const staleLockAge = 24 * time.Hour
+
+// lockInfo is the content of a backup lock file.
+type lockInfo struct {
+	Token     string    `json:"token"`
+	PID       int       `json:"pid"`
+	Host      string    `json:"host"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// lockName returns the lock file guarding backups of one database on a
+// target.
+func lockName(engine, dbName string) string {
+	key := strings.TrimSuffix(strings.TrimPrefix(manifest.LatestNameFor(engine, dbName), "latest-"), ".manifest")
+	return "backup-" + key + ".lock"
+}
+
+// acquireLock takes the advisory lock name on s, so two runs never write the
+// same database's backups and latest pointer at once. A lock older than
+// staleAfter, or held by a process of this host that is gone, is taken over.
+// Targets without atomic creates are locked by writing the lock and reading
+// it back, which narrows the race without closing it.
+func acquireLock(ctx context.Context, s storage.Storage, name string, staleAfter time.Duration) (release func(), err error) {
+	var b [8]byte
+	rand.Read(b[:]) // #nosec G104 -- never fails
+	host, _ := os.Hostname()
+	info := lockInfo{Token: hex.EncodeToString(b[:]), PID: os.Getpid(), Host: host, CreatedAt: time.Now().UTC()}
+	data, err := json.Marshal(info)
+	if err != nil {
+		return nil, err
+	}
+
+	release = func() {
+		// The run's context may be done already; the lock must still go,
+		// unless it went stale and another backup has taken it over
+		ctx := context.WithoutCancel(ctx)
+		if got, err := s.GetMetadata(ctx, name); err == nil {
+			var cur lockInfo
+			if json.Unmarshal(got, &cur) == nil && cur.Token != info.Token {
+				return
+			}
+		}
+		s.Delete(ctx, name) // #nosec G104
+	}
+
+	for attempt := 0; attempt < 2; attempt++ {
+		atomic, err := storage.PutMetadataExclusive(ctx, s, name, data)
+		if atomic && err == nil {
+			return release, nil
+		}
+		if atomic && !errors.Is(err, fs.ErrExist) {
+			return nil, apperrors.Wrap(err, apperrors.TypeResource, "failed to create backup lock "+name, "Check that the target is writable.")
+		}
+
+		holder, held, err := readLock(ctx, s, name, staleAfter)
+		if err != nil {
+			return nil, apperrors.Wrap(err, apperrors.TypeResource, "failed to read backup lock "+name, "Check that the target is reachable, then retry.")
+		}
+		if held {
+			return nil, lockHeldError(name, holder)
+		}
+		if atomic {
+			// Stale: clear it and race for it again
+			if err := s.Delete(ctx, name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return nil, apperrors.Wrap(err, apperrors.TypeResource, "failed to remove stale backup lock "+name, "Delete the lock file by hand.")
+			}
+			continue
+		}
+
+		if err := s.PutMetadata(ctx, name, data); err != nil {
+			return nil, apperrors.Wrap(err, apperrors.TypeResource, "failed to create backup lock "+name, "Check that the target is writable.")
+		}
+		if got, err := s.GetMetadata(ctx, name); err == nil {
+			var cur lockInfo
+			if json.Unmarshal(got, &cur) == nil && cur.Token != info.Token {
+				return nil, lockHeldError(name, cur)
+			}
+		}
+		return release, nil
+	}
+	return nil, lockHeldError(name, lockInfo{})
+}
+
+// readLock reads the lock name and reports whether it is still held. A lock
+// that can't be parsed is dated by the file, so a torn write expires too.
+// Only a missing lock is free: a lock that can't be read for any other
+// reason, such as a dropped connection, returns the error.
+func readLock(ctx context.Context, s storage.Storage, name string, staleAfter time.Duration) (lockInfo, bool, error) {
+	var info lockInfo
+	data, err := s.GetMetadata(ctx, name)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return info, false, nil
+		}
+		// Not every target reports a missing file as fs.ErrNotExist
+		if exists, xerr := s.Exists(ctx, name); xerr == nil && !exists {
+			return info, false, nil
+		}
+		return info, false, err
+	}
+	if json.Unmarshal(data, &info) != nil || info.CreatedAt.IsZero() {
+		st, err := s.Stat(ctx, name)
+		if err != nil {
+			return info, true, nil
+		}
+		info.CreatedAt = st.ModTime
+	}
+	if time.Since(info.CreatedAt) > staleAfter {
+		return info, false, nil
+	}
+	if host, _ := os.Hostname(); info.Host == host && info.PID > 0 && !processAlive(info.PID) {
+		return info, false, nil
+	}
+	return info, true, nil
+}
+
+// processAlive reports whether pid is a running process on this host.
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+func lockHeldError(name string, holder lockInfo) error {
+	msg := "another backup is in progress"
+	if holder.PID > 0 {
+		msg += fmt.Sprintf(" (pid %d on %s, since %s)", holder.PID, holder.Host, holder.CreatedAt.Format(time.RFC3339))
+	}
+	return apperrors.New(apperrors.TypeResource, msg,
+		"Wait for it to finish. If it died, delete "+name+" from the target or wait for the lock to go stale.")
+}
@@ -0,0 +1,164 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	apperrors "github.com/lupppig/dbackup/internal/errors"
+	"github.com/lupppig/dbackup/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// plainStorage hides the backend type, so locking falls back to
+// write-and-read-back as on remote targets.
+type plainStorage struct{ storage.Storage }
+
+func TestAcquireLock_Race(t *testing.T) {
+	ctx := context.Background()
+	s := storage.NewLocalStorage(t.TempDir())
+	name := lockName("postgres", "app")
+
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	releases := make([]func(), 2)
+	errs := make([]error, 2)
+	for i := range 2 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			releases[i], errs[i] = acquireLock(ctx, s, name, staleLockAge)
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	var won int
+	for i, err := range errs {
+		if err == nil {
+			won++
+			defer releases[i]()
+			continue
+		}
+		assert.True(t, apperrors.IsType(err, apperrors.TypeResource), err.Error())
+		assert.Contains(t, err.Error(), "another backup is in progress")
+	}
+	assert.Equal(t, 1, won, "exactly one backup gets the lock")
+}
+
+func TestAcquireLock_Release(t *testing.T) {
+	ctx := context.Background()
+	for _, tc := range []struct {
+		name string
+		s    storage.Storage
+	}{
+		{"atomic", storage.NewLocalStorage(t.TempDir())},
+		{"read back", plainStorage{storage.NewLocalStorage(t.TempDir())}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			name := lockName("sqlite", "/data/app.db")
+
+			release, err := acquireLock(ctx, tc.s, name, staleLockAge)
+			require.NoError(t, err)
+			_, err = acquireLock(ctx, tc.s, name, staleLockAge)
+			assert.True(t, apperrors.IsType(err, apperrors.TypeResource), "second run while held: %v", err)
+
+			// Other databases on the target aren't blocked
+			other, err := acquireLock(ctx, tc.s, lockName("sqlite", "other.db"), staleLockAge)
+			require.NoError(t, err)
+			other()
+
+			release()
+			exists, err := tc.s.Exists(ctx, name)
+			require.NoError(t, err)
+			assert.False(t, exists)
+
+			release, err = acquireLock(ctx, tc.s, name, staleLockAge)
+			require.NoError(t, err, "free again once released")
+			release()
+		})
+	}
+}
+
+func TestAcquireLock_Stale(t *testing.T) {
+	ctx := context.Background()
+	s := storage.NewLocalStorage(t.TempDir())
+	name := lockName("mysql", "shop")
+
+	put := func(info lockInfo) {
+		data, err := json.Marshal(info)
+		require.NoError(t, err)
+		require.NoError(t, s.PutMetadata(ctx, name, data))
+	}
+
+	// Older than the stale age, from another host
+	put(lockInfo{Token: "old", PID: 1, Host: "elsewhere", CreatedAt: time.Now().Add(-2 * time.Hour)})
+	release, err := acquireLock(ctx, s, name, time.Hour)
+	require.NoError(t, err)
+	release()
+
+	// Recent, but its process on this host is gone
+	host, err := os.Hostname()
+	require.NoError(t, err)
+	put(lockInfo{Token: "dead", PID: 1 << 22, Host: host, CreatedAt: time.Now()})
+	release, err = acquireLock(ctx, s, name, time.Hour)
+	require.NoError(t, err)
+
+	// A run that outlived its lock leaves the new holder's lock alone
+	var taken lockInfo
+	data, err := s.GetMetadata(ctx, name)
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(data, &taken))
+	taken.Token = "newer"
+	put(taken)
+	release()
+	exists, err := s.Exists(ctx, name)
+	require.NoError(t, err)
+	assert.True(t, exists)
+}
+
+// flakyReads fails reads of existing files, like a dropped connection.
+type flakyReads struct{ storage.Storage }
+
+func (f flakyReads) GetMetadata(ctx context.Context, name string) ([]byte, error) {
+	return nil, errors.New("connection reset")
+}
+
+func TestAcquireLock_ReadError(t *testing.T) {
+	ctx := context.Background()
+	name := lockName("postgres", "app")
+	for _, tc := range []struct {
+		name string
+		s    storage.Storage
+	}{
+		{"atomic", storage.NewLocalStorage(t.TempDir())},
+		{"write and read back", plainStorage{storage.NewMemStorage(t.Name())}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			release, err := acquireLock(ctx, tc.s, name, staleLockAge)
+			require.NoError(t, err)
+			defer func() { release() }()
+			held, err := tc.s.GetMetadata(ctx, name)
+			require.NoError(t, err)
+
+			// A lock that can't be read is not free
+			_, err = acquireLock(ctx, flakyReads{tc.s}, name, staleLockAge)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), "connection reset")
+			got, err := tc.s.GetMetadata(ctx, name)
+			require.NoError(t, err)
+			assert.Equal(t, held, got, "the holder keeps its lock")
+
+			// A missing lock still is, even when the read error doesn't say so
+			release()
+			release, err = acquireLock(ctx, flakyReads{tc.s}, name, staleLockAge)
+			require.NoError(t, err)
+		})
+	}
+}
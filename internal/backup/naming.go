@@ -0,0 +1,106 @@
+package backup
+
+import (
+	"os"
+	"strings"
+	"time"
+
+	database "github.com/lupppig/dbackup/internal/db"
+	apperrors "github.com/lupppig/dbackup/internal/errors"
+)
+
+// Default layouts of the {date} and {time} name template fields. {time}
+// has milliseconds, as the default name does, so backups started within the
+// same second don't overwrite each other.
+const (
+	nameDateLayout = "20060102"
+	nameTimeLayout = "150405.000"
+)
+
+// ValidateNameTemplate checks a --name-template before any backup starts.
+func ValidateNameTemplate(tmpl string) error {
+	if tmpl == "" {
+		return nil
+	}
+	_, err := expandNameTemplate(tmpl, database.ConnectionParams{DBType: "postgres", DBName: "db", Host: "host"}, time.Now())
+	return err
+}
+
+// expandNameTemplate builds a backup name from tmpl. The fields are
+// {engine}, {db}, {host} (the database host, or this machine for local
+// engines), {date} and {time}; the last two take an optional Go layout, as
+// in {date:2006/01/02}. Slashes in the template make directories on the
+// target, but field values can't add any, and the name may not leave the
+// target.
+func expandNameTemplate(tmpl string, conn database.ConnectionParams, at time.Time) (string, error) {
+	var b strings.Builder
+	rest := tmpl
+	for {
+		open := strings.IndexByte(rest, '{')
+		if open < 0 {
+			if strings.IndexByte(rest, '}') >= 0 {
+				return "", nameTemplateError(tmpl, "unmatched }")
+			}
+			b.WriteString(rest)
+			break
+		}
+		if strings.IndexByte(rest[:open], '}') >= 0 {
+			return "", nameTemplateError(tmpl, "unmatched }")
+		}
+		b.WriteString(rest[:open])
+		end := strings.IndexByte(rest[open:], '}')
+		if end < 0 {
+			return "", nameTemplateError(tmpl, "unclosed {")
+		}
+		field, layout, hasLayout := strings.Cut(rest[open+1:open+end], ":")
+		rest = rest[open+end+1:]
+
+		if hasLayout && layout == "" || hasLayout && field != "date" && field != "time" {
+			return "", nameTemplateError(tmpl, "only {date} and {time} take a layout")
+		}
+		switch field {
+		case "engine":
+			b.WriteString(namePart(strings.ToLower(conn.DBType)))
+		case "db":
+			b.WriteString(namePart(conn.DBName))
+		case "host":
+			host := conn.Host
+			if host == "" {
+				host, _ = os.Hostname()
+			}
+			b.WriteString(namePart(host))
+		case "date", "time":
+			if !hasLayout {
+				layout = map[string]string{"date": nameDateLayout, "time": nameTimeLayout}[field]
+			}
+			b.WriteString(at.Format(layout))
+		default:
+			return "", nameTemplateError(tmpl, "unknown field {"+field+"}")
+		}
+	}
+
+	name := b.String()
+	if strings.ContainsRune(name, '\\') {
+		return "", nameTemplateError(tmpl, "use / to separate directories")
+	}
+	for _, elem := range strings.Split(name, "/") {
+		if elem == "" || elem == "." || elem == ".." {
+			return "", nameTemplateError(tmpl, "the name "+name+" is absolute, has an empty directory or leaves the target")
+		}
+	}
+	return name, nil
+}
+
+// namePart makes a field value safe as part of a single path element.
+func namePart(s string) string {
+	s = strings.NewReplacer("/", "_", "\\", "_").Replace(s)
+	if s == "" || s == "." || s == ".." {
+		return "_"
+	}
+	return s
+}
+
+func nameTemplateError(tmpl, reason string) error {
+	return apperrors.New(apperrors.TypeConfig, "invalid name template "+tmpl+": "+reason,
+		"Use fields {engine}, {db}, {host}, {date} and {time}, e.g. {db}/{date:2006/01/02}/{engine}-{time}.sql.")
+}
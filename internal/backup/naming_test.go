@@ -0,0 +1,68 @@
+package backup
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	database "github.com/lupppig/dbackup/internal/db"
+	apperrors "github.com/lupppig/dbackup/internal/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpandNameTemplate(t *testing.T) {
+	at := time.Date(2024, 3, 9, 14, 5, 7, 42e6, time.UTC)
+	conn := database.ConnectionParams{DBType: "Postgres", DBName: "shop", Host: "db1.internal"}
+
+	for tmpl, want := range map[string]string{
+		"{engine}-{db}-{date}-{time}.sql":             "postgres-shop-20240309-140507.042.sql",
+		"{db}/{date:2006/01/02}/{engine}-{time}.sql":  "shop/2024/03/09/postgres-140507.042.sql",
+		"{host}/{db}-{date:2006-01}-{time:1504}.dump": "db1.internal/shop-2024-03-1405.dump",
+		"nightly.sql": "nightly.sql",
+	} {
+		got, err := expandNameTemplate(tmpl, conn, at)
+		require.NoError(t, err, tmpl)
+		assert.Equal(t, want, got, tmpl)
+	}
+
+	// Values never add directories or climb out of the target
+	got, err := expandNameTemplate("{db}/{engine}.sql", database.ConnectionParams{DBType: "sqlite", DBName: "/data/../app.db"}, at)
+	require.NoError(t, err)
+	assert.Equal(t, "_data_.._app.db/sqlite.sql", got)
+	got, err = expandNameTemplate("{db}/x.sql", database.ConnectionParams{DBType: "mysql", DBName: ".."}, at)
+	require.NoError(t, err)
+	assert.Equal(t, "_/x.sql", got)
+
+	for _, tmpl := range []string{
+		"{tag:env}.sql",
+		"{engine:upper}.sql",
+		"{date:}.sql",
+		"{db.sql",
+		"db}.sql",
+		"/{db}.sql",
+		"../{db}.sql",
+		"{db}//{time}.sql",
+		`{db}\{time}.sql`,
+	} {
+		err := ValidateNameTemplate(tmpl)
+		assert.True(t, apperrors.IsType(err, apperrors.TypeConfig), "%s: %v", tmpl, err)
+	}
+	assert.NoError(t, ValidateNameTemplate(""))
+}
+
+func TestBackupManager_NameTemplate(t *testing.T) {
+	dir := t.TempDir()
+	mgr, err := NewBackupManager(BackupOptions{StorageURI: dir, NameTemplate: "{engine}/{date:2006}/{db}-{time}.sql", NoLatest: true})
+	require.NoError(t, err)
+	require.NoError(t, mgr.Run(context.Background(), &database.SqliteAdapter{}, database.ConnectionParams{DBType: "sqlite", DBName: sqliteFixture(t, 1)}))
+
+	matches, err := filepath.Glob(filepath.Join(dir, "sqlite", time.Now().Format("2006"), "*_app.db-*.sql"))
+	require.NoError(t, err)
+	require.Len(t, matches, 1)
+	_, err = os.Stat(matches[0] + ".manifest")
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.ToSlash(filepath.Join("sqlite", time.Now().Format("2006"), filepath.Base(matches[0])+".manifest")), mgr.ManifestName())
+}
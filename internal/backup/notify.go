@@ -0,0 +1,47 @@
+package backup
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/lupppig/dbackup/internal/notify"
+)
+
+// notifyStart sends stats as a started notification when NotifyOnStart is
+// set: right away, or after NotifyStartAfter so short runs stay quiet. The
+// returned stop cancels a pending notification, and waits for one being sent
+// so it never arrives after the run's outcome.
+func (o BackupOptions) notifyStart(ctx context.Context, stats notify.Stats) (stop func()) {
+	if o.Notifier == nil || !o.NotifyOnStart {
+		return func() {}
+	}
+	stats.Status = notify.StatusStarted
+	start := time.Now()
+
+	var mu sync.Mutex
+	var done bool
+	send := func() {
+		mu.Lock()
+		defer mu.Unlock()
+		if done {
+			return
+		}
+		if o.NotifyStartAfter > 0 {
+			stats.Duration = time.Since(start)
+		}
+		o.Notifier.Notify(ctx, stats) // #nosec G104
+	}
+
+	if o.NotifyStartAfter <= 0 {
+		send()
+		return func() {}
+	}
+	t := time.AfterFunc(o.NotifyStartAfter, send)
+	return func() {
+		t.Stop()
+		mu.Lock()
+		done = true
+		mu.Unlock()
+	}
+}
@@ -1,8 +1,11 @@
 package backup
 
 import (
+	"fmt"
 	"io"
 	"os"
+	"sync/atomic"
+	"time"
 
 	"github.com/mattn/go-isatty"
 
@@ -55,11 +58,17 @@ func (bc *ByteCounter) Write(p []byte) (int, error) {
 	return n, nil
 }
 
-func NewProgressContainer() *mpb.Progress {
+// NewProgressContainer returns nil when stdout is not a terminal. A zero
+// refresh keeps mpb's default redraw interval.
+func NewProgressContainer(refresh time.Duration) *mpb.Progress {
 	if !isatty.IsTerminal(os.Stdout.Fd()) && !isatty.IsCygwinTerminal(os.Stdout.Fd()) {
 		return nil
 	}
-	return mpb.New(mpb.WithWidth(64))
+	opts := []mpb.ContainerOption{mpb.WithWidth(64)}
+	if refresh > 0 {
+		opts = append(opts, mpb.WithRefreshRate(refresh))
+	}
+	return mpb.New(opts...)
 }
 
 func AddBackupBar(p *mpb.Progress, name string) *mpb.Bar {
@@ -78,10 +87,24 @@ func AddBackupBar(p *mpb.Progress, name string) *mpb.Bar {
 	)
 }
 
+// AddRestoreBar shows a download of total bytes. The ETA is averaged over the
+// whole transfer so a slow stretch, such as a chunk rebuilt from parity, does
+// not make it jump. Without a known total it only counts bytes.
 func AddRestoreBar(p *mpb.Progress, name string, total int64) *mpb.Bar {
 	if p == nil {
 		return nil
 	}
+	if total <= 0 {
+		return p.AddBar(0,
+			mpb.PrependDecorators(
+				decor.Name(name, decor.WC{W: len(name) + 4}),
+				decor.CurrentKibiByte("% .2f", decor.WC{W: 12}),
+			),
+			mpb.AppendDecorators(
+				decor.OnComplete(decor.Spinner(nil, decor.WC{W: 5}), "DONE"),
+			),
+		)
+	}
 	return p.AddBar(total,
 		mpb.PrependDecorators(
 			decor.Name(name, decor.WC{W: len(name) + 4}),
@@ -92,6 +115,50 @@ func AddRestoreBar(p *mpb.Progress, name string, total int64) *mpb.Bar {
 				decor.CountersKibiByte("% .2f / % .2f"),
 				"DONE",
 			),
+			decor.OnComplete(decor.AverageETA(decor.ET_STYLE_GO, decor.WC{W: 8}), ""),
 		),
 	)
 }
+
+// finishBar completes bar at its current count, or drops it after a failed
+// transfer so it doesn't claim to be done.
+func finishBar(bar *mpb.Bar, err error) {
+	if bar == nil {
+		return
+	}
+	if err != nil {
+		bar.Abort(false)
+		return
+	}
+	bar.SetTotal(bar.Current(), true)
+}
+
+// AddChunkBar shows dedupe chunk uploads. The chunk count of a stream isn't
+// known up front, so it is a spinner with running totals and the hit rate.
+// The returned callback is meant for DedupeStorage.SetOnChunk.
+func AddChunkBar(p *mpb.Progress, name string) (*mpb.Bar, func(uploaded, total int, bytes int64)) {
+	if p == nil {
+		return nil, nil
+	}
+	var uploaded, total, sent atomic.Int64
+	bar := p.New(0, mpb.SpinnerStyle(),
+		mpb.PrependDecorators(
+			decor.Name(name, decor.WC{W: len(name) + 4}),
+		),
+		mpb.AppendDecorators(
+			decor.Any(func(decor.Statistics) string {
+				n, u := total.Load(), uploaded.Load()
+				hits := 0.0
+				if n > 0 {
+					hits = float64(n-u) / float64(n) * 100
+				}
+				return fmt.Sprintf("%d chunks, %d new (% .2f), %.0f%% deduplicated", n, u, decor.SizeB1024(sent.Load()), hits)
+			}),
+		),
+	)
+	return bar, func(u, n int, b int64) {
+		uploaded.Store(int64(u))
+		total.Store(int64(n))
+		sent.Store(b)
+	}
+}
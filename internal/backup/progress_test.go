@@ -2,7 +2,11 @@ package backup
 
 import (
 	"bytes"
+	"errors"
+	"io"
 	"testing"
+
+	"github.com/vbauerster/mpb/v8"
 )
 
 func TestProgressReader_NilBar(t *testing.T) {
@@ -36,3 +40,25 @@ func TestProgressWriter_NilBar(t *testing.T) {
 		t.Errorf("expected %q, got %q", string(data), buf.String())
 	}
 }
+
+func TestFinishBar(t *testing.T) {
+	p := mpb.New(mpb.WithOutput(io.Discard))
+
+	// An unknown total still completes at whatever was read
+	done := AddRestoreBar(p, "Download", 0)
+	done.IncrBy(42)
+	finishBar(done, nil)
+
+	failed := AddRestoreBar(p, "Download", 100)
+	failed.IncrBy(10)
+	finishBar(failed, errors.New("read failed"))
+
+	p.Wait()
+	if !done.Completed() {
+		t.Error("expected the bar to complete after a successful transfer")
+	}
+	if failed.Completed() || !failed.Aborted() {
+		t.Error("expected the bar to be aborted after a failed transfer")
+	}
+	finishBar(nil, nil)
+}
@@ -15,6 +15,21 @@ import (
 type PruneManager struct {
 	storage storage.Storage
 	options PruneOptions
+	last    PruneSummary
+}
+
+// PruneSummary is what a Prune removed or, with DryRun, would remove.
+type PruneSummary struct {
+	Pruned          []string // Backup names, oldest first
+	ReclaimedBytes  int64    // Stored size of the pruned backups that weren't deduplicated
+	ReclaimedChunks int64    // Dedupe chunks no remaining backup used, deleted with them
+	DryRun          bool
+
+	DeletedBackups   []string // Backup files actually deleted
+	DeletedManifests []string // Manifests actually deleted, including expired tombstones
+	Tombstoned       []string // Manifests of pruned backups kept as tombstones
+	Kept             int      // Matching backups retention left in place
+	Errors           []error  // Deletes that failed; the rest of the pass still ran
 }
 
 type PruneOptions struct {
@@ -23,7 +38,16 @@ type PruneOptions struct {
 	RetentionPolicy RetentionPolicy
 	DBType          string
 	DBName          string
-	Logger          *logger.Logger
+	// AllowPruneAll lets retention remove every backup of a database;
+	// otherwise the newest one is always kept as a recovery point.
+	AllowPruneAll bool
+	// ManifestRetention keeps the manifest of a pruned backup as a
+	// tombstone for this long after its data is deleted; zero deletes it
+	// with the data.
+	ManifestRetention time.Duration
+	// DryRun works out and logs what would be pruned without deleting it.
+	DryRun bool
+	Logger *logger.Logger
 }
 
 func NewPruneManager(s storage.Storage, opts PruneOptions) *PruneManager {
@@ -33,13 +57,33 @@ func NewPruneManager(s storage.Storage, opts PruneOptions) *PruneManager {
 	}
 }
 
+// Prune runs PruneWithResult; Pruned reports what it removed.
 func (m *PruneManager) Prune(ctx context.Context) error {
-	policy := m.options.RetentionPolicy
-	if m.options.Retention == 0 && m.options.Keep == 0 &&
-		policy.KeepDaily == 0 && policy.KeepWeekly == 0 &&
-		policy.KeepMonthly == 0 && policy.KeepYearly == 0 {
+	summary, err := m.PruneWithResult(ctx)
+	m.last = summary
+	return err
+}
+
+// Pruned returns the backups removed by the last Prune, oldest first. A dry
+// run removes none.
+func (m *PruneManager) Pruned() []string {
+	if m.last.DryRun {
 		return nil
 	}
+	return m.last.Pruned
+}
+
+// PruneWithResult applies the retention options and reports what was, or
+// with DryRun would be, removed.
+func (m *PruneManager) PruneWithResult(ctx context.Context) (PruneSummary, error) {
+	summary := PruneSummary{DryRun: m.options.DryRun}
+	policy := m.options.RetentionPolicy
+	hasPolicy := m.options.Retention != 0 || m.options.Keep != 0 ||
+		policy.KeepDaily != 0 || policy.KeepWeekly != 0 ||
+		policy.KeepMonthly != 0 || policy.KeepYearly != 0
+	if !hasPolicy && m.options.ManifestRetention == 0 {
+		return summary, nil
+	}
 
 	// List all manifests
 	//  prefix based on DBType and DBName if possible, or just list all backups
@@ -48,14 +92,15 @@ func (m *PruneManager) Prune(ctx context.Context) error {
 	// Let's list all .manifest files.
 	files, err := m.storage.ListMetadata(ctx, "")
 	if err != nil {
-		return fmt.Errorf("failed to list manifests for pruning: %w", err)
+		return summary, fmt.Errorf("failed to list manifests for pruning: %w", err)
 	}
 
-	var manifests []*manifest.Manifest
+	var manifests, tombstones []*manifest.Manifest
 	manifestMap := make(map[string]string) // manifest name -> data
+	pointed := make(map[string]bool)       // manifests a latest pointer names
 
 	for _, file := range files {
-		if !strings.HasSuffix(file, ".manifest") {
+		if !strings.HasSuffix(file, ".manifest") || manifest.IsWAL(file) {
 			continue
 		}
 
@@ -63,6 +108,12 @@ func (m *PruneManager) Prune(ctx context.Context) error {
 		if err != nil {
 			continue
 		}
+		if manifest.IsLatest(file) {
+			if target, ok := manifest.ParsePointer(data); ok {
+				pointed[target] = true
+			}
+			continue
+		}
 
 		man, err := manifest.Deserialize(data)
 		if err != nil {
@@ -76,12 +127,17 @@ func (m *PruneManager) Prune(ctx context.Context) error {
 			continue
 		}
 
-		manifests = append(manifests, man)
 		manifestMap[man.ID] = file
+		if man.Pruned {
+			tombstones = append(tombstones, man)
+			continue
+		}
+		manifests = append(manifests, man)
 	}
+	m.expireTombstones(ctx, tombstones, manifestMap, &summary)
 
-	if len(manifests) == 0 {
-		return nil
+	if !hasPolicy || len(manifests) == 0 {
+		return summary, nil
 	}
 
 	// Sort by CreatedAt descending (newest first)
@@ -124,31 +180,150 @@ func (m *PruneManager) Prune(ctx context.Context) error {
 		}
 	}
 
-	for id, deleteMe := range toDelete {
-		if !deleteMe {
+	if !m.options.AllowPruneAll {
+		m.keepNewestPerDB(manifests, toDelete)
+		// Restores without a name follow the pointers
+		for _, man := range manifests {
+			if pointed[manifestMap[man.ID]] {
+				toDelete[man.ID] = false
+			}
+		}
+	}
+
+	// Deduplicated targets count the chunks they free
+	counter, counts := m.storage.(interface{ ReclaimedChunks() int64 })
+	var chunksBefore int64
+	if counts {
+		chunksBefore = counter.ReclaimedChunks()
+	}
+
+	// Oldest first, so logs and notifications read in order
+	for i := len(manifests) - 1; i >= 0; i-- {
+		man := manifests[i]
+		if !toDelete[man.ID] {
 			continue
 		}
-		manifestName := manifestMap[id]
+		manifestName := manifestMap[man.ID]
 		// Determine backup file name from manifest
 		// By convention, backupName.manifest
 		backupName := strings.TrimSuffix(manifestName, ".manifest")
+		summary.Pruned = append(summary.Pruned, backupName)
+		if len(man.Chunks) == 0 {
+			summary.ReclaimedBytes += man.Size
+		}
 
+		if m.options.DryRun {
+			if m.options.Logger != nil {
+				m.options.Logger.Info("[DRY-RUN] Would prune old backup", "file", backupName)
+			}
+			continue
+		}
 		if m.options.Logger != nil {
 			m.options.Logger.Info("Pruning old backup", "file", backupName)
 		}
 
 		// Delete backup file
-		if err := m.storage.Delete(ctx, backupName); err != nil && m.options.Logger != nil {
-			m.options.Logger.Warn("Failed to prune backup file", "error", err, "file", backupName)
+		if err := m.storage.Delete(ctx, backupName); err != nil {
+			summary.Errors = append(summary.Errors, fmt.Errorf("delete %s: %w", backupName, err))
+			if m.options.Logger != nil {
+				m.options.Logger.Warn("Failed to prune backup file", "error", err, "file", backupName)
+			}
+		} else {
+			summary.DeletedBackups = append(summary.DeletedBackups, backupName)
+		}
+
+		// Delete manifest; on deduplicated targets this frees the chunks
+		if err := m.storage.Delete(ctx, manifestName); err != nil {
+			summary.Errors = append(summary.Errors, fmt.Errorf("delete %s: %w", manifestName, err))
+			if m.options.Logger != nil {
+				m.options.Logger.Warn("Failed to prune manifest", "error", err, "file", manifestName)
+			}
+			continue
+		}
+		if m.options.ManifestRetention > 0 {
+			err := m.putTombstone(ctx, manifestName, man)
+			if err == nil {
+				summary.Tombstoned = append(summary.Tombstoned, manifestName)
+				continue
+			}
+			summary.Errors = append(summary.Errors, fmt.Errorf("tombstone %s: %w", manifestName, err))
+			if m.options.Logger != nil {
+				m.options.Logger.Warn("Failed to keep manifest of pruned backup", "error", err, "file", manifestName)
+			}
 		}
+		summary.DeletedManifests = append(summary.DeletedManifests, manifestName)
+	}
+	summary.Kept = len(manifests) - len(summary.Pruned)
+	if counts {
+		summary.ReclaimedChunks = counter.ReclaimedChunks() - chunksBefore
+	}
+
+	return summary, nil
+}
+
+// putTombstone writes the tombstone of man, whose data was just deleted, in
+// place of its manifest.
+func (m *PruneManager) putTombstone(ctx context.Context, name string, man *manifest.Manifest) error {
+	data, err := man.Tombstone(time.Now()).Serialize()
+	if err != nil {
+		return err
+	}
+	return m.storage.PutMetadata(ctx, name, data)
+}
 
-		// Delete manifest
-		if err := m.storage.Delete(ctx, manifestName); err != nil && m.options.Logger != nil {
-			m.options.Logger.Warn("Failed to prune manifest", "error", err, "file", manifestName)
+// expireTombstones deletes the tombstones older than ManifestRetention.
+// Without one, tombstones left by earlier runs are kept rather than treated
+// as expired.
+func (m *PruneManager) expireTombstones(ctx context.Context, tombstones []*manifest.Manifest, names map[string]string, summary *PruneSummary) {
+	if m.options.ManifestRetention == 0 {
+		return
+	}
+	now := time.Now()
+	for _, t := range tombstones {
+		if now.Sub(t.PrunedAt) <= m.options.ManifestRetention {
+			continue
 		}
+		name := names[t.ID]
+		if m.options.DryRun {
+			if m.options.Logger != nil {
+				m.options.Logger.Info("[DRY-RUN] Would delete expired manifest", "file", name)
+			}
+			continue
+		}
+		if err := m.storage.Delete(ctx, name); err != nil {
+			summary.Errors = append(summary.Errors, fmt.Errorf("delete %s: %w", name, err))
+			if m.options.Logger != nil {
+				m.options.Logger.Warn("Failed to delete expired manifest", "error", err, "file", name)
+			}
+			continue
+		}
+		summary.DeletedManifests = append(summary.DeletedManifests, name)
 	}
+}
 
-	return nil
+// keepNewestPerDB spares the newest backup of each database whose backups
+// were all marked for deletion, so retention never leaves nothing to restore.
+func (m *PruneManager) keepNewestPerDB(manifests []*manifest.Manifest, toDelete map[string]bool) {
+	newest := make(map[string]*manifest.Manifest)
+	survivors := make(map[string]bool)
+	for _, man := range manifests {
+		if _, ok := newest[man.DBName]; !ok {
+			newest[man.DBName] = man
+		}
+		if !toDelete[man.ID] {
+			survivors[man.DBName] = true
+		}
+	}
+	for db, man := range newest {
+		if survivors[db] {
+			continue
+		}
+		toDelete[man.ID] = false
+		if m.options.Logger != nil {
+			m.options.Logger.Warn("Retention would remove every backup, keeping the newest (--allow-prune-all overrides)",
+				"engine", man.Engine, "db", db, "created_at", man.CreatedAt.Format(time.RFC3339))
+		}
+	}
 }
 
 func (m *PruneManager) applyGFSRetention(manifests []*manifest.Manifest, toKeep map[string]bool) {
@@ -2,11 +2,13 @@ package backup
 
 import (
 	"context"
+	"errors"
 	"io"
 	"testing"
 	"time"
 
 	"github.com/lupppig/dbackup/internal/manifest"
+	"github.com/lupppig/dbackup/internal/storage"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 )
@@ -29,6 +31,11 @@ func (m *MockStorage) Exists(ctx context.Context, name string) (bool, error) {
 	return true, nil
 }
 
+func (m *MockStorage) Stat(ctx context.Context, name string) (storage.StorageInfo, error) {
+	args := m.Called(ctx, name)
+	return args.Get(0).(storage.StorageInfo), args.Error(1)
+}
+
 func (m *MockStorage) Delete(ctx context.Context, name string) error {
 	args := m.Called(ctx, name)
 	return args.Error(0)
@@ -86,8 +93,12 @@ func TestPruneManager_Prune(t *testing.T) {
 		DBName: "db1",
 	})
 
-	err := pm.Prune(ctx)
+	summary, err := pm.PruneWithResult(ctx)
 	assert.NoError(t, err)
+	assert.Equal(t, []string{"b1"}, summary.DeletedBackups)
+	assert.Equal(t, []string{"b1.manifest"}, summary.DeletedManifests)
+	assert.Equal(t, 2, summary.Kept)
+	assert.Empty(t, summary.Errors)
 
 	ms.AssertExpectations(t)
 }
@@ -117,8 +128,256 @@ func TestPruneManager_Retention(t *testing.T) {
 		DBName:    "db1",
 	})
 
-	err := pm.Prune(ctx)
+	summary, err := pm.PruneWithResult(ctx)
 	assert.NoError(t, err)
+	assert.Equal(t, []string{"old.manifest"}, summary.DeletedManifests)
+	assert.Equal(t, 1, summary.Kept)
 
 	ms.AssertExpectations(t)
 }
+
+func TestPruneManager_KeepsNewestWhenAllExpired(t *testing.T) {
+	ctx := context.Background()
+	ms := new(MockStorage)
+
+	// Both backups are older than the 1h retention
+	m1 := &manifest.Manifest{ID: "m1", Engine: "postgres", DBName: "db1", CreatedAt: time.Now().Add(-72 * time.Hour)}
+	m2 := &manifest.Manifest{ID: "m2", Engine: "postgres", DBName: "db1", CreatedAt: time.Now().Add(-48 * time.Hour)}
+
+	m1b, _ := m1.Serialize()
+	m2b, _ := m2.Serialize()
+
+	ms.On("ListMetadata", ctx, "").Return([]string{"old.manifest", "newer.manifest"}, nil)
+	ms.On("GetMetadata", ctx, "old.manifest").Return(m1b, nil)
+	ms.On("GetMetadata", ctx, "newer.manifest").Return(m2b, nil)
+
+	// Only the oldest goes; the newest survives as the recovery point
+	ms.On("Delete", ctx, "old").Return(nil)
+	ms.On("Delete", ctx, "old.manifest").Return(nil)
+
+	pm := NewPruneManager(ms, PruneOptions{
+		Retention: time.Hour,
+		DBType:    "postgres",
+		DBName:    "db1",
+	})
+
+	summary, err := pm.PruneWithResult(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"old"}, summary.Pruned)
+	assert.Equal(t, []string{"old.manifest"}, summary.DeletedManifests)
+	assert.Equal(t, 1, summary.Kept)
+
+	ms.AssertExpectations(t)
+	ms.AssertNotCalled(t, "Delete", ctx, "newer")
+}
+
+func TestPruneManager_KeepsLatestPointerTarget(t *testing.T) {
+	ctx := context.Background()
+	ms := new(MockStorage)
+
+	// The newest backup was taken with --label-latest=false, so the
+	// pointer still names the oldest
+	m1 := &manifest.Manifest{ID: "m1", Engine: "postgres", DBName: "db1", CreatedAt: time.Now().Add(-24 * time.Hour)}
+	m2 := &manifest.Manifest{ID: "m2", Engine: "postgres", DBName: "db1", CreatedAt: time.Now().Add(-12 * time.Hour)}
+	m3 := &manifest.Manifest{ID: "m3", Engine: "postgres", DBName: "db1", CreatedAt: time.Now()}
+	m1b, _ := m1.Serialize()
+	m2b, _ := m2.Serialize()
+	m3b, _ := m3.Serialize()
+
+	ms.On("ListMetadata", ctx, "").Return([]string{"b1.manifest", "b2.manifest", "b3.manifest", manifest.LatestName}, nil)
+	ms.On("GetMetadata", ctx, "b1.manifest").Return(m1b, nil)
+	ms.On("GetMetadata", ctx, "b2.manifest").Return(m2b, nil)
+	ms.On("GetMetadata", ctx, "b3.manifest").Return(m3b, nil)
+	ms.On("GetMetadata", ctx, manifest.LatestName).Return(manifest.NewPointer("b1.manifest"), nil)
+	ms.On("Delete", ctx, "b2").Return(nil)
+	ms.On("Delete", ctx, "b2.manifest").Return(nil)
+
+	pm := NewPruneManager(ms, PruneOptions{
+		Keep:   1,
+		DBType: "postgres",
+		DBName: "db1",
+	})
+
+	summary, err := pm.PruneWithResult(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"b2"}, summary.DeletedBackups)
+
+	ms.AssertExpectations(t)
+	ms.AssertNotCalled(t, "Delete", ctx, "b1")
+}
+
+func TestPruneManager_AllowPruneAll(t *testing.T) {
+	ctx := context.Background()
+	ms := new(MockStorage)
+
+	m1 := &manifest.Manifest{ID: "m1", Engine: "postgres", DBName: "db1", CreatedAt: time.Now().Add(-48 * time.Hour)}
+	m1b, _ := m1.Serialize()
+
+	ms.On("ListMetadata", ctx, "").Return([]string{"old.manifest"}, nil)
+	ms.On("GetMetadata", ctx, "old.manifest").Return(m1b, nil)
+	ms.On("Delete", ctx, "old").Return(nil)
+	ms.On("Delete", ctx, "old.manifest").Return(nil)
+
+	pm := NewPruneManager(ms, PruneOptions{
+		Retention:     time.Hour,
+		DBType:        "postgres",
+		DBName:        "db1",
+		AllowPruneAll: true,
+	})
+
+	// The thin Prune wrapper keeps its summary for Pruned
+	assert.NoError(t, pm.Prune(ctx))
+	assert.Equal(t, []string{"old"}, pm.Pruned())
+
+	ms.AssertExpectations(t)
+}
+
+func TestPruneManager_DryRun(t *testing.T) {
+	ctx := context.Background()
+	ms := new(MockStorage)
+
+	m1 := &manifest.Manifest{ID: "m1", Engine: "postgres", DBName: "db1", Size: 300, CreatedAt: time.Now().Add(-72 * time.Hour)}
+	m2 := &manifest.Manifest{ID: "m2", Engine: "postgres", DBName: "db1", Size: 200, CreatedAt: time.Now().Add(-48 * time.Hour)}
+	m3 := &manifest.Manifest{ID: "m3", Engine: "postgres", DBName: "db1", Size: 100, CreatedAt: time.Now()}
+
+	m1b, _ := m1.Serialize()
+	m2b, _ := m2.Serialize()
+	m3b, _ := m3.Serialize()
+
+	ms.On("ListMetadata", ctx, "").Return([]string{"b1.manifest", "b2.manifest", "b3.manifest"}, nil)
+	ms.On("GetMetadata", ctx, "b1.manifest").Return(m1b, nil)
+	ms.On("GetMetadata", ctx, "b2.manifest").Return(m2b, nil)
+	ms.On("GetMetadata", ctx, "b3.manifest").Return(m3b, nil)
+
+	pm := NewPruneManager(ms, PruneOptions{
+		Retention: 24 * time.Hour,
+		DBType:    "postgres",
+		DBName:    "db1",
+		DryRun:    true,
+	})
+
+	summary, err := pm.PruneWithResult(ctx)
+	assert.NoError(t, err)
+	assert.True(t, summary.DryRun)
+	assert.Equal(t, []string{"b1", "b2"}, summary.Pruned)
+	assert.Equal(t, int64(500), summary.ReclaimedBytes)
+	assert.Empty(t, summary.DeletedBackups)
+	assert.Empty(t, summary.DeletedManifests)
+	assert.Equal(t, 1, summary.Kept)
+
+	// Nothing was removed
+	assert.NoError(t, pm.Prune(ctx))
+	assert.Empty(t, pm.Pruned())
+
+	ms.AssertNotCalled(t, "Delete", mock.Anything, mock.Anything)
+}
+
+func TestPruneManager_ReportsDeleteErrors(t *testing.T) {
+	ctx := context.Background()
+	ms := new(MockStorage)
+
+	m1 := &manifest.Manifest{ID: "m1", Engine: "postgres", DBName: "db1", CreatedAt: time.Now().Add(-48 * time.Hour)}
+	m2 := &manifest.Manifest{ID: "m2", Engine: "postgres", DBName: "db1", CreatedAt: time.Now()}
+	m1b, _ := m1.Serialize()
+	m2b, _ := m2.Serialize()
+
+	ms.On("ListMetadata", ctx, "").Return([]string{"old.manifest", "new.manifest"}, nil)
+	ms.On("GetMetadata", ctx, "old.manifest").Return(m1b, nil)
+	ms.On("GetMetadata", ctx, "new.manifest").Return(m2b, nil)
+	ms.On("Delete", ctx, "old").Return(errors.New("permission denied"))
+	ms.On("Delete", ctx, "old.manifest").Return(nil)
+
+	pm := NewPruneManager(ms, PruneOptions{
+		Retention: 24 * time.Hour,
+		DBType:    "postgres",
+		DBName:    "db1",
+	})
+
+	// A failed delete is reported, not fatal
+	summary, err := pm.PruneWithResult(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, summary.DeletedBackups)
+	assert.Equal(t, []string{"old.manifest"}, summary.DeletedManifests)
+	if assert.Len(t, summary.Errors, 1) {
+		assert.Contains(t, summary.Errors[0].Error(), "old: permission denied")
+	}
+}
+
+func TestPruneManager_ManifestRetention(t *testing.T) {
+	ctx := context.Background()
+	ms := new(MockStorage)
+
+	old := &manifest.Manifest{ID: "m1", Engine: "postgres", DBName: "db1", Chunks: []string{"c1"}, CreatedAt: time.Now().Add(-48 * time.Hour)}
+	cur := &manifest.Manifest{ID: "m2", Engine: "postgres", DBName: "db1", CreatedAt: time.Now()}
+	fresh := (&manifest.Manifest{ID: "m3", Engine: "postgres", DBName: "db1", CreatedAt: time.Now().Add(-72 * time.Hour)}).Tombstone(time.Now().Add(-time.Hour))
+	expired := (&manifest.Manifest{ID: "m4", Engine: "postgres", DBName: "db1", CreatedAt: time.Now().Add(-30 * 24 * time.Hour)}).Tombstone(time.Now().Add(-10 * 24 * time.Hour))
+	oldb, _ := old.Serialize()
+	curb, _ := cur.Serialize()
+	freshb, _ := fresh.Serialize()
+	expiredb, _ := expired.Serialize()
+
+	ms.On("ListMetadata", ctx, "").Return([]string{"old.manifest", "cur.manifest", "fresh.manifest", "expired.manifest"}, nil)
+	ms.On("GetMetadata", ctx, "old.manifest").Return(oldb, nil)
+	ms.On("GetMetadata", ctx, "cur.manifest").Return(curb, nil)
+	ms.On("GetMetadata", ctx, "fresh.manifest").Return(freshb, nil)
+	ms.On("GetMetadata", ctx, "expired.manifest").Return(expiredb, nil)
+	ms.On("Delete", ctx, "old").Return(nil)
+	ms.On("Delete", ctx, "old.manifest").Return(nil)
+	ms.On("Delete", ctx, "expired.manifest").Return(nil)
+
+	var tombstone []byte
+	ms.On("PutMetadata", ctx, "old.manifest", mock.Anything).Run(func(args mock.Arguments) {
+		tombstone = args.Get(2).([]byte)
+	}).Return(nil)
+
+	pm := NewPruneManager(ms, PruneOptions{
+		Retention:         24 * time.Hour,
+		ManifestRetention: 7 * 24 * time.Hour,
+		DBType:            "postgres",
+		DBName:            "db1",
+	})
+
+	summary, err := pm.PruneWithResult(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"old"}, summary.Pruned)
+	assert.Equal(t, []string{"old"}, summary.DeletedBackups)
+	assert.Equal(t, []string{"old.manifest"}, summary.Tombstoned)
+	assert.Equal(t, []string{"expired.manifest"}, summary.DeletedManifests, "only the tombstone past --manifest-retention goes")
+	assert.Equal(t, 1, summary.Kept, "tombstones don't count as kept backups")
+
+	// The manifest is rewritten, after the delete freed its chunks
+	got, err := manifest.Deserialize(tombstone)
+	assert.NoError(t, err)
+	assert.True(t, got.Pruned)
+	assert.Empty(t, got.Chunks)
+	assert.Equal(t, "m1", got.ID)
+	ms.AssertNotCalled(t, "Delete", ctx, "fresh.manifest")
+}
+
+func TestPruneManager_ManifestRetentionOnly(t *testing.T) {
+	ctx := context.Background()
+	ms := new(MockStorage)
+
+	expired := (&manifest.Manifest{ID: "m1", Engine: "postgres", DBName: "db1"}).Tombstone(time.Now().Add(-48 * time.Hour))
+	cur := &manifest.Manifest{ID: "m2", Engine: "postgres", DBName: "db1", CreatedAt: time.Now().Add(-365 * 24 * time.Hour)}
+	expiredb, _ := expired.Serialize()
+	curb, _ := cur.Serialize()
+
+	ms.On("ListMetadata", ctx, "").Return([]string{"expired.manifest", "cur.manifest"}, nil)
+	ms.On("GetMetadata", ctx, "expired.manifest").Return(expiredb, nil)
+	ms.On("GetMetadata", ctx, "cur.manifest").Return(curb, nil)
+	ms.On("Delete", ctx, "expired.manifest").Return(nil)
+
+	// Without a backup retention policy, tombstones still expire
+	pm := NewPruneManager(ms, PruneOptions{
+		ManifestRetention: 24 * time.Hour,
+		DBType:            "postgres",
+		DBName:            "db1",
+	})
+
+	summary, err := pm.PruneWithResult(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, summary.Pruned)
+	assert.Equal(t, []string{"expired.manifest"}, summary.DeletedManifests)
+	ms.AssertNotCalled(t, "Delete", ctx, "cur")
+}
@@ -1,9 +1,9 @@
 package backup
 
 import (
+	"bufio"
 	"bytes"
 	"context"
-	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
 	"io"
@@ -29,6 +29,7 @@ type RestoreManager struct {
 func NewRestoreManager(opts BackupOptions) (*RestoreManager, error) {
 	s, err := storage.FromURI(opts.StorageURI, storage.StorageOptions{
 		AllowInsecure: opts.AllowInsecure,
+		TmpDir:        opts.TmpDir,
 	})
 	if err != nil {
 		return nil, err
@@ -58,8 +59,222 @@ func (m *RestoreManager) SetStorage(s storage.Storage) {
 	m.storage = s
 }
 
+// checkTmpSpace fails before downloading when the restore workspace is too
+// small for the backup, rather than hitting ENOSPC halfway through.
+func (m *RestoreManager) checkTmpSpace(size int64) error {
+	dir := m.Options.TmpDir
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	free, err := storage.FreeSpace(dir)
+	if err != nil || free >= uint64(size) {
+		return nil
+	}
+	return apperrors.New(apperrors.TypeResource,
+		fmt.Sprintf("not enough space for the restore workspace in %s: %d bytes free, %d needed", dir, free, size),
+		"Point --tmp-dir (or DBACKUP_TMP) at a larger filesystem.")
+}
+
+// checkChunks fails before anything is downloaded or applied when chunks of
+// a deduplicated backup are gone and parity cannot rebuild them.
+func (m *RestoreManager) checkChunks(ctx context.Context, chunks []string) error {
+	cs, ok := m.storage.(interface {
+		UnrecoverableChunks(context.Context, []string) ([]string, error)
+	})
+	if !ok {
+		return nil
+	}
+	lost, err := cs.UnrecoverableChunks(ctx, chunks)
+	if err != nil {
+		return apperrors.Wrap(err, apperrors.TypeConnection, "failed to check backup chunks", "Check that the storage target is reachable.")
+	}
+	if len(lost) > 0 {
+		return apperrors.New(apperrors.TypeIntegrity,
+			fmt.Sprintf("backup is missing %d unrecoverable chunk(s), e.g. %s", len(lost), lost[0]),
+			"The database was not touched. Run 'dbackup repair' from a healthy replica, or restore an older backup.")
+	}
+	return nil
+}
+
+// loadDict fetches the zstd dictionary a backup was compressed with.
+func (m *RestoreManager) loadDict(ctx context.Context, id uint32) ([]byte, error) {
+	dict, err := m.storage.GetMetadata(ctx, compress.DictFile)
+	if err != nil {
+		return nil, apperrors.Wrap(err, apperrors.TypeIntegrity, "zstd dictionary missing", "This backup was compressed with "+compress.DictFile+"; restore it from the same target or copy the dictionary alongside it.")
+	}
+	if got, err := compress.DictID(dict); err != nil || got != id {
+		return nil, apperrors.New(apperrors.TypeIntegrity, fmt.Sprintf("zstd dictionary mismatch: backup needs %d", id), "The target's "+compress.DictFile+" was replaced after this backup was taken.")
+	}
+	return dict, nil
+}
+
+// resolveLatest finds the newest backup of conn's database. The per-database
+// pointer is preferred; the target-wide latest.manifest covers single-database
+// targets and backups taken before per-database pointers existed. It returns
+// the pointer used, and the name and content of the manifest it points at.
+func (m *RestoreManager) resolveLatest(ctx context.Context, conn database.ConnectionParams) (string, string, []byte, error) {
+	candidates := []string{manifest.LatestName}
+	if conn.DBName != "" {
+		candidates = append([]string{manifest.LatestNameFor(conn.DBType, conn.DBName)}, candidates...)
+	}
+
+	var err error
+	for _, ptr := range candidates {
+		manPath, data, rerr := m.readLatest(ctx, ptr)
+		if rerr == nil {
+			return ptr, manPath, data, nil
+		}
+		if manPath != ptr {
+			// The pointer exists but its backup is gone; an older pointer
+			// could only restore something else
+			return ptr, manPath, nil, rerr
+		}
+		err = rerr
+	}
+	return manifest.LatestName, manifest.LatestName, nil, err
+}
+
+// readLatest is manifest.ReadLatest with a timeout per metadata read, to
+// avoid long hangs on unreachable targets.
+func (m *RestoreManager) readLatest(ctx context.Context, ptr string) (string, []byte, error) {
+	metaCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	return manifest.ReadLatest(metaCtx, m.storage, ptr)
+}
+
+// loadManifest reads the manifest of the backup called name, or of the latest
+// backup when no file was given. It returns the backup file the manifest
+// points at. A specific file without a manifest restores unverified.
+func (m *RestoreManager) loadManifest(ctx context.Context, conn database.ConnectionParams, name string) (*manifest.Manifest, string, error) {
+	manPath := name
+	if !strings.HasSuffix(name, ".manifest") {
+		manPath = name + ".manifest"
+	}
+
+	var manBytes []byte
+	var err error
+	ptr := ""
+	switch {
+	case m.Options.FileName == "":
+		ptr, manPath, manBytes, err = m.resolveLatest(ctx, conn)
+		name = ptr
+	case manifest.IsLatest(manPath):
+		ptr = manPath
+		manPath, manBytes, err = m.readLatest(ctx, ptr)
+	default:
+		// Use a sub-context with a timeout for the metadata check to avoid long hangs
+		metaCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		manBytes, err = m.storage.GetMetadata(metaCtx, manPath)
+		cancel()
+	}
+
+	if err != nil {
+		if ptr != "" && manPath != ptr {
+			return nil, name, apperrors.Wrap(err, apperrors.TypeResource, "the latest backup is missing", "Restore another backup by name; run 'dbackup backups' to list them.")
+		}
+		if ptr != "" {
+			return nil, name, fmt.Errorf("default manifest %s not found and no specific file provided: %w", manPath, err)
+		}
+		if m.Options.Logger != nil {
+			m.Options.Logger.Warn("Manifest not found for backup, skipping integrity check", "file", name)
+		}
+		return nil, name, nil
+	}
+	if ptr != "" && manPath != ptr && m.Options.Logger != nil {
+		m.Options.Logger.Info("Latest pointer resolved", "pointer", ptr, "manifest", manPath)
+	}
+
+	man, _ := manifest.Deserialize(manBytes)
+	if man == nil {
+		return nil, name, nil
+	}
+	if man.Newer() && m.Options.Logger != nil {
+		m.Options.Logger.Warn("Manifest was written by a newer dbackup; unknown fields are ignored", "schema_version", man.SchemaVersion, "supported", manifest.SchemaVersion)
+	}
+	if man.Engine != "" && !strings.EqualFold(man.Engine, conn.DBType) {
+		return nil, name, fmt.Errorf("engine mismatch: manifest is for %s but restoring to %s", man.Engine, conn.DBType)
+	}
+	if man.Pruned {
+		return nil, name, apperrors.New(apperrors.TypeResource, fmt.Sprintf("backup %s was pruned by retention at %s; only its manifest is kept", name, man.PrunedAt.Format(time.RFC3339)), "Run 'dbackup backups' and restore a backup whose status is ok.")
+	}
+	if m.Options.FileName == "" && ptr == manifest.LatestName && conn.DBName != "" && man.DBName != "" && man.DBName != conn.DBName {
+		return nil, name, apperrors.New(apperrors.TypeConfig,
+			fmt.Sprintf("no latest backup of %s; the target's latest backup is of %s", conn.DBName, man.DBName),
+			"Restore a backup of "+conn.DBName+" by name (run 'dbackup backups' to list them), or pass --name "+manifest.LatestName+" to restore the other database's backup.")
+	}
+	if man.FileName != "" {
+		if m.Options.Logger != nil {
+			m.Options.Logger.Info("Manifest resolved backup file", "manifest", name, "backup", man.FileName)
+		}
+		name = man.FileName
+	}
+	if man.EndLSN != "" && m.Options.Logger != nil {
+		m.Options.Logger.Info("Backup needs WAL range", "timeline", man.Timeline, "start_lsn", man.StartLSN, "end_lsn", man.EndLSN)
+	}
+	return man, name, nil
+}
+
+// ReadManifestFile reads a manifest kept outside the storage target, such as
+// a copy saved for disaster recovery.
+func ReadManifestFile(path string) (*manifest.Manifest, error) {
+	data, err := os.ReadFile(path) // #nosec G304
+	if err != nil {
+		return nil, apperrors.Wrap(err, apperrors.TypeConfig, "cannot read manifest "+path, "Check the --manifest path.")
+	}
+	man, err := manifest.Deserialize(data)
+	if err != nil {
+		return nil, apperrors.Wrap(err, apperrors.TypeIntegrity, "invalid manifest "+path, "Pass a .manifest file written by dbackup.")
+	}
+	if man.FileName == "" && len(man.Chunks) == 0 {
+		return nil, apperrors.New(apperrors.TypeIntegrity, "manifest "+path+" names no backup file or chunks", "Pass a .manifest file written by dbackup.")
+	}
+	return man, nil
+}
+
+// loadManifestFile is loadManifest for Options.ManifestFile: the manifest is
+// read from the local file and only the backup's data comes from storage.
+func (m *RestoreManager) loadManifestFile(conn database.ConnectionParams) (*manifest.Manifest, string, error) {
+	path := m.Options.ManifestFile
+	man, err := ReadManifestFile(path)
+	if err != nil {
+		return nil, path, err
+	}
+	if man.Newer() && m.Options.Logger != nil {
+		m.Options.Logger.Warn("Manifest was written by a newer dbackup; unknown fields are ignored", "schema_version", man.SchemaVersion, "supported", manifest.SchemaVersion)
+	}
+	if man.Engine != "" && !strings.EqualFold(man.Engine, conn.DBType) {
+		return nil, path, apperrors.New(apperrors.TypeConfig, fmt.Sprintf("engine mismatch: manifest is for %s but restoring to %s", man.Engine, conn.DBType), "Restore into a "+man.Engine+" database.")
+	}
+
+	name := man.FileName
+	if name == "" {
+		name = strings.TrimSuffix(filepath.Base(path), ".manifest")
+	}
+	if m.Options.Logger != nil {
+		m.Options.Logger.Info("Using manifest from file", "manifest", path, "backup", name, "chunks", len(man.Chunks))
+	}
+	return man, name, nil
+}
+
+// openBackup opens the backup file name. A deduplicated backup whose manifest
+// came from a local file is reassembled from that manifest's chunks, since
+// storage may not have the manifest any more.
+func (m *RestoreManager) openBackup(ctx context.Context, name string, man *manifest.Manifest) (io.ReadCloser, error) {
+	if m.Options.ManifestFile == "" || man == nil || len(man.Chunks) == 0 {
+		return m.storage.Open(ctx, name)
+	}
+	ms, ok := m.storage.(interface {
+		OpenManifest(context.Context, *manifest.Manifest) (io.ReadCloser, error)
+	})
+	if !ok {
+		return nil, apperrors.New(apperrors.TypeConfig, "the manifest describes a deduplicated backup but deduplication is disabled", "Restore with --dedupe enabled.")
+	}
+	return ms.OpenManifest(ctx, man)
+}
+
 func (m *RestoreManager) Run(ctx context.Context, adapter database.DBAdapter, conn database.ConnectionParams) (err error) {
-	if !m.Options.ConfirmRestore {
+	// Verification never touches the database, so it needs no confirmation
+	if !m.Options.ConfirmRestore && !m.Options.VerifyOnly {
 		return fmt.Errorf("RESTORE DENIED: Destructive operations require explicit confirmation. Use --confirm-restore to proceed")
 	}
 
@@ -71,9 +286,17 @@ func (m *RestoreManager) Run(ctx context.Context, adapter database.DBAdapter, co
 	}
 	name := m.Options.FileName
 	if name == "" {
-		name = "latest.manifest"
+		name = manifest.LatestName
 	}
 
+	op := "Restore"
+	if m.Options.VerifyOnly {
+		op = "Verify"
+	}
+	// Stats for notification: bytes downloaded, and bytes handed to the
+	// engine once decrypted and decompressed
+	var storedSize int64
+	raw := &ByteCounter{}
 	defer func() {
 		if m.Options.Notifier != nil {
 			status := notify.StatusSuccess
@@ -82,48 +305,68 @@ func (m *RestoreManager) Run(ctx context.Context, adapter database.DBAdapter, co
 			}
 			m.Options.Notifier.Notify(ctx, notify.Stats{ // #nosec G104
 				Status:    status,
-				Operation: "Restore",
+				Operation: op,
 				Engine:    conn.DBType,
 				Database:  conn.DBName,
 				FileName:  name,
 				Duration:  time.Since(start),
 				Error:     err,
+
+				Size:        storedSize,
+				LogicalSize: raw.Count,
 			})
 		}
 	}()
 
-	manPath := name
-	if !strings.HasSuffix(name, ".manifest") {
-		manPath = name + ".manifest"
-	}
-
-	// Use a sub-context with a timeout for the metadata check to avoid long hangs
-	metaCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
-	manBytes, err := m.storage.GetMetadata(metaCtx, manPath)
-	cancel()
+	stopStart := m.Options.notifyStart(ctx, notify.Stats{
+		Operation: op,
+		Engine:    conn.DBType,
+		Database:  conn.DBName,
+		FileName:  name,
+	})
+	defer stopStart()
 
-	if err != nil {
-		if m.Options.FileName == "" || name == "latest.manifest" {
-			return fmt.Errorf("default manifest %s not found and no specific file provided: %w", manPath, err)
+	var man *manifest.Manifest
+	if m.Options.NoManifest && m.Options.ManifestFile != "" {
+		return apperrors.New(apperrors.TypeConfig, "--no-manifest and --manifest are mutually exclusive", "Drop one of them.")
+	}
+	if m.Options.ManifestFile != "" {
+		man, name, err = m.loadManifestFile(conn)
+		if err != nil {
+			return err
+		}
+	} else if m.Options.NoManifest {
+		if m.Options.FileName == "" || strings.HasSuffix(name, ".manifest") {
+			return apperrors.New(apperrors.TypeConfig, "--no-manifest restores a raw backup file", "Pass the file to restore with --name.")
 		}
 		if m.Options.Logger != nil {
-			m.Options.Logger.Warn("Manifest not found for backup, skipping integrity check", "file", name)
+			m.Options.Logger.Warn("Restoring without a manifest; integrity verification and engine checks are skipped", "file", name)
+		}
+	} else {
+		man, name, err = m.loadManifest(ctx, conn, name)
+		if err != nil {
+			return err
 		}
 	}
+	if m.Options.CombineBackup {
+		return m.restoreChain(ctx, conn, man)
+	}
 
-	var man *manifest.Manifest
-	if err == nil {
-		man, _ = manifest.Deserialize(manBytes)
-		if man != nil {
-			if man.Engine != "" && !strings.EqualFold(man.Engine, conn.DBType) {
-				return fmt.Errorf("engine mismatch: manifest is for %s but restoring to %s", man.Engine, conn.DBType)
-			}
-			if man.FileName != "" {
-				if m.Options.Logger != nil {
-					m.Options.Logger.Info("Manifest resolved backup file", "manifest", name, "backup", man.FileName)
-				}
-				name = man.FileName
-			}
+	if man != nil && man.ChunkEncryption != "" {
+		ks, ok := m.storage.(interface{ SetKeyManager(*crypto.KeyManager) })
+		if !ok {
+			return apperrors.New(apperrors.TypeConfig, "backup chunks are encrypted but deduplication is disabled", "Restore with --dedupe enabled.")
+		}
+		km, err := m.keyManager()
+		if err != nil {
+			return err
+		}
+		ks.SetKeyManager(km)
+	}
+
+	if man != nil && len(man.Chunks) > 0 {
+		if err := m.checkChunks(ctx, man.Chunks); err != nil {
+			return err
 		}
 	}
 
@@ -132,7 +375,13 @@ func (m *RestoreManager) Run(ctx context.Context, adapter database.DBAdapter, co
 	}
 
 	// Download to temporary workspace for verification
-	tmpDir, err := os.MkdirTemp("", "dbackup-restore-*")
+	totalSize := m.backupSize(ctx, name, man)
+	if totalSize > 0 {
+		if err := m.checkTmpSpace(totalSize); err != nil {
+			return err
+		}
+	}
+	tmpDir, err := os.MkdirTemp(m.Options.TmpDir, "dbackup-restore-*")
 	if err != nil {
 		return fmt.Errorf("failed to create temporary workspace: %w", err)
 	}
@@ -147,37 +396,40 @@ func (m *RestoreManager) Run(ctx context.Context, adapter database.DBAdapter, co
 		return fmt.Errorf("failed to create temp file: %w", err)
 	}
 
-	r, err := m.storage.Open(ctx, name)
+	r, err := m.openBackup(ctx, name, man)
 	if err != nil {
 		f.Close() // #nosec G104
 		return fmt.Errorf("failed to open backup for restore: %w", err)
 	}
 
-	var totalSize int64
+	// Hash while downloading, using the algorithm recorded in the manifest
+	checksumAlgo := ""
 	if man != nil {
-		totalSize = man.Size
+		checksumAlgo = man.ChecksumAlgo
+	}
+	hasher, err := manifest.NewHasher(checksumAlgo)
+	if err != nil {
+		r.Close() // #nosec G104
+		f.Close() // #nosec G104
+		return apperrors.Wrap(err, apperrors.TypeIntegrity, "cannot verify backup checksum", "Upgrade dbackup to a version that supports this manifest's checksum algorithm.")
 	}
 
 	p := m.Options.Progress
 	shouldWait := false
 	if p == nil {
-		p = NewProgressContainer()
+		p = NewProgressContainer(m.Options.ProgressRefresh)
 		shouldWait = true
 	}
 	bar := AddRestoreBar(p, "Download", totalSize)
 
-	// Hash while downloading
-	hasher := sha256.New()
 	pr := NewProgressReader(r, bar)
 	tr := io.TeeReader(pr, hasher)
 
 	if m.Options.Logger != nil {
 		m.Options.Logger.Info("Downloading backup file...", "name", name, "size", totalSize)
 	}
-	_, err = io.Copy(f, tr)
-	if bar != nil {
-		bar.SetTotal(bar.Current(), true)
-	}
+	storedSize, err = io.Copy(f, tr)
+	finishBar(bar, err)
 
 	if shouldWait && p != nil {
 		p.Wait()
@@ -225,6 +477,9 @@ func (m *RestoreManager) Run(ctx context.Context, adapter database.DBAdapter, co
 	actualAlgo := compress.Algorithm(m.Options.Algorithm)
 
 	if man != nil {
+		if man.ChunkEncryption != "" {
+			actualEncrypt = false // Chunks were already decrypted by the dedupe storage
+		}
 		if man.Encryption != "" && man.Encryption != "none" {
 			actualEncrypt = true
 		}
@@ -243,15 +498,7 @@ func (m *RestoreManager) Run(ctx context.Context, adapter database.DBAdapter, co
 	finalReader = io.MultiReader(bytes.NewReader(header[:n]), finalReader)
 
 	if actualEncrypt {
-		if m.Options.EncryptionPassphrase == "" && m.Options.EncryptionKeyFile == "" {
-			// Try environment variable
-			if pass := os.Getenv("DBACKUP_KEY"); pass != "" {
-				m.Options.EncryptionPassphrase = pass
-			} else {
-				return apperrors.New(apperrors.TypeSecurity, "backup is encrypted but no passphrase or key-file was provided", "Set the DBACKUP_KEY environment variable or use --encryption-passphrase.")
-			}
-		}
-		km, err := crypto.NewKeyManager(m.Options.EncryptionPassphrase, m.Options.EncryptionKeyFile)
+		km, err := m.keyManager()
 		if err != nil {
 			return err
 		}
@@ -260,13 +507,28 @@ func (m *RestoreManager) Run(ctx context.Context, adapter database.DBAdapter, co
 	}
 
 	// Handle decompression
-	if actualAlgo == "" || actualAlgo == compress.None {
+	if m.Options.NoManifest {
+		// Files from other tools may be named anything; trust their content
+		if m.Options.Algorithm == "" {
+			br := bufio.NewReaderSize(finalReader, compress.SniffLen)
+			header, _ := br.Peek(compress.SniffLen)
+			actualAlgo = compress.Sniff(header)
+			finalReader = br
+		}
+	} else if actualAlgo == "" || actualAlgo == compress.None {
 		// Auto-detect from filename if still unknown
 		actualAlgo = compress.DetectAlgorithm(name)
 	}
 
+	var dict []byte
+	if man != nil && man.CompressionDict != 0 {
+		if dict, err = m.loadDict(ctx, man.CompressionDict); err != nil {
+			return err
+		}
+	}
+
 	if actualAlgo != compress.None {
-		c, err := compress.NewReader(finalReader, actualAlgo)
+		c, err := compress.NewReaderWithDict(finalReader, actualAlgo, dict)
 		if err != nil {
 			return fmt.Errorf("failed to create decompression reader for %s: %w", actualAlgo, err)
 		}
@@ -274,6 +536,23 @@ func (m *RestoreManager) Run(ctx context.Context, adapter database.DBAdapter, co
 		finalReader = c
 	}
 
+	finalReader = io.TeeReader(finalReader, raw)
+
+	if m.Options.VerifyOnly {
+		n, err := io.Copy(io.Discard, finalReader)
+		if err != nil {
+			return apperrors.Wrap(err, apperrors.TypeIntegrity, "backup could not be decoded", "The backup is corrupt or was written with a different key or format.")
+		}
+		checksum := "unverified (no manifest checksum)"
+		if man != nil && man.Checksum != "" {
+			checksum = "match"
+		}
+		if m.Options.Logger != nil {
+			m.Options.Logger.Info("Restore verification passed", "file", name, "bytes_verified", n, "checksum", checksum)
+		}
+		return nil
+	}
+
 	var runner database.Runner = &database.LocalRunner{}
 	if r, ok := m.storage.(database.Runner); ok {
 		runner = r
@@ -293,3 +572,30 @@ func (m *RestoreManager) Run(ctx context.Context, adapter database.DBAdapter, co
 
 	return nil
 }
+
+// keyManager builds the decryption key from the configured passphrase or
+// key-file, falling back to the DBACKUP_KEY environment variable.
+func (m *RestoreManager) keyManager() (*crypto.KeyManager, error) {
+	if m.Options.EncryptionPassphrase == "" && m.Options.EncryptionKeyFile == "" {
+		pass := os.Getenv("DBACKUP_KEY")
+		if pass == "" {
+			return nil, apperrors.New(apperrors.TypeSecurity, "backup is encrypted but no passphrase or key-file was provided", "Set the DBACKUP_KEY environment variable or use --encryption-passphrase.")
+		}
+		m.Options.EncryptionPassphrase = pass
+	}
+	return crypto.NewKeyManager(m.Options.EncryptionPassphrase, m.Options.EncryptionKeyFile)
+}
+
+// backupSize is the stored size of the backup, from its manifest or, for
+// manifests written before sizes were recorded, from the target. 0 means
+// unknown.
+func (m *RestoreManager) backupSize(ctx context.Context, name string, man *manifest.Manifest) int64 {
+	if man != nil && man.Size > 0 {
+		return man.Size
+	}
+	info, err := m.storage.Stat(ctx, name)
+	if err != nil || info.Size < 0 {
+		return 0
+	}
+	return info.Size
+}
@@ -0,0 +1,157 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lupppig/dbackup/internal/compress"
+	database "github.com/lupppig/dbackup/internal/db"
+	apperrors "github.com/lupppig/dbackup/internal/errors"
+	"github.com/lupppig/dbackup/internal/manifest"
+	"github.com/lupppig/dbackup/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRestoreManager_NoManifest(t *testing.T) {
+	dir := t.TempDir()
+
+	// A gzip dump from another tool, named without a telling suffix
+	var buf bytes.Buffer
+	c, err := compress.New(&buf, compress.Gzip)
+	require.NoError(t, err)
+	_, err = c.Write([]byte("CREATE TABLE t (id int);\n"))
+	require.NoError(t, err)
+	require.NoError(t, c.Close())
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "nightly.dump"), buf.Bytes(), 0600))
+
+	// A stray manifest for another engine must be ignored
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "nightly.dump.manifest"), []byte(`{"id":"x","engine":"mysql"}`), 0600))
+
+	mgr, err := NewRestoreManager(BackupOptions{
+		StorageURI: dir,
+		FileName:   "nightly.dump",
+		NoManifest: true,
+		VerifyOnly: true,
+	})
+	require.NoError(t, err)
+	assert.NoError(t, mgr.Run(context.Background(), nil, database.ConnectionParams{DBType: "postgres"}))
+
+	// An explicit algorithm wins over sniffing
+	mgr.Options.Algorithm = string(compress.Zstd)
+	assert.Error(t, mgr.Run(context.Background(), nil, database.ConnectionParams{DBType: "postgres"}))
+
+	// The file must be named explicitly
+	mgr.Options.FileName = ""
+	assert.Error(t, mgr.Run(context.Background(), nil, database.ConnectionParams{DBType: "postgres"}))
+}
+
+func TestRestoreManager_NotifiesSizes(t *testing.T) {
+	dir := t.TempDir()
+	dump := bytes.Repeat([]byte("INSERT INTO t VALUES (1);\n"), 1000)
+	var buf bytes.Buffer
+	c, err := compress.New(&buf, compress.Gzip)
+	require.NoError(t, err)
+	_, err = c.Write(dump)
+	require.NoError(t, err)
+	require.NoError(t, c.Close())
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "app.sql.gz"), buf.Bytes(), 0600))
+
+	n := &recordingNotifier{}
+	mgr, err := NewRestoreManager(BackupOptions{StorageURI: dir, FileName: "app.sql.gz", NoManifest: true, VerifyOnly: true, Notifier: n})
+	require.NoError(t, err)
+	require.NoError(t, mgr.Run(context.Background(), nil, database.ConnectionParams{DBType: "postgres"}))
+
+	require.Len(t, n.stats, 1)
+	assert.Equal(t, int64(buf.Len()), n.stats[0].Size, "bytes downloaded")
+	assert.Equal(t, int64(len(dump)), n.stats[0].LogicalSize, "bytes after decompression")
+	assert.Positive(t, n.stats[0].Throughput())
+}
+
+func TestRestoreManager_MissingChunksFailBeforeDownload(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+
+	data := make([]byte, 1024*1024)
+	_, err := io.ReadFull(rand.Reader, data)
+	require.NoError(t, err)
+
+	ds := storage.NewDedupeStorage(storage.NewLocalStorage(dir))
+	_, err = ds.Save(ctx, "test.sql", bytes.NewReader(data))
+	require.NoError(t, err)
+	chunks := ds.LastChunks()
+	require.GreaterOrEqual(t, len(chunks), 2)
+	mb, _ := (&manifest.Manifest{ID: "test", Engine: "postgres", FileName: "test.sql", Chunks: chunks}).Serialize()
+	require.NoError(t, ds.PutMetadata(ctx, "test.sql.manifest", mb))
+
+	for _, c := range chunks[:2] {
+		require.NoError(t, os.Remove(filepath.Join(dir, "chunks", c)))
+	}
+
+	mgr, err := NewRestoreManager(BackupOptions{
+		StorageURI:     dir,
+		FileName:       "test.sql",
+		Dedupe:         true,
+		ConfirmRestore: true,
+	})
+	require.NoError(t, err)
+	// A nil adapter proves the restore stops before it reaches the database
+	err = mgr.Run(ctx, nil, database.ConnectionParams{DBType: "postgres"})
+	require.Error(t, err)
+	assert.True(t, apperrors.IsType(err, apperrors.TypeIntegrity), err.Error())
+	assert.Contains(t, err.Error(), "unrecoverable")
+}
+
+func TestRestoreManager_ManifestFile(t *testing.T) {
+	ctx := context.Background()
+	src := sqliteFixture(t, 50)
+	dir := t.TempDir()
+
+	mgr, err := NewBackupManager(BackupOptions{StorageURI: dir, FileName: "app.db", Dedupe: true, Compress: true, Algorithm: "lz4"})
+	require.NoError(t, err)
+	require.NoError(t, mgr.Run(ctx, &database.SqliteAdapter{}, database.ConnectionParams{DBType: "sqlite", DBName: src}))
+
+	// Only the data survives on the target; the operator kept the manifest
+	saved := filepath.Join(t.TempDir(), "saved.manifest")
+	manifests, err := filepath.Glob(filepath.Join(dir, "*.manifest"))
+	require.NoError(t, err)
+	for _, f := range manifests {
+		if !manifest.IsLatest(filepath.Base(f)) {
+			require.NoError(t, os.Rename(f, saved))
+		} else {
+			require.NoError(t, os.Remove(f))
+		}
+	}
+	require.FileExists(t, saved)
+
+	rm, err := NewRestoreManager(BackupOptions{StorageURI: dir, Dedupe: true, ManifestFile: saved, VerifyOnly: true})
+	require.NoError(t, err)
+	require.NoError(t, rm.Run(ctx, nil, database.ConnectionParams{DBType: "sqlite"}))
+
+	err = rm.Run(ctx, nil, database.ConnectionParams{DBType: "postgres"})
+	assert.True(t, apperrors.IsType(err, apperrors.TypeConfig), "engine mismatch: %v", err)
+
+	require.NoError(t, os.WriteFile(saved, []byte("{}"), 0600))
+	err = rm.Run(ctx, nil, database.ConnectionParams{DBType: "sqlite"})
+	assert.True(t, apperrors.IsType(err, apperrors.TypeIntegrity), "empty manifest: %v", err)
+}
+
+func TestRestoreManager_BackupSizeFallsBackToStat(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "old.sql"), bytes.Repeat([]byte("x"), 4096), 0644))
+
+	rm, err := NewRestoreManager(BackupOptions{StorageURI: dir})
+	require.NoError(t, err)
+
+	assert.Equal(t, int64(123), rm.backupSize(ctx, "old.sql", &manifest.Manifest{Size: 123}))
+	// Manifests written before sizes were recorded
+	assert.Equal(t, int64(4096), rm.backupSize(ctx, "old.sql", &manifest.Manifest{}))
+	assert.Equal(t, int64(4096), rm.backupSize(ctx, "old.sql", nil))
+	assert.Equal(t, int64(0), rm.backupSize(ctx, "missing.sql", nil))
+}
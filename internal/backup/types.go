@@ -11,32 +11,64 @@ import (
 )
 
 type BackupOptions struct {
-	DBType        string
-	DBName        string
-	StorageURI    string // Unified targeting URI
-	Compress      bool
-	Algorithm     string
-	FileName      string
-	RemoteExec    bool // Force remote execution if storage is remote
-	AllowInsecure bool // Allow insecure protocols
-	Dedupe        bool // Enable storage-level deduplication (incremental)
-	Audit         bool // Enable tamper-evident audit logging
+	DBType         string
+	DBName         string
+	StorageURI     string // Unified targeting URI
+	Compress       bool
+	Algorithm      string
+	FileName       string
+	NameTemplate   string // Pattern for FileName when it is empty, see expandNameTemplate
+	RemoteExec     bool   // Force remote execution if storage is remote
+	AllowInsecure  bool   // Allow insecure protocols
+	Dedupe         bool   // Enable storage-level deduplication (incremental)
+	Audit          bool   // Enable tamper-evident audit logging
+	ChecksumAlgo   string // Manifest checksum algorithm (sha256, sha512, blake3)
+	ManifestFormat string // Manifest encoding (json, binary); empty means json
+	NoLatest       bool   // Don't move the latest.manifest pointers to this backup
+	ZstdDict       bool   // Compress zstd backups with the target's trained dictionary
+	TmpDir         string // Restore workspace and upload buffers; empty means os.TempDir()
+	Note           string // Free-form comment stored in the manifest
+	IfChanged      bool   // Skip the backup when the engine's change signal matches the latest backup
+	DedupeIndex    string // With Dedupe: local chunk index file consulted before remote Exists checks
+	DedupeAlgo     string // With Dedupe: chunking algorithm, gear (default) or fastcdc
+	Lock           bool   // Refuse to run while another backup of the same database to the target holds its lock
+
+	MaxDuration time.Duration // Abort the backup, dump and upload, once it runs this long
 
 	Retention       time.Duration
 	Keep            int
 	RetentionPolicy RetentionPolicy
+	AllowPruneAll   bool // Let retention delete a database's last backup
+	RetentionDryRun bool // Report what retention would delete without deleting it
+	// ManifestRetention keeps pruned backups' manifests as tombstones this long
+	ManifestRetention time.Duration
 
 	// Encryption
 	Encrypt              bool
+	EncryptChunks        bool // With Dedupe: encrypt each chunk (convergent) instead of the whole stream
 	EncryptionKeyFile    string
 	EncryptionPassphrase string
+	KDF                  string // Passphrase KDF of new streams: pbkdf2 (default), scrypt or argon2id
+	KDFIterations        int    // PBKDF2 iterations or argon2id passes; 0 keeps the KDF's default
 
-	ConfirmRestore bool // Explicitly confirm destructive restore
-	DryRun         bool // Simulation mode
+	ConfirmRestore bool   // Explicitly confirm destructive restore
+	DryRun         bool   // Simulation mode
+	VerifyOnly     bool   // Run the full restore pipeline but discard the output
+	NoManifest     bool   // Restore a raw file by flags and content sniffing alone
+	ManifestFile   string // Restore with this local manifest instead of the one on the target
+	CombineBackup  bool   // Restore a Postgres incremental chain into $PGDATA with pg_combinebackup
 
 	Logger   *logger.Logger
 	Notifier notify.Notifier
+	// NotifyOnStart also notifies when a run begins; with NotifyStartAfter
+	// set, only once the run has lasted that long
+	NotifyOnStart    bool
+	NotifyStartAfter time.Duration
+
 	Progress *mpb.Progress
+	// ProgressRefresh is the redraw interval of progress bars the managers
+	// create themselves; zero keeps the default
+	ProgressRefresh time.Duration
 }
 
 type BackupProcess interface {
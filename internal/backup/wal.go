@@ -0,0 +1,207 @@
+package backup
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/lupppig/dbackup/internal/compress"
+	"github.com/lupppig/dbackup/internal/crypto"
+	apperrors "github.com/lupppig/dbackup/internal/errors"
+	"github.com/lupppig/dbackup/internal/manifest"
+	"github.com/lupppig/dbackup/internal/storage"
+)
+
+// WALName returns where ArchiveWAL stores the segment at path: in the
+// cluster's directory under manifest.WALDir, the cluster being named by
+// Options.DBName, named after the segment plus the compression extension.
+func (m *BackupManager) WALName(path string) string {
+	name := manifest.WALDirFor(m.Options.DBName) + filepath.Base(path)
+	if algo := m.walAlgorithm(); algo != compress.None {
+		name += compress.Extension(algo)
+	}
+	return name
+}
+
+func (m *BackupManager) walAlgorithm() compress.Algorithm {
+	if !m.Options.Compress {
+		return compress.None
+	}
+	if m.Options.Algorithm == "" {
+		return compress.Lz4
+	}
+	return compress.Algorithm(m.Options.Algorithm)
+}
+
+// ArchiveWAL uploads the Postgres WAL segment at path, compressed and
+// encrypted like a backup, with a manifest of its own. It is meant to run as
+// archive_command, which Postgres retries until it succeeds, so a segment
+// whose manifest is already on the target is not uploaded again, provided
+// it was archived with the same content; archived reports whether this call
+// stored it.
+func (m *BackupManager) ArchiveWAL(ctx context.Context, path string) (archived bool, err error) {
+	if m.Options.DBName == "" {
+		return false, apperrors.New(apperrors.TypeConfig, "archiving WAL needs the cluster's name",
+			"Pass --db with a name for the cluster, so clusters sharing the target keep their segments apart.")
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return false, apperrors.Wrap(err, apperrors.TypeConfig, "cannot read WAL segment "+path,
+			"Pass %p in archive_command; Postgres runs it from the data directory, which %p is relative to.")
+	}
+	if !fi.Mode().IsRegular() {
+		return false, apperrors.New(apperrors.TypeConfig, "WAL segment "+path+" is not a regular file",
+			"Pass %p in archive_command, the path of the segment to archive.")
+	}
+
+	name := m.WALName(path)
+	manName := name + ".manifest"
+	if data, err := m.storage.GetMetadata(ctx, manName); err == nil {
+		if err := checkArchivedWAL(data, path, name); err != nil {
+			return false, err
+		}
+		if m.Options.Logger != nil {
+			m.Options.Logger.Info("WAL segment already archived, skipped", "file", name)
+		}
+		return false, nil
+	}
+
+	checksumAlgo := m.Options.ChecksumAlgo
+	if checksumAlgo == "" {
+		checksumAlgo = manifest.ChecksumSHA256
+	}
+	hasher, err := manifest.NewHasher(checksumAlgo)
+	if err != nil {
+		return false, apperrors.Wrap(err, apperrors.TypeConfig, "invalid checksum algorithm", "Use one of: sha256, sha512, blake3.")
+	}
+	sourceHasher, _ := manifest.NewHasher(checksumAlgo) // Checked above
+	if err := manifest.CheckFormat(m.Options.ManifestFormat); err != nil {
+		return false, apperrors.Wrap(err, apperrors.TypeConfig, "invalid manifest format", "Use json or binary.")
+	}
+
+	// As in Run, chunk encryption replaces stream encryption
+	var chunkEncryption string
+	if ce, ok := m.storage.(interface{ ChunkEncryption() string }); ok {
+		chunkEncryption = ce.ChunkEncryption()
+	}
+	streamEncrypt := m.Options.Encrypt && chunkEncryption == ""
+	algo := m.walAlgorithm()
+
+	f, err := os.Open(path) // #nosec G304 -- the segment Postgres asked to archive
+	if err != nil {
+		return false, apperrors.Wrap(err, apperrors.TypeConfig, "cannot read WAL segment "+path, "Check that dbackup runs as the postgres user.")
+	}
+	defer f.Close()
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(writeWAL(pw, io.TeeReader(f, sourceHasher), algo, streamEncrypt, m.Options, filepath.Base(path)))
+	}()
+
+	counter := &ByteCounter{}
+	if _, err := m.storage.Save(ctx, name, io.TeeReader(io.TeeReader(pr, hasher), counter)); err != nil {
+		pr.CloseWithError(err)
+		return false, apperrors.Wrap(err, apperrors.TypeResource, "WAL segment upload failed", "Check storage permissions and disk space; Postgres retries the segment.")
+	}
+
+	encryption := "none"
+	if streamEncrypt {
+		encryption = "aes-256-gcm"
+	}
+	man := manifest.New(fmt.Sprintf("%x", time.Now().UnixNano()), "postgres", string(algo), encryption)
+	man.DBName = m.Options.DBName
+	man.FileName = name
+	if cs, ok := m.storage.(storage.ChunkedStorage); ok {
+		man.Chunks = cs.LastChunks()
+	}
+	man.Checksum = hex.EncodeToString(hasher.Sum(nil))
+	man.ChecksumAlgo = checksumAlgo
+	man.SourceChecksum = hex.EncodeToString(sourceHasher.Sum(nil))
+	man.ChunkEncryption = chunkEncryption
+	man.Origin = origin(m.storage)
+	man.Size = counter.Count
+	man.Version = "0.1.0"
+	_ = man.SetFormat(m.Options.ManifestFormat) // Checked above
+
+	// The manifest goes last: until it exists the segment counts as not
+	// archived, and a retry uploads it again
+	manBytes, err := man.Serialize()
+	if err != nil {
+		return false, err
+	}
+	if err := m.storage.PutMetadata(ctx, manName, manBytes); err != nil {
+		return false, apperrors.Wrap(err, apperrors.TypeResource, "failed to save the WAL segment's manifest", "Check storage permissions; Postgres retries the segment.")
+	}
+	if m.Options.Logger != nil {
+		m.Options.Logger.Info("WAL segment archived", "file", name, "stored_bytes", counter.Count)
+	}
+	return true, nil
+}
+
+// checkArchivedWAL fails unless the segment at path has the content the
+// manifest in data recorded when it was archived as name. Postgres must
+// never be told a segment is archived when the copy on the target differs,
+// as it would be after two clusters archived to the same directory.
+func checkArchivedWAL(data []byte, path, name string) error {
+	man, err := manifest.Deserialize(data)
+	if err != nil {
+		return apperrors.Wrap(err, apperrors.TypeIntegrity, "the manifest of archived WAL segment "+name+" is unreadable", "Check the target; delete the manifest to archive the segment again.")
+	}
+	if man.SourceChecksum == "" {
+		return nil // Archived before source checksums were recorded
+	}
+	hasher, err := manifest.NewHasher(man.ChecksumAlgo)
+	if err != nil {
+		return apperrors.Wrap(err, apperrors.TypeIntegrity, "cannot verify archived WAL segment "+name, "Upgrade dbackup to a version that supports this manifest's checksum algorithm.")
+	}
+	f, err := os.Open(path) // #nosec G304 -- the segment Postgres asked to archive
+	if err != nil {
+		return apperrors.Wrap(err, apperrors.TypeConfig, "cannot read WAL segment "+path, "Check that dbackup runs as the postgres user.")
+	}
+	defer f.Close()
+	if _, err := io.Copy(hasher, f); err != nil {
+		return apperrors.Wrap(err, apperrors.TypeConfig, "cannot read WAL segment "+path, "Check that dbackup runs as the postgres user.")
+	}
+	if hex.EncodeToString(hasher.Sum(nil)) != man.SourceChecksum {
+		return apperrors.New(apperrors.TypeIntegrity, "WAL segment "+name+" is already archived with different content",
+			"Another cluster may be archiving under the same --db name; give each cluster its own. The segment was not uploaded.")
+	}
+	return nil
+}
+
+// writeWAL copies the segment in r to w through the compression and stream
+// encryption opts ask for.
+func writeWAL(w io.Writer, r io.Reader, algo compress.Algorithm, encrypt bool, opts BackupOptions, name string) error {
+	if encrypt {
+		km, err := opts.streamKeyManager()
+		if err != nil {
+			return err
+		}
+		ew, err := crypto.NewEncryptWriter(w, km)
+		if err != nil {
+			return err
+		}
+		defer ew.Close()
+		w = ew
+	}
+	if algo != compress.None {
+		c, err := compress.New(w, algo)
+		if err != nil {
+			return err
+		}
+		if algo == compress.Tar {
+			c.SetTarBufferName(name)
+		}
+		if _, err := io.Copy(c, r); err != nil {
+			c.Close()
+			return err
+		}
+		return c.Close()
+	}
+	_, err := io.Copy(w, r)
+	return err
+}
@@ -0,0 +1,95 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lupppig/dbackup/internal/compress"
+	apperrors "github.com/lupppig/dbackup/internal/errors"
+	"github.com/lupppig/dbackup/internal/manifest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestArchiveWAL(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	mgr, err := NewBackupManager(BackupOptions{StorageURI: dir, DBName: "main", Dedupe: true, Compress: true, Algorithm: "zstd"})
+	require.NoError(t, err)
+
+	// A fake 1 MiB segment, named like the ones Postgres passes as %p
+	segment := bytes.Repeat([]byte("wal record "), 100_000)
+	path := filepath.Join(t.TempDir(), "pg_wal", "000000010000000000000003")
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o750))
+	require.NoError(t, os.WriteFile(path, segment, 0o600))
+
+	archived, err := mgr.ArchiveWAL(ctx, path)
+	require.NoError(t, err)
+	assert.True(t, archived)
+
+	name := "wal/main/000000010000000000000003.zst"
+	assert.Equal(t, name, mgr.WALName(path))
+	data, err := mgr.storage.GetMetadata(ctx, name+".manifest")
+	require.NoError(t, err)
+	man, err := manifest.Deserialize(data)
+	require.NoError(t, err)
+	assert.Equal(t, name, man.FileName)
+	assert.NotEmpty(t, man.Chunks)
+	assert.Equal(t, "main", man.DBName)
+	assert.NotEmpty(t, man.SourceChecksum)
+
+	rc, err := mgr.storage.Open(ctx, name)
+	require.NoError(t, err)
+	defer rc.Close()
+	dr, err := compress.NewReader(rc, compress.Zstd)
+	require.NoError(t, err)
+	got, err := io.ReadAll(dr)
+	require.NoError(t, err)
+	assert.Equal(t, segment, got)
+
+	// archive_command retries are no-ops
+	archived, err = mgr.ArchiveWAL(ctx, path)
+	require.NoError(t, err)
+	assert.False(t, archived)
+
+	// ... unless the segment on the target has other content
+	other := filepath.Join(t.TempDir(), filepath.Base(path))
+	require.NoError(t, os.WriteFile(other, segment[1:], 0o600))
+	_, err = mgr.ArchiveWAL(ctx, other)
+	assert.True(t, apperrors.IsType(err, apperrors.TypeIntegrity), "got %v", err)
+
+	// Another cluster's segment of the same name goes to its own directory
+	replica, err := NewBackupManager(BackupOptions{StorageURI: dir, DBName: "replica", Dedupe: true, Compress: true, Algorithm: "zstd"})
+	require.NoError(t, err)
+	archived, err = replica.ArchiveWAL(ctx, other)
+	require.NoError(t, err)
+	assert.True(t, archived)
+	assert.Equal(t, "wal/replica/000000010000000000000003.zst", replica.WALName(other))
+
+	// Segments are not backups, so retention leaves them alone
+	next := filepath.Join(filepath.Dir(path), "000000010000000000000004")
+	require.NoError(t, os.WriteFile(next, segment[:1000], 0o600))
+	_, err = mgr.ArchiveWAL(ctx, next)
+	require.NoError(t, err)
+	pm := NewPruneManager(mgr.storage, PruneOptions{DBType: "postgres", Keep: 1, AllowPruneAll: true})
+	err = pm.Prune(ctx)
+	require.NoError(t, err)
+	for _, n := range []string{name, mgr.WALName(next)} {
+		_, err = mgr.storage.GetMetadata(ctx, n+".manifest")
+		assert.NoError(t, err, n)
+	}
+
+	_, err = mgr.ArchiveWAL(ctx, filepath.Join(filepath.Dir(path), "000000010000000000000005"))
+	assert.Error(t, err)
+	_, err = mgr.ArchiveWAL(ctx, filepath.Dir(path))
+	assert.Error(t, err)
+
+	noName, err := NewBackupManager(BackupOptions{StorageURI: dir, Dedupe: true})
+	require.NoError(t, err)
+	_, err = noName.ArchiveWAL(ctx, path)
+	assert.True(t, apperrors.IsType(err, apperrors.TypeConfig), "got %v", err)
+}
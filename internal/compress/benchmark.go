@@ -0,0 +1,89 @@
+package compress
+
+import (
+	"errors"
+	"time"
+)
+
+// BenchmarkAlgorithms are the algorithms Benchmark compares by default.
+var BenchmarkAlgorithms = []Algorithm{Lz4, Zstd, Gzip}
+
+// ErrSampleFull is returned by a Sampler's Write once it holds its limit, to
+// stop the dump feeding it.
+var ErrSampleFull = errors.New("benchmark sample is full")
+
+// Sampler keeps the first Limit bytes of a stream for Benchmark.
+type Sampler struct {
+	Limit int
+	buf   []byte
+}
+
+func (s *Sampler) Write(p []byte) (int, error) {
+	room := s.Limit - len(s.buf)
+	if room <= 0 {
+		return 0, ErrSampleFull
+	}
+	if len(p) > room {
+		s.buf = append(s.buf, p[:room]...)
+		return room, ErrSampleFull
+	}
+	s.buf = append(s.buf, p...)
+	return len(p), nil
+}
+
+// Bytes returns the sample collected so far.
+func (s *Sampler) Bytes() []byte {
+	return s.buf
+}
+
+// Full reports whether the sample reached its limit, so the stream was cut
+// short rather than ended.
+func (s *Sampler) Full() bool {
+	return len(s.buf) >= s.Limit
+}
+
+// BenchmarkResult is how one algorithm did on a sample.
+type BenchmarkResult struct {
+	Algorithm  Algorithm     `json:"algorithm"`
+	Size       int64         `json:"size"`       // Compressed size of the sample
+	Ratio      float64       `json:"ratio"`      // Sample size over compressed size
+	Duration   time.Duration `json:"duration"`   // Time to compress the sample
+	Throughput float64       `json:"throughput"` // Sample MB compressed per second
+}
+
+// Benchmark compresses sample with each of algos in turn and reports the
+// compressed size and speed of each.
+func Benchmark(sample []byte, algos []Algorithm) ([]BenchmarkResult, error) {
+	results := make([]BenchmarkResult, 0, len(algos))
+	for _, algo := range algos {
+		var n countWriter
+		start := time.Now()
+		c, err := New(&n, algo)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := c.Write(sample); err != nil {
+			return nil, err
+		}
+		if err := c.Close(); err != nil {
+			return nil, err
+		}
+		r := BenchmarkResult{Algorithm: algo, Size: int64(n), Duration: time.Since(start)}
+		if n > 0 {
+			r.Ratio = float64(len(sample)) / float64(n)
+		}
+		if r.Duration > 0 {
+			r.Throughput = float64(len(sample)) / (1024 * 1024) / r.Duration.Seconds()
+		}
+		results = append(results, r)
+	}
+	return results, nil
+}
+
+// countWriter counts and discards what is written to it.
+type countWriter int64
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	*c += countWriter(len(p))
+	return len(p), nil
+}
@@ -0,0 +1,37 @@
+package compress
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSampler(t *testing.T) {
+	s := &Sampler{Limit: 10}
+	n, err := io.Copy(s, strings.NewReader("0123456789abcdef"))
+	assert.ErrorIs(t, err, ErrSampleFull)
+	assert.Equal(t, int64(10), n)
+	assert.Equal(t, "0123456789", string(s.Bytes()))
+	assert.True(t, s.Full())
+
+	s = &Sampler{Limit: 10}
+	_, err = s.Write([]byte("short"))
+	require.NoError(t, err)
+	assert.False(t, s.Full())
+}
+
+func TestBenchmark(t *testing.T) {
+	sample := bytes.Repeat([]byte("INSERT INTO orders VALUES (1, 'pending');\n"), 2000)
+	results, err := Benchmark(sample, BenchmarkAlgorithms)
+	require.NoError(t, err)
+	require.Len(t, results, len(BenchmarkAlgorithms))
+	for i, r := range results {
+		assert.Equal(t, BenchmarkAlgorithms[i], r.Algorithm)
+		assert.Positive(t, r.Size, r.Algorithm)
+		assert.Greater(t, r.Ratio, 1.0, r.Algorithm)
+	}
+}
@@ -2,6 +2,7 @@ package compress
 
 import (
 	"archive/tar"
+	"bytes"
 	"compress/gzip"
 	"fmt"
 	"io"
@@ -11,6 +12,7 @@ import (
 	"strings"
 
 	"github.com/klauspost/compress/zstd"
+	apperrors "github.com/lupppig/dbackup/internal/errors"
 	"github.com/pierrec/lz4/v4"
 )
 
@@ -24,6 +26,25 @@ const (
 	Tar  Algorithm = "tar"
 )
 
+// Algorithms lists every algorithm New and NewReader accept.
+var Algorithms = []Algorithm{Gzip, Lz4, Zstd, None, Tar}
+
+// ValidateAlgorithm checks a --compression-algo value; empty means the
+// default, lz4.
+func ValidateAlgorithm(name string) error {
+	if name == "" {
+		return nil
+	}
+	names := make([]string, len(Algorithms))
+	for i, a := range Algorithms {
+		if string(a) == name {
+			return nil
+		}
+		names[i] = string(a)
+	}
+	return apperrors.New(apperrors.TypeConfig, "unknown compression algorithm: "+name, "Use one of: "+strings.Join(names, ", ")+".")
+}
+
 type Compressor struct {
 	Writer     io.Writer
 	Tar        *tar.Writer
@@ -37,6 +58,12 @@ type Compressor struct {
 }
 
 func New(w io.Writer, algo Algorithm) (*Compressor, error) {
+	return NewWithDict(w, algo, nil)
+}
+
+// NewWithDict is like New but primes zstd with a dictionary from TrainDict.
+// Other algorithms ignore dict.
+func NewWithDict(w io.Writer, algo Algorithm, dict []byte) (*Compressor, error) {
 	if algo == "" {
 		algo = Lz4
 	}
@@ -72,7 +99,11 @@ func New(w io.Writer, algo Algorithm) (*Compressor, error) {
 		c.compWriter = l
 		c.closer = l
 	case Zstd:
-		z, err := zstd.NewWriter(w)
+		var zopts []zstd.EOption
+		if dict != nil {
+			zopts = append(zopts, zstd.WithEncoderDict(dict))
+		}
+		z, err := zstd.NewWriter(w, zopts...)
 		if err != nil {
 			return nil, err
 		}
@@ -151,6 +182,12 @@ type Decompressor struct {
 }
 
 func NewReader(r io.Reader, algo Algorithm) (*Decompressor, error) {
+	return NewReaderWithDict(r, algo, nil)
+}
+
+// NewReaderWithDict reads zstd streams written with NewWithDict. Other
+// algorithms ignore dict.
+func NewReaderWithDict(r io.Reader, algo Algorithm, dict []byte) (*Decompressor, error) {
 	if algo == "" || algo == None {
 		return &Decompressor{Reader: r}, nil
 	}
@@ -166,13 +203,21 @@ func NewReader(r io.Reader, algo Algorithm) (*Decompressor, error) {
 		if err != nil {
 			return nil, err
 		}
+		// Members joined by other tools (cat a.gz b.gz, pigz) are one
+		// stream; stopping after the first would truncate the dump silently.
+		// zstd and lz4 readers continue across frames the same way
+		gz.Multistream(true)
 		decomp = gz
 		closer = gz
 	case Lz4:
 		l := lz4.NewReader(r)
 		decomp = l
 	case Zstd:
-		z, err := zstd.NewReader(r)
+		var zopts []zstd.DOption
+		if dict != nil {
+			zopts = append(zopts, zstd.WithDecoderDicts(dict))
+		}
+		z, err := zstd.NewReader(r, zopts...)
 		if err != nil {
 			return nil, err
 		}
@@ -195,6 +240,22 @@ func NewReader(r io.Reader, algo Algorithm) (*Decompressor, error) {
 	}, nil
 }
 
+// Extension is the file name suffix of backups compressed with algo, the
+// reverse of DetectAlgorithm. None has none.
+func Extension(algo Algorithm) string {
+	switch algo {
+	case Gzip:
+		return ".gz"
+	case Lz4:
+		return ".lz4"
+	case Zstd:
+		return ".zst"
+	case Tar:
+		return ".tar"
+	}
+	return ""
+}
+
 func DetectAlgorithm(filename string) Algorithm {
 	if strings.HasSuffix(filename, ".gz") {
 		return Gzip
@@ -211,6 +272,25 @@ func DetectAlgorithm(filename string) Algorithm {
 	return None
 }
 
+// SniffLen is how many leading bytes Sniff needs to recognise every format.
+const SniffLen = 262
+
+// Sniff recognises a compressed stream by its magic bytes, for files whose
+// name says nothing about their format. Unknown data is None.
+func Sniff(header []byte) Algorithm {
+	switch {
+	case bytes.HasPrefix(header, []byte{0x1f, 0x8b}):
+		return Gzip
+	case bytes.HasPrefix(header, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		return Zstd
+	case bytes.HasPrefix(header, []byte{0x04, 0x22, 0x4d, 0x18}):
+		return Lz4
+	case len(header) >= SniffLen && string(header[257:SniffLen]) == "ustar":
+		return Tar
+	}
+	return None
+}
+
 func (d *Decompressor) Close() error {
 	if d.closer != nil {
 		return d.closer.Close()
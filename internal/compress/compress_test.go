@@ -1,6 +1,9 @@
 package compress
 
 import (
+	"archive/tar"
+	"bytes"
+	"io"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -25,3 +28,65 @@ func TestDetectAlgorithm(t *testing.T) {
 		})
 	}
 }
+
+func TestExtension(t *testing.T) {
+	for _, algo := range Algorithms {
+		assert.Equal(t, algo, DetectAlgorithm("backup.sql"+Extension(algo)), algo)
+	}
+}
+
+func TestSniff(t *testing.T) {
+	for _, algo := range []Algorithm{Gzip, Lz4, Zstd} {
+		t.Run(string(algo), func(t *testing.T) {
+			var buf bytes.Buffer
+			c, err := New(&buf, algo)
+			assert.NoError(t, err)
+			_, err = io.WriteString(c, "CREATE TABLE t (id int);")
+			assert.NoError(t, err)
+			assert.NoError(t, c.Close())
+
+			assert.Equal(t, algo, Sniff(buf.Bytes()))
+		})
+	}
+	var archive bytes.Buffer
+	tw := tar.NewWriter(&archive)
+	assert.NoError(t, tw.WriteHeader(&tar.Header{Name: "dump.sql", Mode: 0600, Size: 4}))
+	_, _ = tw.Write([]byte("data"))
+	assert.NoError(t, tw.Close())
+	assert.Equal(t, Tar, Sniff(archive.Bytes()))
+
+	assert.Equal(t, None, Sniff([]byte("CREATE TABLE t (id int);")))
+	assert.Equal(t, None, Sniff(nil))
+}
+
+func TestValidateAlgorithm(t *testing.T) {
+	for _, a := range append(Algorithms, "") {
+		assert.NoError(t, ValidateAlgorithm(string(a)))
+	}
+	err := ValidateAlgorithm("brotli")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "brotli")
+}
+
+// Streams joined end to end, as by `cat a.gz b.gz`, decompress to the
+// joined data rather than stopping after the first.
+func TestNewReader_Concatenated(t *testing.T) {
+	for _, algo := range []Algorithm{Gzip, Zstd, Lz4} {
+		t.Run(string(algo), func(t *testing.T) {
+			var joined bytes.Buffer
+			for _, part := range []string{"first part;\n", "second part;\n"} {
+				c, err := New(&joined, algo)
+				assert.NoError(t, err)
+				_, err = c.Write([]byte(part))
+				assert.NoError(t, err)
+				assert.NoError(t, c.Close())
+			}
+
+			d, err := NewReader(&joined, algo)
+			assert.NoError(t, err)
+			got, err := io.ReadAll(d)
+			assert.NoError(t, err)
+			assert.Equal(t, "first part;\nsecond part;\n", string(got))
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package compress
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// DictFile is where a target's zstd dictionary is stored.
+const DictFile = "dict.zstd"
+
+const (
+	// DictSampleSize is how much of a dump is kept to train a dictionary.
+	DictSampleSize = 1 << 20
+
+	dictHistorySize = 64 << 10
+	dictBlockSize   = 16 << 10
+)
+
+// TrainDict builds a zstd dictionary from the start of an uncompressed dump.
+// Small dumps of the same schema share most of their bytes, so a dictionary
+// trained on one lets later ones compress far better.
+func TrainDict(sample []byte) ([]byte, error) {
+	if len(sample) < dictBlockSize {
+		return nil, fmt.Errorf("need at least %d bytes to train a dictionary, got %d", dictBlockSize, len(sample))
+	}
+
+	var contents [][]byte
+	for off := 0; off < len(sample); off += dictBlockSize {
+		contents = append(contents, sample[off:min(off+dictBlockSize, len(sample))])
+	}
+
+	sum := sha256.Sum256(sample)
+	id := binary.BigEndian.Uint32(sum[:4])
+	if id == 0 {
+		id = 1 // 0 means "no dictionary" in a zstd frame
+	}
+
+	return zstd.BuildDict(zstd.BuildDictOptions{
+		ID:       id,
+		Contents: contents,
+		History:  sample[:min(dictHistorySize, len(sample))],
+		Offsets:  [3]int{1, 4, 8},
+	})
+}
+
+// DictID returns the ID a dictionary stamps into the frames it compresses.
+func DictID(dict []byte) (uint32, error) {
+	d, err := zstd.InspectDictionary(dict)
+	if err != nil {
+		return 0, err
+	}
+	return d.ID(), nil
+}
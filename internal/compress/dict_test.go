@@ -0,0 +1,59 @@
+package compress
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func hourlyDump(hour int) []byte {
+	var b bytes.Buffer
+	b.WriteString("CREATE TABLE metrics (id integer PRIMARY KEY, host text, value double precision, taken_at timestamp);\n")
+	for i := 0; i < 400; i++ {
+		fmt.Fprintf(&b, "INSERT INTO metrics VALUES (%d, 'web-%02d.internal', %d.%d, '2024-01-01 %02d:%02d:00');\n", hour*1000+i, i%8, i*7, hour, hour, i%60)
+	}
+	return b.Bytes()
+}
+
+func TestZstdDictionary(t *testing.T) {
+	var sample []byte
+	for h := 0; h < 4; h++ {
+		sample = append(sample, hourlyDump(h)...)
+	}
+	dict, err := TrainDict(sample)
+	require.NoError(t, err)
+	id, err := DictID(dict)
+	require.NoError(t, err)
+	assert.NotZero(t, id)
+
+	compressed := func(data, dict []byte) []byte {
+		var out bytes.Buffer
+		c, err := NewWithDict(&out, Zstd, dict)
+		require.NoError(t, err)
+		_, err = c.Write(data)
+		require.NoError(t, err)
+		require.NoError(t, c.Close())
+		return out.Bytes()
+	}
+
+	next := hourlyDump(5)[:4096] // A small dump, where a dictionary helps most
+	withDict := compressed(next, dict)
+	assert.Less(t, len(withDict), len(compressed(next, nil)))
+
+	// Decompresses with the stored dictionary...
+	d, err := NewReaderWithDict(bytes.NewReader(withDict), Zstd, dict)
+	require.NoError(t, err)
+	got, err := io.ReadAll(d)
+	require.NoError(t, err)
+	assert.Equal(t, next, got)
+
+	// ...but not without it
+	d, err = NewReader(bytes.NewReader(withDict), Zstd)
+	require.NoError(t, err)
+	_, err = io.ReadAll(d)
+	assert.Error(t, err)
+}
@@ -6,8 +6,10 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/fsnotify/fsnotify"
+	apperrors "github.com/lupppig/dbackup/internal/errors"
 	"github.com/spf13/viper"
 )
 
@@ -16,16 +18,27 @@ type Config struct {
 	AllowInsecure        bool          `mapstructure:"allow_insecure"`
 	LogJSON              bool          `mapstructure:"log_json"`
 	NoColor              bool          `mapstructure:"no_color"`
+	LogFile              string        `mapstructure:"log_file"`
+	TmpDir               string        `mapstructure:"tmp_dir"`
+	ProgressRefresh      time.Duration `mapstructure:"progress_refresh"`
 	Notifications        Notifications `mapstructure:"notifications"`
 	EncryptionPassphrase string        `mapstructure:"encryption_passphrase"`
 	EncryptionKeyFile    string        `mapstructure:"encryption_key_file"`
 	Backups              []TaskConfig  `mapstructure:"backups"`
 	Restores             []TaskConfig  `mapstructure:"restores"`
+	RestoresParallel     bool          `mapstructure:"restores_parallel"` // Run dump's restores up to Parallelism at once
+
+	KDF           string `mapstructure:"kdf"`            // Passphrase KDF of new encrypted backups
+	KDFIterations int    `mapstructure:"kdf_iterations"` // PBKDF2 iterations or argon2id passes
 }
 
 type Notifications struct {
 	Slack    SlackConfig     `mapstructure:"slack"`
 	Webhooks []WebhookConfig `mapstructure:"webhooks"`
+	Batch    bool            `mapstructure:"batch"` // Send one summary per dump run instead of per task
+
+	NotifyOnStart    bool          `mapstructure:"notify_on_start"`    // Also notify when a backup or restore begins
+	NotifyStartAfter time.Duration `mapstructure:"notify_start_after"` // Only once the run has lasted this long
 }
 
 type SlackConfig struct {
@@ -54,19 +67,46 @@ type TaskConfig struct {
 	To                   string    `mapstructure:"to"`
 	From                 string    `mapstructure:"from"`
 	FileName             string    `mapstructure:"file_name"`
+	NameTemplate         string    `mapstructure:"name_template"`
 	RemoteExec           bool      `mapstructure:"remote_exec"`
 	Dedupe               *bool     `mapstructure:"dedupe"` // Use pointer to distinguish between false and default true
 	Compress             bool      `mapstructure:"compress"`
 	Algorithm            string    `mapstructure:"algorithm"`
+	ChecksumAlgo         string    `mapstructure:"checksum_algo"`
+	ManifestFormat       string    `mapstructure:"manifest_format"`
 	Encrypt              bool      `mapstructure:"encrypt"`
+	EncryptChunks        bool      `mapstructure:"encrypt_chunks"`
+	ZstdDict             bool      `mapstructure:"zstd_dict"`
 	EncryptionPassphrase string    `mapstructure:"encryption_passphrase"`
 	EncryptionKeyFile    string    `mapstructure:"encryption_key_file"`
 	Retention            string    `mapstructure:"retention"`
 	Keep                 int       `mapstructure:"keep"`
+	KeepDaily            int       `mapstructure:"keep_daily"`
+	KeepWeekly           int       `mapstructure:"keep_weekly"`
+	KeepMonthly          int       `mapstructure:"keep_monthly"`
+	KeepYearly           int       `mapstructure:"keep_yearly"`
+	AllowPruneAll        bool      `mapstructure:"allow_prune_all"`
+	RetentionDryRun      bool      `mapstructure:"retention_dry_run"`
+	ManifestRetention    string    `mapstructure:"manifest_retention"`
+	MaxDuration          string    `mapstructure:"max_duration"`
 	Schedule             string    `mapstructure:"schedule"`
 	Interval             string    `mapstructure:"interval"`
 	DryRun               bool      `mapstructure:"dry_run"`
+	VerifyOnly           bool      `mapstructure:"verify_only"`
 	ConfirmRestore       bool      `mapstructure:"confirm_restore"`
+	OnConflict           string    `mapstructure:"on_conflict"`
+	OverwriteExisting    bool      `mapstructure:"overwrite_existing"`
+	PostRestoreCheck     string    `mapstructure:"post_restore_check"`
+	PostRestoreExpect    string    `mapstructure:"post_restore_expect"`
+	IntoNewDB            string    `mapstructure:"into_new_db"`
+	Note                 string    `mapstructure:"note"`
+	IfChanged            bool      `mapstructure:"if_changed"`
+	Lock                 bool      `mapstructure:"lock"`
+	DedupeIndex          bool      `mapstructure:"dedupe_index"`
+	DedupeAlgo           string    `mapstructure:"dedupe_algo"`
+	ExtraArgs            []string  `mapstructure:"extra_args"`
+	Exclude              []string  `mapstructure:"exclude"`
+	DependsOn            []string  `mapstructure:"depends_on"` // Task IDs that must succeed before this one starts
 }
 
 type TLSConfig struct {
@@ -116,6 +156,9 @@ func Initialize(configPath string) error {
 	if err := v.Unmarshal(&cfg); err != nil {
 		return fmt.Errorf("failed to unmarshal config: %w", err)
 	}
+	if err := cfg.CheckDependencies(); err != nil {
+		return err
+	}
 	configMutex.Lock()
 	globalConfig = &cfg
 	configMutex.Unlock()
@@ -123,7 +166,7 @@ func Initialize(configPath string) error {
 	v.WatchConfig()
 	v.OnConfigChange(func(e fsnotify.Event) {
 		var newCfg Config
-		if err := v.Unmarshal(&newCfg); err == nil {
+		if err := v.Unmarshal(&newCfg); err == nil && newCfg.CheckDependencies() == nil {
 			configMutex.Lock()
 			globalConfig = &newCfg
 			configMutex.Unlock()
@@ -141,3 +184,97 @@ func GetConfig() *Config {
 	}
 	return globalConfig
 }
+
+// immediate reports whether dump runs the task right away rather than on a
+// schedule.
+func (t TaskConfig) immediate() bool {
+	return t.Schedule == "" && t.Interval == ""
+}
+
+// CheckDependencies validates the depends_on of every task. IDs must name
+// exactly one immediate task, since scheduled tasks run on their own clock.
+// Backups run before restores, so a backup can't depend on a restore, and
+// the dependencies may not form a cycle.
+func (c *Config) CheckDependencies() error {
+	type node struct {
+		task    TaskConfig
+		restore bool
+	}
+	var nodes []node
+	for _, t := range c.Backups {
+		nodes = append(nodes, node{t, false})
+	}
+	for _, t := range c.Restores {
+		nodes = append(nodes, node{t, true})
+	}
+
+	byID := make(map[string]int)
+	count := make(map[string]int)
+	for i, n := range nodes {
+		if n.task.ID != "" && n.task.immediate() {
+			byID[n.task.ID] = i
+			count[n.task.ID]++
+		}
+	}
+
+	deps := make([][]int, len(nodes))
+	for i, n := range nodes {
+		if len(n.task.DependsOn) == 0 {
+			continue
+		}
+		id := n.task.ID
+		if !n.task.immediate() {
+			return dependencyError(id, "depends_on is not supported on scheduled tasks")
+		}
+		for _, dep := range n.task.DependsOn {
+			d, ok := byID[dep]
+			switch {
+			case !ok:
+				return dependencyError(id, fmt.Sprintf("depends_on %q: no immediate task has that id", dep))
+			case count[dep] > 1:
+				return dependencyError(id, fmt.Sprintf("depends_on %q: several tasks have that id", dep))
+			case d == i:
+				return dependencyError(id, "a task cannot depend on itself")
+			case !n.restore && nodes[d].restore:
+				return dependencyError(id, fmt.Sprintf("depends_on %q: backups run before restores, so a backup cannot wait for a restore", dep))
+			}
+			deps[i] = append(deps[i], d)
+		}
+	}
+
+	// Depth-first search; meeting a task still on the stack closes a cycle
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make([]int, len(nodes))
+	var visit func(i int) error
+	visit = func(i int) error {
+		state[i] = visiting
+		for _, d := range deps[i] {
+			switch state[d] {
+			case visiting:
+				return dependencyError(nodes[i].task.ID, fmt.Sprintf("depends_on %q forms a cycle", nodes[d].task.ID))
+			case unvisited:
+				if err := visit(d); err != nil {
+					return err
+				}
+			}
+		}
+		state[i] = visited
+		return nil
+	}
+	for i := range nodes {
+		if state[i] == unvisited {
+			if err := visit(i); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func dependencyError(id, msg string) error {
+	return apperrors.New(apperrors.TypeConfig, fmt.Sprintf("task %q: %s", id, msg), "Fix depends_on in the config file so it names other tasks that run right away, without cycles.")
+}
@@ -6,6 +6,7 @@ import (
 	"testing"
 	"time"
 
+	apperrors "github.com/lupppig/dbackup/internal/errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -81,3 +82,60 @@ func TestInitialize_HotReload(t *testing.T) {
 
 	assert.Equal(t, 10, GetConfig().Parallelism)
 }
+
+func TestCheckDependencies(t *testing.T) {
+	chain := Config{
+		Backups: []TaskConfig{{ID: "prod"}, {ID: "nightly", Schedule: "0 2 * * *"}},
+		Restores: []TaskConfig{
+			{ID: "staging", DependsOn: []string{"schema"}},
+			{ID: "schema", DependsOn: []string{"prod"}},
+		},
+	}
+	require.NoError(t, chain.CheckDependencies())
+
+	for name, tc := range map[string]struct {
+		cfg  Config
+		want string
+	}{
+		"cycle": {Config{Restores: []TaskConfig{
+			{ID: "a", DependsOn: []string{"c"}},
+			{ID: "b", DependsOn: []string{"a"}},
+			{ID: "c", DependsOn: []string{"b"}},
+		}}, "forms a cycle"},
+		"self":    {Config{Backups: []TaskConfig{{ID: "a", DependsOn: []string{"a"}}}}, "itself"},
+		"missing": {Config{Backups: []TaskConfig{{ID: "a", DependsOn: []string{"nope"}}}}, `"nope": no immediate task`},
+		"scheduled dependency": {Config{Backups: []TaskConfig{
+			{ID: "nightly", Schedule: "0 2 * * *"},
+			{ID: "a", DependsOn: []string{"nightly"}},
+		}}, `"nightly": no immediate task`},
+		"scheduled task": {Config{Backups: []TaskConfig{
+			{ID: "a"},
+			{ID: "nightly", Interval: "1h", DependsOn: []string{"a"}},
+		}}, "scheduled tasks"},
+		"ambiguous": {Config{Backups: []TaskConfig{{ID: "a"}, {ID: "a"}, {ID: "b", DependsOn: []string{"a"}}}}, "several tasks"},
+		"backup on restore": {Config{
+			Backups:  []TaskConfig{{ID: "a", DependsOn: []string{"r"}}},
+			Restores: []TaskConfig{{ID: "r"}},
+		}, "backups run before restores"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			err := tc.cfg.CheckDependencies()
+			require.Error(t, err)
+			assert.True(t, apperrors.IsType(err, apperrors.TypeConfig), err.Error())
+			assert.Contains(t, err.Error(), tc.want)
+		})
+	}
+}
+
+func TestInitialize_RejectsDependencyCycle(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "backup.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+restores:
+  - id: a
+    depends_on: [b]
+  - id: b
+    depends_on: [a]
+`), 0600))
+	err := Initialize(path)
+	assert.ErrorContains(t, err, "cycle")
+}
@@ -0,0 +1,73 @@
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+)
+
+// ConvergentScheme identifies per-chunk convergent encryption in manifests.
+const ConvergentScheme = "convergent-aes-256-gcm"
+
+// convergentSalt is fixed so that identical chunks always produce identical
+// ciphertext under the same master key, which is what keeps dedupe working.
+var convergentSalt = []byte("dbackup-convergent-v1")
+
+func (km *KeyManager) masterKey() []byte {
+	km.masterOnce.Do(func() {
+		km.master = DeriveKey(string(km.key), convergentSalt)
+	})
+	return km.master
+}
+
+func (km *KeyManager) mac(parts ...[]byte) []byte {
+	h := hmac.New(sha256.New, km.masterKey())
+	for _, p := range parts {
+		h.Write(p)
+	}
+	return h.Sum(nil)
+}
+
+// ChunkID returns the keyed content hash used to address a chunk. Unlike a
+// plain SHA-256 it does not let the storage provider confirm known content.
+func (km *KeyManager) ChunkID(plain []byte) string {
+	return hex.EncodeToString(km.mac([]byte("id:"), plain))
+}
+
+func (km *KeyManager) chunkAEAD(id string) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(km.mac([]byte("key:"), []byte(id)))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// SealChunk encrypts a chunk with a key derived from its ID. Every key seals
+// exactly one plaintext, so a fixed nonce is safe and the output is
+// deterministic.
+func (km *KeyManager) SealChunk(id string, plain []byte) ([]byte, error) {
+	gcm, err := km.chunkAEAD(id)
+	if err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nil, make([]byte, NonceSize), plain, []byte(id)), nil
+}
+
+// OpenChunk decrypts a chunk sealed by SealChunk and verifies it matches id.
+func (km *KeyManager) OpenChunk(id string, sealed []byte) ([]byte, error) {
+	gcm, err := km.chunkAEAD(id)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := gcm.Open(nil, make([]byte, NonceSize), sealed, []byte(id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt chunk %s (wrong key?): %w", id, err)
+	}
+	if km.ChunkID(plain) != id {
+		return nil, fmt.Errorf("chunk %s content does not match its ID", id)
+	}
+	return plain, nil
+}
@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"sync"
 
 	"golang.org/x/crypto/pbkdf2"
 )
@@ -20,12 +21,17 @@ const (
 	TagSize    = 16
 	ChunkSize  = 64 * 1024 // 64KB chunks for GCM streaming
 	MagicBytes = "DBKP"
-	Version    = 1
+	Version    = 2 // Header version written; 1 predates KDFParams
 )
 
 // KeyManager handles key derivation and loading
 type KeyManager struct {
 	key []byte
+	raw bool      // key came from a key file and is used as is
+	kdf KDFParams // How new streams derive their key from a passphrase
+
+	masterOnce sync.Once
+	master     []byte // Convergent master key, derived on first use
 }
 
 func NewKeyManager(passphrase, keyFile string) (*KeyManager, error) {
@@ -34,7 +40,8 @@ func NewKeyManager(passphrase, keyFile string) (*KeyManager, error) {
 	}
 
 	var key []byte
-	if keyFile != "" {
+	raw := keyFile != ""
+	if raw {
 		var err error
 		key, err = os.ReadFile(keyFile)
 		if err != nil {
@@ -52,10 +59,26 @@ func NewKeyManager(passphrase, keyFile string) (*KeyManager, error) {
 		key = []byte(passphrase)
 	}
 
-	return &KeyManager{key: key}, nil
+	return &KeyManager{key: key, raw: raw, kdf: DefaultKDF}, nil
+}
+
+// SetKDF sets the KDF new streams use to derive their key from the
+// passphrase. It has no effect with a key file, and none on decryption,
+// which uses the KDF recorded in the stream's header.
+func (km *KeyManager) SetKDF(p KDFParams) error {
+	if err := p.validate(); err != nil {
+		return err
+	}
+	if p.KDF == KDFNone {
+		return fmt.Errorf("a passphrase needs a KDF")
+	}
+	km.kdf = p
+	return nil
 }
 
-// DeriveKey derives a fixed-size key from a passphrase and salt
+// DeriveKey derives a fixed-size key from a passphrase and salt with
+// PBKDF2-SHA256 and 4096 iterations. Version 1 headers and convergent
+// chunk encryption use it; new streams use their KeyManager's KDF.
 func DeriveKey(passphrase string, salt []byte) []byte {
 	return pbkdf2.Key([]byte(passphrase), salt, 4096, KeySize, sha256.New)
 }
@@ -76,10 +99,15 @@ func NewEncryptWriter(w io.Writer, km *KeyManager) (*EncryptWriter, error) {
 		return nil, err
 	}
 
-	// Use raw key if available (from file); otherwise derive from passphrase.
+	// Use the key file's key as is; otherwise derive one from the passphrase
+	kdf := KDFParams{KDF: KDFNone}
 	key := km.key
-	if len(key) != KeySize {
-		key = DeriveKey(string(key), salt)
+	if !km.raw {
+		kdf = km.kdf
+		var err error
+		if key, err = kdf.derive(km.key, salt); err != nil {
+			return nil, err
+		}
 	}
 
 	block, err := aes.NewCipher(key)
@@ -92,8 +120,9 @@ func NewEncryptWriter(w io.Writer, km *KeyManager) (*EncryptWriter, error) {
 		return nil, err
 	}
 
-	// Write Header: Magic (4) + Version (1) + Salt (32)
+	// Write Header: Magic (4) + Version (1) + KDF (10) + Salt (32)
 	header := append([]byte(MagicBytes), Version)
+	header = append(header, kdf.marshal()...)
 	header = append(header, salt...)
 	if _, err := w.Write(header); err != nil {
 		return nil, err
@@ -216,8 +245,8 @@ func (dr *DecryptReader) Read(p []byte) (int, error) {
 }
 
 func (dr *DecryptReader) readHeader() error {
-	// Magic (4) + Version (1) + Salt (32)
-	head := make([]byte, 4+1+SaltSize)
+	// Magic (4) + Version (1)
+	head := make([]byte, 4+1)
 	if _, err := io.ReadFull(dr.r, head); err != nil {
 		return fmt.Errorf("failed to read encryption header: %w", err)
 	}
@@ -226,10 +255,31 @@ func (dr *DecryptReader) readHeader() error {
 		return fmt.Errorf("corrupt backup: missing security magic")
 	}
 
-	salt := head[5:]
-	key := dr.km.key
-	if len(key) != KeySize {
-		key = DeriveKey(string(key), salt)
+	var key []byte
+	switch head[4] {
+	case 1:
+		// Salt (32); a 32-byte key is used as is, anything else is a
+		// passphrase for DeriveKey
+		salt := make([]byte, SaltSize)
+		if _, err := io.ReadFull(dr.r, salt); err != nil {
+			return fmt.Errorf("failed to read encryption header: %w", err)
+		}
+		key = dr.km.key
+		if len(key) != KeySize {
+			key = DeriveKey(string(key), salt)
+		}
+	case 2:
+		// KDF (10) + Salt (32)
+		rest := make([]byte, kdfParamsSize+SaltSize)
+		if _, err := io.ReadFull(dr.r, rest); err != nil {
+			return fmt.Errorf("failed to read encryption header: %w", err)
+		}
+		var err error
+		if key, err = dr.km.streamKey(unmarshalKDF(rest), rest[kdfParamsSize:]); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unsupported encryption header version %d; upgrade dbackup", head[4])
 	}
 
 	block, err := aes.NewCipher(key)
@@ -245,6 +295,22 @@ func (dr *DecryptReader) readHeader() error {
 	return nil
 }
 
+// streamKey returns the key of a version 2 stream encrypted with kdf and salt.
+func (km *KeyManager) streamKey(kdf KDFParams, salt []byte) ([]byte, error) {
+	if err := kdf.validate(); err != nil {
+		return nil, fmt.Errorf("corrupt backup: %w", err)
+	}
+	switch {
+	case kdf.KDF == KDFNone && !km.raw:
+		return nil, fmt.Errorf("backup was encrypted with a key file, not a passphrase")
+	case kdf.KDF != KDFNone && km.raw:
+		return nil, fmt.Errorf("backup was encrypted with a passphrase, not a key file")
+	case kdf.KDF == KDFNone:
+		return km.key, nil
+	}
+	return kdf.derive(km.key, salt)
+}
+
 func (dr *DecryptReader) nextChunk() error {
 	// [Nonce (12)] + [Len (4)]
 	head := make([]byte, NonceSize+4)
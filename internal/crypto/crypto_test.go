@@ -94,3 +94,25 @@ func TestCrypto_LargeData(t *testing.T) {
 	decrypted, _ := io.ReadAll(dr)
 	assert.Equal(t, largeData, decrypted)
 }
+
+func TestConvergent_SealOpen(t *testing.T) {
+	km, _ := NewKeyManager("pass", "")
+	plain := []byte("same content, same ciphertext")
+
+	id := km.ChunkID(plain)
+	sealed1, err := km.SealChunk(id, plain)
+	require.NoError(t, err)
+	sealed2, err := km.SealChunk(id, plain)
+	require.NoError(t, err)
+	assert.Equal(t, sealed1, sealed2, "convergent encryption must be deterministic")
+	assert.NotContains(t, string(sealed1), string(plain))
+
+	opened, err := km.OpenChunk(id, sealed1)
+	require.NoError(t, err)
+	assert.Equal(t, plain, opened)
+
+	other, _ := NewKeyManager("other", "")
+	assert.NotEqual(t, id, other.ChunkID(plain), "IDs are keyed by the master key")
+	_, err = other.OpenChunk(id, sealed1)
+	assert.Error(t, err)
+}
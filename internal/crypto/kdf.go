@@ -0,0 +1,136 @@
+package crypto
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/scrypt"
+)
+
+// KDF identifies how a stream's key was derived from the passphrase. The id
+// is stored in version 2 headers.
+type KDF byte
+
+const (
+	KDFNone     KDF = 0 // Raw key from a key file
+	KDFPBKDF2   KDF = 1 // PBKDF2-HMAC-SHA256
+	KDFScrypt   KDF = 2
+	KDFArgon2id KDF = 3
+)
+
+// kdfParamsSize is the size of the KDF id and parameters in a header.
+const kdfParamsSize = 1 + 4 + 4 + 1
+
+// KDFParams is a KDF and its cost parameters. What each field means depends
+// on the KDF; unused fields are 0.
+type KDFParams struct {
+	KDF         KDF
+	Cost        uint32 // PBKDF2 iterations, scrypt N, argon2id passes
+	Memory      uint32 // scrypt r, argon2id memory in KiB
+	Parallelism uint8  // scrypt p, argon2id threads
+}
+
+// DefaultKDF is used for passphrases unless another KDF is chosen.
+var DefaultKDF = KDFParams{KDF: KDFPBKDF2, Cost: 600_000}
+
+// ParseKDF returns the parameters for the KDF called name, with its default
+// cost. iterations, if not 0, replaces the PBKDF2 iteration count or the
+// argon2id passes.
+func ParseKDF(name string, iterations int) (KDFParams, error) {
+	var p KDFParams
+	switch strings.ToLower(name) {
+	case "", "pbkdf2":
+		p = DefaultKDF
+	case "scrypt":
+		if iterations != 0 {
+			return p, fmt.Errorf("an iteration count does not apply to scrypt")
+		}
+		p = KDFParams{KDF: KDFScrypt, Cost: 1 << 15, Memory: 8, Parallelism: 1}
+	case "argon2id":
+		p = KDFParams{KDF: KDFArgon2id, Cost: 3, Memory: 64 * 1024, Parallelism: 4}
+	default:
+		return p, fmt.Errorf("unknown KDF %q (use pbkdf2, scrypt or argon2id)", name)
+	}
+	if iterations < 0 || iterations > 100_000_000 {
+		return p, fmt.Errorf("the iteration count must be between 1 and 100000000, got %d", iterations)
+	}
+	if iterations != 0 {
+		p.Cost = uint32(iterations) // #nosec G115 -- bounded above
+	}
+	return p, p.validate()
+}
+
+func (p KDFParams) String() string {
+	switch p.KDF {
+	case KDFNone:
+		return "none"
+	case KDFPBKDF2:
+		return fmt.Sprintf("pbkdf2 (%d iterations)", p.Cost)
+	case KDFScrypt:
+		return fmt.Sprintf("scrypt (N=%d, r=%d, p=%d)", p.Cost, p.Memory, p.Parallelism)
+	case KDFArgon2id:
+		return fmt.Sprintf("argon2id (t=%d, m=%d KiB, p=%d)", p.Cost, p.Memory, p.Parallelism)
+	}
+	return fmt.Sprintf("unknown KDF %d", p.KDF)
+}
+
+// validate bounds the parameters, both to catch typos and so that a header
+// can't make decryption spend unbounded time or memory.
+func (p KDFParams) validate() error {
+	switch p.KDF {
+	case KDFNone:
+		return nil
+	case KDFPBKDF2:
+		if p.Cost < 1000 || p.Cost > 100_000_000 {
+			return fmt.Errorf("pbkdf2 iterations must be between 1000 and 100000000, got %d", p.Cost)
+		}
+		return nil
+	case KDFScrypt:
+		if p.Cost < 2 || p.Cost > 1<<22 || p.Cost&(p.Cost-1) != 0 || p.Memory == 0 || p.Parallelism == 0 ||
+			uint64(p.Cost)*uint64(p.Memory)*128 > 4<<30 {
+			return fmt.Errorf("invalid scrypt parameters: %s", p)
+		}
+		return nil
+	case KDFArgon2id:
+		if p.Cost == 0 || p.Cost > 100 || p.Memory < 8 || p.Memory > 4<<20 || p.Parallelism == 0 {
+			return fmt.Errorf("invalid argon2id parameters: %s", p)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown KDF %d", p.KDF)
+}
+
+// derive returns the key for passphrase and salt.
+func (p KDFParams) derive(passphrase, salt []byte) ([]byte, error) {
+	switch p.KDF {
+	case KDFPBKDF2:
+		return pbkdf2.Key(passphrase, salt, int(p.Cost), KeySize, sha256.New), nil
+	case KDFScrypt:
+		return scrypt.Key(passphrase, salt, int(p.Cost), int(p.Memory), int(p.Parallelism), KeySize)
+	case KDFArgon2id:
+		return argon2.IDKey(passphrase, salt, p.Cost, p.Memory, p.Parallelism, KeySize), nil
+	}
+	return nil, fmt.Errorf("cannot derive a key with %s", p)
+}
+
+func (p KDFParams) marshal() []byte {
+	b := make([]byte, kdfParamsSize)
+	b[0] = byte(p.KDF)
+	binary.BigEndian.PutUint32(b[1:], p.Cost)
+	binary.BigEndian.PutUint32(b[5:], p.Memory)
+	b[9] = p.Parallelism
+	return b
+}
+
+func unmarshalKDF(b []byte) KDFParams {
+	return KDFParams{
+		KDF:         KDF(b[0]),
+		Cost:        binary.BigEndian.Uint32(b[1:]),
+		Memory:      binary.BigEndian.Uint32(b[5:]),
+		Parallelism: b[9],
+	}
+}
@@ -0,0 +1,160 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func encryptAll(t *testing.T, km *KeyManager, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	ew, err := NewEncryptWriter(&buf, km)
+	require.NoError(t, err)
+	_, err = ew.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, ew.Close())
+	return buf.Bytes()
+}
+
+// encryptV1 writes data the way dbackup did before KDFs were recorded:
+// a version 1 header with only the salt, and a key from DeriveKey unless
+// the key is already 32 bytes.
+func encryptV1(t *testing.T, key, data []byte) []byte {
+	t.Helper()
+	salt := make([]byte, SaltSize)
+	_, err := rand.Read(salt)
+	require.NoError(t, err)
+	if len(key) != KeySize {
+		key = DeriveKey(string(key), salt)
+	}
+	block, err := aes.NewCipher(key)
+	require.NoError(t, err)
+	gcm, err := cipher.NewGCM(block)
+	require.NoError(t, err)
+
+	out := append([]byte(MagicBytes), 1)
+	out = append(out, salt...)
+	nonce := make([]byte, NonceSize)
+	_, err = rand.Read(nonce)
+	require.NoError(t, err)
+	ct := gcm.Seal(nil, nonce, data, nil)
+	out = append(out, nonce...)
+	out = binary.BigEndian.AppendUint32(out, uint32(len(ct)))
+	return append(out, ct...)
+}
+
+func TestKDF_RoundTrip(t *testing.T) {
+	data := []byte("orders dump")
+	for _, p := range []KDFParams{
+		DefaultKDF,
+		{KDF: KDFPBKDF2, Cost: 1000},
+		{KDF: KDFScrypt, Cost: 1 << 10, Memory: 8, Parallelism: 1},
+		{KDF: KDFArgon2id, Cost: 1, Memory: 1024, Parallelism: 1},
+	} {
+		t.Run(p.String(), func(t *testing.T) {
+			km, err := NewKeyManager("pass", "")
+			require.NoError(t, err)
+			require.NoError(t, km.SetKDF(p))
+			enc := encryptAll(t, km, data)
+			assert.Equal(t, byte(Version), enc[4])
+			assert.Equal(t, p, unmarshalKDF(enc[5:]))
+
+			// Decryption reads the KDF from the header, whatever the reader's own
+			reader, err := NewKeyManager("pass", "")
+			require.NoError(t, err)
+			got, err := io.ReadAll(NewDecryptReader(bytes.NewReader(enc), reader))
+			require.NoError(t, err)
+			assert.Equal(t, data, got)
+
+			wrong, _ := NewKeyManager("wrong", "")
+			_, err = io.ReadAll(NewDecryptReader(bytes.NewReader(enc), wrong))
+			assert.ErrorContains(t, err, "decryption failed")
+		})
+	}
+}
+
+func TestKDF_ReadsVersion1(t *testing.T) {
+	data := []byte("written by an older dbackup")
+
+	km, _ := NewKeyManager("pass", "")
+	got, err := io.ReadAll(NewDecryptReader(bytes.NewReader(encryptV1(t, []byte("pass"), data)), km))
+	require.NoError(t, err)
+	assert.Equal(t, data, got)
+
+	keyFile := filepath.Join(t.TempDir(), "backup.key")
+	key := []byte("01234567890123456789012345678901")
+	require.NoError(t, os.WriteFile(keyFile, key, 0600))
+	km, err = NewKeyManager("", keyFile)
+	require.NoError(t, err)
+	got, err = io.ReadAll(NewDecryptReader(bytes.NewReader(encryptV1(t, key, data)), km))
+	require.NoError(t, err)
+	assert.Equal(t, data, got)
+}
+
+func TestKDF_KeyFileAndPassphraseDontMix(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "backup.key")
+	require.NoError(t, os.WriteFile(keyFile, []byte("01234567890123456789012345678901"), 0600))
+	fileKM, err := NewKeyManager("", keyFile)
+	require.NoError(t, err)
+	passKM, err := NewKeyManager("01234567890123456789012345678901", "")
+	require.NoError(t, err)
+
+	enc := encryptAll(t, fileKM, []byte("x"))
+	assert.Equal(t, byte(KDFNone), enc[5])
+	_, err = io.ReadAll(NewDecryptReader(bytes.NewReader(enc), passKM))
+	assert.ErrorContains(t, err, "encrypted with a key file")
+
+	enc = encryptAll(t, passKM, []byte("x"))
+	_, err = io.ReadAll(NewDecryptReader(bytes.NewReader(enc), fileKM))
+	assert.ErrorContains(t, err, "encrypted with a passphrase")
+}
+
+func TestKDF_RejectsBadHeaders(t *testing.T) {
+	km, _ := NewKeyManager("pass", "")
+	enc := encryptAll(t, km, []byte("x"))
+
+	future := bytes.Clone(enc)
+	future[4] = 9
+	_, err := io.ReadAll(NewDecryptReader(bytes.NewReader(future), km))
+	assert.ErrorContains(t, err, "unsupported encryption header version 9")
+
+	// A header can't demand absurd work
+	costly := bytes.Clone(enc)
+	binary.BigEndian.PutUint32(costly[6:], 4_000_000_000)
+	_, err = io.ReadAll(NewDecryptReader(bytes.NewReader(costly), km))
+	assert.ErrorContains(t, err, "corrupt backup")
+}
+
+func TestParseKDF(t *testing.T) {
+	p, err := ParseKDF("", 0)
+	require.NoError(t, err)
+	assert.Equal(t, DefaultKDF, p)
+
+	p, err = ParseKDF("pbkdf2", 1_000_000)
+	require.NoError(t, err)
+	assert.Equal(t, uint32(1_000_000), p.Cost)
+
+	p, err = ParseKDF("Argon2id", 5)
+	require.NoError(t, err)
+	assert.Equal(t, KDFArgon2id, p.KDF)
+	assert.Equal(t, uint32(5), p.Cost)
+
+	_, err = ParseKDF("scrypt", 0)
+	assert.NoError(t, err)
+	_, err = ParseKDF("scrypt", 10)
+	assert.Error(t, err)
+	_, err = ParseKDF("pbkdf2", 10)
+	assert.Error(t, err)
+	_, err = ParseKDF("bcrypt", 0)
+	assert.Error(t, err)
+}
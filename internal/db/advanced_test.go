@@ -1,31 +1,35 @@
 package db
 
 import (
+	"archive/tar"
+	"bytes"
 	"context"
 	"database/sql"
 	"io"
+	"os"
 	"path/filepath"
 	"strings"
 	"testing"
 
+	apperrors "github.com/lupppig/dbackup/internal/errors"
 	"github.com/lupppig/dbackup/internal/logger"
 )
 
 type mockRunner struct {
 	lastCmd  string
 	lastArgs []string
+	calls    []string // Every command line, in order
 }
 
 func (m *mockRunner) Run(ctx context.Context, name string, args []string, stdout io.Writer) error {
 	m.lastCmd = name
 	m.lastArgs = args
+	m.calls = append(m.calls, strings.Join(append([]string{name}, args...), " "))
 	return nil
 }
 
 func (m *mockRunner) RunWithIO(ctx context.Context, name string, args []string, stdin io.Reader, stdout io.Writer) error {
-	m.lastCmd = name
-	m.lastArgs = args
-	return nil
+	return m.Run(ctx, name, args, stdout)
 }
 
 func TestPostgresPhysicalBackup(t *testing.T) {
@@ -60,38 +64,170 @@ func TestPostgresPhysicalBackup(t *testing.T) {
 	}
 }
 
+func TestExtraArgsReachRunner(t *testing.T) {
+	ctx := context.Background()
+	extra := []string{"--section=data", "--hex-blob"}
+
+	runner := &mockRunner{}
+	pg := ConnectionParams{Host: "localhost", User: "postgres", DBName: "testdb", ExtraArgs: extra}
+	if err := (&PostgresAdapter{}).RunBackup(ctx, pg, runner, io.Discard); err != nil {
+		t.Fatalf("RunBackup failed: %v", err)
+	}
+	if got := runner.lastArgs[len(runner.lastArgs)-2:]; strings.Join(got, " ") != "--section=data --hex-blob" {
+		t.Errorf("pg_dump args end with %v, want the extra args last", got)
+	}
+
+	if err := (&PostgresAdapter{}).RunRestore(ctx, pg, runner, strings.NewReader("")); err != nil {
+		t.Fatalf("RunRestore failed: %v", err)
+	}
+	if runner.lastCmd != "psql" || runner.lastArgs[len(runner.lastArgs)-1] != "--hex-blob" {
+		t.Errorf("psql args = %v, want the extra args last", runner.lastArgs)
+	}
+
+	// The database name stays the last, positional, argument of mysql
+	my := ConnectionParams{Host: "localhost", User: "root", DBName: "testdb", Port: 3306, ExtraArgs: extra}
+	if err := (&MysqlAdapter{}).RunRestore(ctx, my, runner, strings.NewReader("")); err != nil {
+		t.Fatalf("RunRestore failed: %v", err)
+	}
+	if got := runner.lastArgs[len(runner.lastArgs)-3:]; strings.Join(got, " ") != "--section=data --hex-blob testdb" {
+		t.Errorf("mysql args end with %v", got)
+	}
+}
+
+// tarOf builds a tar archive; a "->" in a name makes a symlink and a trailing
+// "/" a directory.
+func tarOf(t *testing.T, files [][2]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, f := range files {
+		name, body := f[0], f[1]
+		h := &tar.Header{Name: name, Mode: 0600, Size: int64(len(body)), Typeflag: tar.TypeReg}
+		if link, target, ok := strings.Cut(name, " -> "); ok {
+			h = &tar.Header{Name: link, Linkname: target, Typeflag: tar.TypeSymlink}
+		} else if strings.HasSuffix(name, "/") {
+			h = &tar.Header{Name: name, Mode: 0700, Typeflag: tar.TypeDir}
+		}
+		if err := tw.WriteHeader(h); err != nil {
+			t.Fatal(err)
+		}
+		if h.Typeflag == tar.TypeReg {
+			if _, err := tw.Write([]byte(body)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestPostgresPhysicalRestore(t *testing.T) {
+	base := tarOf(t, [][2]string{
+		{"PG_VERSION", "16\n"},
+		{"global/", ""},
+		{"global/pg_control", "control"},
+		{"pg_tblspc/", ""},
+		{"pg_tblspc/16384 -> /srv/tblspc", ""},
+	})
+	wal := tarOf(t, [][2]string{{"000000010000000000000001", "wal"}})
+
+	pgdata := filepath.Join(t.TempDir(), "data")
+	t.Setenv("PGDATA", pgdata)
+	pa := &PostgresAdapter{}
+	pa.SetLogger(logger.New(logger.Config{NoColor: true}))
+
+	// The layout of a directory of pg_basebackup -Ft output files, tarred
+	archive := tarOf(t, [][2]string{{"base.tar", string(base)}, {"pg_wal.tar", string(wal)}})
+	conn := ConnectionParams{DBName: "testdb", IsPhysical: true}
+	if err := pa.RunRestore(context.Background(), conn, &LocalRunner{}, bytes.NewReader(archive)); err != nil {
+		t.Fatalf("RunRestore failed: %v", err)
+	}
+
+	for name, want := range map[string]string{
+		"PG_VERSION":                      "16\n",
+		"global/pg_control":               "control",
+		"pg_wal/000000010000000000000001": "wal",
+	} {
+		got, err := os.ReadFile(filepath.Join(pgdata, name))
+		if err != nil || string(got) != want {
+			t.Errorf("%s = %q, %v; want %q", name, got, err, want)
+		}
+	}
+	if link, err := os.Readlink(filepath.Join(pgdata, "pg_tblspc", "16384")); err != nil || link != "/srv/tblspc" {
+		t.Errorf("tablespace link = %q, %v", link, err)
+	}
+
+	// The data directory now has files, so a second restore is refused
+	err := pa.RunRestore(context.Background(), conn, &LocalRunner{}, bytes.NewReader(archive))
+	if !apperrors.IsType(err, apperrors.TypeConfig) {
+		t.Errorf("restore into a non-empty PGDATA: got %v, want a config error", err)
+	}
+
+	// pg_basebackup -D - writes the bare data directory
+	plain := filepath.Join(t.TempDir(), "plain")
+	if err := ExtractBaseBackup(bytes.NewReader(base), plain); err != nil {
+		t.Fatalf("ExtractBaseBackup failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(plain, "global", "pg_control")); err != nil {
+		t.Error(err)
+	}
+
+	evil := tarOf(t, [][2]string{{"../escaped", "x"}})
+	err = ExtractBaseBackup(bytes.NewReader(evil), filepath.Join(t.TempDir(), "evil"))
+	if !apperrors.IsType(err, apperrors.TypeSecurity) {
+		t.Errorf("path traversal: got %v, want a security error", err)
+	}
+}
+
 func TestMysqlPhysicalRestoreLifecycle(t *testing.T) {
 	ma := &MysqlAdapter{}
 	ma.SetLogger(logger.New(logger.Config{NoColor: true}))
 
-	runner := &mockRunner{}
+	staging := t.TempDir()
 	conn := ConnectionParams{
 		Host:       "localhost",
 		User:       "root",
 		DBName:     "testdb",
 		IsPhysical: true,
+		StagingDir: staging,
 	}
 
-	// Mocking Run to just capture commands
-	err := ma.RunRestore(context.Background(), conn, runner, strings.NewReader("fake xbstream"))
-	if err != nil {
+	// Without a data directory the backup is extracted and prepared, and
+	// left in place for a manual copy-back
+	runner := &mockRunner{}
+	if err := ma.RunRestore(context.Background(), conn, runner, strings.NewReader("fake xbstream")); err != nil {
 		t.Fatalf("RunRestore failed: %v", err)
 	}
-
-	// In our implementation, RunRestore calls RunWithIO for xbstream, then Run for prepare and copy-back.
-	// Our mockRunner.lastCmd will be the last one executed (copy-back).
-	if runner.lastCmd != "xtrabackup" {
-		t.Errorf("expected xtrabackup, got %s", runner.lastCmd)
+	if len(runner.calls) != 3 {
+		t.Fatalf("commands = %q, want mkdir, xbstream, prepare", runner.calls)
+	}
+	dir := strings.TrimPrefix(runner.calls[0], "mkdir -p ")
+	if filepath.Dir(dir) != staging {
+		t.Errorf("staging dir %s is not under %s", dir, staging)
+	}
+	if want := "xbstream -x -C " + dir; runner.calls[1] != want {
+		t.Errorf("extract = %q, want %q", runner.calls[1], want)
+	}
+	if want := "xtrabackup --prepare --target-dir=" + dir; runner.calls[2] != want {
+		t.Errorf("prepare = %q, want %q", runner.calls[2], want)
 	}
 
-	foundCopyBack := false
-	for _, arg := range runner.lastArgs {
-		if arg == "--copy-back" {
-			foundCopyBack = true
-		}
+	// With one, the prepared files are copied back and the staging copy removed
+	conn.DataDir = "/var/lib/mysql"
+	runner = &mockRunner{}
+	if err := ma.RunRestore(context.Background(), conn, runner, strings.NewReader("fake xbstream")); err != nil {
+		t.Fatalf("RunRestore failed: %v", err)
+	}
+	dir = strings.TrimPrefix(runner.calls[0], "mkdir -p ")
+	want := []string{
+		"xtrabackup --prepare --target-dir=" + dir,
+		"xtrabackup --copy-back --target-dir=" + dir + " --datadir=/var/lib/mysql",
+		"rm -rf " + dir,
 	}
-	if !foundCopyBack {
-		t.Error("expected --copy-back in xtrabackup args")
+	if got := runner.calls[2:]; strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("commands after extraction = %q, want %q", got, want)
 	}
 }
 
@@ -135,3 +271,170 @@ type writerWrapper struct {
 func (ww *writerWrapper) Write(p []byte) (n int, err error) {
 	return ww.w.Write(p)
 }
+
+// outputRunner writes out to stdout, as a dump tool would.
+type outputRunner struct {
+	mockRunner
+	out []byte
+}
+
+func (o *outputRunner) Run(ctx context.Context, name string, args []string, stdout io.Writer) error {
+	o.mockRunner.Run(ctx, name, args, stdout) // #nosec G104
+	_, err := stdout.Write(o.out)
+	return err
+}
+
+func TestExcludes(t *testing.T) {
+	for _, tt := range []struct {
+		pattern, name string
+		want          bool
+	}{
+		{"pg_log", "pg_log/", true},
+		{"pg_log", "./pg_log/postgresql.log", true},
+		{"*.tmp", "base/1/x.tmp", true},
+		{"*.tmp", "base/1/x.tmp.keep", false},
+		{"base/*/pgsql_tmp*", "base/1/pgsql_tmp42", true},
+		{"base/*/pgsql_tmp*", "base/1/pgsql_tmp42/file", true},
+		{"base/*/pgsql_tmp*", "global/pgsql_tmp42", false},
+		{"/pg_wal/", "pg_wal/000000010000000000000001", true},
+		{"pg_wal/*", "pg_wal", false},
+	} {
+		if got := excludes(tt.pattern, tt.name); got != tt.want {
+			t.Errorf("excludes(%q, %q) = %v, want %v", tt.pattern, tt.name, got, tt.want)
+		}
+	}
+
+	if err := ValidateExcludes([]string{"pg_log", "base/*/pgsql_tmp*"}); err != nil {
+		t.Errorf("valid patterns rejected: %v", err)
+	}
+	for _, bad := range []string{"[a-", "", "/"} {
+		if err := ValidateExcludes([]string{bad}); !apperrors.IsType(err, apperrors.TypeConfig) {
+			t.Errorf("ValidateExcludes(%q) = %v, want a config error", bad, err)
+		}
+	}
+}
+
+func TestPostgresPhysicalBackup_Exclude(t *testing.T) {
+	runner := &outputRunner{out: tarOf(t, [][2]string{
+		{"backup_label", "START WAL LOCATION"},
+		{"base/1/1259", "heap"},
+		{"base/1/pgsql_tmp42", "scratch"},
+		{"pg_log/", ""},
+		{"pg_log/postgresql.log", "log"},
+	})}
+	conn := ConnectionParams{
+		Host:       "localhost",
+		User:       "postgres",
+		DBName:     "testdb",
+		IsPhysical: true,
+		Exclude:    []string{"pg_log", "base/*/pgsql_tmp*", "*.core"},
+	}
+
+	var out bytes.Buffer
+	if err := (&PostgresAdapter{}).RunBackup(context.Background(), conn, runner, &out); err != nil {
+		t.Fatalf("RunBackup failed: %v", err)
+	}
+	var names []string
+	tr := tar.NewReader(&out)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("filtered output is not a tar: %v", err)
+		}
+		names = append(names, h.Name)
+	}
+	if got := strings.Join(names, ","); got != "backup_label,base/1/1259" {
+		t.Errorf("archive members = %s", got)
+	}
+
+	// pg_dump output has no files to drop
+	conn.IsPhysical = false
+	if err := (&PostgresAdapter{}).RunBackup(context.Background(), conn, runner, io.Discard); !apperrors.IsType(err, apperrors.TypeConfig) {
+		t.Errorf("logical backup with excludes: got %v, want a config error", err)
+	}
+}
+
+func TestScanWALRange(t *testing.T) {
+	bm := `{"PostgreSQL-Backup-Manifest-Version": 1, "Files": [],
+"WAL-Ranges": [
+{ "Timeline": 1, "Start-LSN": "0/9000028", "End-LSN": "0/A000000" },
+{ "Timeline": 2, "Start-LSN": "0/A000000", "End-LSN": "1/100" }
+]}`
+	archive := tarOf(t, [][2]string{
+		{"backup_label", "START WAL LOCATION: 0/9000028"},
+		{"base/1/1259", strings.Repeat("x", 4096)},
+		{"backup_manifest", bm},
+	})
+	trailing := append(archive, bytes.Repeat([]byte{0}, 1024)...)
+
+	r := bytes.NewReader(trailing)
+	rng, ok := ScanWALRange(r)
+	if !ok {
+		t.Fatal("no WAL range found")
+	}
+	if rng != (WALRange{Timeline: 2, StartLSN: "0/9000028", EndLSN: "1/100"}) {
+		t.Errorf("WAL range = %+v", rng)
+	}
+	if r.Len() != 0 {
+		t.Errorf("%d bytes left unread", r.Len())
+	}
+
+	// Archives without a manifest, and streams that aren't tar, are drained too
+	for _, in := range [][]byte{
+		tarOf(t, [][2]string{{"PG_VERSION", "16\n"}}),
+		[]byte("-- PostgreSQL database dump\n"),
+	} {
+		r := bytes.NewReader(in)
+		if rng, ok := ScanWALRange(r); ok {
+			t.Errorf("found WAL range %+v in %q", rng, in)
+		}
+		if r.Len() != 0 {
+			t.Errorf("%d bytes left unread", r.Len())
+		}
+	}
+
+	if _, err := ParseLSN("16/B374D848"); err != nil {
+		t.Error(err)
+	}
+	if _, err := ParseLSN("B374D848"); err == nil {
+		t.Error("LSN without a slash parsed")
+	}
+}
+
+// versionRunner prints out for every command, like a --version run.
+type versionRunner struct{ out string }
+
+func (v versionRunner) Run(ctx context.Context, name string, args []string, stdout io.Writer) error {
+	_, err := io.WriteString(stdout, v.out)
+	return err
+}
+
+func (v versionRunner) RunWithIO(ctx context.Context, name string, args []string, stdin io.Reader, stdout io.Writer) error {
+	return v.Run(ctx, name, args, stdout)
+}
+
+func TestCheckCombineBackup(t *testing.T) {
+	ctx := context.Background()
+	for _, out := range []string{"pg_combinebackup (PostgreSQL) 17.2\n", "pg_combinebackup (PostgreSQL) 18beta1\n"} {
+		if err := CheckCombineBackup(ctx, versionRunner{out}, t.TempDir()); err != nil {
+			t.Errorf("%q: %v", out, err)
+		}
+	}
+	for _, out := range []string{"pg_combinebackup (PostgreSQL) 16.4\n", "garbage\n", ""} {
+		if err := CheckCombineBackup(ctx, versionRunner{out}, t.TempDir()); !apperrors.IsType(err, apperrors.TypeDependency) {
+			t.Errorf("%q: got %v, want a dependency error", out, err)
+		}
+	}
+
+	r := &mockRunner{}
+	pgdata := filepath.Join(t.TempDir(), "pgdata")
+	if err := CombineBackup(ctx, r, []string{"/w/00", "/w/01"}, pgdata); err != nil {
+		t.Fatal(err)
+	}
+	if want := "pg_combinebackup --output " + pgdata + " /w/00 /w/01"; r.calls[0] != want {
+		t.Errorf("ran %q, want %q", r.calls[0], want)
+	}
+}
@@ -0,0 +1,222 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	apperrors "github.com/lupppig/dbackup/internal/errors"
+	"github.com/lupppig/dbackup/internal/logger"
+)
+
+func init() {
+	RegisterAdapter(&CassandraAdapter{})
+}
+
+/*
+CASSANDRA / SCYLLA BACKUP NOTES:
+1. Backups are node-local: `nodetool snapshot` hard-links SSTables inside the
+   node's data directory, and the snapshot directories are then streamed as a tar.
+   The runner must therefore have filesystem access to the data directory, i.e.
+   dbackup runs on the node itself or uses --remote-exec against it.
+2. Restores extract the tar and stream every table through `sstableloader`,
+   which works against a live cluster without stopping it.
+3. The data directory defaults to /var/lib/cassandra/data and can be overridden
+   with a `data_dir` query parameter: cassandra://host:9042/keyspace?data_dir=/data
+*/
+
+const defaultCassandraDataDir = "/var/lib/cassandra/data"
+
+type CassandraAdapter struct {
+	logger *logger.Logger
+}
+
+func (ca *CassandraAdapter) SetLogger(l *logger.Logger) {
+	ca.logger = l
+}
+
+func (ca *CassandraAdapter) Name() string {
+	return "cassandra"
+}
+
+// Capabilities: snapshots are hard links in the node's data directory,
+// streamed as a tar.
+func (ca *CassandraAdapter) Capabilities() Capabilities {
+	return Capabilities{SupportsPhysical: true, SupportsStreaming: true, RequiresLocalDatadir: true, ExcludeFiles: true}
+}
+
+// BuildConnection returns the CQL endpoint (host:port) of the node.
+func (ca *CassandraAdapter) BuildConnection(ctx context.Context, conn ConnectionParams) (string, error) {
+	if conn.Host == "" || conn.DBName == "" {
+		return "", apperrors.New(apperrors.TypeConfig, "missing required Cassandra connection fields", "Provide a host and keyspace, e.g. cassandra://localhost:9042/my_keyspace.")
+	}
+	return fmt.Sprintf("%s:%d", conn.Host, ca.port(conn)), nil
+}
+
+// ListDatabases is a no-op: keyspaces are only reachable through cqlsh on
+// the node, and each one is snapshotted separately.
+func (ca *CassandraAdapter) ListDatabases(ctx context.Context, conn ConnectionParams) ([]string, error) {
+	return nil, nil
+}
+
+func (ca *CassandraAdapter) ChangeSignal(ctx context.Context, conn ConnectionParams) (string, error) {
+	return "", nil
+}
+
+func (ca *CassandraAdapter) TestConnection(ctx context.Context, conn ConnectionParams, runner Runner) error {
+	if runner == nil {
+		return apperrors.New(apperrors.TypeConfig, "cassandra requires a command runner", "Run dbackup on the Cassandra node or use --remote-exec.")
+	}
+	if ca.logger != nil {
+		ca.logger.Info("Testing database connection...", "host", conn.Host, "keyspace", conn.DBName)
+	}
+	if _, err := ca.BuildConnection(ctx, conn); err != nil {
+		return err
+	}
+
+	if err := ca.runCqlsh(ctx, conn, runner, io.Discard, "-e", "SELECT now() FROM system.local"); err != nil {
+		if isNotFound(err) {
+			return apperrors.New(apperrors.TypeDependency, "cqlsh not found", "Please install cqlsh on the Cassandra node to enable connection testing.")
+		}
+		return apperrors.Wrap(err, apperrors.TypeConnection, "failed to connect via cqlsh", "Ensure the node is reachable and the credentials are correct.")
+	}
+
+	// Snapshots live on the node's disk, so the runner must be able to see them
+	ksDir := path.Join(ca.dataDir(conn), conn.DBName)
+	if err := runner.Run(ctx, "test", []string{"-d", ksDir}, io.Discard); err != nil {
+		return apperrors.Wrap(err, apperrors.TypeResource, "cassandra data directory not accessible: "+ksDir, "Cassandra backups are node-local: run dbackup on the node, use --remote-exec, or set ?data_dir= in the URI.")
+	}
+	return nil
+}
+
+func (ca *CassandraAdapter) RunBackup(ctx context.Context, conn ConnectionParams, runner Runner, w io.Writer) error {
+	if _, err := ca.BuildConnection(ctx, conn); err != nil {
+		return err
+	}
+	tag := fmt.Sprintf("dbackup-%d", time.Now().UnixNano())
+	if ca.logger != nil {
+		ca.logger.Info("Taking snapshot (nodetool)...", "engine", ca.Name(), "keyspace", conn.DBName, "tag", tag)
+	}
+
+	if err := runner.Run(ctx, "nodetool", []string{"snapshot", "-t", tag, "--", conn.DBName}, io.Discard); err != nil {
+		if isNotFound(err) {
+			return apperrors.New(apperrors.TypeDependency, "nodetool not found", "Please run the backup on a Cassandra node where nodetool is available.")
+		}
+		return apperrors.Wrap(err, apperrors.TypeInternal, "nodetool snapshot failed", "Check that the keyspace exists and nodetool can reach JMX.")
+	}
+	defer func() {
+		if err := runner.Run(context.Background(), "nodetool", []string{"clearsnapshot", "-t", tag, "--", conn.DBName}, io.Discard); err != nil && ca.logger != nil {
+			ca.logger.Warn("Failed to clear snapshot", "tag", tag, "error", err)
+		}
+	}()
+
+	// Only the snapshot directories of each table are archived:
+	// <table-id>/snapshots/<tag>/...
+	script := `cd "$1/$2" && tar -cf - */snapshots/"$3"`
+	args := []string{"-c", script, "sh", ca.dataDir(conn), conn.DBName, tag}
+	err := withExcludes(conn, ca.logger, w, func(w io.Writer) error {
+		return runner.Run(ctx, "sh", args, w)
+	})
+	if err != nil {
+		return apperrors.Wrap(err, apperrors.TypeResource, "failed to archive snapshot", "Ensure the data directory is readable by the dbackup user.")
+	}
+	return nil
+}
+
+func (ca *CassandraAdapter) RunRestore(ctx context.Context, conn ConnectionParams, runner Runner, r io.Reader) error {
+	if _, err := ca.BuildConnection(ctx, conn); err != nil {
+		return err
+	}
+	if conn.OnConflict != "" {
+		return apperrors.New(apperrors.TypeConfig, "--on-conflict is not supported for cassandra", "Truncate the keyspace's tables before loading the snapshot.")
+	}
+	if conn.IntoNewDB != "" {
+		return apperrors.New(apperrors.TypeConfig, "--into-new-db is not supported for cassandra", "Snapshots load into the keyspace they were taken from; drop --into-new-db.")
+	}
+	if conn.PostRestoreCheck != "" {
+		return apperrors.New(apperrors.TypeConfig, "--post-restore-check is not supported for cassandra", "Drop --post-restore-check and query the keyspace with cqlsh after the restore.")
+	}
+	if ca.logger != nil {
+		ca.logger.Info("Restoring keyspace (sstableloader)...", "engine", ca.Name(), "keyspace", conn.DBName)
+	}
+
+	// sstableloader expects <keyspace>/<table> directories, so each
+	// <table-id>/snapshots/<tag> is moved into place before loading. With a
+	// user, the password comes first on stdin, ahead of the snapshot.
+	// sstableloader has no option to read it from a file, so it is only kept
+	// off the command line dbackup runs.
+	script := `set -e
+ks=$1 host=$2 port=$3
+shift 3
+if [ "$1" = -u ]; then IFS= read -r pw; set -- "$@" -pw "$pw"; fi
+work=$(mktemp -d)
+trap 'rm -rf "$work"' EXIT
+mkdir -p "$work/in" "$work/load/$ks"
+tar -xf - -C "$work/in"
+for snap in "$work"/in/*/snapshots/*; do
+	table=$(basename "$(dirname "$(dirname "$snap")")")
+	mv "$snap" "$work/load/$ks/${table%-*}"
+	sstableloader -d "$host" -p "$port" "$@" "$work/load/$ks/${table%-*}"
+done`
+	args := []string{"-c", script, "sh", conn.DBName, conn.Host, strconv.Itoa(ca.port(conn))}
+	if conn.User != "" {
+		args = append(args, "-u", conn.User)
+		r = io.MultiReader(strings.NewReader(conn.Password+"\n"), r)
+	}
+
+	if err := runner.RunWithIO(ctx, "sh", args, r, nil); err != nil {
+		if isNotFound(err) {
+			return apperrors.New(apperrors.TypeDependency, "sstableloader not found", "Please install the Cassandra tools on the restore host.")
+		}
+		return apperrors.Wrap(err, apperrors.TypeInternal, "sstableloader failed", "Ensure the keyspace and tables exist on the target cluster before restoring.")
+	}
+	return nil
+}
+
+// runCqlsh runs cqlsh against conn with args. Credentials go in a cqlshrc
+// that mktemp creates 0600 and the script removes, the password arriving
+// on stdin, so neither appears in the process list.
+func (ca *CassandraAdapter) runCqlsh(ctx context.Context, conn ConnectionParams, runner Runner, w io.Writer, args ...string) error {
+	args = append([]string{conn.Host, strconv.Itoa(ca.port(conn))}, args...)
+	if conn.TLS.Enabled {
+		args = append(args, "--ssl")
+	}
+	if conn.User == "" {
+		return runner.Run(ctx, "cqlsh", args, w)
+	}
+	script := `set -e
+rc=$(mktemp)
+trap 'rm -f "$rc"' EXIT
+IFS= read -r pw
+printf '[authentication]\nusername = %s\npassword = %s\n' "$1" "$pw" > "$rc"
+shift
+cqlsh --cqlshrc "$rc" "$@"`
+	return runner.RunWithIO(ctx, "sh", append([]string{"-c", script, "sh", conn.User}, args...), strings.NewReader(conn.Password+"\n"), w)
+}
+
+func (ca *CassandraAdapter) port(conn ConnectionParams) int {
+	if conn.Port == 0 {
+		return 9042
+	}
+	return conn.Port
+}
+
+func (ca *CassandraAdapter) dataDir(conn ConnectionParams) string {
+	if conn.DBUri != "" {
+		if u, err := url.Parse(conn.DBUri); err == nil {
+			if d := u.Query().Get("data_dir"); d != "" {
+				return d
+			}
+		}
+	}
+	return defaultCassandraDataDir
+}
+
+func isNotFound(err error) bool {
+	return strings.Contains(err.Error(), "status 127") || strings.Contains(err.Error(), "executable file not found")
+}
@@ -1,14 +1,20 @@
 package db
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"database/sql"
 	"fmt"
 	"io"
 	"net/url"
 	"os"
 	"os/exec"
+	"sort"
 	"strings"
+	"time"
 
+	apperrors "github.com/lupppig/dbackup/internal/errors"
 	"github.com/lupppig/dbackup/internal/logger"
 )
 
@@ -20,6 +26,42 @@ type TLSConfig struct {
 	ClientKey  string
 }
 
+// TLS modes accepted by --tls-mode. Every adapter gives them the same
+// meaning, whatever its driver calls them.
+const (
+	TLSDisable    = "disable"
+	TLSRequire    = "require"     // Encrypt, trust any server certificate
+	TLSVerifyCA   = "verify-ca"   // Also verify the certificate chain
+	TLSVerifyFull = "verify-full" // Also verify the certificate names the host
+)
+
+// EffectiveMode resolves the configuration to one of the TLS* modes. --tls
+// without a mode means require, and a mode other than disable turns TLS on
+// by itself. As in libpq, require with a CA certificate verifies the chain.
+// skip-verify, MySQL's name for require, is accepted.
+func (c TLSConfig) EffectiveMode() (string, error) {
+	mode := strings.ToLower(c.Mode)
+	switch mode {
+	case "", TLSDisable:
+		if !c.Enabled {
+			return TLSDisable, nil
+		}
+		mode = TLSRequire
+	case "skip-verify":
+		mode = TLSRequire
+	case TLSRequire, TLSVerifyCA, TLSVerifyFull:
+	default:
+		return "", apperrors.New(apperrors.TypeConfig, "unknown TLS mode: "+c.Mode, "Use one of: disable, require, verify-ca, verify-full.")
+	}
+	if mode == TLSRequire && c.CACert != "" {
+		mode = TLSVerifyCA
+	}
+	if c.ClientCert != "" && c.ClientKey == "" || c.ClientCert == "" && c.ClientKey != "" {
+		return "", apperrors.New(apperrors.TypeConfig, "both TLS ClientCert and ClientKey must be provided for mTLS", "Pass --tls-client-cert together with --tls-client-key.")
+	}
+	return mode, nil
+}
+
 type ConnectionParams struct {
 	DBType   string
 	DBName   string
@@ -31,6 +73,207 @@ type ConnectionParams struct {
 
 	TLS        TLSConfig
 	IsPhysical bool
+	OnConflict string // Restore conflict strategy, see ConflictClean etc.
+
+	// OverwriteExisting lets a restore replace a file-based database (SQLite)
+	// that already has data, without a conflict strategy asking for it.
+	OverwriteExisting bool
+
+	// ExtraArgs are appended to the engine tool's arguments (pg_dump, psql,
+	// mysqldump, ...) after dbackup's own. Engines without one ignore them.
+	ExtraArgs []string
+
+	// Exclude drops matching files from backups that are tar archives of
+	// files, see ValidateExcludes.
+	Exclude []string
+
+	// StagingDir is where physical MySQL restores unpack and prepare the
+	// backup; empty means the system temp dir. DataDir, when set, is the
+	// data directory the prepared backup is then copied into.
+	StagingDir string
+	DataDir    string
+
+	// PostRestoreCheck is a query run once a logical restore has loaded.
+	// The restore fails if it errors or, with PostRestoreExpect, if the
+	// first column of its first row is anything else.
+	PostRestoreCheck  string
+	PostRestoreExpect string
+
+	// IntoNewDB restores a logical backup into this database instead of
+	// DBName, creating it if missing. The dump's statements that name its
+	// own database are dropped, see stripDatabaseSwitches.
+	IntoNewDB string
+}
+
+// Restore conflict strategies, applied by RunRestore before the dump is
+// streamed into the target. The empty strategy restores on top of whatever
+// is there.
+const (
+	ConflictClean    = "clean"    // Drop the database's objects, keep the database
+	ConflictRecreate = "recreate" // Drop and create the database
+	ConflictFail     = "fail"     // Refuse to restore into a database that has tables
+)
+
+func ValidateOnConflict(strategy string) error {
+	switch strategy {
+	case "", ConflictClean, ConflictRecreate, ConflictFail:
+		return nil
+	}
+	return apperrors.New(apperrors.TypeConfig, "unknown conflict strategy: "+strategy, "Use one of: clean, recreate, fail.")
+}
+
+func errNotEmpty(name string, tables int) error {
+	return apperrors.New(apperrors.TypeConfig, fmt.Sprintf("target database %s already has %d tables", name, tables), "Restore into an empty database, or use --on-conflict clean or recreate to replace its contents.")
+}
+
+// isDryRun reports whether runner only logs commands, in which case restore
+// pre-steps must not touch the database either.
+func isDryRun(runner Runner) bool {
+	_, ok := runner.(*DryRunRunner)
+	return ok
+}
+
+// retarget points conn at IntoNewDB, if set, for a restore.
+func (c ConnectionParams) retarget() ConnectionParams {
+	if c.IntoNewDB == "" {
+		return c
+	}
+	return c.WithDatabase(c.IntoNewDB)
+}
+
+// Statements of logical dumps that create, drop or switch to the dumped
+// database by name (pg_dump --create/--clean, mysqldump --databases).
+var (
+	pgDatabaseSwitches    = []string{`\connect `, "CREATE DATABASE ", "ALTER DATABASE ", "DROP DATABASE "}
+	mysqlDatabaseSwitches = []string{"USE ", "CREATE DATABASE ", "DROP DATABASE ", "/*!40000 DROP DATABASE "}
+)
+
+// stripDatabaseSwitches copies r with the lines starting with one of
+// prefixes removed, so a dump loads into the database it is restored into
+// rather than the one it was taken from. Rows of a COPY ... FROM stdin block
+// are data and copied as they are. Closing the result stops the copy.
+func stripDatabaseSwitches(r io.Reader, prefixes []string) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		br := bufio.NewReaderSize(r, 64*1024)
+		midLine, dropping := false, false
+		copyHeader, inCopy := false, false
+		for {
+			line, err := br.ReadSlice('\n')
+			if !midLine {
+				dropping, copyHeader = false, false
+				if inCopy {
+					// The block ends with a line holding just \.
+					inCopy = string(bytes.TrimRight(line, "\r\n")) != `\.`
+				} else {
+					for _, p := range prefixes {
+						if bytes.HasPrefix(line, []byte(p)) {
+							dropping = true
+							break
+						}
+					}
+					copyHeader = bytes.HasPrefix(line, []byte("COPY "))
+				}
+			}
+			if !dropping {
+				if _, werr := pw.Write(line); werr != nil {
+					return
+				}
+			}
+			midLine = err == bufio.ErrBufferFull
+			if copyHeader && !midLine && bytes.HasSuffix(bytes.TrimRight(line, "\r\n"), []byte("FROM stdin;")) {
+				inCopy = true
+			}
+			if err == io.EOF {
+				pw.Close() // #nosec G104
+				return
+			}
+			if err != nil && !midLine {
+				pw.CloseWithError(err) // #nosec G104
+				return
+			}
+		}
+	}()
+	return pr
+}
+
+// postRestoreCheck runs conn.PostRestoreCheck through a connection from
+// open. Dry runs and restores without a check skip it.
+func postRestoreCheck(ctx context.Context, conn ConnectionParams, runner Runner, l *logger.Logger, open func() (*sql.DB, error)) error {
+	if conn.PostRestoreCheck == "" || isDryRun(runner) {
+		return nil
+	}
+	db, err := open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	got, err := queryFirstValue(ctx, db, conn.PostRestoreCheck)
+	if err != nil {
+		return apperrors.Wrap(err, apperrors.TypeIntegrity, "post-restore check failed", "The restore loaded but the check query errors; inspect the restored schema.")
+	}
+	if conn.PostRestoreExpect != "" && got != conn.PostRestoreExpect {
+		return apperrors.New(apperrors.TypeIntegrity,
+			fmt.Sprintf("post-restore check returned %q, expected %q", got, conn.PostRestoreExpect),
+			"The restored data isn't what the check expects; inspect the restored database.")
+	}
+	if l != nil {
+		l.Info("Post-restore check passed", "result", got)
+	}
+	return nil
+}
+
+// queryFirstValue returns the first column of query's first row as text, or
+// "" when it returns no rows.
+func queryFirstValue(ctx context.Context, db *sql.DB, query string) (string, error) {
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return "", err
+	}
+	if !rows.Next() || len(cols) == 0 {
+		return "", rows.Err()
+	}
+	vals := make([]sql.RawBytes, len(cols))
+	dest := make([]any, len(cols))
+	for i := range vals {
+		dest[i] = &vals[i]
+	}
+	if err := rows.Scan(dest...); err != nil {
+		return "", err
+	}
+	return string(vals[0]), nil
+}
+
+// connectTimeout bounds how long opening a connection for validation and
+// admin queries may take.
+var connectTimeout = 5 * time.Second
+
+// connect readies db, freshly opened for validation or admin queries. Those
+// run one at a time, so the pool is held to a single connection and parallel
+// dump tasks don't each keep spare server connections. The connection is
+// established here within connectTimeout: sql.Open is lazy and would
+// otherwise report an unreachable server from whichever query runs first.
+// On failure db is closed.
+func connect(ctx context.Context, db *sql.DB) error {
+	db.SetMaxOpenConns(1)
+	db.SetMaxIdleConns(1)
+	db.SetConnMaxLifetime(5 * time.Minute)
+
+	ctx, cancel := context.WithTimeout(ctx, connectTimeout)
+	defer cancel()
+	c, err := db.Conn(ctx)
+	if err != nil {
+		db.Close() // #nosec G104
+		return apperrors.Wrap(err, apperrors.TypeConnection, "failed to connect to database", "Verify the database host, port, and credentials.")
+	}
+	return c.Close()
 }
 
 func (c *ConnectionParams) ParseURI() error {
@@ -45,6 +288,9 @@ func (c *ConnectionParams) ParseURI() error {
 	if c.DBType == "" {
 		c.DBType = u.Scheme
 	}
+	if c.DBType == "scylla" {
+		c.DBType = "cassandra"
+	}
 
 	c.Host = u.Hostname()
 	if p := u.Port(); p != "" {
@@ -58,6 +304,8 @@ func (c *ConnectionParams) ParseURI() error {
 			}
 		case "mysql":
 			c.Port = 3306
+		case "cassandra", "scylla":
+			c.Port = 9042
 		}
 	}
 
@@ -81,6 +329,40 @@ func (c *ConnectionParams) ParseURI() error {
 	return nil
 }
 
+// WithDatabase returns a copy of c pointed at another database on the same
+// server. A connection URI keeps its credentials and query options.
+func (c ConnectionParams) WithDatabase(name string) ConnectionParams {
+	c.DBName = name
+	if c.DBUri != "" {
+		if u, err := url.Parse(c.DBUri); err == nil {
+			u.Path = "/" + name
+			u.RawPath = ""
+			c.DBUri = u.String()
+		}
+	}
+	return c
+}
+
+// systemDatabases are skipped by --all-databases unless --include-system is set.
+var systemDatabases = map[string][]string{
+	"postgres": {"template0", "template1"},
+	"mysql":    {"information_schema", "mysql", "performance_schema", "sys"},
+}
+
+// IsSystemDatabase reports whether name is one of engine's built-in databases.
+func IsSystemDatabase(engine, name string) bool {
+	engine = strings.ToLower(engine)
+	if engine == "postgresql" {
+		engine = "postgres"
+	}
+	for _, s := range systemDatabases[engine] {
+		if strings.EqualFold(s, name) {
+			return true
+		}
+	}
+	return false
+}
+
 type BackUpOptions struct {
 	Storage   string
 	Compress  bool
@@ -142,9 +424,32 @@ type DBAdapter interface {
 	BuildConnection(ctx context.Context, conn ConnectionParams) (string, error)
 	RunBackup(ctx context.Context, conn ConnectionParams, runner Runner, w io.Writer) error
 	RunRestore(ctx context.Context, conn ConnectionParams, runner Runner, r io.Reader) error
+	// ListDatabases returns every database on the server conn points at,
+	// including system ones. Engines without a catalog return nil.
+	ListDatabases(ctx context.Context, conn ConnectionParams) ([]string, error)
+	// ChangeSignal returns a cheap value that changes whenever the database
+	// is written to, for skipping backups of idle databases. Engines that
+	// can't tell return "".
+	ChangeSignal(ctx context.Context, conn ConnectionParams) (string, error)
+	Capabilities() Capabilities
 	SetLogger(l *logger.Logger)
 }
 
+// Capabilities describes what an engine's backups can do, so unsupported
+// combinations fail before any tool runs.
+type Capabilities struct {
+	SupportsPhysical    bool // Copies data files instead of dumping (--mysql-physical, pg_basebackup)
+	SupportsIncremental bool // Native incremental backups; dedupe works for every engine regardless
+	SupportsStreaming   bool // The dump streams to storage without being staged on disk first
+	// RequiresLocalDatadir means backups read the data directory (physical
+	// ones, for engines that also dump logically), so they must run on the
+	// database host or through --remote-exec.
+	RequiresLocalDatadir bool
+	// ExcludeFiles means backups (physical ones, for engines that also dump
+	// logically) are tar archives of files, so --exclude can drop some.
+	ExcludeFiles bool
+}
+
 var adapters = map[string]DBAdapter{}
 
 func RegisterAdapter(adapter DBAdapter) {
@@ -158,3 +463,27 @@ func GetAdapter(name string) (DBAdapter, error) {
 	}
 	return adapter, nil
 }
+
+// Adapters returns every registered adapter, sorted by name.
+func Adapters() []DBAdapter {
+	list := make([]DBAdapter, 0, len(adapters))
+	for _, a := range adapters {
+		list = append(list, a)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name() < list[j].Name() })
+	return list
+}
+
+// scanNames reads a single string column from rows.
+func scanNames(rows *sql.Rows) ([]string, error) {
+	defer rows.Close()
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
@@ -2,10 +2,31 @@ package db
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"database/sql"
+	"encoding/pem"
 	"errors"
 	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/go-sql-driver/mysql"
+	apperrors "github.com/lupppig/dbackup/internal/errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -49,6 +70,66 @@ func TestPostgresAdapter_ToolFailure(t *testing.T) {
 	assert.Contains(t, err.Error(), "pg_dump failed") // PostgresAdapter wraps the error
 }
 
+func TestPostgresAdapter_ErrorTypes(t *testing.T) {
+	pa := &PostgresAdapter{}
+	ctx := context.Background()
+
+	_, err := pa.BuildConnection(ctx, ConnectionParams{Host: "h"})
+	assert.True(t, apperrors.IsType(err, apperrors.TypeConfig))
+
+	_, err = pa.BuildConnection(ctx, ConnectionParams{Host: "h", User: "u", DBName: "d", TLS: TLSConfig{Enabled: true, ClientCert: "c.pem"}})
+	assert.True(t, apperrors.IsType(err, apperrors.TypeConfig))
+
+	conn := ConnectionParams{DBUri: "postgres://u:p@h:5432/d"}
+	err = pa.RunRestore(ctx, conn, &MockErrorRunner{Err: errors.New("exec: \"psql\": executable file not found in $PATH")}, strings.NewReader(""))
+	assert.True(t, apperrors.IsType(err, apperrors.TypeDependency))
+
+	err = pa.RunRestore(ctx, conn, &MockErrorRunner{Err: errors.New("exit status 3")}, strings.NewReader(""))
+	assert.True(t, apperrors.IsType(err, apperrors.TypeInternal))
+
+	conn.IsPhysical = true
+	err = pa.RunRestore(ctx, conn, &MockErrorRunner{}, strings.NewReader(""))
+	assert.True(t, apperrors.IsType(err, apperrors.TypeConfig))
+}
+
+func TestTestConnection_BadHost(t *testing.T) {
+	defer func(d time.Duration) { connectTimeout = d }(connectTimeout)
+	connectTimeout = 300 * time.Millisecond
+
+	// A server that accepts connections but never answers the handshake
+	silent, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer silent.Close()
+	go func() {
+		for {
+			c, err := silent.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(io.Discard, c) // #nosec G104
+		}
+	}()
+	port := silent.Addr().(*net.TCPAddr).Port
+
+	// Nothing listens on a port just released
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	closed := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+
+	for _, adapter := range []DBAdapter{&PostgresAdapter{}, &MysqlAdapter{}} {
+		for name, p := range map[string]int{"silent": port, "refused": closed} {
+			t.Run(adapter.Name()+"/"+name, func(t *testing.T) {
+				conn := ConnectionParams{DBType: adapter.Name(), Host: "127.0.0.1", Port: p, User: "u", Password: "p", DBName: "d"}
+				start := time.Now()
+				err := adapter.TestConnection(context.Background(), conn, &LocalRunner{})
+				assert.True(t, apperrors.IsType(err, apperrors.TypeConnection), "got %v", err)
+				assert.Less(t, time.Since(start), 3*time.Second)
+			})
+		}
+	}
+}
+
 func TestMysqlAdapter_ToolFailure(t *testing.T) {
 	ma := &MysqlAdapter{}
 	ctx := context.Background()
@@ -61,3 +142,499 @@ func TestMysqlAdapter_ToolFailure(t *testing.T) {
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "mysqldump execution failed") // MysqlAdapter wraps the error
 }
+
+type recordingRunner struct {
+	calls []string
+}
+
+func (r *recordingRunner) Run(ctx context.Context, name string, args []string, w io.Writer) error {
+	return r.RunWithIO(ctx, name, args, nil, w)
+}
+
+func (r *recordingRunner) RunWithIO(ctx context.Context, name string, args []string, stdin io.Reader, stdout io.Writer) error {
+	r.calls = append(r.calls, name+" "+args[0])
+	return nil
+}
+
+func TestCassandraAdapter_Backup(t *testing.T) {
+	conn := ConnectionParams{DBUri: "scylla://node1/shop?data_dir=/data"}
+	require.NoError(t, conn.ParseURI())
+	assert.Equal(t, "cassandra", conn.DBType)
+	assert.Equal(t, 9042, conn.Port)
+	assert.Equal(t, "shop", conn.DBName)
+
+	ca := &CassandraAdapter{}
+	assert.Equal(t, "/data", ca.dataDir(conn))
+
+	runner := &recordingRunner{}
+	require.NoError(t, ca.RunBackup(context.Background(), conn, runner, io.Discard))
+	assert.Equal(t, []string{"nodetool snapshot", "sh -c", "nodetool clearsnapshot"}, runner.calls)
+}
+
+func TestCassandraAdapter_ToolFailure(t *testing.T) {
+	ca := &CassandraAdapter{}
+	conn := ConnectionParams{Host: "node1", DBName: "shop"}
+	runner := &MockErrorRunner{Err: errors.New("exit status 1")}
+
+	err := ca.RunBackup(context.Background(), conn, runner, io.Discard)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "nodetool snapshot failed")
+
+	_, err = ca.BuildConnection(context.Background(), ConnectionParams{Host: "node1"})
+	assert.Error(t, err, "keyspace is required")
+}
+
+// argsRunner records the arguments and stdin of every command.
+type argsRunner struct {
+	args  [][]string
+	stdin []string
+}
+
+func (r *argsRunner) Run(ctx context.Context, name string, args []string, w io.Writer) error {
+	return r.RunWithIO(ctx, name, args, nil, w)
+}
+
+func (r *argsRunner) RunWithIO(ctx context.Context, name string, args []string, stdin io.Reader, stdout io.Writer) error {
+	r.args = append(r.args, append([]string{name}, args...))
+	in := ""
+	if stdin != nil {
+		b, _ := io.ReadAll(stdin)
+		in = string(b)
+	}
+	r.stdin = append(r.stdin, in)
+	return nil
+}
+
+func TestCassandraAdapter_PasswordNotOnCommandLine(t *testing.T) {
+	ctx := context.Background()
+	conn := ConnectionParams{DBType: "cassandra", Host: "node1", DBName: "shop", User: "admin", Password: "s3cret"}
+	ca := &CassandraAdapter{}
+
+	runner := &argsRunner{}
+	require.NoError(t, ca.RunRestore(ctx, conn, runner, strings.NewReader("TAR")))
+	require.Len(t, runner.args, 1)
+	assert.NotContains(t, strings.Join(runner.args[0], " "), "s3cret")
+	assert.Equal(t, "s3cret\nTAR", runner.stdin[0])
+
+	if runtime.GOOS == "windows" {
+		t.Skip("stub command is a shell script")
+	}
+	// A stub cqlsh keeps what it was given
+	bin, out := t.TempDir(), t.TempDir()
+	script := "#!/bin/sh\necho \"$@\" > " + out + "/args\ncp \"$2\" " + out + "/rc\nstat -c %a \"$2\" > " + out + "/mode\n"
+	require.NoError(t, os.WriteFile(filepath.Join(bin, "cqlsh"), []byte(script), 0o755)) // #nosec G306
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	require.NoError(t, ca.runCqlsh(ctx, conn, NewLocalRunner(nil), io.Discard, "-e", "SELECT 1"))
+
+	args, err := os.ReadFile(filepath.Join(out, "args"))
+	require.NoError(t, err)
+	assert.NotContains(t, string(args), "s3cret")
+	assert.Contains(t, string(args), "node1 9042 -e SELECT 1")
+	rc, err := os.ReadFile(filepath.Join(out, "rc"))
+	require.NoError(t, err)
+	assert.Equal(t, "[authentication]\nusername = admin\npassword = s3cret\n", string(rc))
+	mode, err := os.ReadFile(filepath.Join(out, "mode"))
+	require.NoError(t, err)
+	assert.Equal(t, "600", strings.TrimSpace(string(mode)))
+}
+
+func TestConnectionParams_WithDatabase(t *testing.T) {
+	c := ConnectionParams{DBType: "postgres", DBUri: "postgres://u:p@db:5432/app?sslmode=require"}
+	got := c.WithDatabase("billing")
+	assert.Equal(t, "billing", got.DBName)
+	assert.Equal(t, "postgres://u:p@db:5432/billing?sslmode=require", got.DBUri)
+	assert.Equal(t, "postgres://u:p@db:5432/app?sslmode=require", c.DBUri, "original is untouched")
+
+	flags := ConnectionParams{DBType: "mysql", Host: "db"}.WithDatabase("shop")
+	assert.Equal(t, "shop", flags.DBName)
+	assert.Empty(t, flags.DBUri)
+}
+
+func TestIsSystemDatabase(t *testing.T) {
+	assert.True(t, IsSystemDatabase("postgresql", "template1"))
+	assert.False(t, IsSystemDatabase("postgres", "postgres"))
+	assert.True(t, IsSystemDatabase("mysql", "performance_schema"))
+	assert.False(t, IsSystemDatabase("mysql", "shop"))
+	assert.False(t, IsSystemDatabase("sqlite", "mysql"))
+}
+
+func TestSqliteAdapter_OnConflictFail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.db")
+	require.NoError(t, os.WriteFile(path, []byte("existing"), 0600))
+
+	sq := &SqliteAdapter{}
+	conn := ConnectionParams{DBType: "sqlite", DBName: path, OnConflict: ConflictFail}
+	err := sq.RunRestore(context.Background(), conn, &LocalRunner{}, strings.NewReader("restored"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "already exists")
+
+	// A dry run never touches the target, so it doesn't trip the check
+	require.NoError(t, sq.RunRestore(context.Background(), conn, NewDryRunRunner(nil), strings.NewReader("restored")))
+
+	conn.OnConflict = ConflictRecreate
+	require.NoError(t, sq.RunRestore(context.Background(), conn, &LocalRunner{}, strings.NewReader("restored")))
+	data, _ := os.ReadFile(path)
+	assert.Equal(t, "restored", string(data))
+}
+
+func TestSqliteAdapter_OverwriteExisting(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.db")
+	require.NoError(t, os.WriteFile(path, []byte("existing"), 0600))
+
+	sq := &SqliteAdapter{}
+	conn := ConnectionParams{DBType: "sqlite", DBName: path}
+	err := sq.RunRestore(context.Background(), conn, &LocalRunner{}, strings.NewReader("restored"))
+	var appErr *apperrors.AppError
+	require.True(t, errors.As(err, &appErr), "got %v", err)
+	assert.Equal(t, apperrors.TypeResource, appErr.Type)
+	data, _ := os.ReadFile(path)
+	assert.Equal(t, "existing", string(data), "the refused restore leaves the file alone")
+
+	conn.OverwriteExisting = true
+	require.NoError(t, sq.RunRestore(context.Background(), conn, &LocalRunner{}, strings.NewReader("restored")))
+	data, _ = os.ReadFile(path)
+	assert.Equal(t, "restored", string(data))
+
+	// An empty file, as left by touch or a failed create, has nothing to lose
+	require.NoError(t, os.WriteFile(path, nil, 0600))
+	conn.OverwriteExisting = false
+	require.NoError(t, sq.RunRestore(context.Background(), conn, &LocalRunner{}, strings.NewReader("restored")))
+}
+
+func TestSqliteAdapter_PostRestoreCheck(t *testing.T) {
+	ctx := context.Background()
+	src := filepath.Join(t.TempDir(), "src.db")
+	db, err := sql.Open("sqlite3", src)
+	require.NoError(t, err)
+	_, err = db.Exec("CREATE TABLE users (id INTEGER); INSERT INTO users VALUES (1), (2), (3)")
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+	dump, err := os.ReadFile(src)
+	require.NoError(t, err)
+
+	sq := &SqliteAdapter{}
+	restore := func(check, expect string) error {
+		conn := ConnectionParams{
+			DBType:            "sqlite",
+			DBName:            filepath.Join(t.TempDir(), "restored.db"),
+			PostRestoreCheck:  check,
+			PostRestoreExpect: expect,
+		}
+		return sq.RunRestore(ctx, conn, &LocalRunner{}, strings.NewReader(string(dump)))
+	}
+
+	assert.NoError(t, restore("SELECT count(*) FROM users", "3"))
+	assert.NoError(t, restore("SELECT count(*) FROM users", ""))
+
+	var appErr *apperrors.AppError
+	err = restore("SELECT count(*) FROM users", "4")
+	require.True(t, errors.As(err, &appErr), "got %v", err)
+	assert.Equal(t, apperrors.TypeIntegrity, appErr.Type)
+	assert.Contains(t, err.Error(), `returned "3", expected "4"`)
+
+	// A schema the restore didn't bring back fails the check
+	err = restore("SELECT count(*) FROM orders", "")
+	require.True(t, errors.As(err, &appErr), "got %v", err)
+	assert.Equal(t, apperrors.TypeIntegrity, appErr.Type)
+}
+
+func TestSqliteAdapter_IntoNewDB(t *testing.T) {
+	dir := t.TempDir()
+	orig := filepath.Join(dir, "prod.db")
+	require.NoError(t, os.WriteFile(orig, []byte("prod"), 0600))
+
+	sq := &SqliteAdapter{}
+	staging := filepath.Join(dir, "staging.db")
+	conn := ConnectionParams{DBType: "sqlite", DBName: orig, IntoNewDB: staging}
+	require.NoError(t, sq.RunRestore(context.Background(), conn, &LocalRunner{}, strings.NewReader("restored")))
+
+	data, err := os.ReadFile(staging)
+	require.NoError(t, err)
+	assert.Equal(t, "restored", string(data))
+	data, err = os.ReadFile(orig)
+	require.NoError(t, err)
+	assert.Equal(t, "prod", string(data), "the original database is left alone")
+}
+
+func TestStripDatabaseSwitches(t *testing.T) {
+	long := "INSERT INTO t VALUES ('" + strings.Repeat("x", 100*1024) + "');\n"
+	tests := []struct {
+		name     string
+		prefixes []string
+		in, want string
+	}{
+		{
+			name:     "pg_dump --create --clean",
+			prefixes: pgDatabaseSwitches,
+			in:       "DROP DATABASE prod;\nCREATE DATABASE prod WITH TEMPLATE = template0;\nALTER DATABASE prod SET search_path = app;\n\\connect prod\nCREATE TABLE t (id int);\nCOPY t (id) FROM stdin;\n1\n\\.\n",
+			want:     "CREATE TABLE t (id int);\nCOPY t (id) FROM stdin;\n1\n\\.\n",
+		},
+		{
+			name:     "mysqldump --databases --add-drop-database",
+			prefixes: mysqlDatabaseSwitches,
+			in:       "-- Current Database: `prod`\n/*!40000 DROP DATABASE IF EXISTS `prod`*/;\nCREATE DATABASE /*!32312 IF NOT EXISTS*/ `prod`;\nUSE `prod`;\nCREATE TABLE t (id int);\n",
+			want:     "-- Current Database: `prod`\nCREATE TABLE t (id int);\n",
+		},
+		{
+			name:     "COPY rows are data",
+			prefixes: pgDatabaseSwitches,
+			in:       "\\connect prod\nCOPY notes (body) FROM stdin;\nALTER DATABASE is data here\n\\connect too\n\\.\nALTER DATABASE prod SET x = 1;\nCREATE TABLE u (id int);\n",
+			want:     "COPY notes (body) FROM stdin;\nALTER DATABASE is data here\n\\connect too\n\\.\nCREATE TABLE u (id int);\n",
+		},
+		{
+			name:     "long lines and no final newline",
+			prefixes: mysqlDatabaseSwitches,
+			in:       long + "USE `prod`;\n" + "INSERT INTO t VALUES (1);",
+			want:     long + "INSERT INTO t VALUES (1);",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rc := stripDatabaseSwitches(strings.NewReader(tt.in), tt.prefixes)
+			defer rc.Close()
+			got, err := io.ReadAll(rc)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, string(got))
+		})
+	}
+}
+
+func TestValidateOnConflict(t *testing.T) {
+	for _, s := range []string{"", ConflictClean, ConflictRecreate, ConflictFail} {
+		assert.NoError(t, ValidateOnConflict(s), s)
+	}
+	assert.Error(t, ValidateOnConflict("overwrite"))
+}
+
+func TestSqliteAdapter_TestConnectionLocked(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "app.db")
+	db, err := sql.Open("sqlite3", path)
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Exec("CREATE TABLE t (id INTEGER)")
+	require.NoError(t, err)
+
+	sq := &SqliteAdapter{}
+	conn := ConnectionParams{DBType: "sqlite", DBName: path}
+	require.NoError(t, sq.TestConnection(ctx, conn, nil))
+
+	// A writer holding an exclusive lock keeps readers out
+	writer, err := db.Conn(ctx)
+	require.NoError(t, err)
+	defer writer.Close()
+	_, err = writer.ExecContext(ctx, "BEGIN EXCLUSIVE")
+	require.NoError(t, err)
+	defer writer.ExecContext(ctx, "ROLLBACK") // #nosec G104
+
+	err = sq.TestConnection(ctx, conn, nil)
+	var appErr *apperrors.AppError
+	require.True(t, errors.As(err, &appErr), "got %v", err)
+	assert.Equal(t, apperrors.TypeResource, appErr.Type)
+}
+
+func TestWithBusyTimeout(t *testing.T) {
+	assert.Equal(t, "app.db?_busy_timeout=1000", withBusyTimeout("app.db"))
+	assert.Equal(t, "file:app.db?mode=ro&_busy_timeout=1000", withBusyTimeout("file:app.db?mode=ro"))
+	assert.Equal(t, "", withBusyTimeout(""))
+}
+
+func TestSqliteAdapter_TestConnectionNoPath(t *testing.T) {
+	// The busy timeout must not turn a missing path into a file named after it
+	dir := t.TempDir()
+	t.Chdir(dir)
+	_ = (&SqliteAdapter{}).TestConnection(context.Background(), ConnectionParams{DBType: "sqlite"}, nil)
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestAdapters(t *testing.T) {
+	var names []string
+	for _, a := range Adapters() {
+		names = append(names, a.Name())
+	}
+	assert.Equal(t, []string{"cassandra", "mysql", "postgres", "sqlite"}, names)
+
+	mysql, err := GetAdapter("mysql")
+	require.NoError(t, err)
+	assert.True(t, mysql.Capabilities().SupportsPhysical)
+	assert.True(t, mysql.Capabilities().RequiresLocalDatadir)
+}
+
+func TestTLSConfig_EffectiveMode(t *testing.T) {
+	tests := []struct {
+		cfg  TLSConfig
+		want string
+	}{
+		{TLSConfig{}, TLSDisable},
+		{TLSConfig{Mode: "disable"}, TLSDisable},
+		{TLSConfig{Enabled: true}, TLSRequire},
+		{TLSConfig{Enabled: true, Mode: "disable"}, TLSRequire},
+		{TLSConfig{Mode: "require"}, TLSRequire},
+		{TLSConfig{Mode: "skip-verify"}, TLSRequire},
+		{TLSConfig{Mode: "require", CACert: "ca.pem"}, TLSVerifyCA},
+		{TLSConfig{Mode: "VERIFY-CA"}, TLSVerifyCA},
+		{TLSConfig{Mode: "verify-full"}, TLSVerifyFull},
+	}
+	for _, tt := range tests {
+		got, err := tt.cfg.EffectiveMode()
+		require.NoError(t, err, "%+v", tt.cfg)
+		assert.Equal(t, tt.want, got, "%+v", tt.cfg)
+	}
+
+	_, err := TLSConfig{Mode: "prefer"}.EffectiveMode()
+	assert.True(t, apperrors.IsType(err, apperrors.TypeConfig))
+	_, err = TLSConfig{Mode: "require", ClientKey: "k.pem"}.EffectiveMode()
+	assert.True(t, apperrors.IsType(err, apperrors.TypeConfig))
+}
+
+func TestPostgresAdapter_TLSModes(t *testing.T) {
+	pa := &PostgresAdapter{}
+	for mode, want := range map[string]string{
+		"":            "disable",
+		"require":     "require",
+		"skip-verify": "require",
+		"verify-ca":   "verify-ca",
+		"verify-full": "verify-full",
+	} {
+		dsn, err := pa.BuildConnection(context.Background(), ConnectionParams{Host: "h", User: "u", DBName: "d", TLS: TLSConfig{Mode: mode}})
+		require.NoError(t, err)
+		u, err := url.Parse(dsn)
+		require.NoError(t, err)
+		assert.Equal(t, want, u.Query().Get("sslmode"), "mode %q", mode)
+	}
+}
+
+func TestMysqlAdapter_TLSModes(t *testing.T) {
+	// httptest's certificate is valid for example.com and 127.0.0.1
+	server := httptest.NewTLSServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	defer server.Close()
+	ca := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(ca, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600))
+
+	handshake := func(cfg TLSConfig, host string) error {
+		mode, err := cfg.EffectiveMode()
+		require.NoError(t, err)
+		tc, _, err := mysqlTLSConfig(cfg, mode, host)
+		require.NoError(t, err)
+		conn, err := tls.Dial("tcp", server.Listener.Addr().String(), tc)
+		if err == nil {
+			conn.Close()
+		}
+		return err
+	}
+
+	assert.NoError(t, handshake(TLSConfig{Mode: "require"}, "db.internal"), "require trusts any certificate")
+	assert.NoError(t, handshake(TLSConfig{Mode: "verify-ca", CACert: ca}, "db.internal"), "verify-ca ignores the host name")
+	assert.Error(t, handshake(TLSConfig{Mode: "verify-ca"}, "example.com"), "verify-ca must check the chain")
+	assert.NoError(t, handshake(TLSConfig{Mode: "verify-full", CACert: ca}, "example.com"))
+	assert.Error(t, handshake(TLSConfig{Mode: "verify-full", CACert: ca}, "db.internal"), "verify-full must check the host name")
+
+	for mode, want := range map[string]string{
+		"":            "--ssl=OFF",
+		"require":     "--ssl-mode=REQUIRED",
+		"verify-ca":   "--ssl-mode=VERIFY_CA",
+		"verify-full": "--ssl-mode=VERIFY_IDENTITY",
+	} {
+		args, err := mysqlTLSArgs(TLSConfig{Mode: mode})
+		require.NoError(t, err)
+		assert.Equal(t, []string{want}, args, "mode %q", mode)
+	}
+
+	dsn, err := (&MysqlAdapter{}).BuildConnection(context.Background(), ConnectionParams{Host: "db", User: "u", DBName: "d", TLS: TLSConfig{Mode: "verify-full"}})
+	require.NoError(t, err)
+	assert.Contains(t, dsn, "?tls=dbackup_")
+}
+
+func TestMysqlAdapter_TLSConfigPerHost(t *testing.T) {
+	ma := &MysqlAdapter{}
+	dsnFor := func(host string) string {
+		dsn, err := ma.BuildConnection(context.Background(), ConnectionParams{Host: host, User: "u", DBName: "d", TLS: TLSConfig{Mode: "verify-full"}})
+		require.NoError(t, err)
+		_, name, _ := strings.Cut(dsn, "?tls=")
+		return name
+	}
+
+	// The driver's TLS configs are global; backups of two hosts running at
+	// once must not verify against each other's host name
+	a, b := dsnFor("db-a.internal"), dsnFor("db-b.internal")
+	assert.NotEqual(t, a, b)
+	assert.Equal(t, a, dsnFor("db-a.internal"), "the same host reuses its config")
+
+	tc, _, err := mysqlTLSConfig(TLSConfig{}, TLSVerifyFull, "db-a.internal")
+	require.NoError(t, err)
+	assert.Equal(t, "db-a.internal", tc.ServerName)
+	assert.False(t, tc.InsecureSkipVerify)
+}
+
+func TestMysqlAdapter_TLSConfigConcurrentCAs(t *testing.T) {
+	// Two servers with different self-signed certificates; each CA file
+	// trusts only its own
+	servers := make([]*httptest.Server, 2)
+	cas := make([]string, 2)
+	for i := range servers {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+		tmpl := &x509.Certificate{
+			SerialNumber:          big.NewInt(int64(i + 1)),
+			Subject:               pkix.Name{CommonName: "db"},
+			NotBefore:             time.Now().Add(-time.Hour),
+			NotAfter:              time.Now().Add(time.Hour),
+			IsCA:                  true,
+			BasicConstraintsValid: true,
+			KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		}
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+		require.NoError(t, err)
+
+		servers[i] = httptest.NewUnstartedServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+		servers[i].TLS = &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+		servers[i].StartTLS()
+		defer servers[i].Close()
+		cas[i] = filepath.Join(t.TempDir(), "ca.pem")
+		require.NoError(t, os.WriteFile(cas[i], pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	}
+
+	// Same mode, same host, same file name: only the CA bytes differ
+	names := make([]string, 2)
+	releases := make([]func(), 2)
+	var wg sync.WaitGroup
+	for i := range cas {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			name, release, err := acquireTLSConfig(TLSConfig{Mode: "verify-ca", CACert: cas[i]}, TLSVerifyCA, "db")
+			assert.NoError(t, err)
+			names[i], releases[i] = name, release
+		}(i)
+	}
+	wg.Wait()
+	require.NotEqual(t, names[0], names[1])
+
+	for i, name := range names {
+		// The driver resolves the name while parsing the DSN
+		cfg, err := mysql.ParseDSN("u@tcp(db)/d?tls=" + name)
+		require.NoError(t, err)
+		conn, err := tls.Dial("tcp", servers[i].Listener.Addr().String(), cfg.TLS)
+		require.NoError(t, err, "config %d must trust its own server", i)
+		conn.Close()
+		_, err = tls.Dial("tcp", servers[1-i].Listener.Addr().String(), cfg.TLS)
+		assert.Error(t, err, "config %d must not trust the other server", i)
+	}
+
+	// Another user of the same settings shares the registration
+	again, releaseAgain, err := acquireTLSConfig(TLSConfig{Mode: "verify-ca", CACert: cas[0]}, TLSVerifyCA, "db")
+	require.NoError(t, err)
+	assert.Equal(t, names[0], again)
+	releases[0]()
+	_, err = mysql.ParseDSN("u@tcp(db)/d?tls=" + names[0])
+	assert.NoError(t, err, "still in use")
+	releaseAgain()
+	releases[1]()
+	for _, name := range names {
+		_, err := mysql.ParseDSN("u@tcp(db)/d?tls=" + name)
+		assert.Error(t, err, "released configs are deregistered")
+	}
+}
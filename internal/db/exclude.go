@@ -0,0 +1,127 @@
+package db
+
+import (
+	"archive/tar"
+	"io"
+	"path"
+	"strings"
+
+	apperrors "github.com/lupppig/dbackup/internal/errors"
+	"github.com/lupppig/dbackup/internal/logger"
+)
+
+// ValidateExcludes checks --exclude patterns. A pattern without a slash
+// matches any single path element (pg_log, *.tmp); one with a slash matches
+// a member's path from the archive root, or any directory above it
+// (base/*/pgsql_tmp*). Matching a directory drops everything under it.
+func ValidateExcludes(patterns []string) error {
+	for _, p := range patterns {
+		if strings.Trim(p, "/") == "" {
+			return apperrors.New(apperrors.TypeConfig, "empty exclude pattern", "Remove the empty --exclude.")
+		}
+		if _, err := path.Match(p, ""); err != nil {
+			return apperrors.Wrap(err, apperrors.TypeConfig, "invalid exclude pattern: "+p, "Use shell glob syntax: *, ? and [...].")
+		}
+	}
+	return nil
+}
+
+// excludes reports whether pattern drops the archive member name.
+func excludes(pattern, name string) bool {
+	name = path.Clean(strings.TrimPrefix(name, "./"))
+	if !strings.Contains(strings.Trim(pattern, "/"), "/") {
+		pattern = strings.Trim(pattern, "/")
+		for _, elem := range strings.Split(name, "/") {
+			if ok, _ := path.Match(pattern, elem); ok {
+				return true
+			}
+		}
+		return false
+	}
+	pattern = strings.Trim(path.Clean(pattern), "/")
+	for p := name; ; {
+		if ok, _ := path.Match(pattern, p); ok {
+			return true
+		}
+		i := strings.LastIndex(p, "/")
+		if i < 0 {
+			return false
+		}
+		p = p[:i]
+	}
+}
+
+// filterTar copies the tar stream r to w without the members any pattern
+// excludes. It returns how many members each pattern dropped.
+func filterTar(r io.Reader, w io.Writer, patterns []string) ([]int, error) {
+	hits := make([]int, len(patterns))
+	tr := tar.NewReader(r)
+	tw := tar.NewWriter(w)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return hits, apperrors.Wrap(err, apperrors.TypeInternal, "failed to read the backup archive", "The dump tool's output is not a tar stream; drop --exclude.")
+		}
+
+		drop := false
+		for i, p := range patterns {
+			if excludes(p, h.Name) {
+				hits[i]++
+				drop = true
+			}
+		}
+		if drop {
+			continue
+		}
+		if err := tw.WriteHeader(h); err != nil {
+			return hits, err
+		}
+		if _, err := io.Copy(tw, tr); err != nil {
+			return hits, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return hits, err
+	}
+	// Let the tool finish writing its trailing padding
+	_, err := io.Copy(io.Discard, r)
+	return hits, err
+}
+
+// withExcludes runs backup, which writes a tar stream, with conn.Exclude
+// applied to its output. Patterns that dropped nothing are logged, as they
+// are usually a typo.
+func withExcludes(conn ConnectionParams, l *logger.Logger, w io.Writer, backup func(w io.Writer) error) error {
+	if len(conn.Exclude) == 0 {
+		return backup(w)
+	}
+
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	var hits []int
+	go func() {
+		var err error
+		hits, err = filterTar(pr, w, conn.Exclude)
+		pr.CloseWithError(err) // Unblocks the tool if filtering failed
+		done <- err
+	}()
+
+	err := backup(pw)
+	pw.CloseWithError(err)
+	if ferr := <-done; err == nil {
+		err = ferr
+	}
+	if err != nil {
+		return err
+	}
+
+	for i, p := range conn.Exclude {
+		if hits[i] == 0 && l != nil {
+			l.Warn("Exclude pattern matched nothing", "pattern", p)
+		}
+	}
+	return nil
+}
@@ -2,13 +2,17 @@ package db
 
 import (
 	"context"
+	"crypto/sha256"
 	"crypto/tls"
 	"crypto/x509"
 	"database/sql"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/go-sql-driver/mysql"
@@ -55,37 +59,222 @@ func (ma *MysqlAdapter) Name() string {
 	return "mysql"
 }
 
+// Capabilities: xtrabackup copies the data directory, so physical backups
+// run on the MySQL host.
+func (ma *MysqlAdapter) Capabilities() Capabilities {
+	return Capabilities{SupportsPhysical: true, SupportsStreaming: true, RequiresLocalDatadir: true}
+}
+
 func (ma *MysqlAdapter) TestConnection(ctx context.Context, conn ConnectionParams, runner Runner) error {
 	if ma.logger != nil {
 		ma.logger.Info("Testing database connection...", "host", conn.Host, "db", conn.DBName)
 	}
-	dsn, err := ma.BuildConnection(ctx, conn)
+	db, err := ma.open(ctx, conn)
 	if err != nil {
 		return err
 	}
+	return db.Close()
+}
 
+func (ma *MysqlAdapter) ListDatabases(ctx context.Context, conn ConnectionParams) ([]string, error) {
+	if conn.DBName == "" {
+		conn = conn.WithDatabase("mysql") // Any database works for SHOW DATABASES
+	}
+	db, err := ma.open(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	rows, err := db.QueryContext(ctx, "SHOW DATABASES")
+	if err != nil {
+		return nil, apperrors.Wrap(err, apperrors.TypeConnection, "failed to list databases", "Verify the user has the SHOW DATABASES privilege.")
+	}
+	return scanNames(rows)
+}
+
+// ChangeSignal is the binary log position. It covers the whole server, so
+// writes to any database count. Without binary logging it returns "".
+func (ma *MysqlAdapter) ChangeSignal(ctx context.Context, conn ConnectionParams) (string, error) {
+	db, err := ma.open(ctx, conn)
+	if err != nil {
+		return "", err
+	}
+	defer db.Close()
+
+	// MySQL 8.4 removed SHOW MASTER STATUS; MariaDB and older MySQL lack the new form
+	rows, err := db.QueryContext(ctx, "SHOW BINARY LOG STATUS")
+	if err != nil {
+		rows, err = db.QueryContext(ctx, "SHOW MASTER STATUS")
+	}
+	if err != nil {
+		return "", apperrors.Wrap(err, apperrors.TypeConnection, "failed to read binary log position", "Verify the user has the REPLICATION CLIENT privilege.")
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return "", rows.Err()
+	}
+	cols, err := rows.Columns()
+	if err != nil {
+		return "", err
+	}
+	vals := make([]sql.RawBytes, len(cols))
+	dest := make([]any, len(cols))
+	for i := range vals {
+		dest[i] = &vals[i]
+	}
+	if err := rows.Scan(dest...); err != nil {
+		return "", err
+	}
+	if len(vals) < 2 {
+		return "", nil
+	}
+	return fmt.Sprintf("mysql:%s:%s", vals[0], vals[1]), nil
+}
+
+// open connects without leaving a TLS config registered: sql.Open parses the
+// DSN and copies the config right away, so the registration is released as
+// soon as it returns.
+func (ma *MysqlAdapter) open(ctx context.Context, conn ConnectionParams) (*sql.DB, error) {
+	dsn, release, err := ma.buildDSN(conn)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
 	db, err := sql.Open("mysql", dsn)
 	if err != nil {
-		return apperrors.Wrap(err, apperrors.TypeConfig, "failed to open MySQL connection", "Check your connection string and driver availability.")
+		return nil, apperrors.Wrap(err, apperrors.TypeConfig, "failed to open MySQL connection", "Check your connection string and driver availability.")
+	}
+	if err := connect(ctx, db); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// createDatabase creates conn.DBName unless it exists already.
+func (ma *MysqlAdapter) createDatabase(ctx context.Context, conn ConnectionParams) error {
+	name := conn.DBName
+	conn.DBUri = ""
+	db, err := ma.open(ctx, conn.WithDatabase("mysql"))
+	if err != nil {
+		return err
 	}
 	defer db.Close()
 
-	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
-	defer cancel()
+	if ma.logger != nil {
+		ma.logger.Info("Creating restore target if missing", "database", name)
+	}
+	if _, err := db.ExecContext(ctx, "CREATE DATABASE IF NOT EXISTS `"+strings.ReplaceAll(name, "`", "``")+"`"); err != nil {
+		return apperrors.Wrap(err, apperrors.TypeConnection, "failed to create database "+name, "Check the user has the CREATE privilege, or create it first.")
+	}
+	return nil
+}
 
-	if err := db.PingContext(ctx); err != nil {
-		return apperrors.Wrap(err, apperrors.TypeConnection, "failed to ping database", "Verify the database host, port, and credentials.")
+// prepareRestore applies conn.OnConflict to the target database. The
+// connection fields must already be parsed from any URI.
+func (ma *MysqlAdapter) prepareRestore(ctx context.Context, conn ConnectionParams) error {
+	if conn.OnConflict == "" {
+		return nil
+	}
+	if ma.logger != nil {
+		ma.logger.Info("Preparing restore target", "database", conn.DBName, "on_conflict", conn.OnConflict)
+	}
+
+	name := conn.DBName
+	conn.DBUri = ""
+	quoted := "`" + strings.ReplaceAll(name, "`", "``") + "`"
+
+	switch conn.OnConflict {
+	case ConflictRecreate:
+		db, err := ma.open(ctx, conn.WithDatabase("mysql"))
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		for _, stmt := range []string{"DROP DATABASE IF EXISTS " + quoted, "CREATE DATABASE " + quoted} {
+			if _, err := db.ExecContext(ctx, stmt); err != nil {
+				return apperrors.Wrap(err, apperrors.TypeConnection, "failed to recreate database "+name, "Check the user has the DROP and CREATE privileges.")
+			}
+		}
+
+	case ConflictFail:
+		db, err := ma.open(ctx, conn.WithDatabase("mysql"))
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		var tables int
+		if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = ?", name).Scan(&tables); err != nil {
+			return apperrors.Wrap(err, apperrors.TypeConnection, "failed to inspect target database", "Verify the database host, port, and credentials.")
+		}
+		if tables > 0 {
+			return errNotEmpty(name, tables)
+		}
+
+	case ConflictClean:
+		db, err := ma.open(ctx, conn)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		// FOREIGN_KEY_CHECKS is per session, so keep to one connection
+		c, err := db.Conn(ctx)
+		if err != nil {
+			return apperrors.Wrap(err, apperrors.TypeConnection, "failed to connect to target database", "Verify the database host, port, and credentials.")
+		}
+		defer c.Close()
+
+		rows, err := c.QueryContext(ctx, "SELECT table_name, table_type FROM information_schema.tables WHERE table_schema = ?", name)
+		if err != nil {
+			return apperrors.Wrap(err, apperrors.TypeConnection, "failed to list tables", "Verify the user can read information_schema.")
+		}
+		var stmts []string
+		for rows.Next() {
+			var table, kind string
+			if err := rows.Scan(&table, &kind); err != nil {
+				rows.Close()
+				return err
+			}
+			object := "TABLE"
+			if kind == "VIEW" {
+				object = "VIEW"
+			}
+			stmts = append(stmts, fmt.Sprintf("DROP %s IF EXISTS `%s`", object, strings.ReplaceAll(table, "`", "``")))
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		stmts = append([]string{"SET FOREIGN_KEY_CHECKS = 0"}, stmts...)
+		for _, stmt := range stmts {
+			if _, err := c.ExecContext(ctx, stmt); err != nil {
+				return apperrors.Wrap(err, apperrors.TypeConnection, "failed to clean target database", "Check the user has the DROP privilege.")
+			}
+		}
 	}
 	return nil
 }
 
+// BuildConnection returns a DSN for callers that open it themselves, so its
+// TLS config stays registered for the life of the process. Registrations are
+// named after their content, so repeated calls with the same settings reuse
+// one entry.
 func (ma *MysqlAdapter) BuildConnection(ctx context.Context, conn ConnectionParams) (string, error) {
+	dsn, _, err := ma.buildDSN(conn)
+	return dsn, err
+}
+
+// buildDSN returns the DSN and a func that drops its TLS registration.
+func (ma *MysqlAdapter) buildDSN(conn ConnectionParams) (string, func(), error) {
 	if conn.DBUri != "" {
-		return conn.DBUri, nil
+		return conn.DBUri, func() {}, nil
 	}
 
 	if conn.Host == "" || conn.User == "" || conn.DBName == "" {
-		return "", apperrors.New(apperrors.TypeConfig, "missing required MySQL connection fields", "Check --host, --user, and --db flags.")
+		return "", nil, apperrors.New(apperrors.TypeConfig, "missing required MySQL connection fields", "Check --host, --user, and --db flags.")
 	}
 
 	if conn.Port == 0 {
@@ -94,58 +283,149 @@ func (ma *MysqlAdapter) BuildConnection(ctx context.Context, conn ConnectionPara
 
 	dsn := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s", conn.User, conn.Password, conn.Host, conn.Port, conn.DBName)
 
-	if conn.TLS.Enabled {
-		tlsName, err := ma.ensureTLSConfig(conn.TLS)
-		if err != nil {
-			return "", err
-		}
-		dsn += "?tls=" + tlsName
+	mode, err := conn.TLS.EffectiveMode()
+	if err != nil {
+		return "", nil, err
 	}
-
-	return dsn, nil
+	if mode == TLSDisable {
+		return dsn, func() {}, nil
+	}
+	tlsName, release, err := acquireTLSConfig(conn.TLS, mode, conn.Host)
+	if err != nil {
+		return "", nil, err
+	}
+	return dsn + "?tls=" + tlsName, release, nil
 }
 
-func (ma *MysqlAdapter) ensureTLSConfig(cfg TLSConfig) (string, error) {
-	if cfg.CACert == "" && cfg.ClientCert == "" && (cfg.Mode == "" || cfg.Mode == "disable" || cfg.Mode == "require") {
-		return "true", nil // Default to basic TLS
+// tlsRegistrations counts the users of each TLS config registered with the
+// driver, whose registry is global to the process.
+var tlsRegistrations = struct {
+	sync.Mutex
+	refs map[string]int
+}{refs: map[string]int{}}
+
+// acquireTLSConfig registers the driver TLS config for a resolved mode and
+// returns its name for the DSN. The name is a hash of the mode, host and
+// certificate bytes, so concurrent backups with different settings never
+// share an entry. release deregisters it after its last user.
+func acquireTLSConfig(cfg TLSConfig, mode, host string) (string, func(), error) {
+	tlsConfig, name, err := mysqlTLSConfig(cfg, mode, host)
+	if err != nil {
+		return "", nil, err
+	}
+
+	tlsRegistrations.Lock()
+	defer tlsRegistrations.Unlock()
+	if tlsRegistrations.refs[name] == 0 {
+		if err := mysql.RegisterTLSConfig(name, tlsConfig); err != nil {
+			return "", nil, apperrors.Wrap(err, apperrors.TypeConfig, "failed to register TLS config", "Check the --tls-* flags.")
+		}
+	}
+	tlsRegistrations.refs[name]++
+
+	var once sync.Once
+	release := func() {
+		once.Do(func() {
+			tlsRegistrations.Lock()
+			defer tlsRegistrations.Unlock()
+			if tlsRegistrations.refs[name]--; tlsRegistrations.refs[name] == 0 {
+				delete(tlsRegistrations.refs, name)
+				mysql.DeregisterTLSConfig(name)
+			}
+		})
 	}
+	return name, release, nil
+}
 
+// mysqlTLSConfig builds the crypto/tls config for a resolved TLS mode. The
+// driver's own tls=true verifies the host name too, so each mode gets an
+// explicit config: require trusts any certificate, verify-ca checks only the
+// chain and verify-full checks the chain and that it names host.
+//
+// The returned name identifies the config by content: a hash of the mode,
+// host and the bytes of every certificate and key it was built from.
+func mysqlTLSConfig(cfg TLSConfig, mode, host string) (*tls.Config, string, error) {
 	tlsConfig := &tls.Config{
 		MinVersion: tls.VersionTLS12,
 	}
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00", mode, host)
 
 	if cfg.CACert != "" {
 		rootCertPool := x509.NewCertPool()
 		pem, err := os.ReadFile(cfg.CACert)
 		if err != nil {
-			return "", apperrors.Wrap(err, apperrors.TypeResource, "failed to read CA cert", "Check the path and permissions for your CA certificate.")
+			return nil, "", apperrors.Wrap(err, apperrors.TypeResource, "failed to read CA cert", "Check the path and permissions for your CA certificate.")
 		}
 		if ok := rootCertPool.AppendCertsFromPEM(pem); !ok {
-			return "", apperrors.New(apperrors.TypeSecurity, "failed to append CA cert", "Provide a valid PEM-encoded CA certificate.")
+			return nil, "", apperrors.New(apperrors.TypeSecurity, "failed to append CA cert", "Provide a valid PEM-encoded CA certificate.")
 		}
 		tlsConfig.RootCAs = rootCertPool
+		h.Write(pem)
 	}
+	h.Write([]byte{0})
 
 	if cfg.ClientCert != "" && cfg.ClientKey != "" {
-		clientCert := make([]tls.Certificate, 0, 1)
-		certs, err := tls.LoadX509KeyPair(cfg.ClientCert, cfg.ClientKey)
+		certPEM, err := os.ReadFile(cfg.ClientCert)
+		if err != nil {
+			return nil, "", apperrors.Wrap(err, apperrors.TypeAuth, "failed to load client cert/key", "Verify the certification paths and ensure they match.")
+		}
+		keyPEM, err := os.ReadFile(cfg.ClientKey)
+		if err != nil {
+			return nil, "", apperrors.Wrap(err, apperrors.TypeAuth, "failed to load client cert/key", "Verify the certification paths and ensure they match.")
+		}
+		certs, err := tls.X509KeyPair(certPEM, keyPEM)
 		if err != nil {
-			return "", apperrors.Wrap(err, apperrors.TypeAuth, "failed to load client cert/key", "Verify the certification paths and ensure they match.")
+			return nil, "", apperrors.Wrap(err, apperrors.TypeAuth, "failed to load client cert/key", "Verify the certification paths and ensure they match.")
 		}
-		clientCert = append(clientCert, certs)
-		tlsConfig.Certificates = clientCert
+		tlsConfig.Certificates = []tls.Certificate{certs}
+		h.Write(certPEM)
+		h.Write([]byte{0})
+		h.Write(keyPEM)
 	}
 
-	switch cfg.Mode {
-	case "skip-verify":
-		tlsConfig.InsecureSkipVerify = true
-	case "verify-ca", "verify-full":
-		tlsConfig.InsecureSkipVerify = false
+	switch mode {
+	case TLSRequire:
+		tlsConfig.InsecureSkipVerify = true // #nosec G402 -- require means encryption without verification
+	case TLSVerifyCA:
+		// Go can't verify the chain without the name, so verify it by hand
+		tlsConfig.InsecureSkipVerify = true // #nosec G402
+		roots := tlsConfig.RootCAs
+		tlsConfig.VerifyConnection = func(cs tls.ConnectionState) error {
+			if len(cs.PeerCertificates) == 0 {
+				return fmt.Errorf("server sent no certificate")
+			}
+			opts := x509.VerifyOptions{Roots: roots, Intermediates: x509.NewCertPool()}
+			for _, c := range cs.PeerCertificates[1:] {
+				opts.Intermediates.AddCert(c)
+			}
+			_, err := cs.PeerCertificates[0].Verify(opts)
+			return err
+		}
+	case TLSVerifyFull:
+		tlsConfig.ServerName = host
 	}
+	return tlsConfig, "dbackup_" + hex.EncodeToString(h.Sum(nil)[:8]), nil
+}
 
-	configName := fmt.Sprintf("custom_%t_%t", cfg.CACert != "", cfg.ClientCert != "")
-	mysql.RegisterTLSConfig(configName, tlsConfig) // #nosec G104
-	return configName, nil
+// mysqlTLSArgs returns the mysql/mysqldump client flags for conn's TLS mode.
+func mysqlTLSArgs(cfg TLSConfig) ([]string, error) {
+	mode, err := cfg.EffectiveMode()
+	if err != nil {
+		return nil, err
+	}
+	if mode == TLSDisable {
+		return []string{"--ssl=OFF"}, nil
+	}
+	sslMode := map[string]string{TLSRequire: "REQUIRED", TLSVerifyCA: "VERIFY_CA", TLSVerifyFull: "VERIFY_IDENTITY"}[mode]
+	args := []string{"--ssl-mode=" + sslMode}
+	if cfg.CACert != "" {
+		args = append(args, "--ssl-ca="+cfg.CACert)
+	}
+	if cfg.ClientCert != "" {
+		args = append(args, "--ssl-cert="+cfg.ClientCert, "--ssl-key="+cfg.ClientKey)
+	}
+	return args, nil
 }
 
 func (ma *MysqlAdapter) RunBackup(ctx context.Context, conn ConnectionParams, runner Runner, w io.Writer) error {
@@ -188,14 +468,13 @@ func (ma *MysqlAdapter) runLogicalFull(ctx context.Context, conn ConnectionParam
 		"--no-tablespaces",
 	}
 
-	if conn.TLS.Enabled {
-		if conn.TLS.CACert != "" {
-			args = append(args, fmt.Sprintf("--ssl-ca=%s", conn.TLS.CACert))
-		}
-	} else {
-		args = append(args, "--ssl=OFF")
+	tlsArgs, err := mysqlTLSArgs(conn.TLS)
+	if err != nil {
+		return err
 	}
+	args = append(args, tlsArgs...)
 
+	args = append(args, conn.ExtraArgs...)
 	args = append(args, conn.DBName)
 
 	if err := runner.Run(ctx, "mysqldump", args, w); err != nil {
@@ -222,6 +501,7 @@ func (ma *MysqlAdapter) runPhysicalFull(ctx context.Context, conn ConnectionPara
 		fmt.Sprintf("--user=%s", conn.User),
 		fmt.Sprintf("--password=%s", conn.Password),
 	}
+	args = append(args, conn.ExtraArgs...)
 
 	// XtraBackup streams the entire database instance to stdout in xbstream format.
 	if err := runner.Run(ctx, "xtrabackup", args, w); err != nil {
@@ -248,8 +528,30 @@ func (ma *MysqlAdapter) RunRestore(ctx context.Context, conn ConnectionParams, r
 		mode = "physical"
 	}
 
+	if conn.IntoNewDB != "" && conn.IsPhysical {
+		return apperrors.New(apperrors.TypeConfig, "--into-new-db needs a logical backup", "A physical backup restores the whole server; drop --into-new-db.")
+	}
+	conn = conn.retarget()
+
 	switch mode {
 	case "logical":
+		if !isDryRun(runner) {
+			// Recreate creates the database itself
+			if conn.IntoNewDB != "" && conn.OnConflict != ConflictRecreate {
+				if err := ma.createDatabase(ctx, conn); err != nil {
+					return err
+				}
+			}
+			if err := ma.prepareRestore(ctx, conn); err != nil {
+				return err
+			}
+		}
+		if conn.IntoNewDB != "" {
+			rc := stripDatabaseSwitches(r, mysqlDatabaseSwitches)
+			defer rc.Close()
+			r = rc
+		}
+
 		args := []string{
 			fmt.Sprintf("--host=%s", conn.Host),
 			fmt.Sprintf("--port=%d", conn.Port),
@@ -257,14 +559,13 @@ func (ma *MysqlAdapter) RunRestore(ctx context.Context, conn ConnectionParams, r
 			fmt.Sprintf("--password=%s", conn.Password),
 		}
 
-		if conn.TLS.Enabled {
-			if conn.TLS.CACert != "" {
-				args = append(args, fmt.Sprintf("--ssl-ca=%s", conn.TLS.CACert))
-			}
-		} else {
-			args = append(args, "--ssl=OFF")
+		tlsArgs, err := mysqlTLSArgs(conn.TLS)
+		if err != nil {
+			return err
 		}
+		args = append(args, tlsArgs...)
 
+		args = append(args, conn.ExtraArgs...)
 		args = append(args, conn.DBName)
 
 		if err := runner.RunWithIO(ctx, "mysql", args, r, nil); err != nil {
@@ -273,7 +574,7 @@ func (ma *MysqlAdapter) RunRestore(ctx context.Context, conn ConnectionParams, r
 			}
 			return apperrors.Wrap(err, apperrors.TypeInternal, "mysql restore failed", "Check restore logs or input file.")
 		}
-		return nil
+		return postRestoreCheck(ctx, conn, runner, ma.logger, func() (*sql.DB, error) { return ma.open(ctx, conn) })
 
 	case "physical":
 		return ma.runPhysicalRestore(ctx, conn, runner, r)
@@ -283,18 +584,32 @@ func (ma *MysqlAdapter) RunRestore(ctx context.Context, conn ConnectionParams, r
 	}
 }
 
+// runPhysicalRestore unpacks an xbstream backup into a fresh directory under
+// conn.StagingDir and prepares it. With conn.DataDir it then copies the
+// prepared files into that data directory and removes the staging copy;
+// without, the prepared backup is left for the operator to copy back.
 func (ma *MysqlAdapter) runPhysicalRestore(ctx context.Context, conn ConnectionParams, runner Runner, r io.Reader) error {
-	stagingDir := "./restore_staging"
+	parent := conn.StagingDir
+	if parent == "" {
+		parent = os.TempDir()
+	}
+	stagingDir := filepath.Join(parent, fmt.Sprintf("dbackup-xtrabackup-%d", time.Now().UnixNano()))
+	keep := false
+	defer func() {
+		if keep {
+			return
+		}
+		if rmErr := runner.Run(context.Background(), "rm", []string{"-rf", stagingDir}, io.Discard); rmErr != nil && ma.logger != nil {
+			ma.logger.Warn("Failed to remove staging directory", "staging_dir", stagingDir, "error", rmErr)
+		}
+	}()
+
+	// 1. Extract xbstream
 	if ma.logger != nil {
 		ma.logger.Info("Executing physical restore phase 1: Extraction", "staging_dir", stagingDir)
 	}
-
-	// 1. Extract xbstream
-	// Ensure staging dir exists
-	if _, ok := runner.(*LocalRunner); ok {
-		if err := os.MkdirAll(stagingDir, 0755); err != nil {
-			return apperrors.Wrap(err, apperrors.TypeResource, "failed to create staging directory", "Check permissions for ./restore_staging")
-		}
+	if err := runner.Run(ctx, "mkdir", []string{"-p", stagingDir}, io.Discard); err != nil {
+		return apperrors.Wrap(err, apperrors.TypeResource, "failed to create staging directory", "Check permissions for "+parent+", or pick another directory with --tmp-dir.")
 	}
 
 	extractArgs := []string{"-x", "-C", stagingDir}
@@ -314,13 +629,21 @@ func (ma *MysqlAdapter) runPhysicalRestore(ctx context.Context, conn ConnectionP
 		return apperrors.Wrap(err, apperrors.TypeInternal, "xtrabackup --prepare failed", "The backup data might be inconsistent or corrupted.")
 	}
 
+	if conn.DataDir == "" {
+		keep = true
+		if ma.logger != nil {
+			ma.logger.Info("Backup prepared. Stop MySQL, empty its data directory and run xtrabackup --copy-back, or restore again with --datadir.", "staging_dir", stagingDir)
+		}
+		return nil
+	}
+
 	// 3. Copy-back to data directory
 	if ma.logger != nil {
-		ma.logger.Info("Executing physical restore phase 3: Copy-back", "staging_dir", stagingDir)
+		ma.logger.Info("Executing physical restore phase 3: Copy-back", "staging_dir", stagingDir, "datadir", conn.DataDir)
 		ma.logger.Warn("Copy-back will attempt to restore files to the MySQL data directory. This usually requires the MySQL service to be STOPPED and the data directory to be EMPTY.")
 	}
 
-	copyBackArgs := []string{"--copy-back", fmt.Sprintf("--target-dir=%s", stagingDir)}
+	copyBackArgs := []string{"--copy-back", fmt.Sprintf("--target-dir=%s", stagingDir), fmt.Sprintf("--datadir=%s", conn.DataDir)}
 	if err := runner.Run(ctx, "xtrabackup", copyBackArgs, io.Discard); err != nil {
 		return apperrors.Wrap(err, apperrors.TypeInternal, "xtrabackup --copy-back failed", "Ensure the MySQL data directory is empty and you have write permissions.")
 	}
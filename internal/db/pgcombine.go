@@ -0,0 +1,75 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	apperrors "github.com/lupppig/dbackup/internal/errors"
+)
+
+// minCombineVersion is the first PostgreSQL release with incremental
+// backups and pg_combinebackup.
+const minCombineVersion = 17
+
+// CheckCombineBackup fails unless runner has pg_combinebackup from
+// PostgreSQL 17 or later and pgdata is empty or missing, so a chain restore
+// stops before downloading anything.
+func CheckCombineBackup(ctx context.Context, runner Runner, pgdata string) error {
+	if err := checkEmptyDataDir(pgdata); err != nil {
+		return err
+	}
+	var out bytes.Buffer
+	if err := runner.Run(ctx, "pg_combinebackup", []string{"--version"}, &out); err != nil {
+		return apperrors.Wrap(err, apperrors.TypeDependency, "pg_combinebackup not found", "Install the PostgreSQL 17 (or later) server tools, which provide pg_combinebackup.")
+	}
+	major, err := parseMajorVersion(out.String())
+	if err != nil {
+		return apperrors.Wrap(err, apperrors.TypeDependency, "cannot tell the pg_combinebackup version", "Check that pg_combinebackup --version runs.")
+	}
+	if major < minCombineVersion {
+		return apperrors.New(apperrors.TypeDependency,
+			fmt.Sprintf("pg_combinebackup is from PostgreSQL %d; incremental chains need %d or later", major, minCombineVersion),
+			"Put the PostgreSQL 17 (or later) tools first in PATH.")
+	}
+	return nil
+}
+
+// parseMajorVersion reads the major version from --version output such as
+// "pg_combinebackup (PostgreSQL) 17.2" or "... 18beta1".
+func parseMajorVersion(out string) (int, error) {
+	fields := strings.Fields(out)
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("empty version output")
+	}
+	v := fields[len(fields)-1]
+	end := strings.IndexFunc(v, func(r rune) bool { return r < '0' || r > '9' })
+	if end >= 0 {
+		v = v[:end]
+	}
+	major, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("unexpected version output %q", strings.TrimSpace(out))
+	}
+	return major, nil
+}
+
+// CombineBackup runs pg_combinebackup over the unpacked backups in dirs,
+// full backup first and each incremental after the one it was taken
+// against, writing one consistent data directory to pgdata. pgdata must be
+// empty or missing.
+func CombineBackup(ctx context.Context, runner Runner, dirs []string, pgdata string) error {
+	if len(dirs) == 0 {
+		return apperrors.New(apperrors.TypeInternal, "no backups to combine", "Report this as a bug.")
+	}
+	if err := checkEmptyDataDir(pgdata); err != nil {
+		return err
+	}
+	args := append([]string{"--output", pgdata}, dirs...)
+	if err := runner.Run(ctx, "pg_combinebackup", args, nil); err != nil {
+		return apperrors.Wrap(err, apperrors.TypeInternal, "pg_combinebackup failed", "Check its output above; the chain may be out of order or from different clusters.")
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package db
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+)
+
+// WALRange is the span of WAL a pg_basebackup needs to become consistent,
+// as recorded in the backup_manifest it writes into the archive.
+type WALRange struct {
+	Timeline int
+	StartLSN string
+	EndLSN   string
+}
+
+// ScanWALRange reads a pg_basebackup tar stream and returns the WAL range
+// from its backup_manifest member. It always consumes r to the end, so it
+// can sit on a tee of the dump without stalling it; ok is false when the
+// stream holds no usable manifest (servers before PostgreSQL 13, or
+// --no-manifest in the extra arguments).
+func ScanWALRange(r io.Reader) (rng WALRange, ok bool) {
+	defer io.Copy(io.Discard, r) // #nosec G104
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			return rng, ok
+		}
+		if path.Base(hdr.Name) != "backup_manifest" {
+			continue
+		}
+		if found, err := parseWALRanges(tr); err == nil {
+			rng, ok = found, true
+		}
+	}
+}
+
+// parseWALRanges merges the WAL-Ranges of a backup_manifest into one range:
+// the earliest start, the latest end and the timeline that end is on.
+func parseWALRanges(r io.Reader) (WALRange, error) {
+	var bm struct {
+		WALRanges []struct {
+			Timeline int    `json:"Timeline"`
+			StartLSN string `json:"Start-LSN"`
+			EndLSN   string `json:"End-LSN"`
+		} `json:"WAL-Ranges"`
+	}
+	if err := json.NewDecoder(r).Decode(&bm); err != nil {
+		return WALRange{}, err
+	}
+	if len(bm.WALRanges) == 0 {
+		return WALRange{}, fmt.Errorf("backup_manifest lists no WAL ranges")
+	}
+
+	var rng WALRange
+	var start, end uint64
+	for i, wr := range bm.WALRanges {
+		s, err := ParseLSN(wr.StartLSN)
+		if err != nil {
+			return WALRange{}, err
+		}
+		e, err := ParseLSN(wr.EndLSN)
+		if err != nil {
+			return WALRange{}, err
+		}
+		if i == 0 || s < start {
+			start, rng.StartLSN = s, wr.StartLSN
+		}
+		if i == 0 || e >= end {
+			end, rng.EndLSN, rng.Timeline = e, wr.EndLSN, wr.Timeline
+		}
+	}
+	return rng, nil
+}
+
+// ParseLSN parses a PostgreSQL log sequence number in its X/Y text form.
+func ParseLSN(s string) (uint64, error) {
+	var hi, lo uint32
+	if n, err := fmt.Sscanf(s, "%X/%X", &hi, &lo); err != nil || n != 2 {
+		return 0, fmt.Errorf("invalid LSN %q", s)
+	}
+	return uint64(hi)<<32 | uint64(lo), nil
+}
@@ -1,15 +1,19 @@
 package db
 
 import (
+	"archive/tar"
 	"context"
 	"database/sql"
 	"fmt"
 	"io"
+	"math"
 	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
-	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 	apperrors "github.com/lupppig/dbackup/internal/errors"
 	"github.com/lupppig/dbackup/internal/logger"
 )
@@ -36,6 +40,12 @@ func (pa *PostgresAdapter) Name() string {
 	return "postgres"
 }
 
+// Capabilities: pg_basebackup takes physical backups over a replication
+// connection, so even those work from another host.
+func (pa *PostgresAdapter) Capabilities() Capabilities {
+	return Capabilities{SupportsPhysical: true, SupportsStreaming: true, ExcludeFiles: true}
+}
+
 func (pa *PostgresAdapter) TestConnection(ctx context.Context, conn ConnectionParams, runner Runner) error {
 	if pa.logger != nil {
 		pa.logger.Info("Testing database connection...", "host", conn.Host, "db", conn.DBName)
@@ -61,18 +71,174 @@ func (pa *PostgresAdapter) TestConnection(ctx context.Context, conn ConnectionPa
 		return nil
 	}
 
-	db, err := sql.Open("postgres", dsn)
+	db, err := pa.open(ctx, conn)
 	if err != nil {
-		return apperrors.Wrap(err, apperrors.TypeConfig, "failed to open database connection", "Check your connection string and driver availability.")
+		return err
 	}
+	return db.Close()
+}
 
+func (pa *PostgresAdapter) ListDatabases(ctx context.Context, conn ConnectionParams) ([]string, error) {
+	if conn.DBName == "" {
+		conn = conn.WithDatabase("postgres") // Any database works for reading the catalog
+	}
+	db, err := pa.open(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
 	defer db.Close()
 
-	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
-	defer cancel()
+	// template0 refuses connections, so it could never be dumped anyway
+	rows, err := db.QueryContext(ctx, "SELECT datname FROM pg_database WHERE datallowconn ORDER BY datname")
+	if err != nil {
+		return nil, apperrors.Wrap(err, apperrors.TypeConnection, "failed to list databases", "Verify the user can read pg_database.")
+	}
+	return scanNames(rows)
+}
+
+// ChangeSignal sums the rows inserted, updated and deleted in the database.
+// DDL counts too, as it writes to the catalogs. A stats reset changes the
+// signal, which only costs one extra backup.
+func (pa *PostgresAdapter) ChangeSignal(ctx context.Context, conn ConnectionParams) (string, error) {
+	db, err := pa.open(ctx, conn)
+	if err != nil {
+		return "", err
+	}
+	defer db.Close()
+
+	var writes int64
+	var reset string
+	err = db.QueryRowContext(ctx, `SELECT tup_inserted + tup_updated + tup_deleted, COALESCE(stats_reset::text, '')
+		FROM pg_stat_database WHERE datname = current_database()`).Scan(&writes, &reset)
+	if err != nil {
+		return "", apperrors.Wrap(err, apperrors.TypeConnection, "failed to read pg_stat_database", "Verify the database is reachable.")
+	}
+	return fmt.Sprintf("pg:%d:%s", writes, reset), nil
+}
+
+func (pa *PostgresAdapter) open(ctx context.Context, conn ConnectionParams) (*sql.DB, error) {
+	dsn, err := pa.BuildConnection(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("postgres", withConnectTimeout(dsn))
+	if err != nil {
+		return nil, apperrors.Wrap(err, apperrors.TypeConfig, "failed to open database connection", "Check your connection string and driver availability.")
+	}
+	if err := connect(ctx, db); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// withConnectTimeout adds connectTimeout to a DSN that sets none. lib/pq
+// ignores the context once the socket is open, so without it a server that
+// accepts but never answers the handshake would hang the connection.
+func withConnectTimeout(dsn string) string {
+	if strings.Contains(dsn, "connect_timeout") {
+		return dsn
+	}
+	seconds := strconv.Itoa(max(1, int(math.Ceil(connectTimeout.Seconds()))))
+	if u, err := url.Parse(dsn); err == nil && (u.Scheme == "postgres" || u.Scheme == "postgresql") {
+		q := u.Query()
+		q.Set("connect_timeout", seconds)
+		u.RawQuery = q.Encode()
+		return u.String()
+	}
+	return dsn + " connect_timeout=" + seconds
+}
+
+// pgAdminDatabase returns a database to connect to while creating or
+// dropping name.
+func pgAdminDatabase(name string) string {
+	if name == "postgres" {
+		return "template1"
+	}
+	return "postgres"
+}
+
+// createDatabase creates conn.DBName unless it exists already.
+func (pa *PostgresAdapter) createDatabase(ctx context.Context, conn ConnectionParams) error {
+	db, err := pa.open(ctx, conn.WithDatabase(pgAdminDatabase(conn.DBName)))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
 
-	if err := db.PingContext(ctx); err != nil {
-		return apperrors.Wrap(err, apperrors.TypeConnection, "failed to ping database", "Verify the database host, port, and credentials.")
+	var exists bool
+	if err := db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM pg_database WHERE datname = $1)", conn.DBName).Scan(&exists); err != nil {
+		return apperrors.Wrap(err, apperrors.TypeConnection, "failed to look up database "+conn.DBName, "Verify the user can read pg_database.")
+	}
+	if exists {
+		return nil
+	}
+	if pa.logger != nil {
+		pa.logger.Info("Creating restore target", "database", conn.DBName)
+	}
+	if _, err := db.ExecContext(ctx, "CREATE DATABASE "+pq.QuoteIdentifier(conn.DBName)); err != nil {
+		return apperrors.Wrap(err, apperrors.TypeConnection, "failed to create database "+conn.DBName, "Check the user may create databases, or create it first.")
+	}
+	return nil
+}
+
+// prepareRestore applies conn.OnConflict to the target database.
+func (pa *PostgresAdapter) prepareRestore(ctx context.Context, conn ConnectionParams) error {
+	if conn.OnConflict == "" {
+		return nil
+	}
+	if pa.logger != nil {
+		pa.logger.Info("Preparing restore target", "database", conn.DBName, "on_conflict", conn.OnConflict)
+	}
+
+	if conn.OnConflict == ConflictRecreate {
+		// A database can't be dropped from a session connected to it
+		db, err := pa.open(ctx, conn.WithDatabase(pgAdminDatabase(conn.DBName)))
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		name := pq.QuoteIdentifier(conn.DBName)
+		for _, stmt := range []string{"DROP DATABASE IF EXISTS " + name, "CREATE DATABASE " + name} {
+			if _, err := db.ExecContext(ctx, stmt); err != nil {
+				return apperrors.Wrap(err, apperrors.TypeConnection, "failed to recreate database "+conn.DBName, "Close other sessions on the database and check the user may create databases.")
+			}
+		}
+		return nil
+	}
+
+	db, err := pa.open(ctx, conn)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	switch conn.OnConflict {
+	case ConflictFail:
+		var tables int
+		if err := db.QueryRowContext(ctx, "SELECT count(*) FROM information_schema.tables WHERE table_schema NOT IN ('pg_catalog', 'information_schema')").Scan(&tables); err != nil {
+			return apperrors.Wrap(err, apperrors.TypeConnection, "failed to inspect target database", "Verify the database host, port, and credentials.")
+		}
+		if tables > 0 {
+			return errNotEmpty(conn.DBName, tables)
+		}
+	case ConflictClean:
+		rows, err := db.QueryContext(ctx, "SELECT nspname FROM pg_namespace WHERE nspname NOT LIKE 'pg\\_%' AND nspname <> 'information_schema'")
+		if err != nil {
+			return apperrors.Wrap(err, apperrors.TypeConnection, "failed to list schemas", "Verify the user can read pg_namespace.")
+		}
+		schemas, err := scanNames(rows)
+		if err != nil {
+			return err
+		}
+		for _, s := range schemas {
+			if _, err := db.ExecContext(ctx, "DROP SCHEMA IF EXISTS "+pq.QuoteIdentifier(s)+" CASCADE"); err != nil {
+				return apperrors.Wrap(err, apperrors.TypeConnection, "failed to drop schema "+s, "Check the user owns the objects in the target database.")
+			}
+		}
+		if _, err := db.ExecContext(ctx, "CREATE SCHEMA IF NOT EXISTS public"); err != nil {
+			return apperrors.Wrap(err, apperrors.TypeConnection, "failed to recreate schema public", "Check the user may create schemas.")
+		}
 	}
 	return nil
 }
@@ -83,7 +249,7 @@ func (pa *PostgresAdapter) BuildConnection(ctx context.Context, conn ConnectionP
 	}
 
 	if conn.Host == "" || conn.User == "" || conn.DBName == "" {
-		return "", fmt.Errorf("missing required Postgres connection fields")
+		return "", apperrors.New(apperrors.TypeConfig, "missing required Postgres connection fields", "Check --host, --user, and --db flags, or pass a full --db-uri.")
 	}
 
 	if conn.Port == 0 {
@@ -99,23 +265,19 @@ func (pa *PostgresAdapter) BuildConnection(ctx context.Context, conn ConnectionP
 
 	q := u.Query()
 
-	if conn.TLS.Enabled {
-		if conn.TLS.Mode == "" {
-			conn.TLS.Mode = "require"
-		}
-		q.Set("sslmode", conn.TLS.Mode)
-
+	mode, err := conn.TLS.EffectiveMode()
+	if err != nil {
+		return "", err
+	}
+	q.Set("sslmode", mode)
+	if mode != TLSDisable {
 		if conn.TLS.CACert != "" {
 			q.Set("sslrootcert", conn.TLS.CACert)
 		}
-		if conn.TLS.ClientCert != "" && conn.TLS.ClientKey != "" {
+		if conn.TLS.ClientCert != "" {
 			q.Set("sslcert", conn.TLS.ClientCert)
 			q.Set("sslkey", conn.TLS.ClientKey)
-		} else if conn.TLS.ClientCert != "" || conn.TLS.ClientKey != "" {
-			return "", fmt.Errorf("both TLS ClientCert and ClientKey must be provided for mTLS")
 		}
-	} else {
-		q.Set("sslmode", "disable")
 	}
 
 	u.RawQuery = q.Encode()
@@ -126,6 +288,9 @@ func (pa *PostgresAdapter) RunBackup(ctx context.Context, conn ConnectionParams,
 	if conn.IsPhysical {
 		return pa.runPhysicalBackup(ctx, conn, runner, w)
 	}
+	if len(conn.Exclude) > 0 {
+		return apperrors.New(apperrors.TypeConfig, "--exclude needs a physical backup", "pg_dump output is not a file archive; add --mysql-physical for a pg_basebackup, or drop --exclude.")
+	}
 	// Standard full logical backup
 	return pa.runLogicalBackup(ctx, conn, runner, w)
 }
@@ -151,8 +316,12 @@ func (pa *PostgresAdapter) runPhysicalBackup(ctx context.Context, conn Connectio
 		"--wal-method=fetch",
 		"--pgdata=-",
 	}
+	args = append(args, conn.ExtraArgs...)
 
-	if err := runner.Run(ctx, "pg_basebackup", args, w); err != nil {
+	err = withExcludes(conn, pa.logger, w, func(w io.Writer) error {
+		return runner.Run(ctx, "pg_basebackup", args, w)
+	})
+	if err != nil {
 		if strings.Contains(err.Error(), "status 127") || strings.Contains(err.Error(), "executable file not found") {
 			return apperrors.New(apperrors.TypeDependency, "pg_basebackup not found", "Please install postgresql-client to enable physical backups.")
 		}
@@ -178,6 +347,7 @@ func (pa *PostgresAdapter) runLogicalBackup(ctx context.Context, conn Connection
 		"--no-owner",
 		"--no-acl",
 	}
+	args = append(args, conn.ExtraArgs...)
 
 	if err := runner.Run(ctx, "pg_dump", args, w); err != nil {
 		if strings.Contains(err.Error(), "status 127") || strings.Contains(err.Error(), "executable file not found") {
@@ -195,20 +365,175 @@ func (pa *PostgresAdapter) RunRestore(ctx context.Context, conn ConnectionParams
 	}
 
 	if conn.IsPhysical {
-		if pa.logger != nil {
-			pa.logger.Info("Physical restore detected. Postgres physical restores usually require stopping the database and extracting the tarball into the data directory. dbackup currently only automates the streaming extraction part.")
+		if conn.IntoNewDB != "" {
+			return apperrors.New(apperrors.TypeConfig, "--into-new-db needs a logical backup", "A base backup restores the whole cluster; drop --into-new-db.")
 		}
-		// For now, we just pipe it to 'tar' or just log that it's a manual process if we don't have a clear target dir.
-		// However, for consistency with MySQL, let's at least support streaming it to a dir if provided in the future.
-		// For now, we return an error or a note.
-		return fmt.Errorf("automated physical restore for Postgres is not yet fully implemented. Please manually extract the backup tarball to your PGDATA directory")
+		return pa.runPhysicalRestore(runner, r)
 	}
 
+	conn = conn.retarget()
 	connStr, err := pa.BuildConnection(ctx, conn)
 	if err != nil {
 		return err
 	}
 
-	args := []string{"--dbname", connStr}
-	return runner.RunWithIO(ctx, "psql", args, r, nil)
+	if !isDryRun(runner) {
+		// Recreate creates the database itself
+		if conn.IntoNewDB != "" && conn.OnConflict != ConflictRecreate {
+			if err := pa.createDatabase(ctx, conn); err != nil {
+				return err
+			}
+		}
+		if err := pa.prepareRestore(ctx, conn); err != nil {
+			return err
+		}
+	}
+	if conn.IntoNewDB != "" {
+		rc := stripDatabaseSwitches(r, pgDatabaseSwitches)
+		defer rc.Close()
+		r = rc
+	}
+
+	args := append([]string{"--dbname", connStr}, conn.ExtraArgs...)
+	if err := runner.RunWithIO(ctx, "psql", args, r, nil); err != nil {
+		if strings.Contains(err.Error(), "status 127") || strings.Contains(err.Error(), "executable file not found") {
+			return apperrors.New(apperrors.TypeDependency, "psql client not found", "Please install postgresql-client to enable restores.")
+		}
+		return apperrors.Wrap(err, apperrors.TypeInternal, "psql restore failed", "Check restore logs or input file.")
+	}
+	return postRestoreCheck(ctx, conn, runner, pa.logger, func() (*sql.DB, error) { return pa.open(ctx, conn) })
+}
+
+// runPhysicalRestore unpacks a pg_basebackup tar stream into $PGDATA. The
+// server must be stopped and the directory empty or missing.
+func (pa *PostgresAdapter) runPhysicalRestore(runner Runner, r io.Reader) error {
+	pgdata := os.Getenv("PGDATA")
+	if pgdata == "" {
+		return apperrors.New(apperrors.TypeConfig, "physical restore needs a data directory", "Set PGDATA to the (stopped, empty) data directory to restore into.")
+	}
+	if isDryRun(runner) {
+		if pa.logger != nil {
+			pa.logger.Info("[DRY-RUN] Would extract base backup", "pgdata", pgdata)
+		}
+		return nil
+	}
+	if _, ok := runner.(*LocalRunner); !ok {
+		return apperrors.New(apperrors.TypeConfig, "physical restore is only supported on the local host", "Run dbackup on the database server, without --remote-exec.")
+	}
+
+	if err := checkEmptyDataDir(pgdata); err != nil {
+		return err
+	}
+
+	if pa.logger != nil {
+		pa.logger.Info("Extracting base backup", "pgdata", pgdata)
+	}
+	if err := ExtractBaseBackup(r, pgdata); err != nil {
+		return err
+	}
+	if pa.logger != nil {
+		pa.logger.Info("Physical restore complete. Check ownership (chown -R postgres:postgres) and start the server; it replays the included WAL on startup.", "pgdata", pgdata)
+	}
+	return nil
+}
+
+// checkEmptyDataDir fails unless pgdata is empty or missing, so a restore
+// never mixes its files with a live or old cluster's.
+func checkEmptyDataDir(pgdata string) error {
+	entries, err := os.ReadDir(pgdata)
+	if err != nil && !os.IsNotExist(err) {
+		return apperrors.Wrap(err, apperrors.TypeResource, "cannot read "+pgdata, "Check the permissions of the data directory.")
+	}
+	if len(entries) > 0 {
+		return apperrors.New(apperrors.TypeConfig, "data directory "+pgdata+" is not empty", "Stop the server and move the old data directory aside before restoring.")
+	}
+	return nil
+}
+
+// ExtractBaseBackup writes a pg_basebackup --format=tar stream into pgdata.
+// The stream is either the data directory itself, as pg_basebackup writes to
+// stdout, or a tar of pg_basebackup's output files: base.tar is unpacked
+// into pgdata and pg_wal.tar into pgdata/pg_wal. Symlinks are created last
+// so no file is written through one.
+func ExtractBaseBackup(r io.Reader, pgdata string) error {
+	if err := os.MkdirAll(pgdata, 0700); err != nil {
+		return apperrors.Wrap(err, apperrors.TypeResource, "cannot create "+pgdata, "Check the permissions of the parent directory.")
+	}
+	var links []*tar.Header
+	if err := extractTar(tar.NewReader(r), pgdata, true, &links); err != nil {
+		return err
+	}
+	for _, h := range links {
+		if err := os.Symlink(h.Linkname, h.Name); err != nil {
+			return apperrors.Wrap(err, apperrors.TypeResource, "failed to create symlink "+h.Name, "Check the permissions of the data directory.")
+		}
+	}
+	return nil
+}
+
+// extractTar unpacks tr into dir. At the top level, base.tar and pg_wal.tar
+// members are unpacked rather than written out. Symlink headers are
+// collected in links with Name resolved against dir.
+func extractTar(tr *tar.Reader, dir string, top bool, links *[]*tar.Header) error {
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return apperrors.Wrap(err, apperrors.TypeIntegrity, "invalid base backup archive", "The backup is not a pg_basebackup tar stream, or it is corrupted.")
+		}
+
+		name := filepath.Clean(h.Name)
+		if !filepath.IsLocal(name) {
+			return apperrors.New(apperrors.TypeSecurity, "base backup entry escapes the data directory: "+h.Name, "The archive was not produced by pg_basebackup; do not restore it.")
+		}
+
+		if top && h.Typeflag == tar.TypeReg {
+			switch name {
+			case "base.tar":
+				if err := extractTar(tar.NewReader(tr), dir, false, links); err != nil {
+					return err
+				}
+				continue
+			case "pg_wal.tar":
+				if err := extractTar(tar.NewReader(tr), filepath.Join(dir, "pg_wal"), false, links); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+
+		target := filepath.Join(dir, name)
+		switch h.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(target, 0700)
+		case tar.TypeReg:
+			err = writeTarFile(tr, target, h.FileInfo().Mode().Perm())
+		case tar.TypeSymlink:
+			link := *h
+			link.Name = target
+			*links = append(*links, &link)
+		default:
+			// pg_basebackup writes nothing else; skip rather than guess
+		}
+		if err != nil {
+			return apperrors.Wrap(err, apperrors.TypeResource, "failed to write "+target, "Check the free space and permissions of the data directory.")
+		}
+	}
+}
+
+func writeTarFile(r io.Reader, path string, perm os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_EXCL, perm) // #nosec G304
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil { // #nosec G110
+		f.Close()
+		return err
+	}
+	return f.Close()
 }
@@ -3,15 +3,16 @@ package db
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	apperrors "github.com/lupppig/dbackup/internal/errors"
 	"github.com/lupppig/dbackup/internal/logger"
-	_ "github.com/mattn/go-sqlite3"
 )
 
 func init() {
@@ -26,6 +27,12 @@ func (sq *SqliteAdapter) Name() string {
 	return "sqlite"
 }
 
+// Capabilities: the online backup is staged in a temporary file by VACUUM
+// INTO before it is streamed.
+func (sq *SqliteAdapter) Capabilities() Capabilities {
+	return Capabilities{RequiresLocalDatadir: true}
+}
+
 func (sq *SqliteAdapter) SetLogger(l *logger.Logger) {
 	sq.Logger = l
 }
@@ -34,21 +41,105 @@ func (sq *SqliteAdapter) TestConnection(ctx context.Context, connParams Connecti
 	if sq.Logger != nil {
 		sq.Logger.Info("connecting to sqlite Database...", "path", connParams.DBName)
 	}
-	db, err := sql.Open("sqlite3", connParams.DBName)
+	db, err := sql.Open("sqlite3", withBusyTimeout(connParams.DBName))
 	if err != nil {
 		return apperrors.Wrap(err, apperrors.TypeConfig, "failed to open SQLite DB", "Verify the file path and permissions.")
 	}
 	defer db.Close()
 
-	if err := db.PingContext(ctx); err != nil {
-		return apperrors.Wrap(err, apperrors.TypeResource, "failed to ping SQLite DB", "Ensure the file is a valid SQLite database.")
+	// Bounded so a large database doesn't hold up the backup it precedes
+	checkCtx, cancel := context.WithTimeout(ctx, sqliteCheckTimeout)
+	defer cancel()
+
+	if err := db.PingContext(checkCtx); err != nil {
+		return sq.checkError(err, "failed to ping SQLite DB")
+	}
+
+	// Unlike a ping, quick_check reads the file, so a database locked by a
+	// writer fails here instead of being copied mid-transaction
+	var result string
+	err = db.QueryRowContext(checkCtx, "PRAGMA quick_check").Scan(&result)
+	switch {
+	case errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil:
+		if sq.Logger != nil {
+			sq.Logger.Warn("SQLite quick_check timed out, skipping it", "timeout", sqliteCheckTimeout)
+		}
+	case err != nil:
+		return sq.checkError(err, "failed to read SQLite DB")
+	case result != "ok":
+		return apperrors.New(apperrors.TypeIntegrity, "SQLite quick_check failed: "+result, "Repair the database (e.g. sqlite3 .recover) before backing it up.")
 	}
+
 	if sq.Logger != nil {
 		sq.Logger.Info("Database connection Successful...")
 	}
 	return nil
 }
 
+const (
+	sqliteCheckTimeout = 5 * time.Second
+	sqliteBusyTimeout  = 1000 // ms to wait for a lock before reporting busy
+)
+
+// withBusyTimeout sets the driver's busy timeout on a database path; it is
+// applied when each connection opens, before any statement can run.
+func withBusyTimeout(path string) string {
+	if path == "" {
+		return path
+	}
+	sep := "?"
+	if strings.Contains(path, "?") {
+		sep = "&"
+	}
+	return fmt.Sprintf("%s%s_busy_timeout=%d", path, sep, sqliteBusyTimeout)
+}
+
+// checkError classifies a TestConnection failure. A busy or locked database
+// is a resource error, so --connect-retries waits for the writer.
+func (sq *SqliteAdapter) checkError(err error, msg string) error {
+	if isSQLiteBusy(err) {
+		if sq.Logger != nil {
+			sq.Logger.Warn("SQLite database is locked by another process", "error", err)
+		}
+		return apperrors.Wrap(err, apperrors.TypeResource, "SQLite database is busy or locked", "Back up from this host so the online backup (VACUUM INTO) is used, or retry with --connect-retries once the writer finishes.")
+	}
+	return apperrors.Wrap(err, apperrors.TypeResource, msg, "Ensure the file is a valid SQLite database.")
+}
+
+// ListDatabases is a no-op: a SQLite file is a single database.
+func (sq *SqliteAdapter) ListDatabases(ctx context.Context, conn ConnectionParams) ([]string, error) {
+	return nil, nil
+}
+
+// ChangeSignal combines the database file's size, mtime, change counter and
+// page count with the state of its WAL, where writes land until a
+// checkpoint. Nothing is opened through SQLite, so no lock is taken.
+func (sq *SqliteAdapter) ChangeSignal(ctx context.Context, conn ConnectionParams) (string, error) {
+	path, err := sq.BuildConnection(ctx, conn)
+	if err != nil {
+		return "", err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", apperrors.Wrap(err, apperrors.TypeConfig, "failed to open sqlite database", "Check the database path.")
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	// Bytes 24-31 of the header hold the change counter and the page count
+	header := make([]byte, 32)
+	if _, err := io.ReadFull(f, header); err != nil {
+		return "", apperrors.Wrap(err, apperrors.TypeIntegrity, "failed to read sqlite header", "Verify the file is a SQLite database.")
+	}
+	signal := fmt.Sprintf("sqlite:%d:%d:%x", fi.Size(), fi.ModTime().UnixNano(), header[24:32])
+	if wal, err := os.Stat(path + "-wal"); err == nil {
+		signal += fmt.Sprintf(":%d:%d", wal.Size(), wal.ModTime().UnixNano())
+	}
+	return signal, nil
+}
+
 func (sq *SqliteAdapter) BuildConnection(ctx context.Context, connParams ConnectionParams) (string, error) {
 	path := connParams.DBName
 	if path == "" && connParams.DBUri != "" {
@@ -120,6 +211,10 @@ func (sq *SqliteAdapter) runFullBackup(ctx context.Context, path string, runner
 }
 
 func (sq *SqliteAdapter) RunRestore(ctx context.Context, conn ConnectionParams, runner Runner, r io.Reader) error {
+	// The database is a file, so restoring into a new one is a new path
+	if conn.IntoNewDB != "" {
+		conn.DBName, conn.DBUri = conn.IntoNewDB, ""
+	}
 	path, err := sq.BuildConnection(ctx, conn)
 	if err != nil {
 		return err
@@ -127,7 +222,24 @@ func (sq *SqliteAdapter) RunRestore(ctx context.Context, conn ConnectionParams,
 	if sq.Logger != nil {
 		sq.Logger.Info("restoring sqlite database...", "path", path)
 	}
-	return sq.runFullRestore(ctx, path, r)
+
+	// The restore replaces the file, so clean and recreate need no pre-step.
+	// Without one of them, replacing a database that has data must be asked
+	// for explicitly
+	if !isDryRun(runner) {
+		if fi, err := os.Stat(path); err == nil && fi.Size() > 0 {
+			switch {
+			case conn.OnConflict == ConflictFail:
+				return apperrors.New(apperrors.TypeConfig, "target database "+path+" already exists", "Restore to a new path, or use --on-conflict recreate to replace it.")
+			case conn.OnConflict == "" && !conn.OverwriteExisting:
+				return apperrors.New(apperrors.TypeResource, "target database "+path+" already exists and the restore would overwrite it", "Pass --overwrite-existing to replace it, or restore to a new path.")
+			}
+		}
+	}
+	if err := sq.runFullRestore(ctx, path, r); err != nil {
+		return err
+	}
+	return postRestoreCheck(ctx, conn, runner, sq.Logger, func() (*sql.DB, error) { return sql.Open("sqlite3", path) })
 }
 
 func (sq *SqliteAdapter) runFullRestore(ctx context.Context, path string, r io.Reader) error {
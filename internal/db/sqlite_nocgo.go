@@ -0,0 +1,13 @@
+//go:build !cgo
+
+package db
+
+import "strings"
+
+// isSQLiteBusy reports whether err is SQLITE_BUSY or SQLITE_LOCKED. Without
+// cgo the driver's error codes are not available, so it goes by SQLite's
+// messages for them.
+func isSQLiteBusy(err error) bool {
+	msg := err.Error()
+	return strings.Contains(msg, "database is locked") || strings.Contains(msg, "database table is locked") || strings.Contains(msg, "database is busy")
+}
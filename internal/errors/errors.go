@@ -18,6 +18,47 @@ const (
 	TypeInternal   ErrorType = "Internal"   // Unexpected internal failure
 )
 
+// Process exit codes. Any failure that isn't an AppError exits with
+// ExitFailure; each error type has its own code so automation can decide
+// whether a retry makes sense.
+const (
+	ExitSuccess    = 0
+	ExitFailure    = 1
+	ExitConfig     = 2
+	ExitDependency = 3
+	ExitConnection = 4
+	ExitAuth       = 5
+	ExitSecurity   = 6
+	ExitIntegrity  = 7
+	ExitResource   = 8
+	ExitInternal   = 9
+)
+
+var exitCodes = map[ErrorType]int{
+	TypeConfig:     ExitConfig,
+	TypeDependency: ExitDependency,
+	TypeConnection: ExitConnection,
+	TypeAuth:       ExitAuth,
+	TypeSecurity:   ExitSecurity,
+	TypeIntegrity:  ExitIntegrity,
+	TypeResource:   ExitResource,
+	TypeInternal:   ExitInternal,
+}
+
+// ExitCode maps err to the process exit code of its type.
+func ExitCode(err error) int {
+	if err == nil {
+		return ExitSuccess
+	}
+	var appErr *AppError
+	if errors.As(err, &appErr) {
+		if code, ok := exitCodes[appErr.Type]; ok {
+			return code
+		}
+	}
+	return ExitFailure
+}
+
 // AppError is a rich error type that provides categorize and hints for users.
 type AppError struct {
 	Type    ErrorType
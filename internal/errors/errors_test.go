@@ -40,3 +40,32 @@ func TestAppError_IsType(t *testing.T) {
 	wrapped := fmt.Errorf("wrapped: %w", err)
 	assert.True(t, IsType(wrapped, TypeAuth))
 }
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{nil, ExitSuccess},
+		{errors.New("plain"), ExitFailure},
+		{New(TypeConfig, "x", ""), ExitConfig},
+		{New(TypeDependency, "x", ""), ExitDependency},
+		{New(TypeConnection, "x", ""), ExitConnection},
+		{New(TypeAuth, "x", ""), ExitAuth},
+		{New(TypeSecurity, "x", ""), ExitSecurity},
+		{New(TypeIntegrity, "x", ""), ExitIntegrity},
+		{New(TypeResource, "x", ""), ExitResource},
+		{New(TypeInternal, "x", ""), ExitInternal},
+		{fmt.Errorf("wrapped: %w", New(TypeAuth, "x", "")), ExitAuth},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, ExitCode(tt.err), "%v", tt.err)
+	}
+
+	// Every type has its own code
+	seen := map[int]ErrorType{}
+	for typ, code := range exitCodes {
+		assert.NotContains(t, seen, code, "%s shares code %d with %s", typ, code, seen[code])
+		seen[code] = typ
+	}
+}
@@ -2,10 +2,14 @@ package logger
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"os"
+	"sync"
+
+	"gopkg.in/natefinch/lumberjack.v2"
 )
 
 type contextKey struct{}
@@ -26,11 +30,29 @@ type Logger struct {
 	color bool
 }
 
+// Log file rotation limits
+const (
+	fileMaxSizeMB  = 50
+	fileMaxBackups = 5
+	fileMaxAgeDays = 28
+)
+
 type Config struct {
 	Writer  io.Writer
 	JSON    bool
 	NoColor bool
 	Level   slog.Level
+	File    string // Optional log file, rotated by size; receives logs in addition to Writer
+	Quiet   bool   // Only errors on Writer; File (if set) still gets everything at Level
+}
+
+// ParseLevel accepts debug, info, warn or error, in any case.
+func ParseLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return 0, fmt.Errorf("unknown log level %q", s)
+	}
+	return level, nil
 }
 
 func New(cfg Config) *Logger {
@@ -38,28 +60,16 @@ func New(cfg Config) *Logger {
 		cfg.Writer = os.Stderr
 	}
 
-	opts := &slog.HandlerOptions{
-		Level: cfg.Level,
-		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
-			if a.Key == slog.TimeKey {
-				return slog.Attr{
-					Key:   a.Key,
-					Value: slog.StringValue(a.Value.Time().Format("2006/01/02 15:04:05")),
-				}
-			}
-			return a
-		},
+	consoleLevel := cfg.Level
+	if cfg.Quiet && consoleLevel < slog.LevelError {
+		consoleLevel = slog.LevelError
 	}
 
-	var handler slog.Handler
-	if cfg.JSON {
-		handler = slog.NewJSONHandler(cfg.Writer, opts)
-	} else {
-		handler = &colorHandler{
-			Handler: slog.NewTextHandler(cfg.Writer, opts),
-			Writer:  cfg.Writer,
-			noColor: cfg.NoColor,
-		}
+	handler := newHandler(cfg.Writer, cfg.JSON, cfg.NoColor, consoleLevel)
+	if cfg.File != "" {
+		// Never write ANSI color codes into the file
+		fileHandler := newHandler(fileSink(cfg.File), cfg.JSON, true, cfg.Level)
+		handler = &multiHandler{handlers: []slog.Handler{handler, fileHandler}}
 	}
 
 	return &Logger{
@@ -96,6 +106,96 @@ func (l *Logger) With(args ...any) *Logger {
 	}
 }
 
+func newHandler(w io.Writer, json, noColor bool, level slog.Level) slog.Handler {
+	opts := &slog.HandlerOptions{
+		Level: level,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey {
+				return slog.Attr{
+					Key:   a.Key,
+					Value: slog.StringValue(a.Value.Time().Format("2006/01/02 15:04:05")),
+				}
+			}
+			return a
+		},
+	}
+
+	if json {
+		return slog.NewJSONHandler(w, opts)
+	}
+	return &colorHandler{
+		Handler: slog.NewTextHandler(w, opts),
+		Writer:  w,
+		noColor: noColor,
+	}
+}
+
+var (
+	fileSinks   = map[string]*lumberjack.Logger{}
+	fileSinksMu sync.Mutex
+)
+
+// fileSink returns a shared rotating writer per path so that every logger in
+// the process agrees on when to rotate.
+func fileSink(path string) io.Writer {
+	fileSinksMu.Lock()
+	defer fileSinksMu.Unlock()
+
+	if w, ok := fileSinks[path]; ok {
+		return w
+	}
+	w := &lumberjack.Logger{
+		Filename:   path,
+		MaxSize:    fileMaxSizeMB,
+		MaxBackups: fileMaxBackups,
+		MaxAge:     fileMaxAgeDays,
+	}
+	fileSinks[path] = w
+	return w
+}
+
+// multiHandler fans a record out to every handler that accepts its level.
+type multiHandler struct {
+	handlers []slog.Handler
+}
+
+func (m *multiHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, h := range m.handlers {
+		if h.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *multiHandler) Handle(ctx context.Context, r slog.Record) error {
+	var errs []error
+	for _, h := range m.handlers {
+		if h.Enabled(ctx, r.Level) {
+			if err := h.Handle(ctx, r.Clone()); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (m *multiHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	hs := make([]slog.Handler, len(m.handlers))
+	for i, h := range m.handlers {
+		hs[i] = h.WithAttrs(attrs)
+	}
+	return &multiHandler{handlers: hs}
+}
+
+func (m *multiHandler) WithGroup(name string) slog.Handler {
+	hs := make([]slog.Handler, len(m.handlers))
+	for i, h := range m.handlers {
+		hs[i] = h.WithGroup(name)
+	}
+	return &multiHandler{handlers: hs}
+}
+
 type colorHandler struct {
 	slog.Handler
 	Writer  io.Writer
@@ -3,10 +3,13 @@ package logger
 import (
 	"bytes"
 	"log/slog"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestLogger_JSON(t *testing.T) {
@@ -83,3 +86,68 @@ func TestLogger_With(t *testing.T) {
 	output := buf.String()
 	assert.Contains(t, output, `"context":"request123"`)
 }
+
+func TestLogger_FileSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dbackup.log")
+
+	var console bytes.Buffer
+	l := New(Config{
+		Writer: &console,
+		JSON:   true,
+		Level:  slog.LevelInfo,
+		File:   path,
+	})
+	l.With("db", "app").Info("file message", "key", "value")
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"msg":"file message"`)
+	assert.Contains(t, string(data), `"db":"app"`)
+	assert.Contains(t, console.String(), `"msg":"file message"`, "console output is kept alongside the file")
+}
+
+func TestLogger_FileSinkNoColor(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dbackup.log")
+
+	var console bytes.Buffer
+	l := New(Config{Writer: &console, File: path})
+	l.Warn("colored on console only")
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "colored on console only")
+	assert.NotContains(t, string(data), "\033[")
+	assert.Contains(t, console.String(), "\033[")
+}
+
+func TestLogger_Quiet(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dbackup.log")
+
+	var console bytes.Buffer
+	l := New(Config{Writer: &console, NoColor: true, File: path, Quiet: true})
+	l.Info("routine")
+	l.Error("broken")
+
+	assert.NotContains(t, console.String(), "routine")
+	assert.Contains(t, console.String(), "broken")
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "routine")
+	assert.Contains(t, string(data), "broken")
+}
+
+func TestParseLevel(t *testing.T) {
+	for in, want := range map[string]slog.Level{
+		"debug": slog.LevelDebug,
+		"INFO":  slog.LevelInfo,
+		"warn":  slog.LevelWarn,
+		"error": slog.LevelError,
+	} {
+		got, err := ParseLevel(in)
+		require.NoError(t, err, in)
+		assert.Equal(t, want, got, in)
+	}
+	_, err := ParseLevel("loud")
+	assert.Error(t, err)
+}
@@ -0,0 +1,58 @@
+package manifest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+)
+
+// pointer is the content of a latest pointer: the name of the manifest of
+// the backup it points at, on the same target.
+type pointer struct {
+	Target string `json:"target"`
+}
+
+// MetadataReader is the part of a storage target ReadLatest needs.
+type MetadataReader interface {
+	GetMetadata(ctx context.Context, name string) ([]byte, error)
+}
+
+// NewPointer returns a latest pointer naming the manifest target.
+func NewPointer(target string) []byte {
+	data, _ := json.Marshal(pointer{Target: target}) // A struct of one string always marshals
+	return data
+}
+
+// ParsePointer returns the manifest a latest pointer names. Pointers written
+// by older versions are a full copy of the backup's manifest instead; for
+// those ok is false and data is to be read as the manifest itself.
+func ParsePointer(data []byte) (target string, ok bool) {
+	var p pointer
+	if json.Unmarshal(data, &p) != nil || p.Target == "" {
+		return "", false
+	}
+	return p.Target, true
+}
+
+// ReadLatest reads the latest pointer name from s and returns the manifest
+// of the backup it points at: its name on the target and its content. For a
+// legacy pointer, which is a full copy, that is the pointer itself.
+func ReadLatest(ctx context.Context, s MetadataReader, name string) (string, []byte, error) {
+	data, err := s.GetMetadata(ctx, name)
+	if err != nil {
+		return name, nil, err
+	}
+	target, ok := ParsePointer(data)
+	if !ok {
+		return name, data, nil
+	}
+	if IsLatest(target) || path.IsAbs(target) {
+		return name, nil, fmt.Errorf("latest pointer %s names %s, which is not a backup manifest", name, target)
+	}
+	data, err = s.GetMetadata(ctx, target)
+	if err != nil {
+		return target, nil, fmt.Errorf("latest pointer %s names %s: %w", name, target, err)
+	}
+	return target, data, nil
+}
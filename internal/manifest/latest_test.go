@@ -0,0 +1,66 @@
+package manifest
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type metadataMap map[string][]byte
+
+func (m metadataMap) GetMetadata(_ context.Context, name string) ([]byte, error) {
+	data, ok := m[name]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	return data, nil
+}
+
+func TestReadLatest(t *testing.T) {
+	ctx := context.Background()
+	full, err := (&Manifest{ID: "1", FileName: "shop.sql.gz"}).Serialize()
+	require.NoError(t, err)
+	s := metadataMap{
+		"shop.sql.gz.manifest":          full,
+		LatestName:                      NewPointer("shop.sql.gz.manifest"),
+		"latest-postgres-shop.manifest": full, // Legacy: a full copy
+		"latest-postgres-gone.manifest": NewPointer("gone.sql.gz.manifest"),
+		"latest-postgres-loop.manifest": NewPointer(LatestName),
+	}
+
+	name, data, err := ReadLatest(ctx, s, LatestName)
+	require.NoError(t, err)
+	assert.Equal(t, "shop.sql.gz.manifest", name)
+	assert.Equal(t, full, data)
+
+	name, data, err = ReadLatest(ctx, s, "latest-postgres-shop.manifest")
+	require.NoError(t, err)
+	assert.Equal(t, "latest-postgres-shop.manifest", name)
+	assert.Equal(t, full, data)
+
+	// A dangling pointer reports the manifest it misses
+	name, _, err = ReadLatest(ctx, s, "latest-postgres-gone.manifest")
+	assert.Error(t, err)
+	assert.Equal(t, "gone.sql.gz.manifest", name)
+
+	_, _, err = ReadLatest(ctx, s, "latest-postgres-loop.manifest")
+	assert.Error(t, err)
+	_, _, err = ReadLatest(ctx, s, "latest-postgres-none.manifest")
+	assert.Error(t, err)
+}
+
+func TestParsePointer(t *testing.T) {
+	target, ok := ParsePointer(NewPointer("a.sql.manifest"))
+	assert.True(t, ok)
+	assert.Equal(t, "a.sql.manifest", target)
+
+	full, err := (&Manifest{ID: "1", FileName: "a.sql"}).Serialize()
+	require.NoError(t, err)
+	_, ok = ParsePointer(full)
+	assert.False(t, ok)
+	_, ok = ParsePointer([]byte("not json"))
+	assert.False(t, ok)
+}
@@ -1,51 +1,297 @@
 package manifest
 
 import (
+	"bytes"
 	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/gob"
 	"encoding/hex"
 	"encoding/json"
+	"fmt"
+	"hash"
 	"io"
+	"path"
+	"regexp"
+	"strings"
 	"time"
+	"unicode"
+
+	"lukechampine.com/blake3"
+)
+
+// Supported checksum algorithms for manifest integrity.
+const (
+	ChecksumSHA256 = "sha256"
+	ChecksumSHA512 = "sha512"
+	ChecksumBlake3 = "blake3"
+)
+
+// Supported manifest encodings. JSON stays the default for readability;
+// binary is gob behind a magic prefix and parses much faster for backups
+// with many chunks.
+const (
+	FormatJSON   = "json"
+	FormatBinary = "binary"
 )
 
+// binaryMagic starts every binary manifest. JSON can't begin with a NUL byte,
+// so Deserialize tells the encodings apart without a hint.
+var binaryMagic = []byte{0x00, 'D', 'B', 'M', 0x01}
+
+// CheckFormat validates a manifest encoding name; empty means JSON.
+func CheckFormat(format string) error {
+	switch format {
+	case "", FormatJSON, FormatBinary:
+		return nil
+	default:
+		return fmt.Errorf("unsupported manifest format: %s (valid: %s, %s)", format, FormatJSON, FormatBinary)
+	}
+}
+
+// LatestName points at the most recent backup written to a target.
+const LatestName = "latest.manifest"
+
+// LatestNameFor returns the pointer to the most recent backup of one
+// database, so several databases can share a target without their latest
+// backups overwriting each other.
+func LatestNameFor(engine, dbName string) string {
+	return fmt.Sprintf("latest-%s-%s.manifest", cleanName(strings.ToLower(engine)), cleanName(path.Base(dbName)))
+}
+
+// cleanName replaces whatever could split or escape a file name with _.
+func cleanName(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '_' {
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+// IsLatest reports whether name is a latest pointer rather than the manifest
+// of a backup. Pointers name a backup's manifest, or in older versions
+// duplicate it, and must not be counted as backups when listing, pruning or
+// rekeying; see ReadLatest.
+func IsLatest(name string) bool {
+	base := path.Base(name)
+	return base == LatestName || latestForRe.MatchString(base)
+}
+
+// latestForRe matches the names LatestNameFor returns, whose engine and
+// database parts cannot contain a dash.
+var latestForRe = regexp.MustCompile(`^latest-[a-z0-9._]+-[A-Za-z0-9._]+\.manifest$`)
+
+// WALDir holds the Postgres WAL segments archive-wal uploads, each with its
+// own manifest so dedupe GC keeps its chunks.
+const WALDir = "wal/"
+
+// WALDirFor returns the directory under WALDir for one cluster's segments.
+// Every cluster starts with the same segment names, so clusters sharing a
+// target must not share a directory.
+func WALDirFor(cluster string) string {
+	name := strings.TrimLeft(cleanName(cluster), ".") // No "..", no hidden directory
+	if name == "" {
+		name = "_"
+	}
+	return WALDir + name + "/"
+}
+
+// IsWAL reports whether name is an archived WAL segment or its manifest.
+// Segments belong to no single backup and must not be listed or pruned as
+// backups.
+func IsWAL(name string) bool {
+	return strings.HasPrefix(name, WALDir)
+}
+
+// SchemaVersion is bumped whenever a manifest field is added whose absence
+// needs a default other than its zero value. Manifests written before
+// versioning existed have no schema_version and are treated as version 1.
+const SchemaVersion = 2
+
+// migrations[v] upgrades a manifest from schema version v to v+1.
+var migrations = map[int]func(*Manifest){
+	1: func(m *Manifest) {
+		// v1 predates selectable checksum algorithms; every digest is SHA-256
+		if m.ChecksumAlgo == "" {
+			m.ChecksumAlgo = ChecksumSHA256
+		}
+	},
+}
+
 type Manifest struct {
-	ID          string    `json:"id"`
-	ParentID    string    `json:"parent_id,omitempty"`
-	Engine      string    `json:"engine"`
-	DBName      string    `json:"dbname,omitempty"`
-	Timestamp   string    `json:"timestamp,omitempty"`
-	Version     string    `json:"version"`
-	Checksum    string    `json:"checksum,omitempty"` // SHA-256 of the stored blob
-	Compression string    `json:"compression,omitempty"`
-	Encryption  string    `json:"encryption,omitempty"`
-	CreatedAt   time.Time `json:"created_at"`
-	FileName    string    `json:"file_name,omitempty"`
-	Size        int64     `json:"size,omitempty"`   // Total size of the backup blob
-	Chunks      []string  `json:"chunks,omitempty"` // SHA-256 hashes for dedupe
+	SchemaVersion int `json:"schema_version,omitempty"`
+
+	ID           string    `json:"id"`
+	ParentID     string    `json:"parent_id,omitempty"`
+	Engine       string    `json:"engine"`
+	DBName       string    `json:"dbname,omitempty"`
+	Timestamp    string    `json:"timestamp,omitempty"`
+	Version      string    `json:"version"`
+	Checksum     string    `json:"checksum,omitempty"`      // Digest of the stored blob
+	ChecksumAlgo string    `json:"checksum_algo,omitempty"` // Empty means SHA-256 (pre-existing manifests)
+	Compression  string    `json:"compression,omitempty"`
+	Encryption   string    `json:"encryption,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+	FileName     string    `json:"file_name,omitempty"`
+	Size         int64     `json:"size,omitempty"`   // Total size of the backup blob
+	Chunks       []string  `json:"chunks,omitempty"` // SHA-256 hashes for dedupe
+
+	ChunkEncryption string `json:"chunk_encryption,omitempty"` // Chunks encrypted individually; Chunks then holds keyed IDs
+	CompressionDict uint32 `json:"compression_dict,omitempty"` // ID of the zstd dictionary (dict.zstd) the blob was compressed with
+
+	Origin     string      `json:"origin,omitempty"`     // Scrubbed location the backup was first written to
+	Migrations []Migration `json:"migrations,omitempty"` // Moves between targets, oldest first
+
+	Note string `json:"note,omitempty"` // Free-form operator comment, see SanitizeNote
+
+	ChangeSignal string `json:"change_signal,omitempty"` // Engine's change marker when the dump started, for --if-changed
+
+	Excludes []string `json:"excludes,omitempty"` // --exclude patterns whose files the backup left out
+
+	SourceChecksum string `json:"source_checksum,omitempty"` // Digest, in ChecksumAlgo, of an archived WAL segment before compression and encryption
+
+	// WAL a physical Postgres backup needs to become consistent, taken from
+	// the backup_manifest pg_basebackup writes. Empty for other backups.
+	Timeline int    `json:"timeline,omitempty"`
+	StartLSN string `json:"start_lsn,omitempty"`
+	EndLSN   string `json:"end_lsn,omitempty"`
+
+	// Pruned marks a tombstone: retention deleted the backup's data at
+	// PrunedAt but kept its manifest as a record, see Tombstone.
+	Pruned   bool      `json:"pruned,omitempty"`
+	PrunedAt time.Time `json:"pruned_at,omitzero"`
+
+	format string // Encoding Serialize writes; Deserialize keeps the one it read
+}
+
+// MaxNoteLen bounds a manifest note, in characters.
+const MaxNoteLen = 256
+
+// SanitizeNote makes an operator note safe to store and print on one line:
+// control characters and runs of whitespace become a single space, and the
+// result is cut to MaxNoteLen characters.
+func SanitizeNote(note string) string {
+	fields := strings.FieldsFunc(note, func(r rune) bool {
+		return unicode.IsSpace(r) || unicode.IsControl(r)
+	})
+	clean := []rune(strings.Join(fields, " "))
+	if len(clean) > MaxNoteLen {
+		clean = clean[:MaxNoteLen]
+	}
+	return strings.TrimSpace(string(clean))
+}
+
+// Migration records one `dbackup migrate` of a backup between targets.
+type Migration struct {
+	From string    `json:"from"`
+	To   string    `json:"to"`
+	At   time.Time `json:"at"`
 }
 
 func New(id, engine, compression, encryption string) *Manifest {
 	return &Manifest{
-		ID:          id,
-		Engine:      engine,
-		Compression: compression,
-		Encryption:  encryption,
-		CreatedAt:   time.Now(),
+		SchemaVersion: SchemaVersion,
+		ID:            id,
+		Engine:        engine,
+		Compression:   compression,
+		Encryption:    encryption,
+		CreatedAt:     time.Now(),
+	}
+}
+
+// RecordMigration notes that the backup was copied from one target to
+// another. Locations must already be scrubbed of credentials.
+func (m *Manifest) RecordMigration(from, to string) {
+	if m.Origin == "" {
+		m.Origin = from // Backups older than origin tracking
 	}
+	m.Migrations = append(m.Migrations, Migration{From: from, To: to, At: time.Now()})
+}
+
+// Tombstone returns a copy of m recording that retention deleted its data at
+// at. The copy lists no chunks, so it keeps none from garbage collection.
+func (m *Manifest) Tombstone(at time.Time) *Manifest {
+	t := *m
+	t.Chunks = nil
+	t.Pruned, t.PrunedAt = true, at
+	return &t
+}
+
+// SetFormat selects the encoding Serialize writes, see CheckFormat.
+func (m *Manifest) SetFormat(format string) error {
+	if err := CheckFormat(format); err != nil {
+		return err
+	}
+	m.format = format
+	return nil
+}
+
+// Format returns the encoding Serialize writes.
+func (m *Manifest) Format() string {
+	if m.format == "" {
+		return FormatJSON
+	}
+	return m.format
 }
 
 func (m *Manifest) Serialize() ([]byte, error) {
-	return json.MarshalIndent(m, "", "  ")
+	if m.format != FormatBinary {
+		return json.MarshalIndent(m, "", "  ")
+	}
+	var buf bytes.Buffer
+	buf.Write(binaryMagic)
+	if err := gob.NewEncoder(&buf).Encode(m); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
 }
 
+// Deserialize parses a manifest and upgrades it to the current schema.
+// Unknown fields from newer versions are ignored so older binaries can still
+// read what they understand; see Newer.
 func Deserialize(data []byte) (*Manifest, error) {
 	var m Manifest
-	if err := json.Unmarshal(data, &m); err != nil {
+	if bytes.HasPrefix(data, binaryMagic) {
+		if err := gob.NewDecoder(bytes.NewReader(data[len(binaryMagic):])).Decode(&m); err != nil {
+			return nil, fmt.Errorf("invalid binary manifest: %w", err)
+		}
+		m.format = FormatBinary
+	} else if err := json.Unmarshal(data, &m); err != nil {
 		return nil, err
 	}
+	if m.SchemaVersion == 0 {
+		m.SchemaVersion = 1
+	}
+	for m.SchemaVersion < SchemaVersion {
+		if migrate, ok := migrations[m.SchemaVersion]; ok {
+			migrate(&m)
+		}
+		m.SchemaVersion++
+	}
 	return &m, nil
 }
 
+// Newer reports whether the manifest was written by a newer dbackup whose
+// schema this binary only partially understands.
+func (m *Manifest) Newer() bool {
+	return m.SchemaVersion > SchemaVersion
+}
+
+// NewHasher returns the hash implementation for the given checksum algorithm.
+// An empty algorithm selects SHA-256 so older manifests keep verifying.
+func NewHasher(algo string) (hash.Hash, error) {
+	switch strings.ToLower(algo) {
+	case "", ChecksumSHA256:
+		return sha256.New(), nil
+	case ChecksumSHA512:
+		return sha512.New(), nil
+	case ChecksumBlake3:
+		return blake3.New(32, nil), nil
+	default:
+		return nil, fmt.Errorf("unsupported checksum algorithm: %s (valid: %s, %s, %s)", algo, ChecksumSHA256, ChecksumSHA512, ChecksumBlake3)
+	}
+}
+
 func CalculateChecksum(r io.Reader) (string, error) {
 	h := sha256.New()
 	if _, err := io.Copy(h, r); err != nil {
@@ -1,8 +1,11 @@
 package manifest
 
 import (
+	"fmt"
+	"strings"
 	"testing"
 	"time"
+	"unicode/utf8"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -58,5 +61,161 @@ func TestNewManifest(t *testing.T) {
 	assert.Equal(t, "mysql", m.Engine)
 	assert.Equal(t, "gzip", m.Compression)
 	assert.Equal(t, "aes-256-gcm", m.Encryption)
+	assert.Equal(t, SchemaVersion, m.SchemaVersion)
 	assert.WithinDuration(t, time.Now(), m.CreatedAt, 1*time.Second)
 }
+
+func TestNewHasher(t *testing.T) {
+	for algo, size := range map[string]int{"": 32, ChecksumSHA256: 32, ChecksumSHA512: 64, ChecksumBlake3: 32} {
+		h, err := NewHasher(algo)
+		assert.NoError(t, err)
+		assert.Equal(t, size, h.Size(), "digest size for %q", algo)
+	}
+
+	_, err := NewHasher("md5")
+	assert.Error(t, err)
+}
+
+func TestManifest_DeserializeV1Defaults(t *testing.T) {
+	// Written before schema versioning, checksum_algo and chunk_encryption
+	v1 := []byte(`{
+  "id": "old-backup",
+  "engine": "postgres",
+  "version": "1.0",
+  "checksum": "deadbeef",
+  "compression": "gzip",
+  "created_at": "2024-01-02T03:04:05Z",
+  "file_name": "old.sql.gz",
+  "size": 42
+}`)
+
+	m, err := Deserialize(v1)
+	assert.NoError(t, err)
+	assert.Equal(t, SchemaVersion, m.SchemaVersion)
+	assert.Equal(t, ChecksumSHA256, m.ChecksumAlgo)
+	assert.Empty(t, m.ChunkEncryption)
+	assert.Empty(t, m.ParentID)
+	assert.Equal(t, "deadbeef", m.Checksum)
+	assert.Equal(t, int64(42), m.Size)
+	assert.False(t, m.Newer())
+}
+
+func TestManifest_DeserializeNewerSchema(t *testing.T) {
+	future := []byte(`{"schema_version": 99, "id": "x", "engine": "mysql", "checksum_algo": "blake3", "some_future_field": {"a": 1}}`)
+
+	m, err := Deserialize(future)
+	assert.NoError(t, err)
+	assert.Equal(t, 99, m.SchemaVersion)
+	assert.Equal(t, ChecksumBlake3, m.ChecksumAlgo)
+	assert.True(t, m.Newer())
+}
+
+func TestLatestNames(t *testing.T) {
+	assert.Equal(t, "latest-postgres-shop.manifest", LatestNameFor("Postgres", "shop"))
+	assert.Equal(t, "latest-sqlite-app.db.manifest", LatestNameFor("sqlite", "/var/data/app.db"))
+
+	assert.True(t, IsLatest(LatestName))
+	assert.True(t, IsLatest("latest-mysql-orders.manifest"))
+	assert.False(t, IsLatest("orders-20240101.sql.manifest"))
+	assert.True(t, IsLatest(LatestNameFor("postgres", "/data/my-app.db")))
+	assert.False(t, IsLatest("latest-prod.sql.manifest"))
+	assert.False(t, IsLatest("latest-mysql-orders-2024.sql.manifest"))
+}
+
+func TestManifest_IsWAL(t *testing.T) {
+	assert.True(t, IsWAL("wal/000000010000000000000001.lz4.manifest"))
+	assert.False(t, IsWAL("postgres-shop-20240101.sql.manifest"))
+	assert.True(t, IsWAL(WALDirFor("main")+"000000010000000000000001.lz4"))
+}
+
+func TestWALDirFor(t *testing.T) {
+	assert.Equal(t, "wal/main/", WALDirFor("main"))
+	assert.Equal(t, "wal/eu_west_db1/", WALDirFor("eu-west/db1"))
+	assert.Equal(t, "wal/_/", WALDirFor(".."))
+	assert.Equal(t, "wal/_/", WALDirFor(""))
+}
+
+func TestManifest_RecordMigration(t *testing.T) {
+	m := New("pg", "postgres", "gzip", "")
+	m.RecordMigration("host:/backups", "s3://bucket/backups")
+	m.RecordMigration("s3://bucket/backups", "sftp://nas/backups")
+
+	data, err := m.Serialize()
+	assert.NoError(t, err)
+	got, err := Deserialize(data)
+	assert.NoError(t, err)
+
+	assert.Equal(t, "host:/backups", got.Origin, "origin is backfilled from the first hop")
+	assert.Len(t, got.Migrations, 2)
+	assert.Equal(t, "sftp://nas/backups", got.Migrations[1].To)
+}
+
+func TestManifest_Tombstone(t *testing.T) {
+	m := New("pg", "postgres", "gzip", "")
+	m.Chunks = []string{"c1", "c2"}
+	at := time.Now().Truncate(time.Second)
+
+	data, err := m.Tombstone(at).Serialize()
+	assert.NoError(t, err)
+	got, err := Deserialize(data)
+	assert.NoError(t, err)
+
+	assert.True(t, got.Pruned)
+	assert.True(t, at.Equal(got.PrunedAt))
+	assert.Empty(t, got.Chunks, "a tombstone keeps no chunks alive")
+	assert.Equal(t, m.ID, got.ID)
+	assert.Len(t, m.Chunks, 2, "the original manifest is left alone")
+	assert.False(t, m.Pruned)
+}
+
+func TestSanitizeNote(t *testing.T) {
+	assert.Equal(t, "before v2 migration", SanitizeNote("  before\tv2\n\x1b migration \r\n"))
+	assert.Equal(t, "", SanitizeNote("\n\t"))
+
+	long := SanitizeNote(strings.Repeat("é", MaxNoteLen+10))
+	assert.Equal(t, MaxNoteLen, utf8.RuneCountInString(long))
+}
+
+func TestManifest_BinaryFormat(t *testing.T) {
+	m := New("pg", "postgres", "zstd", "")
+	m.Chunks = []string{"aa", "bb", "cc"}
+	m.RecordMigration("host:/backups", "s3://bucket/backups")
+	assert.NoError(t, m.SetFormat(FormatBinary))
+
+	data, err := m.Serialize()
+	assert.NoError(t, err)
+	assert.Equal(t, byte(0), data[0])
+
+	got, err := Deserialize(data)
+	assert.NoError(t, err)
+	assert.Equal(t, FormatBinary, got.Format(), "the format read is kept for rewrites")
+	assert.Equal(t, m.Chunks, got.Chunks)
+	assert.Equal(t, m.Migrations[0].To, got.Migrations[0].To)
+	assert.True(t, m.CreatedAt.Equal(got.CreatedAt))
+
+	assert.Error(t, m.SetFormat("xml"))
+	_, err = Deserialize(append(append([]byte{}, binaryMagic...), "garbage"...))
+	assert.Error(t, err)
+}
+
+func BenchmarkDeserialize50kChunks(b *testing.B) {
+	m := New("bench", "postgres", "zstd", "")
+	for i := 0; i < 50000; i++ {
+		m.Chunks = append(m.Chunks, fmt.Sprintf("%064x", i))
+	}
+	for _, format := range []string{FormatJSON, FormatBinary} {
+		_ = m.SetFormat(format)
+		data, err := m.Serialize()
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Run(format, func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			for i := 0; i < b.N; i++ {
+				if _, err := Deserialize(data); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// BatchNotifier collects per-task Stats and sends a single consolidated
+// notification on Flush, so parallel runs don't spam the channel.
+type BatchNotifier struct {
+	Inner Notifier
+
+	mu      sync.Mutex
+	pending []Stats
+	started time.Time
+}
+
+func NewBatchNotifier(inner Notifier) *BatchNotifier {
+	return &BatchNotifier{Inner: inner, started: time.Now()}
+}
+
+// Notify queues the stats instead of sending them, so a BatchNotifier can be
+// handed to managers anywhere a Notifier is expected. Started pings are
+// dropped: a batch reports once, when it is flushed.
+func (b *BatchNotifier) Notify(ctx context.Context, stats Stats) error {
+	if stats.Status == StatusStarted {
+		return nil
+	}
+	b.Add(stats)
+	return nil
+}
+
+func (b *BatchNotifier) Add(stats Stats) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.pending = append(b.pending, stats)
+}
+
+// Flush sends one summary for everything collected since the last flush.
+// It is a no-op when nothing was collected.
+func (b *BatchNotifier) Flush(ctx context.Context) error {
+	b.mu.Lock()
+	items := b.pending
+	started := b.started
+	b.pending = nil
+	b.started = time.Now()
+	b.mu.Unlock()
+
+	if len(items) == 0 || b.Inner == nil {
+		return nil
+	}
+
+	return b.Inner.Notify(ctx, summarize(items, time.Since(started)))
+}
+
+func summarize(items []Stats, elapsed time.Duration) Stats {
+	summary := Stats{
+		Status:   StatusSuccess,
+		Database: fmt.Sprintf("%d databases", len(items)),
+		Duration: elapsed,
+		Details:  items,
+	}
+
+	op := items[0].Operation
+	failed := 0
+	for _, s := range items {
+		summary.Size += s.Size
+		summary.LogicalSize += s.LogicalSize
+		if s.Status == StatusError {
+			failed++
+		}
+		if s.Operation != op {
+			op = ""
+		}
+	}
+
+	summary.Operation = "Batch"
+	if op != "" {
+		summary.Operation = "Batch " + op
+	}
+	if failed > 0 {
+		summary.Status = StatusError
+		summary.Error = fmt.Errorf("%d of %d tasks failed", failed, len(items))
+	}
+	return summary
+}
@@ -0,0 +1,74 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type recordingNotifier struct {
+	mu   sync.Mutex
+	sent []Stats
+}
+
+func (r *recordingNotifier) Notify(ctx context.Context, stats Stats) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sent = append(r.sent, stats)
+	return nil
+}
+
+func TestBatchNotifier_FlushSendsOneSummary(t *testing.T) {
+	rec := &recordingNotifier{}
+	b := NewBatchNotifier(rec)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			s := Stats{Status: StatusSuccess, Operation: "Backup", Engine: "postgres", Size: 100, Duration: time.Second}
+			if i == 0 {
+				s.Status = StatusError
+				s.Error = errors.New("boom")
+			}
+			b.Notify(context.Background(), s) // #nosec G104
+		}(i)
+	}
+	wg.Wait()
+	assert.Empty(t, rec.sent, "nothing is sent before Flush")
+
+	require.NoError(t, b.Flush(context.Background()))
+	require.Len(t, rec.sent, 1)
+
+	summary := rec.sent[0]
+	assert.Equal(t, "Batch Backup", summary.Operation)
+	assert.Equal(t, StatusError, summary.Status)
+	assert.Equal(t, int64(500), summary.Size)
+	assert.Len(t, summary.Details, 5)
+	assert.Contains(t, summary.Error.Error(), "1 of 5")
+
+	// A second flush with nothing pending is a no-op
+	require.NoError(t, b.Flush(context.Background()))
+	assert.Len(t, rec.sent, 1)
+}
+
+func TestBatchNotifier_DropsStarted(t *testing.T) {
+	rec := &recordingNotifier{}
+	b := NewBatchNotifier(rec)
+
+	b.Notify(context.Background(), Stats{Status: StatusStarted, Operation: "Backup"}) // #nosec G104
+	require.NoError(t, b.Flush(context.Background()))
+	assert.Empty(t, rec.sent, "a started ping alone is not a batch")
+
+	b.Notify(context.Background(), Stats{Status: StatusStarted, Operation: "Backup"}) // #nosec G104
+	b.Notify(context.Background(), Stats{Status: StatusSuccess, Operation: "Backup"}) // #nosec G104
+	require.NoError(t, b.Flush(context.Background()))
+	require.Len(t, rec.sent, 1)
+	assert.Len(t, rec.sent[0].Details, 1)
+}
@@ -19,6 +19,32 @@ func BuildNotifier(cfg *config.Config) Notifier {
 		}
 	}
 
+	return combine(notifiers)
+}
+
+// Build is BuildNotifier plus a Slack webhook given on the command line or in
+// the environment. A non-empty slackTemplate replaces the configured Slack
+// template for both webhooks, so the CLI and the scheduler send the same
+// messages.
+func Build(cfg *config.Config, slackURL, slackTemplate string) Notifier {
+	conf := *cfg
+	if slackTemplate != "" {
+		conf.Notifications.Slack.Template = slackTemplate
+	}
+	n := BuildNotifier(&conf)
+	if slackURL == "" {
+		return n
+	}
+	var notifiers []Notifier
+	if mn, ok := n.(*MultiNotifier); ok {
+		notifiers = mn.Notifiers
+	} else if n != nil {
+		notifiers = []Notifier{n}
+	}
+	return combine(append(notifiers, NewSlackNotifier(slackURL, conf.Notifications.Slack.Template)))
+}
+
+func combine(notifiers []Notifier) Notifier {
 	if len(notifiers) == 0 {
 		return nil
 	}
@@ -0,0 +1,35 @@
+package notify
+
+import (
+	"testing"
+
+	"github.com/lupppig/dbackup/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuild(t *testing.T) {
+	var cfg config.Config
+	assert.Nil(t, Build(&cfg, "", ""))
+
+	// A webhook from the flag or environment alone
+	n := Build(&cfg, "https://hooks.slack.com/a", "{{.Status}}")
+	sn, ok := n.(*SlackNotifier)
+	require.True(t, ok)
+	assert.Equal(t, "{{.Status}}", sn.Template)
+
+	// The configured template applies to the extra webhook too
+	cfg.Notifications.Slack = config.SlackConfig{WebhookURL: "https://hooks.slack.com/b", Template: "{{.Database}}"}
+	cfg.Notifications.Webhooks = []config.WebhookConfig{{URL: "https://example.com/hook"}}
+	mn, ok := Build(&cfg, "https://hooks.slack.com/a", "").(*MultiNotifier)
+	require.True(t, ok)
+	require.Len(t, mn.Notifiers, 3)
+	assert.Equal(t, "{{.Database}}", mn.Notifiers[0].(*SlackNotifier).Template)
+	assert.Equal(t, "{{.Database}}", mn.Notifiers[2].(*SlackNotifier).Template)
+
+	// An override replaces it for both, without touching the config
+	mn = Build(&cfg, "https://hooks.slack.com/a", "{{.Status}}").(*MultiNotifier)
+	assert.Equal(t, "{{.Status}}", mn.Notifiers[0].(*SlackNotifier).Template)
+	assert.Equal(t, "{{.Status}}", mn.Notifiers[2].(*SlackNotifier).Template)
+	assert.Equal(t, "{{.Database}}", cfg.Notifications.Slack.Template)
+}
@@ -0,0 +1,97 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	maxAttempts   = 3
+	maxRetryAfter = 30 * time.Second
+)
+
+var (
+	// retryBackoff is the base delay between attempts; it doubles on each retry.
+	retryBackoff = 1 * time.Second
+
+	// defaultHTTPClient bounds every request so a hung endpoint can't block the caller.
+	defaultHTTPClient = &http.Client{Timeout: 10 * time.Second}
+)
+
+// doWithRetry sends the request produced by newReq, retrying on network errors,
+// 5xx responses and 429 (honouring Retry-After). newReq is called once per
+// attempt so the request body can be replayed. The caller owns the returned body.
+func doWithRetry(ctx context.Context, client *http.Client, newReq func() (*http.Request, error)) (*http.Response, error) {
+	if client == nil {
+		client = defaultHTTPClient
+	}
+
+	delay := retryBackoff
+	var lastErr error
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		req, err := newReq()
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := client.Do(req)
+		wait := delay
+		switch {
+		case err != nil:
+			lastErr = err
+		case resp.StatusCode == http.StatusTooManyRequests:
+			lastErr = fmt.Errorf("rate limited: %s", resp.Status)
+			if ra, ok := parseRetryAfter(resp.Header.Get("Retry-After")); ok {
+				wait = ra
+			}
+		case resp.StatusCode >= 500:
+			lastErr = fmt.Errorf("server error: %s", resp.Status)
+		default:
+			return resp, nil
+		}
+
+		if attempt == maxAttempts {
+			if resp != nil {
+				return resp, nil
+			}
+			break
+		}
+		if resp != nil {
+			io.Copy(io.Discard, resp.Body) // #nosec G104
+			resp.Body.Close()              // #nosec G104
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+		delay *= 2
+	}
+	return nil, fmt.Errorf("request failed after %d attempts: %w", maxAttempts, lastErr)
+}
+
+func parseRetryAfter(v string) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+	var d time.Duration
+	if secs, err := strconv.Atoi(v); err == nil {
+		d = time.Duration(secs) * time.Second
+	} else if t, err := http.ParseTime(v); err == nil {
+		d = time.Until(t)
+	} else {
+		return 0, false
+	}
+	if d < 0 {
+		d = 0
+	}
+	if d > maxRetryAfter {
+		d = maxRetryAfter
+	}
+	return d, true
+}
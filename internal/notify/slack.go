@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strings"
 	"text/template"
 	"time"
 )
@@ -13,10 +14,11 @@ import (
 type SlackNotifier struct {
 	WebhookURL string
 	Template   string
+	Client     *http.Client
 }
 
 func NewSlackNotifier(url, tmpl string) *SlackNotifier {
-	return &SlackNotifier{WebhookURL: url, Template: tmpl}
+	return &SlackNotifier{WebhookURL: url, Template: tmpl, Client: defaultHTTPClient}
 }
 
 type slackAttachment struct {
@@ -44,9 +46,13 @@ func (s *SlackNotifier) Notify(ctx context.Context, stats Stats) error {
 
 	color := "#36a64f"
 	title := fmt.Sprintf("✅ %s Successful", stats.Operation)
-	if stats.Status == StatusError {
+	switch stats.Status {
+	case StatusError:
 		color = "#ff0000"
 		title = fmt.Sprintf("❌ %s Failed", stats.Operation)
+	case StatusStarted:
+		color = "#439fe0"
+		title = fmt.Sprintf("▶️ %s Started", stats.Operation)
 	}
 
 	attachment := slackAttachment{
@@ -64,7 +70,21 @@ func (s *SlackNotifier) Notify(ctx context.Context, stats Stats) error {
 		{Title: "DB", Value: stats.Engine, Short: true},
 		{Title: "Name", Value: stats.Database, Short: true},
 		{Title: "File", Value: stats.FileName, Short: false},
-		{Title: "Duration", Value: stats.Duration.String(), Short: true},
+	}
+	if stats.Status != StatusStarted || stats.Duration > 0 {
+		attachment.Fields = append(attachment.Fields, struct {
+			Title string `json:"title"`
+			Value string `json:"value"`
+			Short bool   `json:"short"`
+		}{Title: "Duration", Value: stats.Duration.String(), Short: true})
+	}
+
+	if stats.Note != "" {
+		attachment.Fields = append(attachment.Fields, struct {
+			Title string `json:"title"`
+			Value string `json:"value"`
+			Short bool   `json:"short"`
+		}{Title: "Note", Value: stats.Note, Short: false})
 	}
 
 	if stats.Size > 0 {
@@ -75,10 +95,65 @@ func (s *SlackNotifier) Notify(ctx context.Context, stats Stats) error {
 		}{Title: "Size", Value: formatSize(stats.Size), Short: true})
 	}
 
+	if ratio := stats.Ratio(); ratio > 0 {
+		attachment.Fields = append(attachment.Fields, struct {
+			Title string `json:"title"`
+			Value string `json:"value"`
+			Short bool   `json:"short"`
+		}{Title: "Compression", Value: fmt.Sprintf("%.2fx of %s", ratio, formatSize(stats.LogicalSize)), Short: true})
+	}
+
+	if tp := stats.Throughput(); tp > 0 {
+		attachment.Fields = append(attachment.Fields, struct {
+			Title string `json:"title"`
+			Value string `json:"value"`
+			Short bool   `json:"short"`
+		}{Title: "Throughput", Value: fmt.Sprintf("%.1f MB/s", tp), Short: true})
+	}
+
+	if len(stats.Pruned) > 0 {
+		title := "Pruned"
+		if stats.PruneDryRun {
+			title = "Would prune"
+		}
+		value := strings.Join(stats.Pruned, ", ")
+		if r := stats.Reclaimed(); r != "" {
+			value += " (reclaimed " + r + ")"
+		}
+		attachment.Fields = append(attachment.Fields, struct {
+			Title string `json:"title"`
+			Value string `json:"value"`
+			Short bool   `json:"short"`
+		}{Title: title, Value: value, Short: false})
+	}
+
 	if stats.Error != nil {
 		attachment.Text = fmt.Sprintf("*Error:* %v", stats.Error)
 	}
 
+	if len(stats.Details) > 0 {
+		var lines []string
+		for _, d := range stats.Details {
+			icon := "✅"
+			if d.Status == StatusError {
+				icon = "❌"
+			}
+			line := fmt.Sprintf("%s %s/%s (%s", icon, d.Engine, d.Database, d.Duration.Truncate(time.Millisecond))
+			if d.Size > 0 {
+				line += ", " + formatSize(d.Size)
+			}
+			line += ")"
+			if d.Error != nil {
+				line += fmt.Sprintf(": %v", d.Error)
+			}
+			lines = append(lines, line)
+		}
+		if attachment.Text != "" {
+			attachment.Text += "\n"
+		}
+		attachment.Text += strings.Join(lines, "\n")
+	}
+
 	var body []byte
 	var err error
 
@@ -97,13 +172,14 @@ func (s *SlackNotifier) Notify(ctx context.Context, stats Stats) error {
 		}
 	}
 
-	req, err := http.NewRequestWithContext(ctx, "POST", s.WebhookURL, bytes.NewBuffer(body))
-	if err != nil {
-		return err
-	}
-	req.Header.Set("Content-Type", "application/json")
-
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := doWithRetry(ctx, s.Client, func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", s.WebhookURL, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		return req, nil
+	})
 	if err != nil {
 		return err
 	}
@@ -125,17 +201,23 @@ func (s *SlackNotifier) renderTemplate(stats Stats) ([]byte, error) {
 	var buf bytes.Buffer
 	data := struct {
 		Stats
-		FormattedDuration string
+		FormattedDuration  string
+		FormattedReclaimed string
 	}{
-		Stats:             stats,
-		FormattedDuration: stats.Duration.Truncate(time.Second).String(),
+		Stats:              stats,
+		FormattedDuration:  stats.Duration.Truncate(time.Second).String(),
+		FormattedReclaimed: stats.Reclaimed(),
 	}
 
 	if err := tmpl.Execute(&buf, data); err != nil {
 		return nil, err
 	}
 
-	return buf.Bytes(), nil
+	// A template may render the whole payload; plain text becomes the message
+	if out := bytes.TrimSpace(buf.Bytes()); len(out) > 0 && out[0] == '{' && json.Valid(out) {
+		return buf.Bytes(), nil
+	}
+	return json.Marshal(map[string]string{"text": buf.String()})
 }
 
 func formatSize(b int64) string {
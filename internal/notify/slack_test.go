@@ -4,12 +4,15 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestFormatSize(t *testing.T) {
@@ -65,6 +68,32 @@ func TestSlackNotifier_Notify_Success(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestSlackNotifier_Notify_Started(t *testing.T) {
+	var att slackAttachment
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload slackPayload
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&payload))
+		if assert.Len(t, payload.Attachments, 1) {
+			att = payload.Attachments[0]
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	notifier := NewSlackNotifier(server.URL, "")
+	stats := Stats{Status: StatusStarted, Operation: "Backup", Engine: "postgres", Database: "testdb", FileName: "test.sql.lz4"}
+	require.NoError(t, notifier.Notify(context.Background(), stats))
+	assert.Equal(t, "#439fe0", att.Color)
+	assert.Equal(t, "▶️ Backup Started", att.Title)
+	assert.Len(t, att.Fields, 3, "no duration before the run has one")
+
+	// Sent after a delay, it says how long the run has been going
+	stats.Duration = time.Minute
+	require.NoError(t, notifier.Notify(context.Background(), stats))
+	require.Len(t, att.Fields, 4)
+	assert.Equal(t, "1m0s", att.Fields[3].Value)
+}
+
 func TestSlackNotifier_Notify_Error(t *testing.T) {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		var payload slackPayload
@@ -99,3 +128,142 @@ func TestSlackNotifier_EmptyURL(t *testing.T) {
 	err := notifier.Notify(context.Background(), Stats{Operation: "Test"})
 	assert.NoError(t, err) // Should silently return nil if no URL
 }
+
+func TestSlackNotifier_RetriesServerErrors(t *testing.T) {
+	retryBackoff = time.Millisecond
+	defer func() { retryBackoff = time.Second }()
+
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	err := NewSlackNotifier(server.URL, "").Notify(context.Background(), Stats{Operation: "Backup"})
+	assert.NoError(t, err)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+}
+
+func TestSlackNotifier_RespectsRetryAfter(t *testing.T) {
+	retryBackoff = time.Hour // must not be used when Retry-After is present
+	defer func() { retryBackoff = time.Second }()
+
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	err := NewSlackNotifier(server.URL, "").Notify(context.Background(), Stats{Operation: "Backup"})
+	assert.NoError(t, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+func TestSlackNotifier_GivesUpAfterMaxAttempts(t *testing.T) {
+	retryBackoff = time.Millisecond
+	defer func() { retryBackoff = time.Second }()
+
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	err := NewSlackNotifier(server.URL, "").Notify(context.Background(), Stats{Operation: "Backup"})
+	assert.Error(t, err)
+	assert.Equal(t, int32(maxAttempts), atomic.LoadInt32(&calls))
+}
+
+func TestWebhookNotifier_RetriesServerErrors(t *testing.T) {
+	retryBackoff = time.Millisecond
+	defer func() { retryBackoff = time.Second }()
+
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		assert.NotEmpty(t, body, "body must be replayed on every attempt")
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	err := NewWebhookNotifier(server.URL, "", "", nil).Notify(context.Background(), Stats{Operation: "Backup"})
+	assert.NoError(t, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+func TestSlackNotifier_Template(t *testing.T) {
+	var got atomic.Value
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		got.Store(string(body))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	stats := Stats{Status: StatusSuccess, Operation: "Backup", Database: "orders", Duration: 90*time.Second + 400*time.Millisecond}
+
+	// A full payload is sent as rendered
+	err := NewSlackNotifier(server.URL, `{"text": "{{.Operation}} of {{.Database}} took {{.FormattedDuration}}"}`).Notify(context.Background(), stats)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"text": "Backup of orders took 1m30s"}`, got.Load().(string))
+
+	// Plain text becomes the message, escaped
+	err = NewSlackNotifier(server.URL, `{{.Operation}} "{{.Database}}": {{.Status}}`).Notify(context.Background(), stats)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"text": "Backup \"orders\": success"}`, got.Load().(string))
+
+	err = NewSlackNotifier(server.URL, `{{.Nope`).Notify(context.Background(), stats)
+	assert.Error(t, err)
+}
+
+func TestSlackNotifier_Pruned(t *testing.T) {
+	var got atomic.Value
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		got.Store(string(body))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	stats := Stats{
+		Status:          StatusSuccess,
+		Operation:       "Backup",
+		Database:        "orders",
+		Pruned:          []string{"orders-1", "orders-2"},
+		ReclaimedBytes:  1572864,
+		ReclaimedChunks: 12,
+	}
+
+	err := NewSlackNotifier(server.URL, "").Notify(context.Background(), stats)
+	assert.NoError(t, err)
+	var payload slackPayload
+	assert.NoError(t, json.Unmarshal([]byte(got.Load().(string)), &payload))
+	fields := payload.Attachments[0].Fields
+	last := fields[len(fields)-1]
+	assert.Equal(t, "Pruned", last.Title)
+	assert.Equal(t, "orders-1, orders-2 (reclaimed 1.50 MB, 12 chunks)", last.Value)
+
+	stats.PruneDryRun = true
+	err = NewSlackNotifier(server.URL, "").Notify(context.Background(), stats)
+	assert.NoError(t, err)
+	assert.Contains(t, got.Load().(string), `"title":"Would prune"`)
+
+	// Templates see the list and the formatted totals
+	err = NewWebhookNotifier(server.URL, "", `{{len .Pruned}} pruned, {{.FormattedReclaimed}}`, nil).Notify(context.Background(), stats)
+	assert.NoError(t, err)
+	assert.Equal(t, "2 pruned, 1.50 MB, 12 chunks", got.Load().(string))
+}
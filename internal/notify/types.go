@@ -2,6 +2,8 @@ package notify
 
 import (
 	"context"
+	"fmt"
+	"strings"
 	"time"
 )
 
@@ -10,17 +12,55 @@ type Status string
 const (
 	StatusSuccess Status = "success"
 	StatusError   Status = "error"
+	StatusStarted Status = "started" // Sent when a run begins, before its outcome is known
 )
 
 type Stats struct {
-	Status    Status
-	Operation string // "Backup" or "Restore"
-	Engine    string
-	Database  string
-	FileName  string
-	Size      int64
-	Duration  time.Duration
-	Error     error
+	Status      Status
+	Operation   string // "Backup" or "Restore"
+	Engine      string
+	Database    string
+	FileName    string
+	Note        string // Operator comment attached to the backup
+	Size        int64  // Bytes written to storage
+	LogicalSize int64  // Bytes produced by the dump, before compression and encryption
+	Duration    time.Duration
+	Error       error
+	Details     []Stats // Per-task results when this is a batch summary
+
+	Pruned          []string // Old backups retention removed after this one
+	ReclaimedBytes  int64    // Stored size of the pruned backups that weren't deduplicated
+	ReclaimedChunks int64    // Dedupe chunks freed along with the pruned backups
+	PruneDryRun     bool     // Pruned lists what retention would have removed
+}
+
+// Ratio is LogicalSize over Size, or 0 when either is unknown.
+func (s Stats) Ratio() float64 {
+	if s.Size <= 0 || s.LogicalSize <= 0 {
+		return 0
+	}
+	return float64(s.LogicalSize) / float64(s.Size)
+}
+
+// Throughput is the logical dump rate in MB/s, or 0 when unknown.
+func (s Stats) Throughput() float64 {
+	if s.LogicalSize <= 0 || s.Duration <= 0 {
+		return 0
+	}
+	return float64(s.LogicalSize) / (1 << 20) / s.Duration.Seconds()
+}
+
+// Reclaimed describes what the pruned backups freed, e.g. "1.50 MB, 12
+// chunks", or "" when nothing is known.
+func (s Stats) Reclaimed() string {
+	var parts []string
+	if s.ReclaimedBytes > 0 {
+		parts = append(parts, formatSize(s.ReclaimedBytes))
+	}
+	if s.ReclaimedChunks > 0 {
+		parts = append(parts, fmt.Sprintf("%d chunks", s.ReclaimedChunks))
+	}
+	return strings.Join(parts, ", ")
 }
 
 type Notifier interface {
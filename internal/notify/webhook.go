@@ -15,6 +15,7 @@ type WebhookNotifier struct {
 	Method   string
 	Template string
 	Headers  map[string]string
+	Client   *http.Client
 }
 
 func NewWebhookNotifier(url, method, tmpl string, headers map[string]string) *WebhookNotifier {
@@ -26,6 +27,7 @@ func NewWebhookNotifier(url, method, tmpl string, headers map[string]string) *We
 		Method:   method,
 		Template: tmpl,
 		Headers:  headers,
+		Client:   defaultHTTPClient,
 	}
 }
 
@@ -47,17 +49,17 @@ func (n *WebhookNotifier) Notify(ctx context.Context, stats Stats) error {
 		body, _ = json.Marshal(stats)
 	}
 
-	req, err := http.NewRequestWithContext(ctx, n.Method, n.URL, bytes.NewBuffer(body))
-	if err != nil {
-		return err
-	}
-
-	req.Header.Set("Content-Type", "application/json")
-	for k, v := range n.Headers {
-		req.Header.Set(k, v)
-	}
-
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := doWithRetry(ctx, n.Client, func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, n.Method, n.URL, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		for k, v := range n.Headers {
+			req.Header.Set(k, v)
+		}
+		return req, nil
+	})
 	if err != nil {
 		return err
 	}
@@ -79,10 +81,12 @@ func (n *WebhookNotifier) renderTemplate(stats Stats) ([]byte, error) {
 	var buf bytes.Buffer
 	data := struct {
 		Stats
-		FormattedDuration string
+		FormattedDuration  string
+		FormattedReclaimed string
 	}{
-		Stats:             stats,
-		FormattedDuration: stats.Duration.Truncate(time.Second).String(),
+		Stats:              stats,
+		FormattedDuration:  stats.Duration.Truncate(time.Second).String(),
+		FormattedReclaimed: stats.Reclaimed(),
 	}
 
 	if err := tmpl.Execute(&buf, data); err != nil {
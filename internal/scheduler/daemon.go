@@ -0,0 +1,102 @@
+package scheduler
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const (
+	pidFileName    = "scheduler.pid"
+	LogFileName    = "scheduler.log" // Structured daemon logs
+	OutputFileName = "scheduler.out" // Raw daemon stdout/stderr (native tools, panics)
+)
+
+// PIDFilePath returns the location of the daemon pidfile in the state dir.
+func PIDFilePath() (string, error) {
+	dir, err := StateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, pidFileName), nil
+}
+
+// WritePIDFile records pid as the running daemon. It fails with an error
+// matching fs.ErrExist if a pidfile already exists, so of two daemons
+// starting at once only one gets to run.
+func WritePIDFile(pid int) error {
+	path, err := PIDFilePath()
+	if err != nil {
+		return err
+	}
+	// Write the pid aside and link it into place: the link fails if the
+	// pidfile exists, and the pidfile never exists without its contents
+	tmp := fmt.Sprintf("%s.%d.tmp", path, pid)
+	if err := os.WriteFile(tmp, []byte(strconv.Itoa(pid)+"\n"), 0600); err != nil {
+		return err
+	}
+	defer os.Remove(tmp) // #nosec G104
+	return os.Link(tmp, path)
+}
+
+// ReadPIDFile returns the pid recorded by the daemon, or 0 if no pidfile exists.
+func ReadPIDFile() (int, error) {
+	path, err := PIDFilePath()
+	if err != nil {
+		return 0, err
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0, fmt.Errorf("corrupt pidfile %s: %w", path, err)
+	}
+	return pid, nil
+}
+
+func RemovePIDFile() error {
+	path, err := PIDFilePath()
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// RunningPID returns the pid of a live scheduler daemon, or 0 if none is
+// running. Pidfiles left behind by a crashed daemon are removed.
+func RunningPID() (int, error) {
+	pid, err := ReadPIDFile()
+	if err != nil {
+		// Unreadable contents can only come from a broken write; treat as stale
+		return 0, RemovePIDFile()
+	}
+	if pid == 0 {
+		return 0, nil
+	}
+	if !processAlive(pid) {
+		return 0, RemovePIDFile()
+	}
+	return pid, nil
+}
+
+func processAlive(pid int) bool {
+	proc, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	err = proc.Signal(syscall.Signal(0))
+	// EPERM means the process exists but belongs to someone else
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
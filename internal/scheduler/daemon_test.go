@@ -0,0 +1,40 @@
+package scheduler
+
+import (
+	"io/fs"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunningPID(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	pid, err := RunningPID()
+	require.NoError(t, err)
+	assert.Zero(t, pid, "no pidfile means no daemon")
+
+	require.NoError(t, WritePIDFile(os.Getpid()))
+	pid, err = RunningPID()
+	require.NoError(t, err)
+	assert.Equal(t, os.Getpid(), pid)
+
+	// A second daemon can't claim the pidfile
+	assert.ErrorIs(t, WritePIDFile(os.Getpid()+1), fs.ErrExist)
+	pid, err = ReadPIDFile()
+	require.NoError(t, err)
+	assert.Equal(t, os.Getpid(), pid)
+	require.NoError(t, RemovePIDFile())
+
+	// A pid that cannot exist simulates a crashed daemon
+	require.NoError(t, WritePIDFile(1<<30))
+	pid, err = RunningPID()
+	require.NoError(t, err)
+	assert.Zero(t, pid)
+
+	path, err := PIDFilePath()
+	require.NoError(t, err)
+	assert.NoFileExists(t, path, "stale pidfile should be removed")
+}
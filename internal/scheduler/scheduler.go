@@ -3,14 +3,18 @@ package scheduler
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/lupppig/dbackup/internal/backup"
+	"github.com/lupppig/dbackup/internal/config"
 	"github.com/lupppig/dbackup/internal/db"
 	"github.com/lupppig/dbackup/internal/logger"
 	"github.com/lupppig/dbackup/internal/notify"
@@ -22,6 +26,7 @@ type TaskType string
 const (
 	BackupTask  TaskType = "backup"
 	RestoreTask TaskType = "restore"
+	DrillTask   TaskType = "drill" // Non-destructive verify-only restore of the latest backup
 )
 
 type TaskStatus string
@@ -45,12 +50,24 @@ type ScheduledTask struct {
 	LastRun   *time.Time `json:"last_run,omitempty"`
 	NextRun   *time.Time `json:"next_run,omitempty"`
 
+	// Outcome of the last run; Status is reset to pending on daemon start
+	LastResult TaskStatus `json:"last_result,omitempty"`
+	LastError  string     `json:"last_error,omitempty"`
+
 	// Options required to recreate the managers
 	Options TaskOptions `json:"options"`
 
 	cronID cron.EntryID
 }
 
+// Operation is the name used for the task in notifications.
+func (t *ScheduledTask) Operation() string {
+	if t.Type == DrillTask {
+		return "RestoreDrill"
+	}
+	return string(t.Type)
+}
+
 type TaskOptions struct {
 	DBType               string `json:"db_type"`
 	DBName               string `json:"db_name"`
@@ -61,11 +78,15 @@ type TaskOptions struct {
 	EncryptionKeyFile    string `json:"encryption_key_file,omitempty"`
 	EncryptionPassphrase string `json:"-"` // DO NOT STORE PASSPHRASE
 	ConfirmRestore       bool   `json:"confirm_restore"`
+	OnConflict           string `json:"on_conflict,omitempty"`
+	OverwriteExisting    bool   `json:"overwrite_existing,omitempty"`
 	Retries              int    `json:"retries"`
 	RetryDelay           string `json:"retry_delay"`
-	Verify               bool   `json:"verify"`
 	Retention            string `json:"retention,omitempty"`
 	Keep                 int    `json:"keep,omitempty"`
+	// ExtraArgs go to the engine's dump tool for backups and its restore
+	// client for restores
+	ExtraArgs []string `json:"extra_args,omitempty"`
 }
 
 type Scheduler struct {
@@ -75,25 +96,41 @@ type Scheduler struct {
 	dataDir  string
 	maxTasks int
 	running  int
+	logger   *logger.Logger
 }
 
-func NewScheduler() (*Scheduler, error) {
+// StateDir returns the directory holding scheduler state (schedules, logs),
+// creating it if needed.
+func StateDir() (string, error) {
 	home, err := os.UserHomeDir()
 	if err != nil {
-		return nil, err
+		return "", err
 	}
 	dir := filepath.Join(home, ".dbackup")
 	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+func NewScheduler() (*Scheduler, error) {
+	dir, err := StateDir()
+	if err != nil {
 		return nil, err
 	}
 
 	return &Scheduler{
-		cron:    cron.New(),
+		cron:    cron.New(cron.WithParser(specParser)),
 		tasks:   make(map[string]*ScheduledTask),
 		dataDir: dir,
 	}, nil
 }
 
+// SetLogger sets the logger used for task execution. Defaults to stderr.
+func (s *Scheduler) SetLogger(l *logger.Logger) {
+	s.logger = l
+}
+
 func (s *Scheduler) Start() {
 	s.cron.Start()
 }
@@ -135,26 +172,142 @@ func (s *Scheduler) AddTask(task *ScheduledTask) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	// Validate schedule - standard cron or @every
-	spec := task.Schedule
-	if !strings.HasPrefix(spec, "@") && strings.Count(spec, " ") < 4 {
-		// Possibly an interval like "24h", convert to @every
-		if _, err := time.ParseDuration(spec); err == nil {
-			spec = "@every " + spec
-		}
+	if err := s.scheduleLocked(task); err != nil {
+		return err
 	}
+	return s.saveLocked()
+}
 
-	id, err := s.cron.AddFunc(spec, func() {
-		s.executeTask(task.ID)
-	})
+// scheduleLocked registers task with cron and tracks it (caller must hold mu)
+func (s *Scheduler) scheduleLocked(task *ScheduledTask) error {
+	sched, err := ParseSchedule(task.Schedule)
 	if err != nil {
 		return fmt.Errorf("invalid schedule %q: %w", task.Schedule, err)
 	}
 
-	task.cronID = id
+	task.cronID = s.cron.Schedule(sched, cron.FuncJob(func() {
+		s.executeTask(task.ID)
+	}))
 	task.Status = StatusPending
 	s.tasks[task.ID] = task
-	return s.saveLocked()
+	return nil
+}
+
+// specParser accepts 5-field cron specs, 6-field ones with a leading seconds
+// field, and descriptors such as @daily and @every 90m.
+var specParser = cron.NewParser(cron.SecondOptional | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+
+// ParseSchedule parses a task schedule: a cron spec with optional seconds, a
+// descriptor, a bare interval such as "90m", or @reboot, which runs the task
+// once when the scheduler starts (or when it is added to a running one).
+func ParseSchedule(spec string) (cron.Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "@reboot" {
+		return &rebootSchedule{}, nil
+	}
+	if len(strings.Fields(spec)) == 1 && !strings.HasPrefix(spec, "@") {
+		d, err := time.ParseDuration(spec)
+		if err != nil {
+			return nil, fmt.Errorf("not a cron spec or interval: %w", err)
+		}
+		if d <= 0 {
+			return nil, errors.New("interval must be positive")
+		}
+		return cron.Every(d), nil
+	}
+	return specParser.Parse(spec)
+}
+
+// rebootSchedule fires once, the first time cron asks for its next run.
+type rebootSchedule struct {
+	fired bool
+}
+
+func (r *rebootSchedule) Next(t time.Time) time.Time {
+	if r.fired {
+		return time.Time{} // Never again
+	}
+	r.fired = true
+	return t
+}
+
+// Reload re-reads schedules.json and reconciles the cron entries with it
+// without restarting: new tasks are scheduled, deleted tasks are dropped and
+// tasks whose definition changed (schedule, URIs or options) are rescheduled
+// from the file. Unchanged tasks keep their runtime state.
+func (s *Scheduler) Reload() error {
+	onDisk := make(map[string]*ScheduledTask)
+	data, err := os.ReadFile(filepath.Join(s.dataDir, "schedules.json"))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &onDisk); err != nil {
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, t := range s.tasks {
+		if nt, ok := onDisk[id]; !ok || !sameDefinition(t, nt) {
+			s.cron.Remove(t.cronID)
+			delete(s.tasks, id)
+		}
+	}
+
+	var errs []error
+	for id, nt := range onDisk {
+		if _, ok := s.tasks[id]; ok {
+			continue
+		}
+		if err := s.scheduleLocked(nt); err != nil {
+			errs = append(errs, fmt.Errorf("task %s: %w", id, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// sameDefinition reports whether a and b run the same job on the same
+// schedule, ignoring run state such as LastRun. The passphrase is never
+// written to schedules.json, so it isn't compared either.
+func sameDefinition(a, b *ScheduledTask) bool {
+	ao, bo := a.Options, b.Options
+	ao.EncryptionPassphrase, bo.EncryptionPassphrase = "", ""
+	return a.Type == b.Type && a.Engine == b.Engine &&
+		a.SourceURI == b.SourceURI && a.TargetURI == b.TargetURI &&
+		a.Schedule == b.Schedule && reflect.DeepEqual(ao, bo)
+}
+
+// ReloadOnSignal calls Reload every time one of sigs is received, until ctx
+// is cancelled.
+func (s *Scheduler) ReloadOnSignal(ctx context.Context, sigs ...os.Signal) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, sigs...)
+
+	go func() {
+		defer signal.Stop(ch)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ch:
+				l := s.logger
+				if l == nil {
+					l = logger.New(logger.Config{})
+				}
+				if err := s.Reload(); err != nil {
+					l.Error("Failed to reload schedules", "error", err)
+					continue
+				}
+				s.mu.RLock()
+				count := len(s.tasks)
+				s.mu.RUnlock()
+				l.Info("Reloaded schedules", "task_count", count)
+			}
+		}
+	}()
 }
 
 // saveLocked saves tasks without acquiring a lock (caller must hold mu)
@@ -203,7 +356,10 @@ func (s *Scheduler) executeTask(id string) {
 		return
 	}
 
-	l := logger.New(logger.Config{})
+	l := s.logger
+	if l == nil {
+		l = logger.New(logger.Config{})
+	}
 
 	// Constraint: max-tasks
 	if maxTasks > 0 && running >= maxTasks {
@@ -225,10 +381,7 @@ func (s *Scheduler) executeTask(id string) {
 	s.mu.Unlock()
 	s.Save() // #nosec G104
 
-	var notifier notify.Notifier
-	if os.Getenv("SLACK_WEBHOOK") != "" {
-		notifier = notify.NewSlackNotifier(os.Getenv("SLACK_WEBHOOK"), "")
-	}
+	notifier := notify.Build(config.GetConfig(), os.Getenv("SLACK_WEBHOOK"), os.Getenv("SLACK_TEMPLATE"))
 
 	maxRetries := task.Options.Retries
 	retryDelay, _ := time.ParseDuration(task.Options.RetryDelay)
@@ -252,10 +405,12 @@ func (s *Scheduler) executeTask(id string) {
 	s.running--
 	if err != nil {
 		task.Status = StatusFailed
+		task.LastResult = StatusFailed
+		task.LastError = err.Error()
 		l.Error("Scheduled task failed after retries", "id", task.ID, "error", err)
 		if notifier != nil {
 			notifier.Notify(context.Background(), notify.Stats{ // #nosec G104
-				Operation: string(task.Type),
+				Operation: task.Operation(),
 				Engine:    task.Engine,
 				Database:  task.Options.DBName,
 				FileName:  task.Options.FileName,
@@ -265,10 +420,12 @@ func (s *Scheduler) executeTask(id string) {
 		}
 	} else {
 		task.Status = StatusSuccess
+		task.LastResult = StatusSuccess
+		task.LastError = ""
 		l.Info("Scheduled task succeeded", "id", task.ID)
 		if notifier != nil {
 			notifier.Notify(context.Background(), notify.Stats{ // #nosec G104
-				Operation: string(task.Type),
+				Operation: task.Operation(),
 				Engine:    task.Engine,
 				Database:  task.Options.DBName,
 				FileName:  task.Options.FileName,
@@ -284,18 +441,25 @@ func (s *Scheduler) runInternal(t *ScheduledTask, l *logger.Logger, n notify.Not
 	ctx := context.Background()
 
 	conn := db.ConnectionParams{
-		DBType: t.Options.DBType,
-		DBName: t.Options.DBName,
-		DBUri:  t.SourceURI,
+		DBType:    t.Options.DBType,
+		DBName:    t.Options.DBName,
+		DBUri:     t.SourceURI,
+		ExtraArgs: t.Options.ExtraArgs,
 	}
-	if t.Type == RestoreTask {
+	switch t.Type {
+	case RestoreTask:
 		conn.DBUri = t.TargetURI
+		conn.OnConflict = t.Options.OnConflict
+		conn.OverwriteExisting = t.Options.OverwriteExisting
+	case DrillTask:
+		conn.DBUri = "" // Nothing is applied, so there is no target database
 	}
 
 	if err := conn.ParseURI(); err != nil {
 		return err
 	}
 
+	notes := config.GetConfig().Notifications
 	opts := backup.BackupOptions{
 		DBType:               t.Options.DBType,
 		DBName:               t.Options.DBName,
@@ -308,8 +472,11 @@ func (s *Scheduler) runInternal(t *ScheduledTask, l *logger.Logger, n notify.Not
 		EncryptionKeyFile:    t.Options.EncryptionKeyFile,
 		EncryptionPassphrase: os.Getenv("DBACKUP_KEY"),
 		ConfirmRestore:       t.Options.ConfirmRestore,
+		TmpDir:               os.Getenv("DBACKUP_TMP"),
 		Logger:               l,
 		Notifier:             n,
+		NotifyOnStart:        notes.NotifyOnStart || notes.NotifyStartAfter > 0,
+		NotifyStartAfter:     notes.NotifyStartAfter,
 	}
 
 	if t.Options.Retention != "" {
@@ -325,9 +492,13 @@ func (s *Scheduler) runInternal(t *ScheduledTask, l *logger.Logger, n notify.Not
 	}
 	opts.Keep = t.Options.Keep
 
-	if t.Type == RestoreTask {
+	if t.Type == RestoreTask || t.Type == DrillTask {
 		opts.StorageURI = t.SourceURI
 	}
+	if t.Type == DrillTask {
+		opts.VerifyOnly = true
+		opts.Notifier = nil // executeTask reports the drill as "RestoreDrill"
+	}
 
 	var adapter db.DBAdapter
 	switch strings.ToLower(conn.DBType) {
@@ -337,6 +508,8 @@ func (s *Scheduler) runInternal(t *ScheduledTask, l *logger.Logger, n notify.Not
 		adapter = &db.MysqlAdapter{}
 	case "sqlite":
 		adapter = &db.SqliteAdapter{}
+	case "cassandra", "scylla":
+		adapter = &db.CassandraAdapter{}
 	default:
 		return fmt.Errorf("unsupported database: %s", conn.DBType)
 	}
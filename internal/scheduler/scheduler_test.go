@@ -1,9 +1,13 @@
 package scheduler
 
 import (
+	"context"
+	"encoding/json"
 	"os"
 	"path/filepath"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -41,3 +45,122 @@ func TestScheduler_Core(t *testing.T) {
 	require.NoError(t, err)
 	assert.Len(t, s2.ListTasks(), 1)
 }
+
+func TestScheduler_ReloadOnSIGHUP(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	s, err := NewScheduler()
+	require.NoError(t, err)
+	defer func() { <-s.Stop().Done() }()
+
+	require.NoError(t, s.AddTask(&ScheduledTask{ID: "old", Type: BackupTask, Schedule: "@daily"}))
+	require.NoError(t, s.AddTask(&ScheduledTask{ID: "edited", Type: BackupTask, Schedule: "@daily", TargetURI: "/a"}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s.ReloadOnSignal(ctx, syscall.SIGHUP)
+
+	// Another process rewrites schedules.json: "old" removed, "new" added and
+	// "edited" given another target and options on the same schedule
+	onDisk := map[string]*ScheduledTask{
+		"new":    {ID: "new", Type: BackupTask, Schedule: "1h"},
+		"edited": {ID: "edited", Type: BackupTask, Schedule: "@daily", TargetURI: "/b", Options: TaskOptions{Keep: 3}},
+	}
+	data, err := json.Marshal(onDisk)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(s.dataDir, "schedules.json"), data, 0600))
+
+	require.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGHUP))
+
+	assert.Eventually(t, func() bool {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		edited, ok := s.tasks["edited"]
+		return len(s.tasks) == 2 && s.tasks["new"] != nil && ok && edited.TargetURI == "/b" && edited.Options.Keep == 3
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.Len(t, s.cron.Entries(), 2)
+}
+
+func TestScheduler_DrillRecordsResult(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	s, err := NewScheduler()
+	require.NoError(t, err)
+	defer func() { <-s.Stop().Done() }()
+
+	// No backup exists at the source, so the drill must fail and say why
+	task := &ScheduledTask{
+		ID:        "drill",
+		Type:      DrillTask,
+		SourceURI: t.TempDir(),
+		Schedule:  "@daily",
+		Options:   TaskOptions{DBType: "sqlite", RetryDelay: "1ms"},
+	}
+	require.NoError(t, s.AddTask(task))
+	assert.Equal(t, "RestoreDrill", task.Operation())
+
+	s.executeTask(task.ID)
+
+	tasks := s.ListTasks()
+	require.Len(t, tasks, 1)
+	assert.Equal(t, StatusFailed, tasks[0].LastResult)
+	assert.NotEmpty(t, tasks[0].LastError)
+	assert.NotNil(t, tasks[0].LastRun)
+}
+
+func TestParseSchedule(t *testing.T) {
+	base := time.Date(2026, 1, 1, 10, 0, 10, 0, time.UTC)
+
+	// Six fields: the first is seconds
+	sched, err := ParseSchedule("*/30 * * * * *")
+	require.NoError(t, err)
+	assert.Equal(t, base.Add(20*time.Second), sched.Next(base))
+
+	// Five fields still mean minute precision
+	sched, err = ParseSchedule("*/5 * * * *")
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2026, 1, 1, 10, 5, 0, 0, time.UTC), sched.Next(base))
+
+	// A bare duration is an interval
+	sched, err = ParseSchedule("90m")
+	require.NoError(t, err)
+	assert.Equal(t, base.Add(90*time.Minute), sched.Next(base))
+
+	sched, err = ParseSchedule("@every 90m")
+	require.NoError(t, err)
+	assert.Equal(t, base.Add(90*time.Minute), sched.Next(base))
+
+	// @reboot runs once, straight away
+	sched, err = ParseSchedule("@reboot")
+	require.NoError(t, err)
+	assert.Equal(t, base, sched.Next(base))
+	assert.True(t, sched.Next(base.Add(time.Hour)).IsZero())
+
+	for _, bad := range []string{"", "-5m", "soon", "* * *", "@hourly-ish"} {
+		_, err := ParseSchedule(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestScheduler_RebootRunsOnStart(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	s, err := NewScheduler()
+	require.NoError(t, err)
+	defer func() { <-s.Stop().Done() }()
+
+	task := &ScheduledTask{
+		ID:        "boot",
+		Type:      DrillTask,
+		SourceURI: t.TempDir(),
+		Schedule:  "@reboot",
+		Options:   TaskOptions{DBType: "sqlite", RetryDelay: "1ms"},
+	}
+	require.NoError(t, s.AddTask(task))
+	s.Start()
+
+	assert.Eventually(t, func() bool {
+		return !s.cron.Entry(task.cronID).Prev.IsZero()
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.True(t, s.cron.Entry(task.cronID).Next.IsZero(), "@reboot must not run again")
+}
@@ -92,6 +92,30 @@ func (s *AuditStorage) Save(ctx context.Context, name string, r io.Reader) (stri
 	return loc, err
 }
 
+// auditFile logs a file written through CreateFile once it is committed or
+// aborted, as Save logs its result.
+type auditFile struct {
+	FileWriter
+	ctx  context.Context
+	s    *AuditStorage
+	name string
+}
+
+func (f *auditFile) Commit() (string, error) {
+	loc, err := f.FileWriter.Commit()
+	status := "success"
+	if err != nil {
+		status = "error: " + err.Error()
+	}
+	f.s.log(f.ctx, "SAVE", f.name, status, "")
+	return loc, err
+}
+
+func (f *auditFile) Abort() {
+	f.FileWriter.Abort()
+	f.s.log(f.ctx, "SAVE", f.name, "error: aborted", "")
+}
+
 func (s *AuditStorage) Open(ctx context.Context, name string) (io.ReadCloser, error) {
 	rc, err := s.inner.Open(ctx, name)
 	status := "success"
@@ -106,6 +130,10 @@ func (s *AuditStorage) Exists(ctx context.Context, name string) (bool, error) {
 	return s.inner.Exists(ctx, name)
 }
 
+func (s *AuditStorage) Stat(ctx context.Context, name string) (StorageInfo, error) {
+	return s.inner.Stat(ctx, name)
+}
+
 func (s *AuditStorage) Delete(ctx context.Context, name string) error {
 	err := s.inner.Delete(ctx, name)
 	status := "success"
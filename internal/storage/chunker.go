@@ -3,6 +3,9 @@ package storage
 import (
 	"bufio"
 	"io"
+	"math/bits"
+
+	apperrors "github.com/lupppig/dbackup/internal/errors"
 )
 
 const (
@@ -79,6 +82,42 @@ var gear = [256]uint64{
 	0x6efebc314aef9fd5, 0x85081a33cc5a0e89, 0x774d6226ce259c35, 0xc645ee032ad5c172,
 }
 
+// Chunking algorithms for DedupeOptions.Algorithm. Each cuts the same data
+// at different points, so backups only deduplicate against chunks written
+// with the same algorithm.
+const (
+	ChunkerGear    = "gear"    // The original Gear chunker; the default, as existing chunks were cut with it
+	ChunkerFastCDC = "fastcdc" // FastCDC with normalized chunking, for more even chunk sizes
+)
+
+// Splitter cuts a stream into content-defined chunks. Next returns io.EOF
+// once the stream is exhausted.
+type Splitter interface {
+	Next() ([]byte, error)
+}
+
+// ValidateChunker checks a --dedupe-algo value; empty means ChunkerGear.
+func ValidateChunker(algo string) error {
+	switch algo {
+	case "", ChunkerGear, ChunkerFastCDC:
+		return nil
+	}
+	return apperrors.New(apperrors.TypeConfig, "unknown chunking algorithm: "+algo, "Use "+ChunkerGear+" or "+ChunkerFastCDC+".")
+}
+
+// NewSplitter returns the chunker for algo, see ValidateChunker.
+func NewSplitter(algo string, r io.Reader) (Splitter, error) {
+	switch algo {
+	case "", ChunkerGear:
+		return NewChunker(r), nil
+	case ChunkerFastCDC:
+		return NewFastCDC(r), nil
+	}
+	return nil, ValidateChunker(algo)
+}
+
+// Chunker is the Gear chunker. Its cut points decide the IDs of every chunk
+// already stored, so it must not change.
 type Chunker struct {
 	r *bufio.Reader
 }
@@ -121,3 +160,66 @@ func (c *Chunker) Next() ([]byte, error) {
 
 	return buf, nil
 }
+
+// FastCDC masks, from Xia et al., "FastCDC: a Fast and Efficient
+// Content-Defined Chunking Approach for Data Deduplication". Below
+// avgChunkSize the stricter fastcdcMaskS makes a cut unlikely, above it the
+// looser fastcdcMaskL makes one likely, which draws sizes toward the average.
+// Their bits are spread over the top of the hash, where each bit depends on
+// more of the preceding bytes.
+var (
+	fastcdcMaskS = spreadMask(bits.Len(avgChunkSize) - 1 + 2)
+	fastcdcMaskL = spreadMask(bits.Len(avgChunkSize) - 1 - 2)
+)
+
+// spreadMask returns a mask of n bits spread evenly over the top 48 bits of
+// a uint64.
+func spreadMask(n int) uint64 {
+	var m uint64
+	for i := 0; i < n; i++ {
+		m |= 1 << (63 - i*48/n)
+	}
+	return m
+}
+
+// FastCDC is the FastCDC chunker. It uses the Gear table and the same size
+// limits as Chunker, but skips hashing below minChunkSize and normalizes
+// chunk sizes around avgChunkSize.
+type FastCDC struct {
+	r *bufio.Reader
+}
+
+func NewFastCDC(r io.Reader) *FastCDC {
+	return &FastCDC{r: bufio.NewReaderSize(r, maxChunkSize)}
+}
+
+// Next returns the next content-defined chunk.
+func (c *FastCDC) Next() ([]byte, error) {
+	buf := make([]byte, minChunkSize, avgChunkSize*2)
+	n, err := io.ReadFull(c.r, buf)
+	if err != nil {
+		if n > 0 {
+			return buf[:n], nil
+		}
+		return nil, err
+	}
+
+	var hash uint64
+	for len(buf) < maxChunkSize {
+		b, err := c.r.ReadByte()
+		if err != nil {
+			return buf, nil
+		}
+		buf = append(buf, b)
+		hash = (hash << 1) + gear[b]
+
+		mask := fastcdcMaskL
+		if len(buf) < avgChunkSize {
+			mask = fastcdcMaskS
+		}
+		if hash&mask == 0 {
+			break
+		}
+	}
+	return buf, nil
+}
@@ -3,7 +3,10 @@ package storage
 import (
 	"bytes"
 	"crypto/rand"
+	"fmt"
 	"io"
+	"math"
+	mrand "math/rand/v2"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -75,3 +78,121 @@ func TestChunker_DataIntegrity(t *testing.T) {
 
 	assert.Equal(t, data, reconstructed)
 }
+
+// chunkStats splits data with algo and returns the chunks' coefficient of
+// variation (standard deviation over mean size) and their set.
+func chunkStats(t testing.TB, algo string, data []byte) (float64, map[string]int) {
+	sp, err := NewSplitter(algo, bytes.NewReader(data))
+	require.NoError(t, err)
+	set := make(map[string]int)
+	var sizes []float64
+	var total []byte
+	for {
+		chunk, err := sp.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		set[string(chunk)] = len(chunk)
+		sizes = append(sizes, float64(len(chunk)))
+		total = append(total, chunk...)
+	}
+	require.Equal(t, data, total, "%s lost or reordered bytes", algo)
+
+	var mean, sq float64
+	for _, s := range sizes {
+		mean += s
+	}
+	mean /= float64(len(sizes))
+	for _, s := range sizes {
+		sq += (s - mean) * (s - mean)
+	}
+	return math.Sqrt(sq/float64(len(sizes))) / mean, set
+}
+
+// editedStreams returns 32MB of pseudo-random data and a copy with small
+// inserts and overwrites scattered through it, like two dumps of a
+// database between which some rows changed.
+func editedStreams() ([]byte, []byte) {
+	rng := mrand.New(mrand.NewPCG(1, 2))
+	base := make([]byte, 32<<20)
+	for i := range base {
+		base[i] = byte(rng.Uint32())
+	}
+	edited := bytes.Clone(base)
+	for i := 0; i < 64; i++ {
+		at := rng.IntN(len(edited))
+		edit := []byte(fmt.Sprintf("edit %d", i))
+		if i%2 == 0 {
+			edited = append(edited[:at], append(edit, edited[at:]...)...)
+		} else {
+			copy(edited[at:], edit)
+		}
+	}
+	return base, edited
+}
+
+func TestChunkers_FastCDCvsGear(t *testing.T) {
+	if testing.Short() {
+		t.Skip("chunks 64MB per algorithm")
+	}
+	base, edited := editedStreams()
+
+	cv := make(map[string]float64)
+	ratio := make(map[string]float64)
+	for _, algo := range []string{ChunkerGear, ChunkerFastCDC} {
+		var before, after map[string]int
+		cv[algo], before = chunkStats(t, algo, base)
+		_, after = chunkStats(t, algo, edited)
+
+		// Share of the edited stream's bytes already stored by the first
+		var shared, total int
+		for c, n := range after {
+			total += n
+			if _, ok := before[c]; ok {
+				shared += n
+			}
+		}
+		ratio[algo] = float64(shared) / float64(total)
+		t.Logf("%-8s chunks %d, size CV %.3f, dedup %.1f%%", algo, len(before), cv[algo], ratio[algo]*100)
+	}
+
+	assert.Less(t, cv[ChunkerFastCDC], cv[ChunkerGear], "FastCDC should cut more even chunks")
+	// FastCDC's chunks are larger on average, so each edit invalidates more
+	// bytes; both must still keep most of the stream
+	assert.Greater(t, ratio[ChunkerFastCDC], 0.8)
+	assert.Greater(t, ratio[ChunkerGear], 0.8)
+}
+
+// Chunk IDs on every existing target depend on where Gear cuts, so its cut
+// points are pinned.
+func TestChunker_GearCutPointsStable(t *testing.T) {
+	rng := mrand.New(mrand.NewPCG(3, 4))
+	data := make([]byte, 1<<20)
+	for i := range data {
+		data[i] = byte(rng.Uint32())
+	}
+	var sizes []int
+	for _, c := range collectChunks(t, data) {
+		sizes = append(sizes, len(c))
+	}
+	assert.Equal(t, []int{71432, 46924, 44575, 53147, 41508, 43567, 49648, 38744, 63532, 33138, 72043,
+		49409, 35649, 40473, 38912, 50178, 42885, 103167, 35874, 70738, 23033}, sizes)
+}
+
+func BenchmarkChunkers(b *testing.B) {
+	data, _ := editedStreams()
+	for _, algo := range []string{ChunkerGear, ChunkerFastCDC} {
+		b.Run(algo, func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			for b.Loop() {
+				sp, _ := NewSplitter(algo, bytes.NewReader(data))
+				for {
+					if _, err := sp.Next(); err != nil {
+						break
+					}
+				}
+			}
+		})
+	}
+}
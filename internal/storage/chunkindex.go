@@ -0,0 +1,100 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// ChunkIndex is a local SQLite cache of the chunks known to exist on each
+// deduplicated target, keyed by the target's Location. It only ever answers
+// "present": a miss falls through to the remote Exists.
+//
+// Every entry of a target belongs to the chunk generation it was recorded
+// under (see DedupeStorage.generation). Anything that deletes chunks moves
+// the target to a new generation, which empties the target's entries the
+// next time the index is used against it.
+type ChunkIndex struct {
+	db *sql.DB
+}
+
+// OpenChunkIndex opens the index at path, creating it if needed.
+func OpenChunkIndex(path string) (*ChunkIndex, error) {
+	// Several processes (the daemon, dump workers) may share the file
+	db, err := sql.Open("sqlite3", path+"?_busy_timeout=5000&_journal_mode=WAL")
+	if err != nil {
+		return nil, fmt.Errorf("open chunk index: %w", err)
+	}
+	_, err = db.Exec(`
+CREATE TABLE IF NOT EXISTS targets (target TEXT PRIMARY KEY, generation TEXT NOT NULL);
+CREATE TABLE IF NOT EXISTS chunks (target TEXT NOT NULL, hash TEXT NOT NULL, PRIMARY KEY (target, hash)) WITHOUT ROWID;`)
+	if err != nil {
+		db.Close() // #nosec G104
+		return nil, fmt.Errorf("open chunk index %s: %w", path, err)
+	}
+	return &ChunkIndex{db: db}, nil
+}
+
+// Has reports whether hash is recorded as present on target.
+func (x *ChunkIndex) Has(ctx context.Context, target, hash string) (bool, error) {
+	var one int
+	err := x.db.QueryRowContext(ctx, `SELECT 1 FROM chunks WHERE target = ? AND hash = ?`, target, hash).Scan(&one)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Add records hashes as present on target.
+func (x *ChunkIndex) Add(ctx context.Context, target string, hashes []string) error {
+	tx, err := x.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() // #nosec G104
+
+	stmt, err := tx.PrepareContext(ctx, `INSERT OR IGNORE INTO chunks (target, hash) VALUES (?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, h := range hashes {
+		if _, err := stmt.ExecContext(ctx, target, h); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Generation returns the chunk generation target's entries were recorded
+// under; ok is false if the index knows nothing about target.
+func (x *ChunkIndex) Generation(ctx context.Context, target string) (gen string, ok bool, err error) {
+	err = x.db.QueryRowContext(ctx, `SELECT generation FROM targets WHERE target = ?`, target).Scan(&gen)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	}
+	return gen, err == nil, err
+}
+
+// Reset forgets every chunk of target and starts recording under generation.
+func (x *ChunkIndex) Reset(ctx context.Context, target, generation string) error {
+	tx, err := x.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() // #nosec G104
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM chunks WHERE target = ?`, target); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT OR REPLACE INTO targets (target, generation) VALUES (?, ?)`, target, generation); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (x *ChunkIndex) Close() error {
+	return x.db.Close()
+}
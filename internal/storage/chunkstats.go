@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/lupppig/dbackup/internal/manifest"
+)
+
+// ChunkStats describes how a deduplicated backup's chunks are shared, within
+// the backup and with the backup of the same database before it.
+type ChunkStats struct {
+	Chunks       int   `json:"chunks"`         // Chunks the backup is made of
+	Unique       int   `json:"unique"`         // Distinct chunks among them
+	AvgChunkSize int64 `json:"avg_chunk_size"` // Stored size over chunk count; 0 when the size is unknown
+
+	Previous string `json:"previous,omitempty"` // File of the prior backup of the database; empty for the first
+	Shared   int    `json:"shared"`             // Distinct chunks the prior backup also has
+	New      int    `json:"new"`                // Distinct chunks the prior backup doesn't have
+	NewBytes int64  `json:"new_bytes"`          // New chunks times AvgChunkSize, an estimate of the delta
+}
+
+// CompareChunks computes the ChunkStats of m against prev, which may be nil.
+func CompareChunks(m, prev *manifest.Manifest) ChunkStats {
+	st := ChunkStats{Chunks: len(m.Chunks)}
+	if st.Chunks > 0 {
+		st.AvgChunkSize = m.Size / int64(st.Chunks)
+	}
+
+	before := make(map[string]bool)
+	if prev != nil {
+		st.Previous = prev.FileName
+		for _, c := range prev.Chunks {
+			before[c] = true
+		}
+	}
+
+	seen := make(map[string]bool, len(m.Chunks))
+	for _, c := range m.Chunks {
+		if seen[c] {
+			continue
+		}
+		seen[c] = true
+		if before[c] {
+			st.Shared++
+		} else {
+			st.New++
+		}
+	}
+	st.Unique = len(seen)
+	st.NewBytes = int64(st.New) * st.AvgChunkSize
+	return st
+}
+
+// ChunkStats compares the chunks of m with those of the newest earlier
+// backup of the same engine and database on the target. Manifests that
+// can't be read are passed over; pruned ones have no chunks to compare.
+func (s *DedupeStorage) ChunkStats(ctx context.Context, m *manifest.Manifest) (ChunkStats, error) {
+	files, err := s.inner.ListMetadata(ctx, "")
+	if err != nil {
+		return ChunkStats{}, fmt.Errorf("list manifests: %w", err)
+	}
+
+	var prev *manifest.Manifest
+	for _, f := range files {
+		if !strings.HasSuffix(f, ".manifest") || manifest.IsLatest(f) {
+			continue
+		}
+		data, err := s.inner.GetMetadata(ctx, f)
+		if err != nil {
+			continue
+		}
+		other, err := manifest.Deserialize(data)
+		if err != nil || other.Pruned || other.ID == m.ID {
+			continue
+		}
+		if other.Engine != m.Engine || other.DBName != m.DBName || !other.CreatedAt.Before(m.CreatedAt) {
+			continue
+		}
+		if prev == nil || other.CreatedAt.After(prev.CreatedAt) {
+			prev = other
+		}
+	}
+	return CompareChunks(m, prev), nil
+}
@@ -0,0 +1,61 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/lupppig/dbackup/internal/manifest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompareChunks(t *testing.T) {
+	m := &manifest.Manifest{FileName: "b", Size: 400, Chunks: []string{"a", "b", "a", "c"}}
+
+	st := CompareChunks(m, nil)
+	assert.Equal(t, ChunkStats{Chunks: 4, Unique: 3, AvgChunkSize: 100, New: 3, NewBytes: 300}, st)
+
+	prev := &manifest.Manifest{FileName: "a", Chunks: []string{"a", "x"}}
+	st = CompareChunks(m, prev)
+	assert.Equal(t, "a", st.Previous)
+	assert.Equal(t, 1, st.Shared)
+	assert.Equal(t, 2, st.New)
+	assert.Equal(t, int64(200), st.NewBytes)
+}
+
+func TestDedupeStorage_ChunkStats(t *testing.T) {
+	ctx := context.Background()
+	ds := NewDedupeStorage(NewLocalStorage(t.TempDir()))
+	now := time.Now()
+
+	put := func(m *manifest.Manifest) {
+		data, err := m.Serialize()
+		require.NoError(t, err)
+		require.NoError(t, ds.PutMetadata(ctx, m.FileName+".manifest", data))
+	}
+	backup := func(id, file, db string, at time.Time, chunks ...string) *manifest.Manifest {
+		m := &manifest.Manifest{ID: id, FileName: file, Engine: "postgres", DBName: db, CreatedAt: at, Chunks: chunks}
+		put(m)
+		return m
+	}
+
+	backup("1", "old.sql", "app", now.Add(-2*time.Hour), "a")
+	backup("2", "prev.sql", "app", now.Add(-time.Hour), "a", "b")
+	backup("3", "other.sql", "shop", now.Add(-time.Minute), "a", "b", "c")
+	pruned := &manifest.Manifest{ID: "4", FileName: "pruned.sql", Engine: "postgres", DBName: "app", CreatedAt: now.Add(-time.Minute), Chunks: []string{"c"}}
+	put(pruned.Tombstone(now))
+	cur := backup("5", "cur.sql", "app", now, "a", "b", "c")
+	backup("6", "next.sql", "app", now.Add(time.Hour), "a", "b", "c")
+
+	st, err := ds.ChunkStats(ctx, cur)
+	require.NoError(t, err)
+	assert.Equal(t, "prev.sql", st.Previous, "newest earlier live backup of the same database")
+	assert.Equal(t, 2, st.Shared)
+	assert.Equal(t, 1, st.New)
+
+	first, err := ds.ChunkStats(ctx, &manifest.Manifest{ID: "0", Engine: "postgres", DBName: "app", CreatedAt: now.Add(-3 * time.Hour), Chunks: []string{"a"}})
+	require.NoError(t, err)
+	assert.Empty(t, first.Previous)
+	assert.Equal(t, 1, first.New)
+}
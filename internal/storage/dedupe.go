@@ -3,44 +3,124 @@ package storage
 import (
 	"bytes"
 	"context"
+	"crypto/rand"
 	"crypto/sha256"
 	"encoding/binary"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	mrand "math/rand/v2"
 	"path/filepath"
 	"runtime"
 	"strings"
 	"sync"
+	"sync/atomic"
 
+	"github.com/lupppig/dbackup/internal/crypto"
 	"github.com/lupppig/dbackup/internal/manifest"
 )
 
+// DedupeOptions configures optional DedupeStorage behaviour.
+type DedupeOptions struct {
+	// Encrypt chunks the plaintext and stores every chunk encrypted with a key
+	// derived from its content and the KeyManager's master key (convergent
+	// encryption). Identical chunks still deduplicate, at the cost of revealing
+	// to anyone holding the storage that two backups share a chunk.
+	Encrypt    bool
+	KeyManager *crypto.KeyManager
+
+	// OnChunk is called after each chunk of a Save, in stream order. total
+	// counts the chunks so far; uploaded and bytes cover only the ones that
+	// weren't already stored, so total-uploaded are deduplication hits.
+	OnChunk func(uploaded, total int, bytes int64)
+
+	// Index, if set, is consulted before the remote Exists of each chunk and
+	// learns the chunks of every successful Save. It is closed with the
+	// DedupeStorage.
+	Index *ChunkIndex
+
+	// Algorithm picks the chunker, ChunkerGear (the default) or
+	// ChunkerFastCDC. Chunks cut by different algorithms rarely match, so a
+	// target should stick to one.
+	Algorithm string
+}
+
+// generationFile holds a random ID that changes whenever chunks are deleted
+// from the target, so a ChunkIndex can tell its entries went stale.
+const generationFile = "chunks.gen"
+
+// DedupeStorage stores backups as content-addressed chunks under chunks/.
+// The namespace is shared by every backup on the target, whatever its engine
+// or database, so identical data deduplicates across databases. The price is
+// that a chunk can only be freed after reading every manifest on the target:
+// Delete and GC fail closed and keep chunks when any manifest can't be read.
 type DedupeStorage struct {
 	inner      Storage
+	opts       DedupeOptions
 	lastChunks []string
+	reclaimed  atomic.Int64
 }
 
 func NewDedupeStorage(inner Storage) *DedupeStorage {
 	return &DedupeStorage{inner: inner}
 }
 
+func NewDedupeStorageWithOptions(inner Storage, opts DedupeOptions) (*DedupeStorage, error) {
+	if opts.Encrypt && opts.KeyManager == nil {
+		return nil, fmt.Errorf("chunk encryption requires a key manager")
+	}
+	if err := ValidateChunker(opts.Algorithm); err != nil {
+		return nil, err
+	}
+	return &DedupeStorage{inner: inner, opts: opts}, nil
+}
+
+// ReclaimedChunks counts the orphaned chunks Delete has removed so far.
+func (s *DedupeStorage) ReclaimedChunks() int64 {
+	return s.reclaimed.Load()
+}
+
+// ChunkEncryption returns the scheme new chunks are encrypted with, or "" if
+// chunks are stored in the clear.
+func (s *DedupeStorage) ChunkEncryption() string {
+	if s.opts.Encrypt {
+		return crypto.ConvergentScheme
+	}
+	return ""
+}
+
+// SetOnChunk replaces the per-chunk progress callback, see DedupeOptions.OnChunk.
+func (s *DedupeStorage) SetOnChunk(fn func(uploaded, total int, bytes int64)) {
+	s.opts.OnChunk = fn
+}
+
+// SetKeyManager provides the key used to read chunk-encrypted backups.
+func (s *DedupeStorage) SetKeyManager(km *crypto.KeyManager) {
+	s.opts.KeyManager = km
+}
+
 func (s *DedupeStorage) LastChunks() []string {
 	return s.lastChunks
 }
 
 func (s *DedupeStorage) Save(ctx context.Context, name string, r io.Reader) (string, error) {
-	chunker := NewChunker(r)
+	chunker, err := NewSplitter(s.opts.Algorithm, r)
+	if err != nil {
+		return "", err
+	}
 	s.lastChunks = nil
+	indexed := s.useIndex(ctx)
 
-	const stripeSize = 10
 	var stripe [][]byte
+	var stripeHashes []string
 
 	type chunkResult struct {
-		id   int
-		data []byte
-		hash string
-		err  error
+		id       int
+		data     []byte
+		hash     string
+		uploaded bool
+		err      error
 	}
 
 	numWorkers := runtime.NumCPU()
@@ -58,8 +138,16 @@ func (s *DedupeStorage) Save(ctx context.Context, name string, r io.Reader) (str
 	resultMap := make(map[int]chunkResult)
 	nextID := 0
 	processID := 0
+	uploaded := 0
+	var uploadedBytes int64
 	feederDone := make(chan bool)
 
+	// Hashes already checked or written during this Save; concurrent workers
+	// hitting the same hash wait for the first one instead of racing it
+	var seen sync.Map
+	var partialErr *PartialError
+	var partialOnce sync.Once
+
 	var wg sync.WaitGroup
 	var workerErr error
 	var errOnce sync.Once
@@ -73,18 +161,49 @@ func (s *DedupeStorage) Save(ctx context.Context, name string, r io.Reader) (str
 		go func() {
 			defer wg.Done()
 			for job := range jobs {
-				hash := sha256.Sum256(job.data)
-				hashStr := hex.EncodeToString(hash[:])
-
-				// Check and Save if not exists
-				chunkPath := "chunks/" + hashStr
-				exists, err := s.inner.Exists(ctx, chunkPath)
-				if err == nil && !exists {
-					_, err = s.inner.Save(ctx, chunkPath, bytes.NewReader(job.data))
+				hashStr, data, err := s.sealChunk(job.data)
+
+				// Check and Save if not exists, once per hash per stream
+				uploaded := false
+				if err == nil {
+					v, _ := seen.LoadOrStore(hashStr, &chunkState{})
+					st := v.(*chunkState)
+					st.once.Do(func() {
+						chunkPath := "chunks/" + hashStr
+						var exists bool
+						if indexed {
+							// A lookup error only costs the remote check
+							exists, _ = s.opts.Index.Has(ctx, s.inner.Location(), hashStr)
+						}
+						if !exists {
+							exists, err = s.inner.Exists(ctx, chunkPath)
+						}
+						if err == nil && !exists {
+							_, err = s.inner.Save(ctx, chunkPath, bytes.NewReader(data))
+							uploaded = true
+							// A chunk's content is fixed by its name, so losing a
+							// race to another backup writing it is success
+							var pe *PartialError
+							if err != nil && !errors.As(err, &pe) && ctx.Err() == nil {
+								if ok, _ := s.inner.Exists(ctx, chunkPath); ok {
+									err, uploaded = nil, false
+								}
+							}
+						}
+						// A chunk that reached some of several targets is not fatal
+						var pe *PartialError
+						if errors.As(err, &pe) {
+							partialOnce.Do(func() { partialErr = pe })
+							err = nil
+						}
+						st.err = err
+					})
+					err = st.err
 				}
+				job.data = data
 
 				select {
-				case results <- chunkResult{id: job.id, data: job.data, hash: hashStr, err: err}:
+				case results <- chunkResult{id: job.id, data: job.data, hash: hashStr, uploaded: uploaded, err: err}:
 				case <-ctx.Done():
 					return
 				}
@@ -154,12 +273,20 @@ func (s *DedupeStorage) Save(ctx context.Context, name string, r io.Reader) (str
 			}
 
 			s.lastChunks = append(s.lastChunks, res.hash)
+			if res.uploaded {
+				uploaded++
+				uploadedBytes += int64(len(res.data))
+			}
+			if s.opts.OnChunk != nil {
+				s.opts.OnChunk(uploaded, len(s.lastChunks), uploadedBytes)
+			}
 			stripe = append(stripe, res.data)
+			stripeHashes = append(stripeHashes, res.hash)
 			if len(stripe) == stripeSize {
-				if err := s.saveParity(ctx, stripe); err != nil {
+				if err := s.saveParity(ctx, stripe, stripeHashes); err != nil {
 					// Log parity error
 				}
-				stripe = nil
+				stripe, stripeHashes = nil, nil
 			}
 			processID++
 		}
@@ -181,13 +308,121 @@ final:
 	}
 
 	if len(stripe) > 0 {
-		_ = s.saveParity(ctx, stripe)
+		_ = s.saveParity(ctx, stripe, stripeHashes)
 	}
 
+	if partialErr != nil {
+		return s.inner.Location() + "/" + name, partialErr
+	}
+	if indexed {
+		// Best effort: a chunk missing from the index is just checked remotely
+		_ = s.opts.Index.Add(ctx, s.inner.Location(), s.lastChunks)
+	}
 	return s.inner.Location() + "/" + name, nil
 }
 
-func (s *DedupeStorage) saveParity(ctx context.Context, stripe [][]byte) error {
+// useIndex reports whether the chunk index can be trusted for this target,
+// first emptying it if chunks were deleted since it was filled. Without a
+// readable generation the index is not used and every chunk is checked
+// remotely.
+func (s *DedupeStorage) useIndex(ctx context.Context) bool {
+	if s.opts.Index == nil {
+		return false
+	}
+	gen, err := s.generation(ctx)
+	if err != nil {
+		return false
+	}
+	loc := s.inner.Location()
+	known, ok, err := s.opts.Index.Generation(ctx, loc)
+	if err != nil {
+		return false
+	}
+	if !ok || known != gen {
+		if err := s.opts.Index.Reset(ctx, loc, gen); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// generation returns the target's chunk generation, "" if chunks were never
+// deleted from it.
+func (s *DedupeStorage) generation(ctx context.Context) (string, error) {
+	data, err := s.inner.GetMetadata(ctx, generationFile)
+	if err == nil {
+		return string(data), nil
+	}
+	if exists, xerr := s.inner.Exists(ctx, generationFile); xerr == nil && !exists {
+		return "", nil
+	}
+	return "", err
+}
+
+// bumpGeneration marks the target's chunk set as changed, after deleting
+// chunks, so that chunk indexes drop their entries for it.
+func (s *DedupeStorage) bumpGeneration(ctx context.Context) error {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+	return s.inner.PutMetadata(ctx, generationFile, []byte(hex.EncodeToString(id)))
+}
+
+// RebuildIndex refills the chunk index for this target from a listing of
+// chunks/, replacing whatever it held. It returns the number of chunks
+// recorded.
+func (s *DedupeStorage) RebuildIndex(ctx context.Context) (int, error) {
+	if s.opts.Index == nil {
+		return 0, fmt.Errorf("no chunk index configured")
+	}
+	gen, err := s.generation(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("read chunk generation: %w", err)
+	}
+	list := s.inner.ListMetadata
+	if ms, ok := s.inner.(*MultiStorage); ok {
+		// A chunk is only present when every target has it, as Exists says
+		list = ms.listCommon
+	}
+	files, err := list(ctx, "chunks/")
+	if err != nil {
+		return 0, fmt.Errorf("list chunks: %w", err)
+	}
+	hashes := make([]string, 0, len(files))
+	for _, f := range files {
+		hashes = append(hashes, filepath.Base(f))
+	}
+
+	loc := s.inner.Location()
+	if err := s.opts.Index.Reset(ctx, loc, gen); err != nil {
+		return 0, err
+	}
+	if err := s.opts.Index.Add(ctx, loc, hashes); err != nil {
+		return 0, err
+	}
+	return len(hashes), nil
+}
+
+type chunkState struct {
+	once sync.Once
+	err  error
+}
+
+// sealChunk returns the ID and stored form of a plaintext chunk.
+func (s *DedupeStorage) sealChunk(plain []byte) (string, []byte, error) {
+	if !s.opts.Encrypt {
+		hash := sha256.Sum256(plain)
+		return hex.EncodeToString(hash[:]), plain, nil
+	}
+	id := s.opts.KeyManager.ChunkID(plain)
+	sealed, err := s.opts.KeyManager.SealChunk(id, plain)
+	return id, sealed, err
+}
+
+// saveParity stores XOR parity over the stored form of a stripe of chunks,
+// keyed by the hash of their IDs.
+func (s *DedupeStorage) saveParity(ctx context.Context, stripe [][]byte, hashes []string) error {
 	if len(stripe) == 0 {
 		return nil
 	}
@@ -212,18 +447,24 @@ func (s *DedupeStorage) saveParity(ctx context.Context, stripe [][]byte) error {
 		}
 	}
 
-	h := sha256.New()
-	for _, b := range stripe {
-		chash := sha256.Sum256(b)
-		h.Write([]byte(hex.EncodeToString(chash[:])))
-	}
-	stripeHash := hex.EncodeToString(h.Sum(nil))
-
 	fullParity := append(header, parity...)
-	_, err := s.inner.Save(ctx, "parity/"+stripeHash, bytes.NewReader(fullParity))
+	_, err := s.inner.Save(ctx, "parity/"+stripeHash(hashes), bytes.NewReader(fullParity))
 	return err
 }
 
+// stripeSize is the number of consecutive chunks of a backup that share one
+// parity object.
+const stripeSize = 10
+
+// stripeHash names the parity object of a stripe by the IDs of its chunks.
+func stripeHash(hashes []string) string {
+	h := sha256.New()
+	for _, hash := range hashes {
+		h.Write([]byte(hash))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
 func (s *DedupeStorage) Open(ctx context.Context, name string) (io.ReadCloser, error) {
 	manifestName := name
 	data, err := s.inner.GetMetadata(ctx, manifestName)
@@ -235,20 +476,56 @@ func (s *DedupeStorage) Open(ctx context.Context, name string) (io.ReadCloser, e
 	if err != nil {
 		return s.inner.Open(ctx, name)
 	}
+	if manifest.IsLatest(manifestName) {
+		if _, data, err = manifest.ReadLatest(ctx, s.inner, manifestName); err != nil {
+			return nil, err
+		}
+	}
 
 	m, err := manifest.Deserialize(data)
 	if err != nil || len(m.Chunks) == 0 {
 		// Not a dedupe manifest, try as raw file
 		return s.inner.Open(ctx, name)
 	}
+	return s.OpenManifest(ctx, m)
+}
+
+// OpenManifest reassembles the backup described by m from its chunks,
+// whether or not m is stored on the target.
+func (s *DedupeStorage) OpenManifest(ctx context.Context, m *manifest.Manifest) (io.ReadCloser, error) {
+	encrypted := m.ChunkEncryption != ""
+	if encrypted {
+		if m.ChunkEncryption != crypto.ConvergentScheme {
+			return nil, fmt.Errorf("unsupported chunk encryption %q", m.ChunkEncryption)
+		}
+		if s.opts.KeyManager == nil {
+			return nil, fmt.Errorf("backup chunks are encrypted but no passphrase or key-file was provided")
+		}
+	}
 
 	readers := make([]io.Reader, len(m.Chunks))
 	closers := make([]io.Closer, 0, len(m.Chunks))
+	closeAll := func() {
+		for _, c := range closers {
+			c.Close() // #nosec G104
+		}
+	}
 
 	for i, hash := range m.Chunks {
 		chunkPath := "chunks/" + hash
 		exists, _ := s.inner.Exists(ctx, chunkPath)
-		if exists {
+		if exists && encrypted {
+			sealed, err := s.getChunkData(ctx, hash)
+			if err == nil {
+				plain, err := s.opts.KeyManager.OpenChunk(hash, sealed)
+				if err != nil {
+					closeAll()
+					return nil, err
+				}
+				readers[i] = bytes.NewReader(plain)
+				continue
+			}
+		} else if exists {
 			r, err := s.inner.Open(ctx, chunkPath)
 			if err == nil {
 				readers[i] = r
@@ -258,11 +535,12 @@ func (s *DedupeStorage) Open(ctx context.Context, name string) (io.ReadCloser, e
 		}
 
 		// Chunk is missing, try recovery via parity
-		recovered, err := s.tryRecoverChunk(ctx, m.Chunks, i)
+		recovered, err := s.tryRecoverChunk(ctx, m.Chunks, i, encrypted)
+		if err == nil && encrypted {
+			recovered, err = s.opts.KeyManager.OpenChunk(hash, recovered)
+		}
 		if err != nil {
-			for _, c := range closers {
-				c.Close() // #nosec G104
-			}
+			closeAll()
 			return nil, fmt.Errorf("failed to open/recover chunk %s: %w", hash, err)
 		}
 		readers[i] = io.NopCloser(bytes.NewReader(recovered))
@@ -274,8 +552,9 @@ func (s *DedupeStorage) Open(ctx context.Context, name string) (io.ReadCloser, e
 	}, nil
 }
 
-func (s *DedupeStorage) tryRecoverChunk(ctx context.Context, allChunks []string, missingIndex int) ([]byte, error) {
-	const stripeSize = 10
+// tryRecoverChunk rebuilds the stored form of a missing chunk from parity.
+// Encrypted chunks are verified by the caller when they are decrypted.
+func (s *DedupeStorage) tryRecoverChunk(ctx context.Context, allChunks []string, missingIndex int, encrypted bool) ([]byte, error) {
 	stripeIdx := (missingIndex / stripeSize) * stripeSize
 	stripeEnd := stripeIdx + stripeSize
 	if stripeEnd > len(allChunks) {
@@ -283,13 +562,7 @@ func (s *DedupeStorage) tryRecoverChunk(ctx context.Context, allChunks []string,
 	}
 
 	stripeHashes := allChunks[stripeIdx:stripeEnd]
-	h := sha256.New()
-	for _, hash := range stripeHashes {
-		h.Write([]byte(hash))
-	}
-	stripeHash := hex.EncodeToString(h.Sum(nil))
-
-	fullParity, err := s.inner.GetMetadata(ctx, "parity/"+stripeHash)
+	fullParity, err := s.inner.GetMetadata(ctx, "parity/"+stripeHash(stripeHashes))
 	if err != nil {
 		return nil, fmt.Errorf("parity chunk not found: %w", err)
 	}
@@ -323,6 +596,9 @@ func (s *DedupeStorage) tryRecoverChunk(ctx context.Context, allChunks []string,
 
 	recovered = temp[:missingLen]
 
+	if encrypted {
+		return recovered, nil
+	}
 	recoveredHash := sha256.Sum256(recovered)
 	if hex.EncodeToString(recoveredHash[:]) != allChunks[missingIndex] {
 		return nil, fmt.Errorf("recovered chunk hash mismatch")
@@ -358,47 +634,80 @@ func (s *DedupeStorage) Delete(ctx context.Context, name string) error {
 		return s.inner.Delete(ctx, name)
 	}
 
-	// 2. Identify candidate chunks to delete
-	candidates := make(map[string]bool)
-	for _, c := range man.Chunks {
-		candidates[c] = true
-	}
+	// 2. Find the chunks other backups, of any database, still use. If that
+	// scan is incomplete the chunks stay behind for GC, which reports why.
+	referenced, scanErr := s.referencedChunks(ctx, name)
 
 	// 3. Delete the manifest itself
 	if err := s.inner.Delete(ctx, name); err != nil {
 		return err
 	}
+	if scanErr != nil {
+		return nil
+	}
 
-	// 4. Read all remaining manifests to find referenced chunks
-	files, err := s.ListMetadata(ctx, "")
+	// 4. Delete orphaned chunks, moving the generation first so chunk
+	// indexes stop trusting them even if a delete fails half way
+	var orphans []string
+	for _, c := range man.Chunks {
+		if !referenced[c] {
+			orphans = append(orphans, c)
+		}
+	}
+	if len(orphans) == 0 {
+		return nil
+	}
+	if err := s.bumpGeneration(ctx); err != nil {
+		return err
+	}
+	for _, c := range orphans {
+		if s.inner.Delete(ctx, "chunks/"+c) == nil {
+			s.reclaimed.Add(1)
+		}
+	}
+
+	return nil
+}
+
+// referencedChunks collects the chunks of every manifest on the target
+// except skip. Latest pointers written by older dbackups count too: they are
+// full copies of a backup's manifest that restores follow, and can outlive
+// it, e.g. after the backup was deleted by hand. Current pointers only name
+// a manifest and have no chunks of their own. Any manifest that
+// can't be listed, read or parsed fails the scan: counting it as empty would
+// free chunks it still uses.
+func (s *DedupeStorage) referencedChunks(ctx context.Context, skip string) (map[string]bool, error) {
+	referenced := make(map[string]bool)
+	err := s.scanManifests(ctx, skip, func(m *manifest.Manifest) {
+		for _, c := range m.Chunks {
+			referenced[c] = true
+		}
+	})
+	return referenced, err
+}
+
+// scanManifests calls fn with every manifest on the target except skip,
+// failing on any it can't list, read or parse.
+func (s *DedupeStorage) scanManifests(ctx context.Context, skip string, fn func(m *manifest.Manifest)) error {
+	files, err := s.inner.ListMetadata(ctx, "")
 	if err != nil {
-		return nil // gracefully skip GC if list fails
+		return fmt.Errorf("list manifests: %w", err)
 	}
 
 	for _, f := range files {
-		if !strings.HasSuffix(f, ".manifest") || f == name || f == "latest.manifest" {
-			continue
-		}
-		fdata, ferr := s.GetMetadata(ctx, f)
-		if ferr != nil {
+		if !strings.HasSuffix(f, ".manifest") || f == skip {
 			continue
 		}
-		fman, ferr := manifest.Deserialize(fdata)
-		if ferr != nil || fman == nil {
-			continue
+		data, err := s.inner.GetMetadata(ctx, f)
+		if err != nil {
+			return fmt.Errorf("read manifest %s: %w", f, err)
 		}
-		for _, c := range fman.Chunks {
-			if candidates[c] {
-				delete(candidates, c) // chunk is still in use, remove from deletion candidates
-			}
+		m, err := manifest.Deserialize(data)
+		if err != nil {
+			return fmt.Errorf("parse manifest %s: %w", f, err)
 		}
+		fn(m)
 	}
-
-	// 5. Delete orphaned chunks
-	for c := range candidates {
-		_ = s.inner.Delete(ctx, "chunks/"+c)
-	}
-
 	return nil
 }
 
@@ -406,16 +715,77 @@ func (s *DedupeStorage) Exists(ctx context.Context, name string) (bool, error) {
 	return s.inner.Exists(ctx, name)
 }
 
+// Stat reports a deduplicated backup with the size and date its manifest
+// records; for manifests without a size, the total size of its stored
+// chunks. Other files are passed through.
+func (s *DedupeStorage) Stat(ctx context.Context, name string) (StorageInfo, error) {
+	data, err := s.inner.GetMetadata(ctx, name+".manifest")
+	if err != nil {
+		return s.inner.Stat(ctx, name)
+	}
+	m, err := manifest.Deserialize(data)
+	if err != nil || len(m.Chunks) == 0 {
+		return s.inner.Stat(ctx, name)
+	}
+
+	info := StorageInfo{Size: m.Size, ModTime: m.CreatedAt}
+	if m.Size > 0 {
+		return info, nil
+	}
+	// Manifests from before sizes were recorded
+	for _, hash := range m.Chunks {
+		ci, err := s.inner.Stat(ctx, "chunks/"+hash)
+		if err != nil {
+			return StorageInfo{}, err
+		}
+		info.Size += ci.Size
+	}
+	return info, nil
+}
+
+// Verify checks that every chunk referenced by a manifest on the target
+// exists, returning the missing ones.
 func (s *DedupeStorage) Verify(ctx context.Context) ([]string, error) {
+	report, err := s.VerifySample(ctx, 1)
+	if err != nil {
+		return nil, err
+	}
+	return report.Missing, nil
+}
+
+// VerifyReport is the outcome of VerifySample.
+type VerifyReport struct {
+	Missing    []string // Checked chunks that are not on the target
+	Referenced int      // Distinct chunks referenced by the manifests
+	Checked    int      // Chunks whose existence was checked
+	Latest     string   // Manifest of the newest backup, always checked in full
+}
+
+// Coverage returns the fraction of referenced chunks that were checked.
+func (r VerifyReport) Coverage() float64 {
+	if r.Referenced == 0 {
+		return 1
+	}
+	return float64(r.Checked) / float64(r.Referenced)
+}
+
+// VerifySample is Verify for stores too large to check every chunk, where
+// each check is a request to the backend. It checks the chunks of the newest
+// backup and a random rate (0 to 1) of the others; a rate of 1 or more
+// checks them all.
+func (s *DedupeStorage) VerifySample(ctx context.Context, rate float64) (VerifyReport, error) {
+	var report VerifyReport
+
 	// 1. Get all manifests
 	files, err := s.inner.ListMetadata(ctx, "")
 	if err != nil {
-		return nil, err
+		return report, err
 	}
 
 	referenced := make(map[string]bool)
+	var newest *manifest.Manifest
 	for _, f := range files {
-		if !strings.HasSuffix(f, ".manifest") || f == "latest.manifest" {
+		if !strings.HasSuffix(f, ".manifest") {
 			continue
 		}
 		data, err := s.inner.GetMetadata(ctx, f)
@@ -426,49 +796,146 @@ func (s *DedupeStorage) Verify(ctx context.Context) ([]string, error) {
 		if err != nil {
 			continue
 		}
+		// A legacy latest pointer's chunks must exist for restores that
+		// follow it, but it is a copy, never the newest backup itself
 		for _, c := range m.Chunks {
 			referenced[c] = true
 		}
+		if manifest.IsLatest(f) {
+			continue
+		}
+		if len(m.Chunks) > 0 && (newest == nil || m.CreatedAt.After(newest.CreatedAt)) {
+			newest, report.Latest = m, f
+		}
+	}
+	report.Referenced = len(referenced)
+
+	latest := make(map[string]bool)
+	if newest != nil {
+		for _, c := range newest.Chunks {
+			latest[c] = true
+		}
 	}
 
-	// 2. Check existence of all referenced chunks
-	var missing []string
+	// 2. Check existence of the referenced chunks picked
 	for c := range referenced {
+		if rate < 1 && !latest[c] && mrand.Float64() >= rate {
+			continue
+		}
 		exists, err := s.inner.Exists(ctx, "chunks/"+c)
 		if err != nil {
-			return nil, err
+			return report, err
 		}
+		report.Checked++
 		if !exists {
-			missing = append(missing, c)
+			report.Missing = append(report.Missing, c)
 		}
 	}
 
-	return missing, nil
+	return report, nil
 }
 
-func (s *DedupeStorage) GC(ctx context.Context) (int, error) {
-	// 1. Get all manifests and collect all referenced chunks
-	files, err := s.inner.ListMetadata(ctx, "")
-	if err != nil {
-		return 0, err
-	}
-
-	referenced := make(map[string]bool)
-	for _, f := range files {
-		if !strings.HasSuffix(f, ".manifest") || f == "latest.manifest" {
+// UnrecoverableChunks returns the chunks of a backup that are missing and
+// cannot be rebuilt from parity. A missing chunk is recoverable only when it
+// is the sole gap in its stripe and the stripe's parity block exists.
+func (s *DedupeStorage) UnrecoverableChunks(ctx context.Context, chunks []string) ([]string, error) {
+	present := make(map[string]bool)
+	for _, c := range chunks {
+		if _, seen := present[c]; seen {
 			continue
 		}
-		data, err := s.inner.GetMetadata(ctx, f)
+		exists, err := s.inner.Exists(ctx, "chunks/"+c)
 		if err != nil {
+			return nil, err
+		}
+		present[c] = exists
+	}
+
+	var lost []string
+	reported := make(map[string]bool)
+	for start := 0; start < len(chunks); start += stripeSize {
+		end := start + stripeSize
+		if end > len(chunks) {
+			end = len(chunks)
+		}
+		stripe := chunks[start:end]
+
+		var gaps []string
+		for _, c := range stripe {
+			if !present[c] {
+				gaps = append(gaps, c)
+			}
+		}
+		if len(gaps) == 0 {
 			continue
 		}
-		m, err := manifest.Deserialize(data)
+		if len(gaps) == 1 {
+			ok, err := s.inner.Exists(ctx, "parity/"+stripeHash(stripe))
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				continue
+			}
+		}
+		for _, c := range gaps {
+			if !reported[c] {
+				reported[c] = true
+				lost = append(lost, c)
+			}
+		}
+	}
+	return lost, nil
+}
+
+// Repair copies the given chunks, typically what Verify reported missing,
+// from a healthy replica of the same backups. Chunks the replica can't
+// provide either, or whose content doesn't match their ID, are skipped and
+// reported in the returned error. Encrypted chunks can only be checked, and
+// so copied, with the key set by SetKeyManager.
+func (s *DedupeStorage) Repair(ctx context.Context, healthy *DedupeStorage, missing []string) (int, error) {
+	var errs []error
+	repaired := 0
+	for _, c := range missing {
+		data, err := healthy.getChunkData(ctx, c)
+		if err == nil {
+			err = s.checkChunk(c, data)
+		}
 		if err != nil {
+			errs = append(errs, fmt.Errorf("chunk %s: %w", c, err))
 			continue
 		}
-		for _, c := range m.Chunks {
-			referenced[c] = true
+		if _, err := s.inner.Save(ctx, "chunks/"+c, bytes.NewReader(data)); err != nil {
+			errs = append(errs, fmt.Errorf("chunk %s: %w", c, err))
+			continue
 		}
+		repaired++
+	}
+	return repaired, errors.Join(errs...)
+}
+
+// checkChunk fails unless data, a chunk as stored, is the chunk called id.
+func (s *DedupeStorage) checkChunk(id string, data []byte) error {
+	hash := sha256.Sum256(data)
+	if hex.EncodeToString(hash[:]) == id {
+		return nil
+	}
+	if s.opts.KeyManager == nil {
+		return fmt.Errorf("content does not match the chunk ID (encrypted chunks need the passphrase or key file to be checked)")
+	}
+	if _, err := s.opts.KeyManager.OpenChunk(id, data); err != nil {
+		return fmt.Errorf("content does not match the chunk ID: %w", err)
+	}
+	return nil
+}
+
+// GC removes chunks no manifest references. It deletes nothing unless every
+// manifest on the target could be read.
+func (s *DedupeStorage) GC(ctx context.Context) (int, error) {
+	// 1. Get all manifests and collect all referenced chunks
+	referenced, err := s.referencedChunks(ctx, "")
+	if err != nil {
+		return 0, fmt.Errorf("chunk GC skipped, no chunks removed: %w", err)
 	}
 
 	// 2. List all actual chunks in storage
@@ -478,21 +945,61 @@ func (s *DedupeStorage) GC(ctx context.Context) (int, error) {
 		return 0, err
 	}
 
-	// 3. Delete orphans
-	deletedCount := 0
+	// 3. Delete orphans, moving the generation first so chunk indexes stop
+	// trusting them even if a delete fails half way
+	var orphans []string
 	for _, chunkPath := range actualChunks {
 		// chunkPath might be "chunks/hash" or just "hash" depending on implementation
-		hash := filepath.Base(chunkPath)
-		if !referenced[hash] {
-			if err := s.inner.Delete(ctx, chunkPath); err == nil {
-				deletedCount++
-			}
+		if !referenced[filepath.Base(chunkPath)] {
+			orphans = append(orphans, chunkPath)
+		}
+	}
+	if len(orphans) == 0 {
+		return 0, nil
+	}
+	if err := s.bumpGeneration(ctx); err != nil {
+		return 0, fmt.Errorf("chunk GC skipped, no chunks removed: %w", err)
+	}
+	deletedCount := 0
+	for _, chunkPath := range orphans {
+		if err := s.inner.Delete(ctx, chunkPath); err == nil {
+			deletedCount++
 		}
 	}
 
 	return deletedCount, nil
 }
 
+// PruneParity deletes the parity objects of stripes no manifest on the
+// target is made of, left behind as backups are pruned and chunks change.
+// Like GC, it removes nothing if any manifest can't be read.
+func (s *DedupeStorage) PruneParity(ctx context.Context) (int, error) {
+	referenced := make(map[string]bool)
+	err := s.scanManifests(ctx, "", func(m *manifest.Manifest) {
+		for i := 0; i < len(m.Chunks); i += stripeSize {
+			referenced[stripeHash(m.Chunks[i:min(i+stripeSize, len(m.Chunks))])] = true
+		}
+	})
+	if err != nil {
+		return 0, fmt.Errorf("parity pruning skipped, nothing removed: %w", err)
+	}
+
+	objects, err := s.inner.ListMetadata(ctx, "parity/")
+	if err != nil {
+		return 0, err
+	}
+	deleted := 0
+	for _, p := range objects {
+		if referenced[filepath.Base(p)] {
+			continue
+		}
+		if err := s.inner.Delete(ctx, p); err == nil {
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
 func (s *DedupeStorage) Location() string {
 	return s.inner.Location()
 }
@@ -522,6 +1029,9 @@ func (s *DedupeStorage) ListMetadata(ctx context.Context, prefix string) ([]stri
 }
 
 func (s *DedupeStorage) Close() error {
+	if s.opts.Index != nil {
+		s.opts.Index.Close() // #nosec G104
+	}
 	return s.inner.Close()
 }
 
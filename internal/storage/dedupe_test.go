@@ -3,9 +3,19 @@ package storage
 import (
 	"bytes"
 	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
 	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/lupppig/dbackup/internal/crypto"
 	"github.com/lupppig/dbackup/internal/manifest"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -47,6 +57,32 @@ func TestDedupeStorage_SaveAndOpen(t *testing.T) {
 	assert.Equal(t, data, readData)
 }
 
+func TestDedupeStorage_FastCDC(t *testing.T) {
+	ctx := context.Background()
+	local := NewLocalStorage(t.TempDir())
+	_, err := NewDedupeStorageWithOptions(local, DedupeOptions{Algorithm: "rabin"})
+	assert.Error(t, err)
+
+	dedupe, err := NewDedupeStorageWithOptions(local, DedupeOptions{Algorithm: ChunkerFastCDC})
+	require.NoError(t, err)
+	data := make([]byte, 1<<20)
+	_, err = rand.Read(data)
+	require.NoError(t, err)
+	_, err = dedupe.Save(ctx, "fastcdc.sql", bytes.NewReader(data))
+	require.NoError(t, err)
+	manBytes, err := (&manifest.Manifest{ID: "fastcdc", Chunks: dedupe.LastChunks()}).Serialize()
+	require.NoError(t, err)
+	require.NoError(t, dedupe.PutMetadata(ctx, "fastcdc.sql.manifest", manBytes))
+
+	// Restores need no option: the manifest lists the chunks whatever cut them
+	rc, err := NewDedupeStorage(local).Open(ctx, "fastcdc.sql")
+	require.NoError(t, err)
+	defer rc.Close()
+	got, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	assert.Equal(t, data, got)
+}
+
 func TestDedupeStorage_DeduplicationRatio(t *testing.T) {
 	ctx := context.Background()
 	local := NewLocalStorage(t.TempDir())
@@ -122,6 +158,51 @@ func TestDedupeStorage_Verify_GC(t *testing.T) {
 	assert.Equal(t, chunks[0], missing[0])
 }
 
+func TestDedupeStorage_VerifySample(t *testing.T) {
+	ctx := context.Background()
+	local := NewLocalStorage(t.TempDir())
+	dedupe := NewDedupeStorage(local)
+
+	put := func(name string, created time.Time, chunks []string) {
+		for _, c := range chunks {
+			_, err := local.Save(ctx, "chunks/"+c, strings.NewReader(c))
+			require.NoError(t, err)
+		}
+		mb, err := (&manifest.Manifest{Chunks: chunks, CreatedAt: created}).Serialize()
+		require.NoError(t, err)
+		require.NoError(t, dedupe.PutMetadata(ctx, name, mb))
+	}
+	var old, recent []string
+	for i := range 500 {
+		old = append(old, fmt.Sprintf("old%03d", i))
+	}
+	for i := range 10 {
+		recent = append(recent, fmt.Sprintf("new%02d", i))
+	}
+	put("old.manifest", time.Now().Add(-time.Hour), old)
+	put("new.manifest", time.Now(), recent)
+	require.NoError(t, local.Delete(ctx, "chunks/new03"))
+
+	// A zero rate still checks the newest backup in full
+	report, err := dedupe.VerifySample(ctx, 0)
+	require.NoError(t, err)
+	assert.Equal(t, "new.manifest", report.Latest)
+	assert.Equal(t, 510, report.Referenced)
+	assert.Equal(t, 10, report.Checked)
+	assert.Equal(t, []string{"new03"}, report.Missing)
+
+	report, err = dedupe.VerifySample(ctx, 0.2)
+	require.NoError(t, err)
+	assert.Greater(t, report.Checked, 10)
+	assert.Less(t, report.Checked, 510)
+	assert.InDelta(t, float64(report.Checked)/510, report.Coverage(), 1e-9)
+
+	report, err = dedupe.VerifySample(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, 510, report.Checked)
+	assert.Equal(t, 1.0, report.Coverage())
+}
+
 func TestDedupeStorage_ParityRecovery(t *testing.T) {
 	ctx := context.Background()
 	local := NewLocalStorage(t.TempDir())
@@ -168,3 +249,602 @@ func TestDedupeStorage_ParityRecovery(t *testing.T) {
 	assert.Equal(t, data, d, "Data should be reconstructed exactly")
 	rc.Close()
 }
+
+func TestDedupeStorage_EncryptedChunks(t *testing.T) {
+	ctx := context.Background()
+	local := NewLocalStorage(t.TempDir())
+	km, err := crypto.NewKeyManager("secret", "")
+	require.NoError(t, err)
+	dedupe, err := NewDedupeStorageWithOptions(local, DedupeOptions{Encrypt: true, KeyManager: km})
+	require.NoError(t, err)
+
+	pattern := []byte("plaintext that must never reach the storage backend ")
+	data := make([]byte, 0, 512*1024)
+	for len(data) < 512*1024 {
+		data = append(data, pattern...)
+	}
+
+	_, err = dedupe.Save(ctx, "enc", bytes.NewReader(data))
+	require.NoError(t, err)
+	chunks := dedupe.LastChunks()
+	require.Greater(t, len(chunks), 1)
+
+	// Chunks are encrypted at rest
+	stored, err := local.GetMetadata(ctx, "chunks/"+chunks[0])
+	require.NoError(t, err)
+	assert.NotContains(t, string(stored), string(pattern))
+
+	// Saving the same content again writes no new chunks
+	_, err = dedupe.Save(ctx, "enc2", bytes.NewReader(data))
+	require.NoError(t, err)
+	assert.Equal(t, chunks, dedupe.LastChunks())
+
+	man := &manifest.Manifest{Chunks: chunks, ChunkEncryption: dedupe.ChunkEncryption()}
+	mb, _ := man.Serialize()
+	require.NoError(t, dedupe.PutMetadata(ctx, "enc.manifest", mb))
+
+	rc, err := dedupe.Open(ctx, "enc")
+	require.NoError(t, err)
+	d, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	rc.Close()
+	assert.Equal(t, data, d)
+
+	// A missing encrypted chunk is still recovered via parity
+	require.NoError(t, local.Delete(ctx, "chunks/"+chunks[0]))
+	rc, err = dedupe.Open(ctx, "enc")
+	require.NoError(t, err, "Should recover via parity")
+	d, err = io.ReadAll(rc)
+	require.NoError(t, err)
+	rc.Close()
+	assert.Equal(t, data, d)
+
+	// Without the key the backup cannot be read
+	_, err = NewDedupeStorage(local).Open(ctx, "enc")
+	assert.Error(t, err)
+
+	wrong, _ := crypto.NewKeyManager("wrong", "")
+	plain := NewDedupeStorage(local)
+	plain.SetKeyManager(wrong)
+	_, err = plain.Open(ctx, "enc")
+	assert.Error(t, err)
+}
+
+type countingStorage struct {
+	Storage
+	exists, saves atomic.Int32
+}
+
+func (c *countingStorage) Exists(ctx context.Context, name string) (bool, error) {
+	c.exists.Add(1)
+	return c.Storage.Exists(ctx, name)
+}
+
+func (c *countingStorage) Save(ctx context.Context, name string, r io.Reader) (string, error) {
+	if strings.HasPrefix(name, "chunks/") {
+		c.saves.Add(1)
+	}
+	return c.Storage.Save(ctx, name, r)
+}
+
+func TestDedupeStorage_RepeatedChunksHitStorageOnce(t *testing.T) {
+	ctx := context.Background()
+	inner := &countingStorage{Storage: NewLocalStorage(t.TempDir())}
+	dedupe := NewDedupeStorage(inner)
+
+	// The Gear hash only sees the last 64 bytes, so a 64-byte period makes
+	// chunk boundaries periodic and chunks repeat exactly
+	data := bytes.Repeat([]byte("0123456789abcdef"), 4*4*1024*1024/64)
+
+	_, err := dedupe.Save(ctx, "repeat", bytes.NewReader(data))
+	require.NoError(t, err)
+
+	chunks := dedupe.LastChunks()
+	unique := make(map[string]bool)
+	for _, c := range chunks {
+		unique[c] = true
+	}
+	require.Less(t, len(unique), len(chunks), "test data should repeat chunks")
+
+	assert.Equal(t, int32(len(unique)), inner.exists.Load(), "one Exists per distinct chunk")
+	assert.Equal(t, int32(len(unique)), inner.saves.Load(), "one Save per distinct chunk")
+}
+
+func TestDedupeStorage_OnChunk(t *testing.T) {
+	ctx := context.Background()
+	var uploaded, total int
+	var sent int64
+	dedupe, err := NewDedupeStorageWithOptions(NewLocalStorage(t.TempDir()), DedupeOptions{
+		OnChunk: func(u, n int, b int64) { uploaded, total, sent = u, n, b },
+	})
+	require.NoError(t, err)
+
+	data := make([]byte, 512*1024)
+	for i := range data {
+		data[i] = byte(i * 7 % 251)
+	}
+	_, err = dedupe.Save(ctx, "first", bytes.NewReader(data))
+	require.NoError(t, err)
+	assert.Equal(t, len(dedupe.LastChunks()), total)
+	assert.LessOrEqual(t, uploaded, total)
+	assert.Positive(t, sent)
+
+	// The second save finds every chunk already stored
+	_, err = dedupe.Save(ctx, "second", bytes.NewReader(data))
+	require.NoError(t, err)
+	assert.Equal(t, len(dedupe.LastChunks()), total)
+	assert.Zero(t, uploaded)
+	assert.Zero(t, sent)
+}
+
+func TestDedupeStorage_Repair(t *testing.T) {
+	ctx := context.Background()
+	healthyMem, err := FromURI("mem://"+t.Name()+"/healthy", StorageOptions{})
+	require.NoError(t, err)
+	damagedMem, err := FromURI("mem://"+t.Name()+"/damaged", StorageOptions{})
+	require.NoError(t, err)
+	healthy := NewDedupeStorage(healthyMem)
+	damaged := NewDedupeStorage(damagedMem)
+
+	data := make([]byte, 256*1024)
+	for i := range data {
+		data[i] = byte(i * 13 % 251)
+	}
+	for _, ds := range []*DedupeStorage{healthy, damaged} {
+		_, err := ds.Save(ctx, "test", bytes.NewReader(data))
+		require.NoError(t, err)
+		mb, _ := (&manifest.Manifest{Chunks: ds.LastChunks()}).Serialize()
+		require.NoError(t, ds.PutMetadata(ctx, "test.manifest", mb))
+	}
+
+	chunks := damaged.LastChunks()
+	require.NotEmpty(t, chunks)
+	require.NoError(t, damagedMem.Delete(ctx, "chunks/"+chunks[0]))
+
+	missing, err := damaged.Verify(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{chunks[0]}, missing)
+
+	repaired, err := damaged.Repair(ctx, healthy, missing)
+	require.NoError(t, err)
+	assert.Equal(t, 1, repaired)
+
+	missing, err = damaged.Verify(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, missing)
+
+	// A chunk the replica lacks too is reported, not silently skipped
+	_, err = damaged.Repair(ctx, healthy, []string{"deadbeef"})
+	assert.Error(t, err)
+
+	// So is one whose copy on the replica is corrupt, which is not written
+	good, err := healthy.getChunkData(ctx, chunks[0])
+	require.NoError(t, err)
+	require.NoError(t, damagedMem.Delete(ctx, "chunks/"+chunks[0]))
+	_, err = healthyMem.Save(ctx, "chunks/"+chunks[0], strings.NewReader("bit rot"))
+	require.NoError(t, err)
+	repaired, err = damaged.Repair(ctx, healthy, []string{chunks[0]})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), chunks[0])
+	assert.Zero(t, repaired)
+	ok, err := damagedMem.Exists(ctx, "chunks/"+chunks[0])
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	_, err = healthyMem.Save(ctx, "chunks/"+chunks[0], bytes.NewReader(good))
+	require.NoError(t, err)
+	repaired, err = damaged.Repair(ctx, healthy, []string{chunks[0]})
+	require.NoError(t, err)
+	assert.Equal(t, 1, repaired)
+
+	rc, err := damaged.Open(ctx, "test")
+	require.NoError(t, err)
+	defer rc.Close()
+	got, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	assert.Equal(t, data, got)
+}
+
+func TestDedupeStorage_RepairEncrypted(t *testing.T) {
+	ctx := context.Background()
+	km, err := crypto.NewKeyManager("secret", "")
+	require.NoError(t, err)
+	healthy, err := NewDedupeStorageWithOptions(NewMemStorage(t.Name()+"/healthy"), DedupeOptions{Encrypt: true, KeyManager: km})
+	require.NoError(t, err)
+	_, err = healthy.Save(ctx, "enc", strings.NewReader("encrypted chunk data"))
+	require.NoError(t, err)
+	chunks := healthy.LastChunks()
+
+	// Without the key a sealed chunk can't be told from a corrupt one
+	damaged := NewDedupeStorage(NewMemStorage(t.Name() + "/damaged"))
+	_, err = damaged.Repair(ctx, healthy, chunks)
+	assert.Error(t, err)
+
+	damaged.SetKeyManager(km)
+	repaired, err := damaged.Repair(ctx, healthy, chunks)
+	require.NoError(t, err)
+	assert.Equal(t, len(chunks), repaired)
+}
+
+// saveManifest stores a dedupe backup of data with a manifest for engine/db.
+func saveManifest(t *testing.T, ds *DedupeStorage, name, db string, data []byte) []string {
+	t.Helper()
+	ctx := context.Background()
+	_, err := ds.Save(ctx, name, bytes.NewReader(data))
+	require.NoError(t, err)
+	chunks := ds.LastChunks()
+	mb, _ := (&manifest.Manifest{ID: name, Engine: "postgres", DBName: db, Chunks: chunks}).Serialize()
+	require.NoError(t, ds.PutMetadata(ctx, name+".manifest", mb))
+	return chunks
+}
+
+func TestDedupeStorage_SharedChunksAcrossDatabases(t *testing.T) {
+	ctx := context.Background()
+	local := NewLocalStorage(t.TempDir())
+	dedupe := NewDedupeStorage(local)
+
+	shared := make([]byte, 1024*1024)
+	_, err := io.ReadFull(rand.Reader, shared)
+	require.NoError(t, err)
+	ordersChunks := saveManifest(t, dedupe, "orders", "orders", shared)
+	usersChunks := saveManifest(t, dedupe, "users", "users", append(append([]byte{}, shared...), []byte("users only")...))
+	inUsers := make(map[string]bool)
+	for _, c := range usersChunks {
+		inUsers[c] = true
+	}
+	common := 0
+	for _, c := range ordersChunks {
+		if inUsers[c] {
+			common++
+		}
+	}
+	require.Positive(t, common, "the two databases should share chunks")
+
+	// Deleting one database's backup keeps the chunks the other one uses
+	require.NoError(t, dedupe.Delete(ctx, "orders.manifest"))
+	for _, c := range usersChunks {
+		exists, err := local.Exists(ctx, "chunks/"+c)
+		require.NoError(t, err)
+		assert.True(t, exists, "chunk %s of users was deleted with orders", c)
+	}
+
+	removed, err := dedupe.GC(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, removed)
+
+	missing, err := dedupe.Verify(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, missing)
+}
+
+func TestDedupeStorage_GCFailsClosed(t *testing.T) {
+	ctx := context.Background()
+	local := NewLocalStorage(t.TempDir())
+	dedupe := NewDedupeStorage(local)
+
+	data := []byte("backup of a database whose manifest gets corrupted")
+	chunks := saveManifest(t, dedupe, "orders", "orders", data)
+	_, err := local.Save(ctx, "chunks/orphan", bytes.NewReader([]byte("orphan")))
+	require.NoError(t, err)
+
+	// An unreadable manifest could reference any chunk, so nothing is removed
+	require.NoError(t, local.PutMetadata(ctx, "users.manifest", []byte("{not json")))
+	removed, err := dedupe.GC(ctx)
+	assert.Error(t, err)
+	assert.Zero(t, removed)
+	exists, _ := local.Exists(ctx, "chunks/orphan")
+	assert.True(t, exists)
+
+	// Delete still removes the manifest but keeps its chunks
+	require.NoError(t, dedupe.Delete(ctx, "orders.manifest"))
+	exists, _ = local.Exists(ctx, "orders.manifest")
+	assert.False(t, exists)
+	for _, c := range chunks {
+		exists, _ = local.Exists(ctx, "chunks/"+c)
+		assert.True(t, exists)
+	}
+}
+
+func TestDedupeStorage_LatestPointerKeepsChunks(t *testing.T) {
+	ctx := context.Background()
+	local := NewLocalStorage(t.TempDir())
+	dedupe := NewDedupeStorage(local)
+
+	data := make([]byte, 256*1024)
+	_, err := io.ReadFull(rand.Reader, data)
+	require.NoError(t, err)
+	chunks := saveManifest(t, dedupe, "orders", "orders", data)
+	mb, err := local.GetMetadata(ctx, "orders.manifest")
+	require.NoError(t, err)
+	for _, latest := range []string{manifest.LatestName, manifest.LatestNameFor("postgres", "orders")} {
+		require.NoError(t, local.PutMetadata(ctx, latest, mb))
+	}
+
+	// The backup's own manifest goes, but restores still follow the pointers
+	require.NoError(t, dedupe.Delete(ctx, "orders.manifest"))
+	removed, err := dedupe.GC(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, removed)
+	for _, c := range chunks {
+		exists, _ := local.Exists(ctx, "chunks/"+c)
+		assert.True(t, exists, "chunk %s of latest.manifest", c)
+	}
+
+	rc, err := dedupe.Open(ctx, manifest.LatestName)
+	require.NoError(t, err)
+	got, err := io.ReadAll(rc)
+	rc.Close()
+	require.NoError(t, err)
+	assert.Equal(t, data, got)
+
+	// Verify checks the pointers' chunks too
+	require.NoError(t, local.Delete(ctx, "chunks/"+chunks[0]))
+	missing, err := dedupe.Verify(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{chunks[0]}, missing)
+}
+
+type failingListStorage struct {
+	Storage
+}
+
+func (f *failingListStorage) ListMetadata(ctx context.Context, prefix string) ([]string, error) {
+	return nil, io.ErrUnexpectedEOF
+}
+
+func TestDedupeStorage_DeleteKeepsChunksWhenListFails(t *testing.T) {
+	ctx := context.Background()
+	local := NewLocalStorage(t.TempDir())
+	chunks := saveManifest(t, NewDedupeStorage(local), "orders", "orders", []byte("some data"))
+
+	dedupe := NewDedupeStorage(&failingListStorage{Storage: local})
+	require.NoError(t, dedupe.Delete(ctx, "orders.manifest"))
+	for _, c := range chunks {
+		exists, _ := local.Exists(ctx, "chunks/"+c)
+		assert.True(t, exists)
+	}
+
+	_, err := dedupe.GC(ctx)
+	assert.Error(t, err)
+}
+
+func TestDedupeStorage_UnrecoverableChunks(t *testing.T) {
+	ctx := context.Background()
+	local := NewLocalStorage(t.TempDir())
+	dedupe := NewDedupeStorage(local)
+
+	data := make([]byte, 1024*1024)
+	_, err := io.ReadFull(rand.Reader, data)
+	require.NoError(t, err)
+	chunks := saveManifest(t, dedupe, "test", "test", data)
+	require.GreaterOrEqual(t, len(chunks), 2)
+
+	lost, err := dedupe.UnrecoverableChunks(ctx, chunks)
+	require.NoError(t, err)
+	assert.Empty(t, lost)
+
+	// A single gap in a stripe is covered by parity
+	require.NoError(t, local.Delete(ctx, "chunks/"+chunks[0]))
+	lost, err = dedupe.UnrecoverableChunks(ctx, chunks)
+	require.NoError(t, err)
+	assert.Empty(t, lost)
+
+	// Two gaps in the same stripe are not
+	require.NoError(t, local.Delete(ctx, "chunks/"+chunks[1]))
+	lost, err = dedupe.UnrecoverableChunks(ctx, chunks)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{chunks[0], chunks[1]}, lost)
+}
+
+func TestDedupeStorage_ChunkIndex(t *testing.T) {
+	ctx := context.Background()
+	inner := &countingStorage{Storage: NewLocalStorage(t.TempDir())}
+	indexPath := filepath.Join(t.TempDir(), "index.db")
+
+	// Each backup runs with a fresh DedupeStorage over the same index file
+	openIndexed := func() *DedupeStorage {
+		idx, err := OpenChunkIndex(indexPath)
+		require.NoError(t, err)
+		ds, err := NewDedupeStorageWithOptions(inner, DedupeOptions{Index: idx})
+		require.NoError(t, err)
+		t.Cleanup(func() { idx.Close() })
+		return ds
+	}
+
+	data := make([]byte, 2*1024*1024)
+	_, err := io.ReadFull(rand.Reader, data)
+	require.NoError(t, err)
+
+	ds := openIndexed()
+	first := saveManifest(t, ds, "first", "app", data)
+	checked := inner.exists.Load()
+	require.GreaterOrEqual(t, checked, int32(len(first)), "an empty index checks every chunk remotely")
+
+	// A repeat backup finds every chunk in the index
+	inner.exists.Store(0)
+	inner.saves.Store(0)
+	saveManifest(t, openIndexed(), "second", "app", data)
+	assert.LessOrEqual(t, inner.exists.Load(), int32(1), "only the generation lookup may reach the target")
+	assert.Zero(t, inner.saves.Load())
+
+	// Deleting chunks elsewhere moves the generation, so the index is
+	// dropped instead of vouching for chunks that are gone
+	other := NewDedupeStorage(inner)
+	require.NoError(t, other.Delete(ctx, "first.manifest"))
+	require.NoError(t, other.Delete(ctx, "second.manifest"))
+	inner.saves.Store(0)
+	third := saveManifest(t, openIndexed(), "third", "app", data)
+	assert.Equal(t, int32(len(first)), inner.saves.Load(), "chunks deleted since indexing must be uploaded again")
+
+	missing, err := other.UnrecoverableChunks(ctx, third)
+	require.NoError(t, err)
+	assert.Empty(t, missing)
+}
+
+func TestDedupeStorage_RebuildIndex(t *testing.T) {
+	ctx := context.Background()
+	local := NewLocalStorage(t.TempDir())
+	chunks := saveManifest(t, NewDedupeStorage(local), "b", "app", []byte("some data to index"))
+
+	idx, err := OpenChunkIndex(filepath.Join(t.TempDir(), "index.db"))
+	require.NoError(t, err)
+	ds, err := NewDedupeStorageWithOptions(local, DedupeOptions{Index: idx})
+	require.NoError(t, err)
+	defer ds.Close()
+
+	n, err := ds.RebuildIndex(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, len(chunks), n)
+	for _, c := range chunks {
+		ok, err := idx.Has(ctx, local.Location(), c)
+		require.NoError(t, err)
+		assert.True(t, ok)
+	}
+}
+
+// racingStorage loses every chunk upload to a concurrent writer: the chunk
+// lands, but the Save reports an error.
+type racingStorage struct {
+	Storage
+}
+
+func (r *racingStorage) Save(ctx context.Context, name string, rd io.Reader) (string, error) {
+	loc, err := r.Storage.Save(ctx, name, rd)
+	if err == nil && strings.HasPrefix(name, "chunks/") {
+		return loc, errors.New("destination exists")
+	}
+	return loc, err
+}
+
+func TestDedupeStorage_ConcurrentSameChunk(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	data := make([]byte, 2*1024*1024)
+	_, err := rand.Read(data)
+	require.NoError(t, err)
+
+	// Several backups of the same data share one target, as under dump
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ds := NewDedupeStorage(NewLocalStorage(dir))
+			_, err := ds.Save(ctx, fmt.Sprintf("backup-%d", i), bytes.NewReader(data))
+			assert.NoError(t, err)
+		}(i)
+	}
+	wg.Wait()
+
+	entries, err := os.ReadDir(filepath.Join(dir, "chunks"))
+	require.NoError(t, err)
+	for _, e := range entries {
+		assert.NotContains(t, e.Name(), ".tmp", "no temporary files are left behind")
+	}
+
+	ds := NewDedupeStorage(NewLocalStorage(dir))
+	saveManifest(t, ds, "check", "app", data)
+	rc, err := ds.Open(ctx, "check")
+	require.NoError(t, err)
+	defer rc.Close()
+	got, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	assert.Equal(t, data, got)
+
+	// A chunk upload that fails because another writer got there first
+	// still counts as stored
+	racing := NewDedupeStorage(&racingStorage{Storage: NewLocalStorage(t.TempDir())})
+	_, err = racing.Save(ctx, "raced", bytes.NewReader(data))
+	assert.NoError(t, err)
+}
+
+func TestDedupeStorage_Stat(t *testing.T) {
+	ctx := context.Background()
+	local := NewLocalStorage(t.TempDir())
+	dedupe := NewDedupeStorage(local)
+
+	data := bytes.Repeat([]byte("chunked backup "), 20000)
+	_, err := dedupe.Save(ctx, "db.sql", bytes.NewReader(data))
+	require.NoError(t, err)
+	created := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	man := &manifest.Manifest{ID: "m", Chunks: dedupe.LastChunks(), CreatedAt: created}
+	manBytes, _ := man.Serialize()
+	require.NoError(t, dedupe.PutMetadata(ctx, "db.sql.manifest", manBytes))
+
+	// A chunked backup of an old manifest is as large as its chunks
+	info, err := dedupe.Stat(ctx, "db.sql")
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(data)), info.Size)
+	assert.True(t, created.Equal(info.ModTime))
+
+	// The recorded size is used as is, even with chunks missing
+	man.Size = int64(len(data))
+	manBytes, _ = man.Serialize()
+	require.NoError(t, dedupe.PutMetadata(ctx, "db.sql.manifest", manBytes))
+	require.NoError(t, local.Delete(ctx, "chunks/"+man.Chunks[0]))
+	info, err = dedupe.Stat(ctx, "db.sql")
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(data)), info.Size)
+	assert.True(t, created.Equal(info.ModTime))
+
+	// Other files pass through
+	info, err = dedupe.Stat(ctx, "db.sql.manifest")
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(manBytes)), info.Size)
+}
+
+func TestDedupeStorage_PruneParity(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	local := NewLocalStorage(dir)
+	dedupe := NewDedupeStorage(local)
+
+	parity := func() map[string]bool {
+		t.Helper()
+		entries, err := os.ReadDir(filepath.Join(dir, "parity"))
+		require.NoError(t, err)
+		names := make(map[string]bool)
+		for _, e := range entries {
+			names[e.Name()] = true
+		}
+		return names
+	}
+
+	oldData := make([]byte, 256*1024)
+	_, err := io.ReadFull(rand.Reader, oldData)
+	require.NoError(t, err)
+	newData := make([]byte, 256*1024)
+	_, err = io.ReadFull(rand.Reader, newData)
+	require.NoError(t, err)
+	oldChunks := saveManifest(t, dedupe, "old", "orders", oldData)
+	newChunks := saveManifest(t, dedupe, "new", "orders", newData)
+	before := parity()
+
+	// The old backup goes; its chunks are collected, its parity is not
+	require.NoError(t, dedupe.Delete(ctx, "old.manifest"))
+	_, err = dedupe.GC(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, before, parity())
+
+	removed, err := dedupe.PruneParity(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, len(before)-len(parity()), removed)
+	assert.Positive(t, removed)
+
+	after := parity()
+	for i := 0; i < len(newChunks); i += stripeSize {
+		assert.True(t, after[stripeHash(newChunks[i:min(i+stripeSize, len(newChunks))])], "stripe %d of the kept backup", i/stripeSize)
+	}
+	for i := 0; i < len(oldChunks); i += stripeSize {
+		assert.False(t, after[stripeHash(oldChunks[i:min(i+stripeSize, len(oldChunks))])], "stripe %d of the deleted backup", i/stripeSize)
+	}
+
+	// The kept backup still recovers a lost chunk from parity
+	require.NoError(t, os.Remove(filepath.Join(dir, "chunks", newChunks[0])))
+	rc, err := dedupe.Open(ctx, "new")
+	require.NoError(t, err)
+	got, err := io.ReadAll(rc)
+	rc.Close()
+	require.NoError(t, err)
+	assert.Equal(t, newData, got)
+}
@@ -10,6 +10,7 @@ import (
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/lupppig/dbackup/internal/db"
 )
@@ -39,15 +40,26 @@ func (s *DockerStorage) Save(ctx context.Context, name string, r io.Reader) (str
 	mkdirCmd := exec.CommandContext(ctx, "docker", "exec", s.containerName, "mkdir", "-p", filepath.Dir(path))
 	_ = mkdirCmd.Run() // Ignore errors if directory exists or mkdir fails (cp will fail anyway if truly bad)
 
-	// Stream to container using 'docker cp -'
-	args := []string{"cp", "-", fmt.Sprintf("%s:%s", s.containerName, path)}
-	cmd := exec.CommandContext(ctx, "docker", args...)
-	cmd.Stdin = r
-	cmd.Stderr = os.Stderr
-	if err := cmd.Run(); err != nil {
-		return "", fmt.Errorf("docker save failed: %w", err)
+	write := func(p string, r io.Reader) error {
+		// Stream to container using 'docker cp -'
+		args := []string{"cp", "-", fmt.Sprintf("%s:%s", s.containerName, p)}
+		cmd := exec.CommandContext(ctx, "docker", args...)
+		cmd.Stdin = r
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("docker save failed: %w", err)
+		}
+		return nil
+	}
+	rename := func(from, to string) error {
+		return s.Run(ctx, "mv", []string{"-f", from, to}, io.Discard)
+	}
+	remove := func(p string) error {
+		return s.Run(ctx, "rm", []string{"-f", p}, io.Discard)
+	}
+	if err := saveAtomic(path, r, write, rename, remove); err != nil {
+		return "", err
 	}
-
 	return "docker://" + s.containerName + path, nil
 }
 
@@ -72,6 +84,18 @@ func (s *DockerStorage) Exists(ctx context.Context, name string) (bool, error) {
 	return err == nil, nil
 }
 
+func (s *DockerStorage) Stat(ctx context.Context, name string) (StorageInfo, error) {
+	var out bytes.Buffer
+	if err := s.Run(ctx, "stat", []string{"-c", "%s %Y", filepath.Join(s.remotePath, name)}, &out); err != nil {
+		return StorageInfo{}, err
+	}
+	var size, mtime int64
+	if _, err := fmt.Sscanf(out.String(), "%d %d", &size, &mtime); err != nil {
+		return StorageInfo{}, fmt.Errorf("unexpected stat output %q: %w", out.String(), err)
+	}
+	return StorageInfo{Size: size, ModTime: time.Unix(mtime, 0)}, nil
+}
+
 func (s *DockerStorage) Delete(ctx context.Context, name string) error {
 	path := filepath.Join(s.remotePath, name)
 	return s.Run(ctx, "rm", []string{path}, io.Discard)
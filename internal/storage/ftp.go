@@ -1,67 +1,249 @@
 package storage
 
 import (
-	"bytes"
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
+	"net"
+	"net/textproto"
 	"net/url"
 	"path/filepath"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/jlaffaye/ftp"
+	apperrors "github.com/lupppig/dbackup/internal/errors"
 )
 
 type FTPStorage struct {
-	client     *ftp.ServerConn
+	client     ftpConn
+	cfg        ftpConfig
 	remotePath string
 	host       string
 }
 
-func NewFTPStorage(u *url.URL, opts StorageOptions) (*FTPStorage, error) {
-	if !opts.AllowInsecure {
-		return nil, fmt.Errorf("insecure protocol FTP requires explicit opt-in with --allow-insecure")
+// ftpConn is the part of an FTP control connection the storage uses.
+type ftpConn interface {
+	Stor(path string, r io.Reader) error
+	Retr(path string) (io.ReadCloser, error)
+	List(path string) ([]*ftp.Entry, error)
+	FileSize(path string) (int64, error)
+	IsGetTimeSupported() bool
+	GetTime(path string) (time.Time, error)
+	Delete(path string) error
+	Rename(from, to string) error
+	MakeDir(path string) error
+	NoOp() error
+	Quit() error
+}
+
+// serverConn is an ftpConn on a real server.
+type serverConn struct {
+	*ftp.ServerConn
+}
+
+func (c serverConn) Retr(path string) (io.ReadCloser, error) {
+	return c.ServerConn.Retr(path)
+}
+
+// ftpConfig is how to reach an FTP server, from the ftp:// URI.
+type ftpConfig struct {
+	addr    string
+	user    string
+	pass    string
+	pasv    bool   // ?mode=passive: plain PASV instead of EPSV
+	tlsMode string // ?tls=explicit (AUTH TLS) or implicit; empty for plain FTP
+
+	skipVerify bool // ?tls-skip-verify=true, only with --allow-insecure
+}
+
+// parseFTPConfig reads the connection settings of an ftp:// or ftps:// URI;
+// ftps:// means explicit TLS. Plain FTP sends credentials in the clear and
+// needs opts.AllowInsecure; FTPS doesn't.
+func parseFTPConfig(u *url.URL, opts StorageOptions) (ftpConfig, error) {
+	q := u.Query()
+	cfg := ftpConfig{addr: u.Host, user: u.User.Username(), tlsMode: q.Get("tls")}
+	cfg.pass, _ = u.User.Password()
+	if u.Scheme == "ftps" && cfg.tlsMode == "" {
+		cfg.tlsMode = "explicit"
+	}
+	switch q.Get("mode") {
+	case "", "epsv":
+	case "passive", "pasv":
+		cfg.pasv = true
+	case "active":
+		return cfg, apperrors.New(apperrors.TypeConfig, "FTP active mode is not supported", "Use ?mode=passive, which also works behind NAT when the server's passive ports are reachable.")
+	default:
+		return cfg, apperrors.New(apperrors.TypeConfig, "invalid FTP mode: "+q.Get("mode"), "Use ?mode=epsv (default) or ?mode=passive.")
+	}
+
+	switch cfg.tlsMode {
+	case "", "explicit", "implicit":
+	default:
+		return cfg, apperrors.New(apperrors.TypeConfig, "invalid FTP tls value: "+cfg.tlsMode, "Use ?tls=explicit (AUTH TLS, port 21) or ?tls=implicit (port 990).")
+	}
+	// Implicit TLS has its own well-known port
+	if !strings.Contains(cfg.addr, ":") {
+		if cfg.tlsMode == "implicit" {
+			cfg.addr += ":990"
+		} else {
+			cfg.addr += ":21"
+		}
+	}
+	if cfg.tlsMode == "" && !opts.AllowInsecure {
+		return cfg, fmt.Errorf("insecure protocol FTP requires explicit opt-in with --allow-insecure, or FTPS with ftps://")
+	}
+
+	if q.Get("tls-skip-verify") == "true" {
+		if cfg.tlsMode == "" {
+			return cfg, apperrors.New(apperrors.TypeConfig, "tls-skip-verify needs FTPS", "Use ftps:// or drop ?tls-skip-verify.")
+		}
+		if !opts.AllowInsecure {
+			return cfg, apperrors.New(apperrors.TypeSecurity, "skipping FTPS certificate verification requires --allow-insecure", "Add the server's CA to the system trust store, or pass --allow-insecure.")
+		}
+		cfg.skipVerify = true
 	}
-	user := u.User.Username()
-	pass, _ := u.User.Password()
-	host := u.Host
-	if !strings.Contains(host, ":") {
-		host = host + ":21"
+	return cfg, nil
+}
+
+// dial connects and logs in.
+func (c ftpConfig) dial() (ftpConn, error) {
+	options := []ftp.DialOption{ftp.DialWithTimeout(5 * time.Second), ftp.DialWithDisabledEPSV(c.pasv)}
+	host, _, _ := strings.Cut(c.addr, ":")
+	tc := &tls.Config{ServerName: host, MinVersion: tls.VersionTLS12, InsecureSkipVerify: c.skipVerify} // #nosec G402 -- opt-in behind --allow-insecure
+	switch c.tlsMode {
+	case "explicit":
+		options = append(options, ftp.DialWithExplicitTLS(tc))
+	case "implicit":
+		options = append(options, ftp.DialWithTLS(tc))
 	}
 
-	c, err := ftp.Dial(host, ftp.DialWithTimeout(5*time.Second))
+	client, err := ftp.Dial(c.addr, options...)
 	if err != nil {
 		return nil, err
 	}
+	if err := client.Login(c.user, c.pass); err != nil {
+		client.Quit() // #nosec G104
+		return nil, err
+	}
+	return serverConn{client}, nil
+}
 
-	err = c.Login(user, pass)
+func NewFTPStorage(u *url.URL, opts StorageOptions) (*FTPStorage, error) {
+	cfg, err := parseFTPConfig(u, opts)
+	if err != nil {
+		return nil, err
+	}
+	c, err := cfg.dial()
 	if err != nil {
-		c.Quit() // #nosec G104
 		return nil, err
 	}
 
 	return &FTPStorage{
 		client:     c,
+		cfg:        cfg,
 		remotePath: u.Path,
-		host:       host,
+		host:       cfg.addr,
 	}, nil
 }
 
+// ftpAttempts bounds how often a transfer is tried; the delay before a
+// retry doubles each time.
+const ftpAttempts = 3
+
+var ftpRetryDelay = 500 * time.Millisecond
+
+// retry runs op until it succeeds, fails permanently or runs out of
+// attempts. Before each retry the control connection is checked and, if it
+// dropped, dialled again.
+func (s *FTPStorage) retry(ctx context.Context, op func() error) error {
+	var err error
+	for attempt := 0; attempt < ftpAttempts; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return err
+			case <-time.After(ftpRetryDelay << (attempt - 1)):
+			}
+			s.reconnect()
+		}
+		if err = op(); err == nil || !ftpTransient(err) {
+			return err
+		}
+	}
+	return err
+}
+
+// reconnect replaces the control connection if it no longer answers.
+func (s *FTPStorage) reconnect() {
+	if s.client.NoOp() == nil {
+		return
+	}
+	if c, err := s.cfg.dial(); err == nil {
+		s.client.Quit() // #nosec G104
+		s.client = c
+	}
+}
+
+// ftpTransient reports whether a failed FTP operation may succeed if
+// tried again: 4xx replies are transient by definition, and so are
+// dropped connections. 5xx replies, such as a missing file, are not.
+func ftpTransient(err error) bool {
+	var reply *textproto.Error
+	if errors.As(err, &reply) {
+		return reply.Code >= 400 && reply.Code < 500
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE)
+}
+
+// stor uploads r to path. Only a reader that can be rewound is retried, as
+// a failed attempt may have consumed part of it.
+func (s *FTPStorage) stor(ctx context.Context, path string, r io.Reader) error {
+	seeker, ok := r.(io.Seeker)
+	if !ok {
+		return s.client.Stor(path, r)
+	}
+	start, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return s.client.Stor(path, r)
+	}
+	return s.retry(ctx, func() error {
+		if _, err := seeker.Seek(start, io.SeekStart); err != nil {
+			return err
+		}
+		return s.client.Stor(path, r)
+	})
+}
+
+// retr starts downloading path.
+func (s *FTPStorage) retr(ctx context.Context, path string) (io.ReadCloser, error) {
+	var resp io.ReadCloser
+	err := s.retry(ctx, func() (err error) {
+		resp, err = s.client.Retr(path)
+		return err
+	})
+	return resp, err
+}
+
 func (s *FTPStorage) Save(ctx context.Context, name string, r io.Reader) (string, error) {
 	path := filepath.Join(s.remotePath, name)
 	if err := s.ensureDir(filepath.Dir(path)); err != nil {
 		return "", err
 	}
-	err := s.client.Stor(path, r)
-	if err != nil {
+	stor := func(path string, r io.Reader) error { return s.stor(ctx, path, r) }
+	if err := saveAtomic(path, r, stor, s.rename, s.client.Delete); err != nil {
 		return "", err
 	}
-	return "ftp://" + s.host + path, nil
+	return s.scheme() + "://" + s.host + path, nil
 }
 
 func (s *FTPStorage) Open(ctx context.Context, name string) (io.ReadCloser, error) {
-	return s.client.Retr(filepath.Join(s.remotePath, name))
+	return s.retr(ctx, filepath.Join(s.remotePath, name))
 }
 
 func (s *FTPStorage) Exists(ctx context.Context, name string) (bool, error) {
@@ -73,12 +255,33 @@ func (s *FTPStorage) Exists(ctx context.Context, name string) (bool, error) {
 	return false, nil
 }
 
+// Stat uses SIZE, and MDTM when the server supports it.
+func (s *FTPStorage) Stat(ctx context.Context, name string) (StorageInfo, error) {
+	path := filepath.Join(s.remotePath, name)
+	size, err := s.client.FileSize(path)
+	if err != nil {
+		return StorageInfo{}, err
+	}
+	info := StorageInfo{Size: size}
+	if s.client.IsGetTimeSupported() {
+		info.ModTime, _ = s.client.GetTime(path)
+	}
+	return info, nil
+}
+
 func (s *FTPStorage) Delete(ctx context.Context, name string) error {
 	return s.client.Delete(filepath.Join(s.remotePath, name))
 }
 
 func (s *FTPStorage) Location() string {
-	return "ftp://" + s.host + s.remotePath
+	return s.scheme() + "://" + s.host + s.remotePath
+}
+
+func (s *FTPStorage) scheme() string {
+	if s.cfg.tlsMode != "" {
+		return "ftps"
+	}
+	return "ftp"
 }
 
 func (s *FTPStorage) PutMetadata(ctx context.Context, name string, data []byte) error {
@@ -86,54 +289,118 @@ func (s *FTPStorage) PutMetadata(ctx context.Context, name string, data []byte)
 	if err := s.ensureDir(filepath.Dir(path)); err != nil {
 		return err
 	}
-	return s.client.Stor(path, bytes.NewReader(data))
+	stor := func(path string, r io.Reader) error { return s.stor(ctx, path, r) }
+	return putAtomic(path, data, stor, s.rename, s.client.Delete)
+}
+
+// rename replaces to with from. Some servers refuse to rename onto an
+// existing file.
+func (s *FTPStorage) rename(from, to string) error {
+	if err := s.client.Rename(from, to); err == nil {
+		return nil
+	}
+	s.client.Delete(to) // #nosec G104
+	return s.client.Rename(from, to)
 }
 
 func (s *FTPStorage) GetMetadata(ctx context.Context, name string) ([]byte, error) {
 	path := filepath.Join(s.remotePath, name)
-	r, err := s.client.Retr(path)
-	if err != nil {
-		return nil, err
-	}
-	defer r.Close()
-	return io.ReadAll(r)
+	var data []byte
+	err := s.retry(ctx, func() error {
+		r, err := s.client.Retr(path)
+		if err != nil {
+			return err
+		}
+		data, err = io.ReadAll(r)
+		if cerr := r.Close(); err == nil {
+			err = cerr
+		}
+		return err
+	})
+	return data, err
 }
 
+// ListMetadata walks subdirectories, as name templates can put manifests in
+// them; chunks/ is only walked when prefix asks for it.
 func (s *FTPStorage) ListMetadata(ctx context.Context, prefix string) ([]string, error) {
 	searchDir := s.remotePath
-	basePrefix := prefix
-
-	if strings.Contains(prefix, "/") {
+	if prefix != "" {
 		if strings.HasSuffix(prefix, "/") {
 			searchDir = filepath.Join(s.remotePath, prefix)
-			basePrefix = ""
 		} else {
 			searchDir = filepath.Join(s.remotePath, filepath.Dir(prefix))
-			basePrefix = filepath.Base(prefix)
 		}
 	}
 
-	entries, err := s.client.NameList(searchDir)
-	if err != nil {
-		return nil, nil // Assume dir doesn't exist
-	}
-
 	var files []string
-	for _, entry := range entries {
-		name := filepath.Base(entry)
-		if basePrefix == "" || strings.HasPrefix(name, basePrefix) {
-			relDir := ""
-			if strings.Contains(prefix, "/") {
-				if strings.HasSuffix(prefix, "/") {
-					relDir = prefix
-				} else {
-					relDir = filepath.Dir(prefix) + "/"
+	var walk func(dir string) error
+	walk = func(dir string) error {
+		entries, err := s.list(ctx, dir)
+		if err != nil {
+			return err
+		}
+
+		for _, entry := range entries {
+			name := filepath.Base(entry.Name)
+			if name == "." || name == ".." {
+				continue
+			}
+			path := filepath.Join(dir, name)
+			switch entry.Type {
+			case ftp.EntryTypeFolder:
+				if name == "chunks" && !strings.Contains(prefix, "chunks") {
+					continue
+				}
+				if err := walk(path); err != nil {
+					return err
+				}
+			case ftp.EntryTypeFile:
+				rel := strings.TrimPrefix(path, s.remotePath)
+				rel = strings.TrimPrefix(rel, "/")
+				rel = filepath.ToSlash(rel)
+
+				if prefix != "" && !strings.HasSuffix(prefix, "/") && !strings.HasPrefix(rel, prefix) {
+					continue
 				}
+				files = append(files, rel)
 			}
-			files = append(files, relDir+name)
+		}
+		return nil
+	}
+
+	err := walk(searchDir)
+	return files, err
+}
+
+// list lists dir; a directory that doesn't exist is empty. FTP answers 550
+// both for a missing directory and for one it won't show, so a 550 only
+// counts as missing when the parent's listing lacks the directory. Any
+// other failure is returned: a directory that can't be read may hold
+// manifests, and hiding it would let GC delete the chunks they reference.
+func (s *FTPStorage) list(ctx context.Context, dir string) ([]*ftp.Entry, error) {
+	var entries []*ftp.Entry
+	err := s.retry(ctx, func() (err error) {
+		entries, err = s.client.List(dir)
+		return err
+	})
+	if err == nil {
+		return entries, nil
+	}
+	var reply *textproto.Error
+	parent := filepath.Dir(dir)
+	if !errors.As(err, &reply) || reply.Code != ftp.StatusFileUnavailable || parent == dir {
+		return nil, fmt.Errorf("list %s: %w", dir, err)
+	}
+	siblings, perr := s.list(ctx, parent)
+	if perr != nil {
+		return nil, fmt.Errorf("list %s: %w", dir, err)
+	}
+	for _, e := range siblings {
+		if filepath.Base(e.Name) == filepath.Base(dir) {
+			return nil, fmt.Errorf("list %s: %w", dir, err)
 		}
 	}
-	return files, nil
+	return nil, nil
 }
 
 func (s *FTPStorage) ensureDir(path string) error {
@@ -3,11 +3,25 @@ package storage
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
 	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
 	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
 	"testing"
+	"time"
 
+	"github.com/jlaffaye/ftp"
+	apperrors "github.com/lupppig/dbackup/internal/errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/testcontainers/testcontainers-go"
@@ -83,6 +97,19 @@ func TestFTPStorage_Integration(t *testing.T) {
 		assert.Contains(t, files, name)
 	})
 
+	t.Run("PassiveMode", func(t *testing.T) {
+		pu, err := url.Parse(uri + "?mode=passive")
+		require.NoError(t, err)
+		ps, err := NewFTPStorage(pu, StorageOptions{AllowInsecure: true})
+		require.NoError(t, err)
+		defer ps.Close()
+
+		require.NoError(t, ps.PutMetadata(ctx, "pasv.manifest", []byte("pasv")))
+		got, err := ps.GetMetadata(ctx, "pasv.manifest")
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("pasv"), got)
+	})
+
 	t.Run("Delete", func(t *testing.T) {
 		name := "to_delete.txt"
 		_, err := s.Save(ctx, name, bytes.NewReader([]byte("bye")))
@@ -95,3 +122,346 @@ func TestFTPStorage_Integration(t *testing.T) {
 		assert.Error(t, err)
 	})
 }
+
+func TestParseFTPConfig(t *testing.T) {
+	parse := func(uri string, insecure bool) (ftpConfig, error) {
+		u, err := url.Parse(uri)
+		require.NoError(t, err)
+		return parseFTPConfig(u, StorageOptions{AllowInsecure: insecure})
+	}
+
+	cfg, err := parse("ftp://u:p@files.example.com/backups", true)
+	require.NoError(t, err)
+	assert.Equal(t, ftpConfig{addr: "files.example.com:21", user: "u", pass: "p"}, cfg)
+
+	cfg, err = parse("ftp://u:p@files.example.com:2121/backups?mode=passive", true)
+	require.NoError(t, err)
+	assert.True(t, cfg.pasv)
+	assert.Equal(t, "files.example.com:2121", cfg.addr)
+
+	// FTPS needs no opt-in; plain FTP does
+	_, err = parse("ftp://u:p@files.example.com/backups", false)
+	assert.ErrorContains(t, err, "--allow-insecure")
+	cfg, err = parse("ftp://u:p@files.example.com/backups?tls=explicit", false)
+	require.NoError(t, err)
+	assert.Equal(t, "explicit", cfg.tlsMode)
+	assert.Equal(t, "files.example.com:21", cfg.addr)
+
+	// Implicit TLS defaults to port 990
+	cfg, err = parse("ftp://u:p@files.example.com/backups?tls=implicit", false)
+	require.NoError(t, err)
+	assert.Equal(t, "files.example.com:990", cfg.addr)
+	cfg, err = parse("ftp://u:p@files.example.com:2990/backups?tls=implicit", false)
+	require.NoError(t, err)
+	assert.Equal(t, "files.example.com:2990", cfg.addr)
+
+	for _, uri := range []string{
+		"ftp://u:p@files.example.com/?mode=active",
+		"ftp://u:p@files.example.com/?mode=fast",
+		"ftp://u:p@files.example.com/?tls=yes",
+	} {
+		_, err := parse(uri, true)
+		assert.True(t, apperrors.IsType(err, apperrors.TypeConfig), "%s: %v", uri, err)
+	}
+}
+
+func TestFTPTransient(t *testing.T) {
+	for _, tc := range []struct {
+		err       error
+		transient bool
+	}{
+		{&textproto.Error{Code: 421, Msg: "Too many connections"}, true},
+		{&textproto.Error{Code: 425, Msg: "Can't open data connection"}, true},
+		{&textproto.Error{Code: 550, Msg: "No such file"}, false},
+		{fmt.Errorf("upload: %w", syscall.ECONNRESET), true},
+		{io.ErrUnexpectedEOF, true},
+		{errors.New("permission denied"), false},
+	} {
+		assert.Equal(t, tc.transient, ftpTransient(tc.err), tc.err.Error())
+	}
+}
+
+// fakeFTPS answers just enough of FTP for a client to log in over explicit
+// TLS, and records the commands it received in the clear.
+func fakeFTPS(t *testing.T, cert tls.Certificate) (addr string, plain func() []string) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+
+	var mu sync.Mutex
+	var seen []string
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				var conn net.Conn = c
+				tp := textproto.NewConn(conn)
+				tp.PrintfLine("220 ready")
+				secure := false
+				for {
+					line, err := tp.ReadLine()
+					if err != nil {
+						return
+					}
+					cmd, _, _ := strings.Cut(line, " ")
+					if !secure {
+						mu.Lock()
+						seen = append(seen, cmd)
+						mu.Unlock()
+					}
+					switch cmd {
+					case "AUTH":
+						tp.PrintfLine("234 go ahead")
+						conn = tls.Server(c, &tls.Config{Certificates: []tls.Certificate{cert}})
+						tp = textproto.NewConn(conn)
+						secure = true
+					case "USER":
+						tp.PrintfLine("331 password please")
+					case "PASS":
+						tp.PrintfLine("230 logged in")
+					case "FEAT":
+						tp.PrintfLine("211 no features")
+					case "QUIT":
+						tp.PrintfLine("221 bye")
+						return
+					default:
+						tp.PrintfLine("200 ok")
+					}
+				}
+			}()
+		}
+	}()
+	return l.Addr().String(), func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), seen...)
+	}
+}
+
+func TestFTPStorage_ExplicitTLS(t *testing.T) {
+	// httptest's certificate is valid for 127.0.0.1 but signed by no trusted CA
+	server := httptest.NewTLSServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	defer server.Close()
+	addr, plain := fakeFTPS(t, server.TLS.Certificates[0])
+
+	open := func(query string, insecure bool) error {
+		u, err := url.Parse("ftps://u:secret@" + addr + "/backups" + query)
+		require.NoError(t, err)
+		s, err := FromURI(u.String(), StorageOptions{AllowInsecure: insecure})
+		if err == nil {
+			assert.Equal(t, "ftps://"+addr+"/backups", s.Location())
+			s.Close()
+		}
+		return err
+	}
+
+	// The certificate is verified, and no plaintext opt-in is needed for that
+	err := open("", false)
+	var unknown x509.UnknownAuthorityError
+	assert.ErrorAs(t, err, &unknown)
+
+	assert.True(t, apperrors.IsType(open("?tls-skip-verify=true", false), apperrors.TypeSecurity))
+	require.NoError(t, open("?tls-skip-verify=true", true))
+	assert.Equal(t, []string{"AUTH", "AUTH"}, plain(), "credentials only ever go over TLS")
+}
+
+// memFTP is an in-memory FTP server behind an ftpConn. Listing the
+// directory locked fails with lockErr.
+type memFTP struct {
+	mu      sync.Mutex
+	files   map[string][]byte
+	dirs    map[string]bool
+	locked  string
+	lockErr error
+}
+
+func newMemFTP() *memFTP {
+	return &memFTP{files: map[string][]byte{}, dirs: map[string]bool{"/": true}}
+}
+
+func ftpUnavailable(msg string) error {
+	return &textproto.Error{Code: ftp.StatusFileUnavailable, Msg: msg}
+}
+
+func (m *memFTP) Stor(path string, r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.dirs[filepath.Dir(path)] {
+		return ftpUnavailable("No such directory")
+	}
+	m.files[path] = data
+	return nil
+}
+
+func (m *memFTP) Retr(path string) (io.ReadCloser, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, ok := m.files[path]
+	if !ok {
+		return nil, ftpUnavailable("No such file")
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (m *memFTP) List(path string) ([]*ftp.Entry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	path = filepath.Clean(path)
+	if path == m.locked {
+		return nil, m.lockErr
+	}
+	if !m.dirs[path] {
+		return nil, ftpUnavailable("No such directory")
+	}
+	var entries []*ftp.Entry
+	for f, data := range m.files {
+		if filepath.Dir(f) == path {
+			entries = append(entries, &ftp.Entry{Name: filepath.Base(f), Type: ftp.EntryTypeFile, Size: uint64(len(data))})
+		}
+	}
+	for d := range m.dirs {
+		if d != path && filepath.Dir(d) == path {
+			entries = append(entries, &ftp.Entry{Name: filepath.Base(d), Type: ftp.EntryTypeFolder})
+		}
+	}
+	return entries, nil
+}
+
+func (m *memFTP) FileSize(path string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, ok := m.files[path]
+	if !ok {
+		return 0, ftpUnavailable("No such file")
+	}
+	return int64(len(data)), nil
+}
+
+func (m *memFTP) IsGetTimeSupported() bool { return false }
+
+func (m *memFTP) GetTime(path string) (time.Time, error) {
+	return time.Time{}, errors.New("MDTM not supported")
+}
+
+func (m *memFTP) Delete(path string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.files[path]; !ok {
+		return ftpUnavailable("No such file")
+	}
+	delete(m.files, path)
+	return nil
+}
+
+func (m *memFTP) Rename(from, to string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, ok := m.files[from]
+	if !ok {
+		return ftpUnavailable("No such file")
+	}
+	delete(m.files, from)
+	m.files[to] = data
+	return nil
+}
+
+func (m *memFTP) MakeDir(path string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.dirs[path] || !m.dirs[filepath.Dir(path)] {
+		return ftpUnavailable("Can't create directory")
+	}
+	m.dirs[path] = true
+	return nil
+}
+
+func (m *memFTP) NoOp() error { return nil }
+func (m *memFTP) Quit() error { return nil }
+
+func TestFTPStorage_ListFailsClosed(t *testing.T) {
+	defer func(d time.Duration) { ftpRetryDelay = d }(ftpRetryDelay)
+	ftpRetryDelay = time.Millisecond
+	ctx := context.Background()
+	server := newMemFTP()
+	s := &FTPStorage{client: server, remotePath: "/backups"}
+
+	// A fresh target is empty, not an error
+	files, err := s.ListMetadata(ctx, "")
+	require.NoError(t, err)
+	assert.Empty(t, files)
+
+	ds := NewDedupeStorage(s)
+	chunks := saveManifest(t, ds, "orders", "orders", bytes.Repeat([]byte("orders "), 10000))
+	_, err = s.Save(ctx, "chunks/orphan", bytes.NewReader([]byte("orphan")))
+	require.NoError(t, err)
+
+	for _, lockErr := range []error{
+		ftpUnavailable("Permission denied"), // The directory exists, so 550 isn't "missing"
+		&textproto.Error{Code: ftp.StatusNotAvailable, Msg: "Too many connections"},
+		fmt.Errorf("read: %w", syscall.ECONNRESET),
+	} {
+		server.locked, server.lockErr = "/backups", lockErr
+		_, err := s.ListMetadata(ctx, "")
+		assert.ErrorIs(t, err, lockErr)
+		removed, err := ds.GC(ctx)
+		assert.Error(t, err)
+		assert.Zero(t, removed)
+		for _, c := range append(chunks, "orphan") {
+			ok, err := s.Exists(ctx, "chunks/"+c)
+			require.NoError(t, err)
+			assert.True(t, ok, c)
+		}
+	}
+
+	server.locked = ""
+	removed, err := ds.GC(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, removed)
+}
+
+func TestFTPStorage_ListMetadataWalksSubdirectories(t *testing.T) {
+	ctx := context.Background()
+	s := &FTPStorage{client: newMemFTP(), remotePath: "/backups"}
+
+	// A name template such as {db}/{date:2006/01/02}/{engine}-{time}.sql
+	// puts manifests below the target root
+	ds := NewDedupeStorage(s)
+	nested := saveManifest(t, ds, "orders/2024/03/09/postgres-140507.042.sql", "orders", bytes.Repeat([]byte("nested "), 10000))
+	saveManifest(t, ds, "users.sql", "users", []byte("flat"))
+
+	files, err := s.ListMetadata(ctx, "")
+	require.NoError(t, err)
+	var manifests []string
+	for _, f := range files {
+		assert.False(t, strings.HasPrefix(f, "chunks/"), "chunks are left out")
+		if strings.HasSuffix(f, ".manifest") {
+			manifests = append(manifests, f)
+		}
+	}
+	assert.ElementsMatch(t, []string{"orders/2024/03/09/postgres-140507.042.sql.manifest", "users.sql.manifest"}, manifests)
+	files, err = s.ListMetadata(ctx, "orders/2024/")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"orders/2024/03/09/postgres-140507.042.sql.manifest"}, files)
+	files, err = s.ListMetadata(ctx, "us")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"users.sql.manifest"}, files)
+
+	// GC sees the nested manifest and keeps its chunks
+	removed, err := ds.GC(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, removed)
+	for _, c := range nested {
+		ok, err := s.Exists(ctx, "chunks/"+c)
+		require.NoError(t, err)
+		assert.True(t, ok, c)
+	}
+}
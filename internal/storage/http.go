@@ -0,0 +1,188 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	apperrors "github.com/lupppig/dbackup/internal/errors"
+)
+
+// httpIndexFile lists the files published under an HTTP source, as a JSON
+// array of paths relative to the base URL.
+const httpIndexFile = "index.json"
+
+var ErrReadOnly = apperrors.New(apperrors.TypeConfig, "read-only backend", "HTTP sources can only be restored or migrated from. Write backups to a local, sftp or s3 target.")
+
+// HTTPStorage reads backups published behind a web server. Credentials in
+// the URI become basic auth (user:pass@) or a bearer token (token@).
+type HTTPStorage struct {
+	client   *http.Client
+	base     *url.URL
+	user     string
+	password string
+	token    string
+}
+
+func NewHTTPStorage(u *url.URL, opts StorageOptions) (*HTTPStorage, error) {
+	if u.Scheme == "http" && !opts.AllowInsecure {
+		return nil, fmt.Errorf("insecure protocol HTTP requires explicit opt-in with --allow-insecure")
+	}
+
+	s := &HTTPStorage{
+		client: &http.Client{}, // No overall timeout: large backups stream for a long time
+	}
+	if u.User != nil {
+		if pass, ok := u.User.Password(); ok {
+			s.user, s.password = u.User.Username(), pass
+		} else {
+			s.token = u.User.Username()
+		}
+	}
+
+	base := *u
+	base.User = nil
+	base.Path = strings.TrimSuffix(base.Path, "/")
+	s.base = &base
+	return s, nil
+}
+
+func (s *HTTPStorage) url(name string) string {
+	u := *s.base
+	u.Path = u.Path + "/" + strings.TrimPrefix(name, "/")
+	return u.String()
+}
+
+func (s *HTTPStorage) do(ctx context.Context, method, name string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, s.url(name), nil)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case s.token != "":
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	case s.user != "":
+		req.SetBasicAuth(s.user, s.password)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		resp.Body.Close() // #nosec G104
+		return nil, fmt.Errorf("%s: %w", name, os.ErrNotExist)
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		resp.Body.Close() // #nosec G104
+		return nil, apperrors.New(apperrors.TypeAuth, fmt.Sprintf("HTTP %d fetching %s", resp.StatusCode, name), "Check the credentials in the source URI (user:pass@ for basic auth, token@ for a bearer token).")
+	case resp.StatusCode >= 300:
+		resp.Body.Close() // #nosec G104
+		return nil, fmt.Errorf("HTTP %d fetching %s", resp.StatusCode, name)
+	}
+	return resp, nil
+}
+
+func (s *HTTPStorage) Save(ctx context.Context, name string, r io.Reader) (string, error) {
+	return "", ErrReadOnly
+}
+
+func (s *HTTPStorage) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	resp, err := s.do(ctx, http.MethodGet, name)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *HTTPStorage) Exists(ctx context.Context, name string) (bool, error) {
+	resp, err := s.do(ctx, http.MethodHead, name)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return false, nil
+		}
+		return false, err
+	}
+	resp.Body.Close() // #nosec G104
+	return true, nil
+}
+
+// Stat sends a HEAD request; servers that omit Content-Length report -1.
+func (s *HTTPStorage) Stat(ctx context.Context, name string) (StorageInfo, error) {
+	resp, err := s.do(ctx, http.MethodHead, name)
+	if err != nil {
+		return StorageInfo{}, err
+	}
+	resp.Body.Close() // #nosec G104
+	info := StorageInfo{Size: resp.ContentLength}
+	if lm := resp.Header.Get("Last-Modified"); lm != "" {
+		info.ModTime, _ = http.ParseTime(lm)
+	}
+	return info, nil
+}
+
+func (s *HTTPStorage) Delete(ctx context.Context, name string) error {
+	return ErrReadOnly
+}
+
+func (s *HTTPStorage) Location() string {
+	return s.base.String()
+}
+
+func (s *HTTPStorage) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
+
+func (s *HTTPStorage) PutMetadata(ctx context.Context, name string, data []byte) error {
+	return ErrReadOnly
+}
+
+func (s *HTTPStorage) GetMetadata(ctx context.Context, name string) ([]byte, error) {
+	resp, err := s.do(ctx, http.MethodGet, name)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
+
+// ListMetadata reads index.json from the base URL and filters it by prefix.
+// Without an index the source can still be restored by explicit name.
+func (s *HTTPStorage) ListMetadata(ctx context.Context, prefix string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	data, err := s.GetMetadata(ctx, httpIndexFile)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var index []string
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, apperrors.Wrap(err, apperrors.TypeConfig, "invalid "+httpIndexFile+" at "+s.Location(), "The index must be a JSON array of file paths relative to the base URL.")
+	}
+
+	var files []string
+	for _, f := range index {
+		f = strings.TrimPrefix(f, "/")
+		// Match LocalStorage: chunks are only listed when asked for
+		if strings.HasPrefix(f, "chunks/") && !strings.Contains(prefix, "chunks") {
+			continue
+		}
+		if prefix != "" && !strings.HasPrefix(f, prefix) {
+			continue
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPStorage_ReadOnly(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "db.sql"), []byte("dump"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "db.sql.manifest"), []byte("{}"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "index.json"), []byte(`["db.sql","db.sql.manifest","chunks/ab/abcd"]`), 0644))
+
+	files := http.StripPrefix("/backups", http.FileServer(http.Dir(dir)))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer s3cret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		files.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	base := strings.Replace(srv.URL, "http://", "http://s3cret@", 1) + "/backups"
+
+	_, err := FromURI(base, StorageOptions{})
+	assert.Error(t, err, "plain http must require --allow-insecure")
+
+	s, err := FromURI(base, StorageOptions{AllowInsecure: true})
+	require.NoError(t, err)
+	require.IsType(t, &HTTPStorage{}, s)
+	assert.NotContains(t, s.Location(), "s3cret")
+
+	rc, err := s.Open(ctx, "db.sql")
+	require.NoError(t, err)
+	data, _ := io.ReadAll(rc)
+	rc.Close()
+	assert.Equal(t, "dump", string(data))
+
+	ok, err := s.Exists(ctx, "missing.sql")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	list, err := s.ListMetadata(ctx, "db.")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"db.sql", "db.sql.manifest"}, list)
+
+	_, err = s.Save(ctx, "x", strings.NewReader("x"))
+	assert.True(t, errors.Is(err, ErrReadOnly))
+	assert.True(t, errors.Is(s.Delete(ctx, "db.sql"), ErrReadOnly))
+	assert.True(t, errors.Is(s.PutMetadata(ctx, "x.manifest", nil), ErrReadOnly))
+
+	// Wrong token surfaces as an auth error rather than "not found"
+	bad, err := FromURI(strings.Replace(base, "s3cret", "wrong", 1), StorageOptions{AllowInsecure: true})
+	require.NoError(t, err)
+	_, err = bad.GetMetadata(ctx, "db.sql.manifest")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "HTTP 401")
+}
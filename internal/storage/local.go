@@ -21,29 +21,57 @@ func NewLocalStorage(baseDir string) *LocalStorage {
 }
 
 func (s *LocalStorage) Save(ctx context.Context, name string, r io.Reader) (string, error) {
+	f, err := s.create(name)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Abort()
+		return "", fmt.Errorf("failed to write data: %w", err)
+	}
+	return f.Commit()
+}
+
+// localFile is a file being written through a temporary sibling, renamed
+// into place on Commit.
+type localFile struct {
+	f    *os.File
+	tmp  string
+	path string
+}
+
+func (s *LocalStorage) create(name string) (*localFile, error) {
 	path := filepath.Join(s.baseDir, name)
 	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
-		return "", fmt.Errorf("failed to create directory: %w", err)
+		return nil, fmt.Errorf("failed to create directory: %w", err)
 	}
 
-	tmpPath := path + ".tmp"
-	f, err := os.Create(tmpPath)
+	tmpPath := tempName(path)
+	f, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
 	if err != nil {
-		return "", fmt.Errorf("failed to create temp file: %w", err)
+		return nil, fmt.Errorf("failed to create temp file: %w", err)
 	}
-	defer os.Remove(tmpPath) // Cleanup on failure
+	return &localFile{f: f, tmp: tmpPath, path: path}, nil
+}
 
-	if _, err := io.Copy(f, r); err != nil {
-		f.Close() // #nosec G104
+func (f *localFile) Write(p []byte) (int, error) {
+	return f.f.Write(p)
+}
+
+func (f *localFile) Commit() (string, error) {
+	defer os.Remove(f.tmp) // Cleanup on failure
+	if err := f.f.Close(); err != nil {
 		return "", fmt.Errorf("failed to write data: %w", err)
 	}
-	f.Close() // #nosec G104
-
-	if err := os.Rename(tmpPath, path); err != nil {
+	if err := os.Rename(f.tmp, f.path); err != nil {
 		return "", fmt.Errorf("failed to finalize file (rename): %w", err)
 	}
+	return f.path, nil
+}
 
-	return path, nil
+func (f *localFile) Abort() {
+	f.f.Close()      // #nosec G104
+	os.Remove(f.tmp) // #nosec G104
 }
 
 func (s *LocalStorage) Open(ctx context.Context, name string) (io.ReadCloser, error) {
@@ -62,6 +90,14 @@ func (s *LocalStorage) Exists(ctx context.Context, name string) (bool, error) {
 	return false, err
 }
 
+func (s *LocalStorage) Stat(ctx context.Context, name string) (StorageInfo, error) {
+	fi, err := os.Stat(filepath.Join(s.baseDir, name))
+	if err != nil {
+		return StorageInfo{}, err
+	}
+	return StorageInfo{Size: fi.Size(), ModTime: fi.ModTime()}, nil
+}
+
 func (s *LocalStorage) Delete(ctx context.Context, name string) error {
 	path := filepath.Join(s.baseDir, name)
 	return os.Remove(path)
@@ -76,7 +112,35 @@ func (s *LocalStorage) PutMetadata(ctx context.Context, name string, data []byte
 	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
 		return err
 	}
-	return os.WriteFile(path, data, 0644)
+	write := func(p string, r io.Reader) error {
+		f, err := os.Create(p)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(f, r); err != nil {
+			f.Close() // #nosec G104
+			return err
+		}
+		return f.Close()
+	}
+	return putAtomic(path, data, write, os.Rename, os.Remove)
+}
+
+// putMetadataExclusive writes name only if it doesn't exist yet, failing
+// with an error matching fs.ErrExist otherwise. The file is written aside
+// and hard-linked into place, so readers never see it half written.
+func (s *LocalStorage) putMetadataExclusive(name string, data []byte) error {
+	path := filepath.Join(s.baseDir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := tempName(path)
+	defer os.Remove(tmp) // #nosec G104
+
+	if err := os.WriteFile(tmp, data, 0644); err != nil { // #nosec G306
+		return err
+	}
+	return os.Link(tmp, path)
 }
 
 func (s *LocalStorage) GetMetadata(ctx context.Context, name string) ([]byte, error) {
@@ -0,0 +1,166 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemStorage keeps objects in memory, for tests and dry runs. Every
+// MemStorage with the same name in a process shares its objects, so a
+// mem:// target can be written and read back by separate commands.
+type MemStorage struct {
+	name string
+	objs *memObjects
+}
+
+type memObjects struct {
+	mu   sync.Mutex
+	data map[string]memObject
+}
+
+type memObject struct {
+	data    []byte
+	modTime time.Time
+}
+
+var (
+	memMu      sync.Mutex
+	memTargets = map[string]*memObjects{}
+)
+
+// NewMemStorage returns the in-memory target called name.
+func NewMemStorage(name string) *MemStorage {
+	memMu.Lock()
+	defer memMu.Unlock()
+	objs, ok := memTargets[name]
+	if !ok {
+		objs = &memObjects{data: map[string]memObject{}}
+		memTargets[name] = objs
+	}
+	return &MemStorage{name: name, objs: objs}
+}
+
+func (s *MemStorage) put(name string, data []byte) {
+	s.objs.mu.Lock()
+	defer s.objs.mu.Unlock()
+	s.objs.data[name] = memObject{data: data, modTime: time.Now()}
+}
+
+func (s *MemStorage) get(name string) ([]byte, error) {
+	s.objs.mu.Lock()
+	defer s.objs.mu.Unlock()
+	obj, ok := s.objs.data[name]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", name, os.ErrNotExist)
+	}
+	return obj.data, nil
+}
+
+func (s *MemStorage) Save(ctx context.Context, name string, r io.Reader) (string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return "", fmt.Errorf("failed to write data: %w", err)
+	}
+	s.put(name, data)
+	return s.Location() + "/" + name, nil
+}
+
+// memFile buffers a file until Commit stores it.
+type memFile struct {
+	s    *MemStorage
+	name string
+	buf  bytes.Buffer
+}
+
+func (f *memFile) Write(p []byte) (int, error) {
+	return f.buf.Write(p)
+}
+
+func (f *memFile) Commit() (string, error) {
+	f.s.put(f.name, f.buf.Bytes())
+	return f.s.Location() + "/" + f.name, nil
+}
+
+func (f *memFile) Abort() {
+	f.buf.Reset()
+}
+
+func (s *MemStorage) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	data, err := s.get(name)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (s *MemStorage) Exists(ctx context.Context, name string) (bool, error) {
+	_, err := s.get(name)
+	return err == nil, nil
+}
+
+func (s *MemStorage) Stat(ctx context.Context, name string) (StorageInfo, error) {
+	s.objs.mu.Lock()
+	defer s.objs.mu.Unlock()
+	obj, ok := s.objs.data[name]
+	if !ok {
+		return StorageInfo{}, fmt.Errorf("%s: %w", name, os.ErrNotExist)
+	}
+	return StorageInfo{Size: int64(len(obj.data)), ModTime: obj.modTime}, nil
+}
+
+func (s *MemStorage) Delete(ctx context.Context, name string) error {
+	s.objs.mu.Lock()
+	defer s.objs.mu.Unlock()
+	if _, ok := s.objs.data[name]; !ok {
+		return fmt.Errorf("%s: %w", name, os.ErrNotExist)
+	}
+	delete(s.objs.data, name)
+	return nil
+}
+
+func (s *MemStorage) Location() string {
+	return "mem://" + s.name
+}
+
+func (s *MemStorage) Close() error {
+	return nil
+}
+
+func (s *MemStorage) PutMetadata(ctx context.Context, name string, data []byte) error {
+	s.put(name, bytes.Clone(data))
+	return nil
+}
+
+func (s *MemStorage) GetMetadata(ctx context.Context, name string) ([]byte, error) {
+	data, err := s.get(name)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.Clone(data), nil
+}
+
+// ListMetadata lists like LocalStorage: names starting with prefix, leaving
+// out chunks/ unless the prefix is in it.
+func (s *MemStorage) ListMetadata(ctx context.Context, prefix string) ([]string, error) {
+	s.objs.mu.Lock()
+	defer s.objs.mu.Unlock()
+	var names []string
+	for name := range s.objs.data {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		if strings.HasPrefix(name, "chunks/") && !strings.Contains(prefix, "chunks") {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
@@ -0,0 +1,275 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// MultiStorage fans writes out to several targets so one dump can satisfy
+// the 3-2-1 rule. A target that fails is dropped and the others carry on;
+// reads are served by the first target that answers.
+type MultiStorage struct {
+	targets []Storage
+}
+
+func NewMultiStorage(targets ...Storage) *MultiStorage {
+	return &MultiStorage{targets: targets}
+}
+
+// PartialError reports a write that reached some targets but not all.
+type PartialError struct {
+	Succeeded []string
+	Failed    map[string]error
+}
+
+func (e *PartialError) Error() string {
+	var failed []string
+	for loc, err := range e.Failed {
+		failed = append(failed, fmt.Sprintf("%s: %v", Scrub(loc), err))
+	}
+	sort.Strings(failed)
+	var ok []string
+	for _, loc := range e.Succeeded {
+		ok = append(ok, Scrub(loc))
+	}
+	return fmt.Sprintf("partial success: written to [%s], failed on [%s]", strings.Join(ok, ", "), strings.Join(failed, "; "))
+}
+
+// result turns per-target errors into nil, a *PartialError or, when every
+// target failed, a joined error.
+func (m *MultiStorage) result(errs []error) error {
+	pe := &PartialError{Failed: make(map[string]error)}
+	var all []error
+	for i, err := range errs {
+		loc := m.targets[i].Location()
+		if err != nil {
+			pe.Failed[loc] = err
+			all = append(all, fmt.Errorf("%s: %w", Scrub(loc), err))
+		} else {
+			pe.Succeeded = append(pe.Succeeded, loc)
+		}
+	}
+	switch {
+	case len(pe.Failed) == 0:
+		return nil
+	case len(pe.Succeeded) == 0:
+		return errors.Join(all...)
+	default:
+		return pe
+	}
+}
+
+func (m *MultiStorage) Save(ctx context.Context, name string, r io.Reader) (string, error) {
+	writers := make([]*io.PipeWriter, len(m.targets))
+	errs := make([]error, len(m.targets))
+	var wg sync.WaitGroup
+
+	for i, t := range m.targets {
+		pr, pw := io.Pipe()
+		writers[i] = pw
+		wg.Add(1)
+		go func(i int, t Storage) {
+			defer wg.Done()
+			_, err := t.Save(ctx, name, pr)
+			errs[i] = err
+			if err == nil {
+				err = io.ErrClosedPipe // Unblock writers if Save returned early
+			}
+			pr.CloseWithError(err) // #nosec G104
+		}(i, t)
+	}
+
+	// Tee the stream; a target whose Save failed stops receiving data
+	_, copyErr := io.Copy(&fanoutWriter{writers: writers, failed: make([]bool, len(writers))}, r)
+	for _, pw := range writers {
+		if copyErr != nil {
+			pw.CloseWithError(copyErr) // #nosec G104
+		} else {
+			pw.Close() // #nosec G104
+		}
+	}
+	wg.Wait()
+
+	if errors.Is(copyErr, errAllTargetsFailed) {
+		return "", m.result(errs)
+	}
+	if copyErr != nil {
+		return "", copyErr
+	}
+	return m.Location() + "/" + name, m.result(errs)
+}
+
+var errAllTargetsFailed = errors.New("all storage targets failed")
+
+type fanoutWriter struct {
+	writers []*io.PipeWriter
+	failed  []bool
+}
+
+func (f *fanoutWriter) Write(p []byte) (int, error) {
+	alive := 0
+	for i, w := range f.writers {
+		if f.failed[i] {
+			continue
+		}
+		if _, err := w.Write(p); err != nil {
+			f.failed[i] = true
+			continue
+		}
+		alive++
+	}
+	if alive == 0 {
+		return 0, errAllTargetsFailed
+	}
+	return len(p), nil
+}
+
+func (m *MultiStorage) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	var errs []error
+	for _, t := range m.targets {
+		rc, err := t.Open(ctx, name)
+		if err == nil {
+			return rc, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", Scrub(t.Location()), err))
+	}
+	return nil, errors.Join(errs...)
+}
+
+// Exists reports true only if every target has name, so that dedupe chunks
+// missing from one target are written to it.
+func (m *MultiStorage) Exists(ctx context.Context, name string) (bool, error) {
+	for _, t := range m.targets {
+		ok, err := t.Exists(ctx, name)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// Stat reports the first target that has name, as Open reads from it.
+func (m *MultiStorage) Stat(ctx context.Context, name string) (StorageInfo, error) {
+	var errs []error
+	for _, t := range m.targets {
+		info, err := t.Stat(ctx, name)
+		if err == nil {
+			return info, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", Scrub(t.Location()), err))
+	}
+	return StorageInfo{}, errors.Join(errs...)
+}
+
+func (m *MultiStorage) Delete(ctx context.Context, name string) error {
+	errs := make([]error, len(m.targets))
+	for i, t := range m.targets {
+		errs[i] = t.Delete(ctx, name)
+	}
+	return m.result(errs)
+}
+
+func (m *MultiStorage) Location() string {
+	locs := make([]string, len(m.targets))
+	for i, t := range m.targets {
+		locs[i] = t.Location()
+	}
+	return strings.Join(locs, ",")
+}
+
+func (m *MultiStorage) Close() error {
+	var errs []error
+	for _, t := range m.targets {
+		if err := t.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (m *MultiStorage) PutMetadata(ctx context.Context, name string, data []byte) error {
+	errs := make([]error, len(m.targets))
+	for i, t := range m.targets {
+		errs[i] = t.PutMetadata(ctx, name, data)
+	}
+	return m.result(errs)
+}
+
+func (m *MultiStorage) GetMetadata(ctx context.Context, name string) ([]byte, error) {
+	var errs []error
+	for _, t := range m.targets {
+		data, err := t.GetMetadata(ctx, name)
+		if err == nil {
+			return data, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", Scrub(t.Location()), err))
+	}
+	return nil, errors.Join(errs...)
+}
+
+// ListMetadata lists the files on any target. A backup can reach only some
+// targets, and GC must see its manifest wherever it is, so the listings are
+// merged, and one target failing to list fails the whole call rather than
+// hiding that target's files.
+func (m *MultiStorage) ListMetadata(ctx context.Context, prefix string) ([]string, error) {
+	lists, err := m.listAll(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	var files []string
+	for _, list := range lists {
+		for _, f := range list {
+			if !seen[f] {
+				seen[f] = true
+				files = append(files, f)
+			}
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// listCommon lists the files every target has, as Exists reports them.
+func (m *MultiStorage) listCommon(ctx context.Context, prefix string) ([]string, error) {
+	lists, err := m.listAll(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	count := make(map[string]int)
+	for _, list := range lists {
+		for _, f := range list {
+			count[f]++
+		}
+	}
+	var files []string
+	for f, n := range count {
+		if n == len(lists) {
+			files = append(files, f)
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+func (m *MultiStorage) listAll(ctx context.Context, prefix string) ([][]string, error) {
+	lists := make([][]string, len(m.targets))
+	var errs []error
+	for i, t := range m.targets {
+		files, err := t.ListMetadata(ctx, prefix)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", Scrub(t.Location()), err))
+			continue
+		}
+		lists[i] = files
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return lists, nil
+}
@@ -0,0 +1,148 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"path/filepath"
+	"testing"
+
+	"github.com/lupppig/dbackup/internal/manifest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type failingStorage struct {
+	Storage
+}
+
+func (f *failingStorage) Save(ctx context.Context, name string, r io.Reader) (string, error) {
+	// Read a little, then fail mid-stream like a dropped connection
+	io.CopyN(io.Discard, r, 10) // #nosec G104
+	return "", errors.New("connection reset")
+}
+
+func (f *failingStorage) Location() string { return "s3://broken/bucket" }
+
+func TestMultiStorage_FanOut(t *testing.T) {
+	ctx := context.Background()
+	dirA, dirB := t.TempDir(), t.TempDir()
+
+	s, err := FromURI(dirA+","+dirB, StorageOptions{})
+	require.NoError(t, err)
+	require.IsType(t, &MultiStorage{}, s)
+
+	data := bytes.Repeat([]byte("backup data "), 100000)
+	_, err = s.Save(ctx, "dump.sql", bytes.NewReader(data))
+	require.NoError(t, err)
+	require.NoError(t, s.PutMetadata(ctx, "dump.sql.manifest", []byte("{}")))
+
+	for _, dir := range []string{dirA, dirB} {
+		got, err := NewLocalStorage(dir).GetMetadata(ctx, "dump.sql")
+		require.NoError(t, err)
+		assert.Equal(t, data, got)
+		assert.FileExists(t, filepath.Join(dir, "dump.sql.manifest"))
+	}
+
+	// Open falls back to the next target when the first one lost the file
+	require.NoError(t, NewLocalStorage(dirA).Delete(ctx, "dump.sql"))
+	rc, err := s.Open(ctx, "dump.sql")
+	require.NoError(t, err)
+	got, _ := io.ReadAll(rc)
+	rc.Close()
+	assert.Equal(t, data, got)
+}
+
+func TestMultiStorage_PartialFailure(t *testing.T) {
+	ctx := context.Background()
+	good := NewLocalStorage(t.TempDir())
+	s := NewMultiStorage(&failingStorage{Storage: NewLocalStorage(t.TempDir())}, good)
+
+	data := bytes.Repeat([]byte("x"), 1<<20)
+	_, err := s.Save(ctx, "dump.sql", bytes.NewReader(data))
+
+	var pe *PartialError
+	require.ErrorAs(t, err, &pe)
+	assert.Equal(t, []string{good.Location()}, pe.Succeeded)
+	assert.Contains(t, pe.Error(), "connection reset")
+
+	got, err := good.GetMetadata(ctx, "dump.sql")
+	require.NoError(t, err)
+	assert.Equal(t, data, got, "healthy target still gets the full stream")
+
+	// All targets failing is a plain error, not a partial success
+	s = NewMultiStorage(&failingStorage{Storage: good}, &failingStorage{Storage: good})
+	_, err = s.Save(ctx, "dump.sql", bytes.NewReader(data))
+	require.Error(t, err)
+	assert.False(t, errors.As(err, &pe))
+	assert.Contains(t, err.Error(), "connection reset")
+}
+
+// listFailing is a target whose listings fail, like a dropped connection.
+type listFailing struct {
+	Storage
+}
+
+func (l *listFailing) ListMetadata(ctx context.Context, prefix string) ([]string, error) {
+	return nil, errors.New("connection reset")
+}
+
+func TestMultiStorage_GCKeepsPartialBackups(t *testing.T) {
+	ctx := context.Background()
+	a, b := NewMemStorage(t.Name()+"-a"), NewMemStorage(t.Name()+"-b")
+	ds := NewDedupeStorage(NewMultiStorage(a, b))
+
+	saveManifest(t, ds, "full", "app", bytes.Repeat([]byte("on both targets "), 10000))
+	// A backup whose chunks reached both targets, but whose manifest was
+	// written to b only
+	partial := bytes.Repeat([]byte("manifest only on the second target "), 10000)
+	_, err := ds.Save(ctx, "partial", bytes.NewReader(partial))
+	require.NoError(t, err)
+	chunks := ds.LastChunks()
+	mb, err := (&manifest.Manifest{ID: "partial", Engine: "postgres", DBName: "app", Chunks: chunks}).Serialize()
+	require.NoError(t, err)
+	require.NoError(t, b.PutMetadata(ctx, "partial.manifest", mb))
+
+	removed, err := ds.GC(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, removed)
+	for _, c := range chunks {
+		ok, err := b.Exists(ctx, "chunks/"+c)
+		require.NoError(t, err)
+		assert.True(t, ok, c)
+	}
+	rc, err := ds.Open(ctx, "partial")
+	require.NoError(t, err)
+	got, err := io.ReadAll(rc)
+	rc.Close()
+	require.NoError(t, err)
+	assert.Equal(t, partial, got)
+
+	// A target that can't list could hold any manifest, so nothing is removed
+	_, err = b.Save(ctx, "chunks/orphan", bytes.NewReader([]byte("orphan")))
+	require.NoError(t, err)
+	ds = NewDedupeStorage(NewMultiStorage(&listFailing{Storage: a}, b))
+	removed, err = ds.GC(ctx)
+	assert.ErrorContains(t, err, "connection reset")
+	assert.Zero(t, removed)
+	ok, _ := b.Exists(ctx, "chunks/orphan")
+	assert.True(t, ok)
+}
+
+func TestMultiStorage_ListMetadata(t *testing.T) {
+	ctx := context.Background()
+	a, b := NewMemStorage(t.Name()+"-a"), NewMemStorage(t.Name()+"-b")
+	require.NoError(t, a.PutMetadata(ctx, "x.manifest", []byte("{}")))
+	require.NoError(t, a.PutMetadata(ctx, "both.manifest", []byte("{}")))
+	require.NoError(t, b.PutMetadata(ctx, "both.manifest", []byte("{}")))
+	require.NoError(t, b.PutMetadata(ctx, "y.manifest", []byte("{}")))
+	m := NewMultiStorage(a, b)
+
+	files, err := m.ListMetadata(ctx, "")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"both.manifest", "x.manifest", "y.manifest"}, files)
+	files, err = m.listCommon(ctx, "")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"both.manifest"}, files)
+}
@@ -3,15 +3,18 @@ package storage
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/base64"
 	"fmt"
 	"io"
 	"net"
+	"net/http"
 	"net/url"
 	"os"
 	"strings"
-
 	"time"
 
+	apperrors "github.com/lupppig/dbackup/internal/errors"
 	"github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/credentials"
 )
@@ -22,35 +25,140 @@ type S3Storage struct {
 	prefix     string
 	endpoint   string
 	useSSL     bool
+	tmpDir     string
+	transport  http.RoundTripper
+	sha256     bool
 }
 
-func NewS3Storage(u *url.URL) (*S3Storage, error) {
-	endpoint := u.Host
-	bucketName := ""
-	prefix := ""
+// s3Config is what an s3:// or minio:// URI resolves to.
+type s3Config struct {
+	endpoint  string
+	bucket    string
+	prefix    string
+	accessKey string
+	secretKey string
+	useSSL    bool
+	region    string
+	lookup    minio.BucketLookupType
+	sha256    bool
+}
 
-	pathParts := strings.SplitN(strings.TrimPrefix(u.Path, "/"), "/", 2)
-	if len(pathParts) > 0 {
-		bucketName = pathParts[0]
+// parseS3URI reads the bucket and prefix from the path and the client options
+// from the query. ?endpoint= replaces the URI host for providers whose
+// endpoint doesn't fit in it, and ?path-style=false switches to
+// virtual-hosted buckets. ?checksum= picks how uploads are verified, see
+// putOptions.
+func parseS3URI(u *url.URL) (s3Config, error) {
+	q := u.Query()
+	cfg := s3Config{
+		endpoint: u.Host,
+		useSSL:   q.Get("ssl") != "false",
+		region:   q.Get("region"),
+		lookup:   minio.BucketLookupPath, // Path-style avoids location probes
 	}
+	cfg.accessKey = u.User.Username()
+	cfg.secretKey, _ = u.User.Password()
+
+	pathParts := strings.SplitN(strings.TrimPrefix(u.Path, "/"), "/", 2)
+	cfg.bucket = pathParts[0]
 	if len(pathParts) > 1 {
-		prefix = pathParts[1]
+		cfg.prefix = pathParts[1]
+	}
+	if cfg.bucket == "" {
+		return cfg, fmt.Errorf("S3/MinIO bucket name is required in URI path")
+	}
+	if cfg.region == "" {
+		cfg.region = "us-east-1"
 	}
 
-	if bucketName == "" {
-		return nil, fmt.Errorf("S3/MinIO bucket name is required in URI path")
+	if ep := q.Get("endpoint"); ep != "" {
+		if scheme, rest, ok := strings.Cut(ep, "://"); ok {
+			secure := scheme == "https"
+			if scheme != "http" && scheme != "https" {
+				return cfg, apperrors.New(apperrors.TypeConfig, "unsupported endpoint scheme: "+scheme, "Use an https:// or http:// endpoint, or just host[:port].")
+			}
+			if q.Has("ssl") && secure != cfg.useSSL {
+				return cfg, apperrors.New(apperrors.TypeConfig, "endpoint scheme "+scheme+" contradicts ssl="+q.Get("ssl"), "Drop ?ssl= or make it match the endpoint scheme.")
+			}
+			cfg.useSSL = secure
+			ep = rest
+		}
+		ep = strings.TrimSuffix(ep, "/")
+		if strings.Contains(ep, "/") {
+			return cfg, apperrors.New(apperrors.TypeConfig, "endpoint must not contain a path: "+ep, "Put the bucket and prefix in the URI path, e.g. s3://KEY:SECRET@/bucket/prefix?endpoint=host.")
+		}
+		cfg.endpoint = ep
+	}
+	if cfg.endpoint == "" {
+		return cfg, apperrors.New(apperrors.TypeConfig, "S3 endpoint is missing", "Put the endpoint in the URI host or pass ?endpoint=.")
 	}
 
-	accessKey := u.User.Username()
-	secretKey, _ := u.User.Password()
+	switch q.Get("path-style") {
+	case "", "true":
+	case "false":
+		host := cfg.endpoint
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if net.ParseIP(host) != nil {
+			return cfg, apperrors.New(apperrors.TypeConfig, "virtual-hosted buckets need a DNS endpoint, not "+host, "Remove ?path-style=false for IP endpoints.")
+		}
+		if cfg.useSSL && strings.Contains(cfg.bucket, ".") {
+			return cfg, apperrors.New(apperrors.TypeConfig, "bucket "+cfg.bucket+" contains dots and can't be used virtual-hosted over TLS", "The TLS certificate won't match; keep path-style or rename the bucket.")
+		}
+		cfg.lookup = minio.BucketLookupDNS
+	default:
+		return cfg, apperrors.New(apperrors.TypeConfig, "invalid path-style value: "+q.Get("path-style"), "Use ?path-style=true or ?path-style=false.")
+	}
 
-	useSSL := u.Query().Get("ssl") != "false"
-	if u.Scheme == "minio" && !strings.Contains(endpoint, ":") {
-		// Default MinIO port if not specified
-		// endpoint = endpoint + ":9000"
+	// x-amz-checksum-sha256 needs trailing headers, which GCS and many
+	// gateways reject, so only AWS gets it unless asked for
+	switch q.Get("checksum") {
+	case "":
+		cfg.sha256 = strings.HasSuffix(cfg.endpoint, "amazonaws.com")
+	case "sha256":
+		cfg.sha256 = true
+	case "md5":
+	default:
+		return cfg, apperrors.New(apperrors.TypeConfig, "invalid checksum value: "+q.Get("checksum"), "Use ?checksum=sha256 or ?checksum=md5.")
 	}
+	return cfg, nil
+}
 
-	transport, err := minio.DefaultTransport(useSSL)
+func NewS3Storage(u *url.URL, opts StorageOptions) (*S3Storage, error) {
+	cfg, err := parseS3URI(u)
+	if err != nil {
+		return nil, err
+	}
+
+	creds := credentials.NewStaticV4(cfg.accessKey, cfg.secretKey, "")
+	if cfg.accessKey == "" {
+		creds = ambientS3Credentials(cfg.endpoint)
+	}
+	return newS3Storage(cfg, creds, opts)
+}
+
+// ambientS3Credentials finds credentials outside the URI: the AWS and MinIO
+// environment variables, the AWS credentials file, then, against AWS only,
+// the instance or container role. Role credentials are temporary and are
+// fetched again when they expire, so a backup can outlive them. With none
+// found, requests are anonymous.
+func ambientS3Credentials(endpoint string) *credentials.Credentials {
+	providers := []credentials.Provider{
+		&credentials.EnvAWS{},
+		&credentials.EnvMinio{},
+		&credentials.FileAWSCredentials{},
+	}
+	// Elsewhere the metadata endpoint would only add a timeout to every
+	// anonymous request
+	if strings.HasSuffix(endpoint, "amazonaws.com") {
+		providers = append(providers, &credentials.IAM{})
+	}
+	return credentials.NewChainCredentials(providers)
+}
+
+func newS3Storage(cfg s3Config, creds *credentials.Credentials, opts StorageOptions) (*S3Storage, error) {
+	transport, err := minio.DefaultTransport(cfg.useSSL)
 	if err == nil {
 		transport.DialContext = (&net.Dialer{
 			Timeout:   10 * time.Second,
@@ -60,17 +168,14 @@ func NewS3Storage(u *url.URL) (*S3Storage, error) {
 		transport.IdleConnTimeout = 30 * time.Second
 	}
 
-	region := u.Query().Get("region")
-	if region == "" {
-		region = "us-east-1"
-	}
-
-	client, err := minio.New(endpoint, &minio.Options{
-		Creds:        credentials.NewStaticV4(accessKey, secretKey, ""),
-		Secure:       useSSL,
+	client, err := minio.New(cfg.endpoint, &minio.Options{
+		Creds:        creds,
+		Secure:       cfg.useSSL,
 		Transport:    transport,
-		Region:       region,
-		BucketLookup: minio.BucketLookupPath, // Force path-style to avoid location probes
+		Region:       cfg.region,
+		BucketLookup: cfg.lookup,
+		// Needed to send the x-amz-checksum-sha256 of uploads, see putOptions
+		TrailingHeaders: cfg.sha256,
 	})
 	if err == nil {
 		client.SetAppInfo("dbackup", "1.0.0")
@@ -81,13 +186,20 @@ func NewS3Storage(u *url.URL) (*S3Storage, error) {
 		return nil, fmt.Errorf("failed to initialize S3 client: %w", err)
 	}
 
-	return &S3Storage{
+	st := &S3Storage{
 		client:     client,
-		bucketName: bucketName,
-		prefix:     prefix,
-		endpoint:   endpoint,
-		useSSL:     useSSL,
-	}, nil
+		bucketName: cfg.bucket,
+		prefix:     cfg.prefix,
+		endpoint:   cfg.endpoint,
+		useSSL:     cfg.useSSL,
+		tmpDir:     opts.TmpDir,
+		transport:  http.DefaultTransport,
+		sha256:     cfg.sha256,
+	}
+	if transport != nil {
+		st.transport = transport
+	}
+	return st, nil
 }
 
 func (s *S3Storage) Save(ctx context.Context, name string, r io.Reader) (string, error) {
@@ -95,15 +207,26 @@ func (s *S3Storage) Save(ctx context.Context, name string, r io.Reader) (string,
 
 	var size int64 = -1
 	var readerToUpload io.Reader = r
+	var sum []byte
 
 	// Try to determine size if possible
 	switch v := r.(type) {
 	case *bytes.Buffer:
 		size = int64(v.Len())
+		if size <= s3SinglePutSize {
+			h := sha256.Sum256(v.Bytes())
+			sum = h[:]
+		}
 	case *bytes.Reader:
 		size = int64(v.Len())
+		if size <= s3SinglePutSize {
+			sum = hashSeeker(v)
+		}
 	case *strings.Reader:
 		size = int64(v.Len())
+		if size <= s3SinglePutSize {
+			sum = hashSeeker(v)
+		}
 	case *os.File:
 		if fi, err := v.Stat(); err == nil {
 			size = fi.Size()
@@ -113,14 +236,16 @@ func (s *S3Storage) Save(ctx context.Context, name string, r io.Reader) (string,
 	// If size is unknown, buffer to a temporary file to ensure known size
 	// and avoid high memory pressure from minio-go's internal buffering.
 	if size == -1 {
-		tmpFile, err := os.CreateTemp("", "dbackup-s3-upload-*")
+		tmpFile, err := os.CreateTemp(s.tmpDir, "dbackup-s3-upload-*")
 		if err != nil {
 			return "", fmt.Errorf("failed to create temporary file for S3 upload: %w", err)
 		}
 		defer os.Remove(tmpFile.Name())
 		defer tmpFile.Close()
 
-		size, err = io.Copy(tmpFile, r)
+		// Hash the source, not the buffer, so a corrupted temp file is caught too
+		h := sha256.New()
+		size, err = io.Copy(io.MultiWriter(tmpFile, h), r)
 		if err != nil {
 			return "", fmt.Errorf("failed to buffer stream to temporary file: %w", err)
 		}
@@ -129,13 +254,14 @@ func (s *S3Storage) Save(ctx context.Context, name string, r io.Reader) (string,
 			return "", fmt.Errorf("failed to seek to start of temporary file: %w", err)
 		}
 		readerToUpload = tmpFile
+		if size <= s3SinglePutSize {
+			sum = h.Sum(nil)
+		}
 	}
 
-	_, err := s.client.PutObject(ctx, s.bucketName, objectName, readerToUpload, size, minio.PutObjectOptions{
-		ContentType: "application/octet-stream",
-	})
+	_, err := s.client.PutObject(ctx, s.bucketName, objectName, readerToUpload, size, s.putOptions("application/octet-stream", sum))
 	if err != nil {
-		return "", fmt.Errorf("failed to upload object to S3: %w", err)
+		return "", uploadError(name, err)
 	}
 
 	scheme := "s3"
@@ -148,23 +274,77 @@ func (s *S3Storage) Save(ctx context.Context, name string, r io.Reader) (string,
 	return fmt.Sprintf("%s://%s/%s/%s", scheme, s.endpoint, s.bucketName, objectName), nil
 }
 
+// s3SinglePutSize is the largest object uploaded in one PUT, minio-go's
+// default part size. Only those carry a whole-object checksum; larger ones
+// go multipart with an MD5 per part.
+const s3SinglePutSize = 16 << 20
+
+// putOptions makes S3 verify an upload server-side. With sum, the SHA-256
+// of the source bytes, a single PUT is rejected unless it stored exactly
+// them; without, or on targets without SHA-256 checksums, minio-go sends
+// each part's Content-MD5.
+func (s *S3Storage) putOptions(contentType string, sum []byte) minio.PutObjectOptions {
+	opts := minio.PutObjectOptions{ContentType: contentType}
+	if sum == nil || !s.sha256 {
+		opts.SendContentMd5 = true
+		return opts
+	}
+	opts.DisableMultipart = true
+	opts.UserMetadata = map[string]string{"x-amz-checksum-sha256": base64.StdEncoding.EncodeToString(sum)}
+	return opts
+}
+
+// hashSeeker returns the SHA-256 of what is left in r, leaving r where it was.
+func hashSeeker(r io.ReadSeeker) []byte {
+	off, err := r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil
+	}
+	h := sha256.New()
+	_, err = io.Copy(h, r)
+	if _, serr := r.Seek(off, io.SeekStart); err != nil || serr != nil {
+		return nil
+	}
+	return h.Sum(nil)
+}
+
+// uploadError reports a checksum mismatch as corruption on the way to S3.
+func uploadError(name string, err error) error {
+	switch minio.ToErrorResponse(err).Code {
+	case "BadDigest", "XAmzContentChecksumMismatch", "XAmzContentSHA256Mismatch":
+		return apperrors.Wrap(err, apperrors.TypeIntegrity, "S3 rejected "+name+": the uploaded data does not match its checksum", "The data was corrupted on the way to S3. Retry, and check proxies or the network if it happens again.")
+	}
+	return fmt.Errorf("failed to upload object to S3: %w", err)
+}
+
 func (s *S3Storage) Open(ctx context.Context, name string) (io.ReadCloser, error) {
 	objectName := s.getObjectName(name)
 	return s.client.GetObject(ctx, s.bucketName, objectName, minio.GetObjectOptions{})
 }
 
+// Exists issues a HEAD request, so the object body is never downloaded. A
+// HEAD 404 has no error body; some gateways then report a code other than
+// NoSuchKey, so any 404 except a missing bucket means the key is absent.
 func (s *S3Storage) Exists(ctx context.Context, name string) (bool, error) {
 	_, err := s.client.StatObject(ctx, s.bucketName, s.getObjectName(name), minio.StatObjectOptions{})
 	if err == nil {
 		return true, nil
 	}
 	errResponse := minio.ToErrorResponse(err)
-	if errResponse.Code == "NoSuchKey" {
+	if errResponse.Code == "NoSuchKey" || (errResponse.StatusCode == http.StatusNotFound && errResponse.Code != "NoSuchBucket") {
 		return false, nil
 	}
 	return false, err
 }
 
+func (s *S3Storage) Stat(ctx context.Context, name string) (StorageInfo, error) {
+	info, err := s.client.StatObject(ctx, s.bucketName, s.getObjectName(name), minio.StatObjectOptions{})
+	if err != nil {
+		return StorageInfo{}, err
+	}
+	return StorageInfo{Size: info.Size, ModTime: info.LastModified}, nil
+}
+
 func (s *S3Storage) Delete(ctx context.Context, name string) error {
 	objectName := s.getObjectName(name)
 	return s.client.RemoveObject(ctx, s.bucketName, objectName, minio.RemoveObjectOptions{})
@@ -176,10 +356,12 @@ func (s *S3Storage) Location() string {
 
 func (s *S3Storage) PutMetadata(ctx context.Context, name string, data []byte) error {
 	objectName := s.getObjectName(name)
-	_, err := s.client.PutObject(ctx, s.bucketName, objectName, bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{
-		ContentType: "application/json",
-	})
-	return err
+	sum := sha256.Sum256(data)
+	_, err := s.client.PutObject(ctx, s.bucketName, objectName, bytes.NewReader(data), int64(len(data)), s.putOptions("application/json", sum[:]))
+	if err != nil {
+		return uploadError(name, err)
+	}
+	return nil
 }
 
 func (s *S3Storage) GetMetadata(ctx context.Context, name string) ([]byte, error) {
@@ -219,6 +401,26 @@ func (s *S3Storage) ListMetadata(ctx context.Context, prefix string) ([]string,
 	return files, nil
 }
 
+// ServerTime reads the endpoint's clock from the Date header of an unsigned
+// HEAD request; any status will do. Requests signed more than 15 minutes
+// away from this clock are rejected.
+func (s *S3Storage) ServerTime(ctx context.Context) (time.Time, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, s.client.EndpointURL().String(), nil)
+	if err != nil {
+		return time.Time{}, err
+	}
+	resp, err := (&http.Client{Transport: s.transport, Timeout: 10 * time.Second}).Do(req)
+	if err != nil {
+		return time.Time{}, err
+	}
+	resp.Body.Close()
+	date := resp.Header.Get("Date")
+	if date == "" {
+		return time.Time{}, fmt.Errorf("%s sent no Date header", s.endpoint)
+	}
+	return http.ParseTime(date)
+}
+
 func (s *S3Storage) getObjectName(name string) string {
 	if s.prefix == "" {
 		return name
@@ -3,12 +3,23 @@ package storage
 import (
 	"bytes"
 	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
 	"fmt"
 	"io"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	apperrors "github.com/lupppig/dbackup/internal/errors"
 	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/testcontainers/testcontainers-go"
@@ -54,7 +65,7 @@ func TestS3Storage_Integration(t *testing.T) {
 	u, err := url.Parse(uri)
 	require.NoError(t, err)
 
-	s, err := NewS3Storage(u)
+	s, err := NewS3Storage(u, StorageOptions{})
 	require.NoError(t, err)
 
 	// Create bucket
@@ -92,6 +103,19 @@ func TestS3Storage_Integration(t *testing.T) {
 		assert.Contains(t, files, name)
 	})
 
+	t.Run("Exists", func(t *testing.T) {
+		name := "chunks/exists-check"
+		exists, err := s.Exists(ctx, name)
+		require.NoError(t, err)
+		assert.False(t, exists)
+
+		_, err = s.Save(ctx, name, bytes.NewReader([]byte("chunk")))
+		require.NoError(t, err)
+		exists, err = s.Exists(ctx, name)
+		require.NoError(t, err)
+		assert.True(t, exists)
+	})
+
 	t.Run("Delete", func(t *testing.T) {
 		name := "to_delete.txt"
 		_, err := s.Save(ctx, name, bytes.NewReader([]byte("bye")))
@@ -122,3 +146,292 @@ func TestS3Storage_Integration(t *testing.T) {
 		assert.Equal(t, content, got)
 	})
 }
+
+func TestParseS3URI(t *testing.T) {
+	tests := []struct {
+		name    string
+		uri     string
+		want    s3Config
+		wantErr bool
+	}{
+		{
+			name: "defaults",
+			uri:  "s3://KEY:SECRET@minio.local:9000/backups/prod",
+			want: s3Config{endpoint: "minio.local:9000", bucket: "backups", prefix: "prod", accessKey: "KEY", secretKey: "SECRET", useSSL: true, region: "us-east-1", lookup: minio.BucketLookupPath},
+		},
+		{
+			name: "r2 endpoint override",
+			uri:  "s3://KEY:SECRET@/backups?endpoint=https://acct.r2.cloudflarestorage.com&region=auto",
+			want: s3Config{endpoint: "acct.r2.cloudflarestorage.com", bucket: "backups", accessKey: "KEY", secretKey: "SECRET", useSSL: true, region: "auto", lookup: minio.BucketLookupPath},
+		},
+		{
+			name: "wasabi virtual-hosted",
+			uri:  "s3://KEY:SECRET@s3.eu-central-1.wasabisys.com/backups?region=eu-central-1&path-style=false",
+			want: s3Config{endpoint: "s3.eu-central-1.wasabisys.com", bucket: "backups", accessKey: "KEY", secretKey: "SECRET", useSSL: true, region: "eu-central-1", lookup: minio.BucketLookupDNS},
+		},
+		{
+			name: "plain http endpoint",
+			uri:  "s3://KEY:SECRET@ignored/backups?endpoint=http://10.0.0.5:7480",
+			want: s3Config{endpoint: "10.0.0.5:7480", bucket: "backups", accessKey: "KEY", secretKey: "SECRET", useSSL: false, region: "us-east-1", lookup: minio.BucketLookupPath},
+		},
+		{
+			name: "aws sends sha256 checksums",
+			uri:  "s3://KEY:SECRET@s3.eu-west-1.amazonaws.com/backups?region=eu-west-1",
+			want: s3Config{endpoint: "s3.eu-west-1.amazonaws.com", bucket: "backups", accessKey: "KEY", secretKey: "SECRET", useSSL: true, region: "eu-west-1", lookup: minio.BucketLookupPath, sha256: true},
+		},
+		{
+			name: "gcs sends md5",
+			uri:  "s3://KEY:SECRET@storage.googleapis.com/backups",
+			want: s3Config{endpoint: "storage.googleapis.com", bucket: "backups", accessKey: "KEY", secretKey: "SECRET", useSSL: true, region: "us-east-1", lookup: minio.BucketLookupPath},
+		},
+		{
+			name: "sha256 opt-in",
+			uri:  "s3://KEY:SECRET@minio.local:9000/backups?checksum=sha256",
+			want: s3Config{endpoint: "minio.local:9000", bucket: "backups", accessKey: "KEY", secretKey: "SECRET", useSSL: true, region: "us-east-1", lookup: minio.BucketLookupPath, sha256: true},
+		},
+		{name: "bad checksum", uri: "s3://K:S@host/backups?checksum=crc32", wantErr: true},
+		{name: "no bucket", uri: "s3://KEY:SECRET@host/", wantErr: true},
+		{name: "no endpoint", uri: "s3://KEY:SECRET@/backups", wantErr: true},
+		{name: "endpoint with path", uri: "s3://K:S@/backups?endpoint=host/extra", wantErr: true},
+		{name: "scheme contradicts ssl", uri: "s3://K:S@/backups?endpoint=http://host&ssl=true", wantErr: true},
+		{name: "unknown scheme", uri: "s3://K:S@/backups?endpoint=ftp://host", wantErr: true},
+		{name: "virtual-hosted ip", uri: "s3://K:S@10.0.0.5:9000/backups?path-style=false", wantErr: true},
+		{name: "virtual-hosted dotted bucket", uri: "s3://K:S@s3.example.com/my.backups?path-style=false", wantErr: true},
+		{name: "bad path-style", uri: "s3://K:S@host/backups?path-style=maybe", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := url.Parse(tt.uri)
+			require.NoError(t, err)
+			got, err := parseS3URI(u)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestS3Storage_ExistsUsesHead(t *testing.T) {
+	var gets atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodHead {
+			gets.Add(1)
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		switch r.URL.Path {
+		case "/backups/chunks/present":
+			w.Header().Set("Content-Length", "5")
+			w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+			w.Header().Set("ETag", `"abc"`)
+			w.WriteHeader(http.StatusOK)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	u, err := url.Parse("s3://KEY:SECRET@" + strings.TrimPrefix(server.URL, "http://") + "/backups?ssl=false")
+	require.NoError(t, err)
+	s, err := NewS3Storage(u, StorageOptions{})
+	require.NoError(t, err)
+
+	exists, err := s.Exists(context.Background(), "chunks/present")
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	exists, err = s.Exists(context.Background(), "chunks/missing")
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	assert.Zero(t, gets.Load(), "Exists must not download objects")
+}
+
+// decodeAWSChunked strips the signed chunk framing minio-go uses for
+// uploads over plain HTTP.
+func decodeAWSChunked(body []byte) []byte {
+	var out []byte
+	for len(body) > 0 {
+		header, rest, ok := bytes.Cut(body, []byte("\r\n"))
+		if !ok {
+			break
+		}
+		sizeHex, _, _ := bytes.Cut(header, []byte(";"))
+		var size int
+		fmt.Sscanf(string(sizeHex), "%x", &size)
+		if size == 0 || size > len(rest) {
+			break
+		}
+		out = append(out, rest[:size]...)
+		body = bytes.TrimPrefix(rest[size:], []byte("\r\n"))
+	}
+	return out
+}
+
+func TestS3Storage_UploadChecksum(t *testing.T) {
+	var corrupt atomic.Bool
+	var mu sync.Mutex
+	stored := map[string][]byte{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+			body = decodeAWSChunked(body)
+		}
+		// A byte flipped on the way, as a faulty proxy or NIC would
+		if corrupt.Load() && len(body) > 0 {
+			body[0] ^= 0xff
+		}
+		var ok bool
+		if want := r.Header.Get("Content-Md5"); want != "" {
+			sum := md5.Sum(body) // #nosec G401 -- what S3 verifies
+			ok = want == base64.StdEncoding.EncodeToString(sum[:])
+		} else {
+			sum := sha256.Sum256(body)
+			ok = r.Header.Get("X-Amz-Checksum-Sha256") == base64.StdEncoding.EncodeToString(sum[:])
+		}
+		if !ok {
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `<Error><Code>BadDigest</Code><Message>The SHA256 you specified did not match the calculated checksum.</Message></Error>`)
+			return
+		}
+		mu.Lock()
+		stored[r.URL.Path] = body
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	u, err := url.Parse("s3://KEY:SECRET@" + strings.TrimPrefix(server.URL, "http://") + "/backups?ssl=false&checksum=sha256")
+	require.NoError(t, err)
+	s, err := NewS3Storage(u, StorageOptions{TmpDir: t.TempDir()})
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	_, err = s.Save(ctx, "chunks/abc", bytes.NewReader([]byte("chunk data")))
+	require.NoError(t, err)
+	// A stream of unknown size is hashed while it is buffered
+	_, err = s.Save(ctx, "dump.sql", io.MultiReader(strings.NewReader("CREATE TABLE t;"), strings.NewReader(" INSERT 1;")))
+	require.NoError(t, err)
+	require.NoError(t, s.PutMetadata(ctx, "dump.sql.manifest", []byte("{}")))
+	assert.Equal(t, "CREATE TABLE t; INSERT 1;", string(stored["/backups/dump.sql"]))
+
+	corrupt.Store(true)
+	_, err = s.Save(ctx, "dump2.sql", io.MultiReader(strings.NewReader("CREATE TABLE t;")))
+	require.Error(t, err)
+	assert.True(t, apperrors.IsType(err, apperrors.TypeIntegrity), err.Error())
+	err = s.PutMetadata(ctx, "dump2.sql.manifest", []byte("{}"))
+	assert.True(t, apperrors.IsType(err, apperrors.TypeIntegrity), "manifest upload: %v", err)
+	assert.NotContains(t, stored, "/backups/dump2.sql")
+
+	// Other providers, such as GCS, verify a Content-MD5 instead
+	u, err = url.Parse("s3://KEY:SECRET@" + strings.TrimPrefix(server.URL, "http://") + "/backups?ssl=false")
+	require.NoError(t, err)
+	s, err = NewS3Storage(u, StorageOptions{TmpDir: t.TempDir()})
+	require.NoError(t, err)
+	_, err = s.Save(ctx, "dump3.sql", strings.NewReader("CREATE TABLE t;"))
+	assert.True(t, apperrors.IsType(err, apperrors.TypeIntegrity), "md5 upload: %v", err)
+	corrupt.Store(false)
+	_, err = s.Save(ctx, "dump3.sql", strings.NewReader("CREATE TABLE t;"))
+	require.NoError(t, err)
+	require.NoError(t, s.PutMetadata(ctx, "dump3.sql.manifest", []byte("{}")))
+	assert.Equal(t, "CREATE TABLE t;", string(stored["/backups/dump3.sql"]))
+}
+
+// rotatingProvider hands out a new access key each time it is asked, and
+// reports its key expired once a request has been signed with it.
+type rotatingProvider struct {
+	n       atomic.Int32
+	expired atomic.Bool
+}
+
+func (p *rotatingProvider) Retrieve() (credentials.Value, error) {
+	p.expired.Store(false)
+	return credentials.Value{
+		AccessKeyID:     fmt.Sprintf("KEY%d", p.n.Add(1)),
+		SecretAccessKey: "SECRET",
+		SessionToken:    "TOKEN",
+		SignerType:      credentials.SignatureV4,
+	}, nil
+}
+
+func (p *rotatingProvider) RetrieveWithCredContext(*credentials.CredContext) (credentials.Value, error) {
+	return p.Retrieve()
+}
+
+func (p *rotatingProvider) IsExpired() bool {
+	return p.expired.Load()
+}
+
+func TestS3Storage_RefreshesExpiredCredentials(t *testing.T) {
+	var mu sync.Mutex
+	var keys []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, after, _ := strings.Cut(r.Header.Get("Authorization"), "Credential=")
+		key, _, _ := strings.Cut(after, "/")
+		mu.Lock()
+		keys = append(keys, key)
+		mu.Unlock()
+		io.Copy(io.Discard, r.Body)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	u, err := url.Parse("s3://" + strings.TrimPrefix(server.URL, "http://") + "/backups?ssl=false")
+	require.NoError(t, err)
+	cfg, err := parseS3URI(u)
+	require.NoError(t, err)
+	provider := &rotatingProvider{}
+	s, err := newS3Storage(cfg, credentials.New(provider), StorageOptions{})
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	require.NoError(t, s.PutMetadata(ctx, "a.manifest", []byte("{}")))
+	// The temporary key expires half way through the backup
+	provider.expired.Store(true)
+	require.NoError(t, s.PutMetadata(ctx, "b.manifest", []byte("{}")))
+
+	assert.Equal(t, []string{"KEY1", "KEY2"}, keys)
+}
+
+func TestNewS3Storage_KeepsURICredentials(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "ENVKEY")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "ENVSECRET")
+
+	u, err := url.Parse("s3://URIKEY:URISECRET@localhost:9000/backups?ssl=false")
+	require.NoError(t, err)
+	s, err := NewS3Storage(u, StorageOptions{})
+	require.NoError(t, err)
+	v, err := s.client.GetCreds()
+	require.NoError(t, err)
+	assert.Equal(t, "URIKEY", v.AccessKeyID)
+
+	u, err = url.Parse("s3://localhost:9000/backups?ssl=false")
+	require.NoError(t, err)
+	s, err = NewS3Storage(u, StorageOptions{})
+	require.NoError(t, err)
+	v, err = s.client.GetCreds()
+	require.NoError(t, err)
+	assert.Equal(t, "ENVKEY", v.AccessKeyID)
+}
+
+func TestS3Storage_ServerTime(t *testing.T) {
+	skewed := time.Now().Add(-10 * time.Minute).UTC()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Date", skewed.Format(http.TimeFormat))
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	u, err := url.Parse("s3://KEY:SECRET@" + strings.TrimPrefix(server.URL, "http://") + "/backups?ssl=false")
+	require.NoError(t, err)
+	s, err := NewS3Storage(u, StorageOptions{})
+	require.NoError(t, err)
+
+	got, err := s.ServerTime(context.Background())
+	require.NoError(t, err)
+	assert.WithinDuration(t, skewed, got, time.Second)
+}
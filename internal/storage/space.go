@@ -0,0 +1,29 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// AvailableSpace reports the free space behind s if it writes to local disk.
+// Remote backends report false; their space can't be checked up front.
+func AvailableSpace(s Storage) (uint64, bool) {
+	switch v := s.(type) {
+	case *LocalStorage:
+		// The target directory may not exist yet; its nearest parent will
+		dir := v.baseDir
+		for {
+			if _, err := os.Stat(dir); err == nil || filepath.Dir(dir) == dir {
+				break
+			}
+			dir = filepath.Dir(dir)
+		}
+		free, err := FreeSpace(dir)
+		return free, err == nil
+	case *DedupeStorage:
+		return AvailableSpace(v.inner)
+	case *AuditStorage:
+		return AvailableSpace(v.inner)
+	}
+	return 0, false
+}
@@ -0,0 +1,11 @@
+//go:build windows
+
+package storage
+
+import "errors"
+
+// FreeSpace is not implemented on Windows. Callers treat the error as
+// unknown free space and skip their preflight checks.
+func FreeSpace(path string) (uint64, error) {
+	return 0, errors.New("unknown free space on " + path)
+}
@@ -2,12 +2,15 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"net"
 	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"time"
 
@@ -24,6 +27,12 @@ type SSHStorage struct {
 	remotePath string
 	host       string
 	user       *url.Userinfo
+
+	// hostKeyFingerprint pins the server's key (SHA256:...); ciphers and
+	// keyExchanges restrict negotiation. Empty values keep the defaults.
+	hostKeyFingerprint string
+	ciphers            []string
+	keyExchanges       []string
 }
 
 func NewSSHStorage(u *url.URL) (*SSHStorage, error) {
@@ -35,13 +44,66 @@ func NewSSHStorage(u *url.URL) (*SSHStorage, error) {
 	remotePath := u.Path
 	remotePath = strings.TrimPrefix(remotePath, "/./")
 
+	q := u.Query()
+	fingerprint := q.Get("host-key-fingerprint")
+	if fingerprint != "" && !strings.HasPrefix(fingerprint, "SHA256:") {
+		return nil, apperrors.New(apperrors.TypeConfig, "invalid host-key-fingerprint: "+fingerprint, "Use the SHA256:... form printed by 'ssh-keygen -lf <host key>'.")
+	}
+	supported := ssh.SupportedAlgorithms()
+	ciphers, err := parseSSHAlgorithms(q.Get("ciphers"), supported.Ciphers, "ciphers")
+	if err != nil {
+		return nil, err
+	}
+	kex, err := parseSSHAlgorithms(q.Get("kex"), supported.KeyExchanges, "kex")
+	if err != nil {
+		return nil, err
+	}
+
 	return &SSHStorage{
-		remotePath: remotePath,
-		host:       host,
-		user:       u.User,
+		remotePath:         remotePath,
+		host:               host,
+		user:               u.User,
+		hostKeyFingerprint: fingerprint,
+		ciphers:            ciphers,
+		keyExchanges:       kex,
 	}, nil
 }
 
+// parseSSHAlgorithms splits a comma-separated list and rejects names the ssh
+// package does not implement, so a typo fails here rather than mid-handshake.
+func parseSSHAlgorithms(list string, supported []string, param string) ([]string, error) {
+	if list == "" {
+		return nil, nil
+	}
+	var algos []string
+	for _, a := range strings.Split(list, ",") {
+		a = strings.TrimSpace(a)
+		if a == "" {
+			continue
+		}
+		if !slices.Contains(supported, a) {
+			return nil, apperrors.New(apperrors.TypeConfig, fmt.Sprintf("unsupported SSH %s algorithm: %s", param, a), "Supported: "+strings.Join(supported, ", "))
+		}
+		algos = append(algos, a)
+	}
+	return algos, nil
+}
+
+// hostKeyCallback accepts any key unless a fingerprint is pinned.
+func (s *SSHStorage) hostKeyCallback() ssh.HostKeyCallback {
+	if s.hostKeyFingerprint == "" {
+		return ssh.InsecureIgnoreHostKey() // #nosec G106
+	}
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		if got := ssh.FingerprintSHA256(key); got != s.hostKeyFingerprint {
+			return apperrors.New(apperrors.TypeSecurity,
+				fmt.Sprintf("SSH host key mismatch for %s: got %s, pinned %s", hostname, got, s.hostKeyFingerprint),
+				"The server's key changed or the connection is being intercepted. Update host-key-fingerprint only after confirming the new key out of band.")
+		}
+		return nil
+	}
+}
+
 func (s *SSHStorage) connect() error {
 	if s.sftpClient != nil {
 		return nil
@@ -53,9 +115,11 @@ func (s *SSHStorage) connect() error {
 	config := &ssh.ClientConfig{
 		User:            user,
 		Auth:            []ssh.AuthMethod{},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		HostKeyCallback: s.hostKeyCallback(),
 		Timeout:         10 * time.Second,
 	}
+	config.Ciphers = s.ciphers
+	config.KeyExchanges = s.keyExchanges
 
 	if pass != "" {
 		config.Auth = append(config.Auth, ssh.Password(pass))
@@ -93,6 +157,9 @@ func (s *SSHStorage) connect() error {
 
 	client, err := ssh.Dial("tcp", s.host, config)
 	if err != nil {
+		if apperrors.IsType(err, apperrors.TypeSecurity) {
+			return err
+		}
 		return apperrors.Wrap(err, apperrors.TypeConnection, "failed to connect via SSH", "Check host reachability, SSH port, and credentials.")
 	}
 
@@ -116,13 +183,7 @@ func (s *SSHStorage) Save(ctx context.Context, name string, r io.Reader) (string
 		return "", fmt.Errorf("failed to create remote directory %s: %w", filepath.Dir(path), err)
 	}
 
-	f, err := s.sftpClient.Create(path)
-	if err != nil {
-		return "", fmt.Errorf("failed to create remote file %s: %w", path, err)
-	}
-	defer f.Close()
-
-	if _, err := io.Copy(f, r); err != nil {
+	if err := saveAtomic(path, r, s.writeFile, s.rename, s.sftpClient.Remove); err != nil {
 		return "", err
 	}
 	return "sftp://" + s.host + path, nil
@@ -155,6 +216,17 @@ func (s *SSHStorage) Exists(ctx context.Context, name string) (bool, error) {
 	return false, err
 }
 
+func (s *SSHStorage) Stat(ctx context.Context, name string) (StorageInfo, error) {
+	if err := s.connect(); err != nil {
+		return StorageInfo{}, err
+	}
+	fi, err := s.sftpClient.Stat(filepath.Join(s.remotePath, name))
+	if err != nil {
+		return StorageInfo{}, err
+	}
+	return StorageInfo{Size: fi.Size(), ModTime: fi.ModTime()}, nil
+}
+
 func (s *SSHStorage) Delete(ctx context.Context, name string) error {
 	if err := s.connect(); err != nil {
 		return err
@@ -174,13 +246,29 @@ func (s *SSHStorage) PutMetadata(ctx context.Context, name string, data []byte)
 	if err := s.sftpClient.MkdirAll(filepath.Dir(path)); err != nil {
 		return fmt.Errorf("failed to create remote directory %s: %w", filepath.Dir(path), err)
 	}
+	return putAtomic(path, data, s.writeFile, s.rename, s.sftpClient.Remove)
+}
+
+func (s *SSHStorage) writeFile(path string, r io.Reader) error {
 	f, err := s.sftpClient.Create(path)
 	if err != nil {
 		return fmt.Errorf("failed to create remote file %s: %w", path, err)
 	}
-	defer f.Close()
-	_, err = f.Write(data)
-	return err
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close() // #nosec G104
+		return err
+	}
+	return f.Close()
+}
+
+// rename replaces to with from. Plain SFTP rename refuses to overwrite, so the
+// OpenSSH posix-rename extension is preferred when the server has it.
+func (s *SSHStorage) rename(from, to string) error {
+	if err := s.sftpClient.PosixRename(from, to); err == nil {
+		return nil
+	}
+	s.sftpClient.Remove(to) // #nosec G104
+	return s.sftpClient.Rename(from, to)
 }
 
 func (s *SSHStorage) GetMetadata(ctx context.Context, name string) ([]byte, error) {
@@ -214,8 +302,13 @@ func (s *SSHStorage) ListMetadata(ctx context.Context, prefix string) ([]string,
 	var walk func(dir string) error
 	walk = func(dir string) error {
 		entries, err := s.sftpClient.ReadDir(dir)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil // Nothing stored here yet
+		}
 		if err != nil {
-			return nil // Skip inaccessible or non-existent
+			// A directory that can't be read may hold manifests; hiding it
+			// would let GC delete the chunks they reference
+			return fmt.Errorf("list %s: %w", dir, err)
 		}
 
 		for _, entry := range entries {
@@ -3,15 +3,23 @@ package storage
 import (
 	"bytes"
 	"context"
+	"crypto/ed25519"
+	"crypto/rand"
 	"fmt"
 	"io"
+	"net"
 	"net/url"
+	"os"
+	"sync/atomic"
 	"testing"
 
+	apperrors "github.com/lupppig/dbackup/internal/errors"
+	"github.com/pkg/sftp"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/testcontainers/testcontainers-go"
 	"github.com/testcontainers/testcontainers-go/wait"
+	"golang.org/x/crypto/ssh"
 )
 
 func TestSSHStorage_Integration(t *testing.T) {
@@ -104,3 +112,126 @@ func TestSSHStorage_Integration(t *testing.T) {
 		assert.Contains(t, buf.String(), "hello from ssh")
 	})
 }
+
+// startSSHServer accepts one handshake with hostKey and returns its address.
+func startSSHServer(t *testing.T, hostKey ssh.Signer) string {
+	t.Helper()
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(ssh.ConnMetadata, []byte) (*ssh.Permissions, error) { return nil, nil },
+	}
+	config.AddHostKey(hostKey)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		ssh.NewServerConn(conn, config) // #nosec G104
+	}()
+	return l.Addr().String()
+}
+
+func TestSSHStorage_HostKeyPinning(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(priv)
+	require.NoError(t, err)
+
+	addr := startSSHServer(t, signer)
+	u, err := url.Parse("sftp://user:pass@" + addr + "/upload?host-key-fingerprint=SHA256:AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA")
+	require.NoError(t, err)
+	s, err := NewSSHStorage(u)
+	require.NoError(t, err)
+
+	_, err = s.Exists(context.Background(), "x")
+	require.Error(t, err)
+	assert.True(t, apperrors.IsType(err, apperrors.TypeSecurity), err.Error())
+
+	// The matching fingerprint is accepted by the callback
+	cb := (&SSHStorage{hostKeyFingerprint: ssh.FingerprintSHA256(signer.PublicKey())}).hostKeyCallback()
+	assert.NoError(t, cb(addr, nil, signer.PublicKey()))
+}
+
+func TestNewSSHStorage_AlgorithmParams(t *testing.T) {
+	u, _ := url.Parse("sftp://user@host/backups?ciphers=aes256-gcm@openssh.com,chacha20-poly1305@openssh.com&kex=curve25519-sha256")
+	s, err := NewSSHStorage(u)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"aes256-gcm@openssh.com", "chacha20-poly1305@openssh.com"}, s.ciphers)
+	assert.Equal(t, []string{"curve25519-sha256"}, s.keyExchanges)
+
+	for _, q := range []string{"ciphers=rot13", "kex=none", "host-key-fingerprint=MD5:aa:bb"} {
+		u, _ := url.Parse("sftp://user@host/backups?" + q)
+		_, err := NewSSHStorage(u)
+		assert.True(t, apperrors.IsType(err, apperrors.TypeConfig), q)
+	}
+}
+
+// lockedLister fails listings of dir with a permission error while locked.
+type lockedLister struct {
+	sftp.FileLister
+	dir    string
+	locked *atomic.Bool
+}
+
+func (l lockedLister) Filelist(r *sftp.Request) (sftp.ListerAt, error) {
+	if r.Method == "List" && r.Filepath == l.dir && l.locked.Load() {
+		return nil, os.ErrPermission
+	}
+	return l.FileLister.Filelist(r)
+}
+
+// memSFTP serves an in-memory SFTP filesystem to an SSHStorage at /backups.
+func memSFTP(t *testing.T, locked string, lock *atomic.Bool) *SSHStorage {
+	t.Helper()
+	handlers := sftp.InMemHandler()
+	handlers.FileList = lockedLister{FileLister: handlers.FileList, dir: locked, locked: lock}
+	serverConn, clientConn := net.Pipe()
+	server := sftp.NewRequestServer(serverConn, handlers)
+	go server.Serve() // #nosec G104
+	t.Cleanup(func() { server.Close() })
+
+	client, err := sftp.NewClientPipe(clientConn, clientConn)
+	require.NoError(t, err)
+	s := &SSHStorage{sftpClient: client, remotePath: "/backups"}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestSSHStorage_ListFailsClosed(t *testing.T) {
+	ctx := context.Background()
+	var locked atomic.Bool
+	s := memSFTP(t, "/backups/app", &locked)
+
+	// A fresh target is empty, not an error
+	files, err := s.ListMetadata(ctx, "")
+	require.NoError(t, err)
+	assert.Empty(t, files)
+
+	ds := NewDedupeStorage(s)
+	chunks := saveManifest(t, ds, "app/orders", "orders", bytes.Repeat([]byte("orders "), 10000))
+	_, err = s.Save(ctx, "chunks/orphan", bytes.NewReader([]byte("orphan")))
+	require.NoError(t, err)
+
+	// The directory holding the manifest can't be read: GC must not take
+	// its chunks for orphans
+	locked.Store(true)
+	_, err = s.ListMetadata(ctx, "")
+	assert.ErrorContains(t, err, "permission denied")
+	removed, err := ds.GC(ctx)
+	assert.Error(t, err)
+	assert.Zero(t, removed)
+	for _, c := range append(chunks, "orphan") {
+		ok, err := s.Exists(ctx, "chunks/"+c)
+		require.NoError(t, err)
+		assert.True(t, ok, c)
+	}
+
+	locked.Store(false)
+	removed, err = ds.GC(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, removed)
+}
@@ -1,17 +1,23 @@
 package storage
 
 import (
+	"bytes"
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"io"
 	"net/url"
+	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	apperrors "github.com/lupppig/dbackup/internal/errors"
 )
 
 type StorageOptions struct {
 	AllowInsecure bool
+	TmpDir        string // Where backends buffer uploads; empty means os.TempDir()
 }
 
 func FromURI(uriStr string, opts StorageOptions) (Storage, error) {
@@ -19,6 +25,29 @@ func FromURI(uriStr string, opts StorageOptions) (Storage, error) {
 		return NewLocalStorage(""), nil
 	}
 
+	// Comma-separated targets fan out to every one of them
+	if parts := splitTargets(uriStr); len(parts) > 1 {
+		var targets []Storage
+		for _, part := range parts {
+			part = strings.TrimSpace(part)
+			if part == "" {
+				continue
+			}
+			t, err := FromURI(part, opts)
+			if err != nil {
+				for _, prev := range targets {
+					prev.Close() // #nosec G104
+				}
+				return nil, err
+			}
+			targets = append(targets, t)
+		}
+		if len(targets) == 1 {
+			return targets[0], nil
+		}
+		return NewMultiStorage(targets...), nil
+	}
+
 	if !strings.Contains(uriStr, "://") {
 		// Heuristic to detect SSH/SFTP shorthand like user@host:path or user@host
 		if strings.Contains(uriStr, "@") {
@@ -55,7 +84,7 @@ func FromURI(uriStr string, opts StorageOptions) (Storage, error) {
 	}
 
 	if !strings.Contains(uriStr, "://") {
-		return NewLocalStorage(uriStr), nil
+		return newLocalFromPath(uriStr)
 	}
 
 	// Use a simple fmt for internal debug since logger isn't available here yet
@@ -67,19 +96,25 @@ func FromURI(uriStr string, opts StorageOptions) (Storage, error) {
 
 	switch u.Scheme {
 	case "local", "file":
+		// local://~/x and local://./x put the first element in the host;
+		// local:///x and file:///x are absolute
 		path := u.Path
 		if u.Host != "" {
-			path = filepath.Join(u.Host, path)
+			path = u.Host + path
 		}
-		return NewLocalStorage(path), nil
+		return newLocalFromPath(path)
 	case "ssh", "sftp":
 		return NewSSHStorage(u)
 	case "s3", "minio":
-		return NewS3Storage(u)
-	case "ftp":
+		return NewS3Storage(u, opts)
+	case "ftp", "ftps":
 		return NewFTPStorage(u, opts)
 	case "docker":
 		return NewDockerStorage(u)
+	case "http", "https":
+		return NewHTTPStorage(u, opts)
+	case "mem":
+		return NewMemStorage(u.Host + u.Path), nil
 	case "dedupe":
 		wrapped, err := FromURI(u.Query().Get("target"), opts)
 		if err != nil {
@@ -87,37 +122,135 @@ func FromURI(uriStr string, opts StorageOptions) (Storage, error) {
 		}
 		return NewDedupeStorage(wrapped), nil
 	default:
-		return nil, apperrors.New(apperrors.TypeConfig, "unsupported storage scheme: "+u.Scheme, "Supported schemes are: local, sftp, ftp, docker.")
+		return nil, apperrors.New(apperrors.TypeConfig, "unsupported storage scheme: "+u.Scheme, "Supported schemes are: local, sftp, s3, ftp(s), docker, http(s), mem.")
 	}
 }
 
-// Scrub removes sensitive information from a URI for logging
+// sensitiveParams marks query parameters whose values must not be logged,
+// matched as substrings of the lower-cased parameter name.
+var sensitiveParams = []string{"pass", "secret", "token", "key", "signature", "credential"}
+
+const scrubMask = "********"
+
+// Scrub removes sensitive information from a URI for logging: passwords and
+// S3 secret keys in userinfo, bearer tokens of http(s) sources, and secret
+// query parameters. Nested URIs (dedupe://?target=...) are scrubbed too.
 func Scrub(uriStr string) string {
+	if parts := splitTargets(uriStr); len(parts) > 1 {
+		for i, p := range parts {
+			parts[i] = Scrub(strings.TrimSpace(p))
+		}
+		return strings.Join(parts, ",")
+	}
 	u, err := url.Parse(uriStr)
 	if err != nil {
 		return uriStr
 	}
+
+	userStr, maskedUser := "", false
 	if u.User != nil {
 		if _, ok := u.User.Password(); ok {
-			// Manually construct the string to avoid URL encoding of the mask
-			userStr := u.User.Username() + ":********"
-			if u.Host != "" {
-				userStr += "@" + u.Host
+			userStr, maskedUser = u.User.Username()+":"+scrubMask+"@", true
+		} else if u.Scheme == "http" || u.Scheme == "https" {
+			userStr, maskedUser = scrubMask+"@", true // A lone user is a bearer token
+		} else {
+			userStr = u.User.Username() + "@"
+		}
+	}
+	query, maskedQuery := scrubQuery(u.RawQuery)
+	if !maskedUser && !maskedQuery {
+		return u.String()
+	}
+
+	// Manually construct the string to avoid URL encoding of the mask
+	res := u.Scheme + "://" + userStr + u.Host + u.Path
+	if query != "" {
+		res += "?" + query
+	}
+	return res
+}
+
+func scrubQuery(raw string) (string, bool) {
+	if raw == "" {
+		return raw, false
+	}
+	changed := false
+	pairs := strings.Split(raw, "&")
+	for i, pair := range pairs {
+		key, value, _ := strings.Cut(pair, "=")
+		lower := strings.ToLower(key)
+		if lower == "target" {
+			if v, err := url.QueryUnescape(value); err == nil && Scrub(v) != v {
+				pairs[i], changed = key+"="+Scrub(v), true
 			}
-			res := u.Scheme + "://" + userStr + u.Path
-			if u.RawQuery != "" {
-				res += "?" + u.RawQuery
+			continue
+		}
+		for _, p := range sensitiveParams {
+			if strings.Contains(lower, p) && value != "" {
+				pairs[i], changed = key+"="+scrubMask, true
+				break
 			}
-			return res
 		}
 	}
-	return u.String()
+	return strings.Join(pairs, "&"), changed
+}
+
+// splitTargets splits a comma-separated list of targets. Inside a query
+// string a comma separates values of one parameter (?ciphers=a,b) unless
+// what follows looks like a target of its own: a URI, a path or a
+// shorthand such as s3:bucket.
+func splitTargets(s string) []string {
+	var parts []string
+	for _, piece := range strings.Split(s, ",") {
+		if n := len(parts); n > 0 && strings.Contains(parts[n-1], "?") && !startsTarget(strings.TrimSpace(piece)) {
+			parts[n-1] += "," + piece
+			continue
+		}
+		parts = append(parts, piece)
+	}
+	return parts
+}
+
+func startsTarget(s string) bool {
+	return strings.Contains(s, ":") || strings.HasPrefix(s, "/") || strings.HasPrefix(s, ".") || strings.HasPrefix(s, "~")
+}
+
+// newLocalFromPath opens a local target at path, expanding a leading ~ to
+// the user's home directory and cleaning the result.
+func newLocalFromPath(path string) (Storage, error) {
+	path, err := localPath(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewLocalStorage(path), nil
+}
+
+// localPath resolves a local target path.
+func localPath(path string) (string, error) {
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", apperrors.Wrap(err, apperrors.TypeConfig, "cannot expand ~ in storage path "+path, "Set $HOME or use an absolute path.")
+		}
+		path = filepath.Join(home, path[1:])
+	}
+	if path == "" {
+		return "", nil
+	}
+	return filepath.Clean(path), nil
+}
+
+// StorageInfo is what a backend reports about a stored file.
+type StorageInfo struct {
+	Size    int64
+	ModTime time.Time // Zero when the backend doesn't know it
 }
 
 type Storage interface {
 	Save(ctx context.Context, name string, r io.Reader) (string, error)
 	Open(ctx context.Context, name string) (io.ReadCloser, error)
 	Exists(ctx context.Context, name string) (bool, error)
+	Stat(ctx context.Context, name string) (StorageInfo, error)
 	Delete(ctx context.Context, name string) error
 	Location() string
 	Close() error
@@ -132,3 +265,84 @@ type ChunkedStorage interface {
 	Storage
 	LastChunks() []string
 }
+
+// FileWriter is a file being written to a target. Commit makes it
+// visible under its name and returns its location, as Save does; Abort
+// discards it.
+type FileWriter interface {
+	io.Writer
+	Commit() (string, error)
+	Abort()
+}
+
+// CreateFile opens name on s for writing, so a producer can write a file
+// straight into the target instead of through a pipe to Save. It reports
+// false, and creates nothing, when s can only store files through Save.
+func CreateFile(ctx context.Context, s Storage, name string) (FileWriter, bool, error) {
+	switch v := s.(type) {
+	case *LocalStorage:
+		f, err := v.create(name)
+		if err != nil {
+			return nil, true, err
+		}
+		return f, true, nil
+	case *MemStorage:
+		return &memFile{s: v, name: name}, true, nil
+	case *AuditStorage:
+		f, ok, err := CreateFile(ctx, v.inner, name)
+		if !ok {
+			return nil, false, nil
+		}
+		if err != nil {
+			v.log(ctx, "SAVE", name, "error: "+err.Error(), "")
+			return nil, true, err
+		}
+		return &auditFile{FileWriter: f, ctx: ctx, s: v, name: name}, true, nil
+	}
+	return nil, false, nil
+}
+
+// PutMetadataExclusive writes the metadata file name unless it already
+// exists, in which case the error matches fs.ErrExist. It reports false, and
+// writes nothing, when s can't create files atomically.
+func PutMetadataExclusive(ctx context.Context, s Storage, name string, data []byte) (bool, error) {
+	switch v := s.(type) {
+	case *LocalStorage:
+		return true, v.putMetadataExclusive(name, data)
+	case *DedupeStorage:
+		return PutMetadataExclusive(ctx, v.inner, name, data)
+	case *AuditStorage:
+		return PutMetadataExclusive(ctx, v.inner, name, data)
+	}
+	return false, nil
+}
+
+// putAtomic writes data to path through a temporary sibling and a rename, so
+// a crash mid-write never leaves a truncated manifest (notably
+// latest.manifest, which auto-restore depends on) where readers look.
+func putAtomic(path string, data []byte, write func(path string, r io.Reader) error, rename func(from, to string) error, remove func(path string) error) error {
+	return saveAtomic(path, bytes.NewReader(data), write, rename, remove)
+}
+
+// saveAtomic is putAtomic for a stream. Each call writes its own temporary
+// file, so concurrent writers of the same path (two backups uploading the
+// same new chunk) never interleave; the last rename wins with a whole file.
+func saveAtomic(path string, r io.Reader, write func(path string, r io.Reader) error, rename func(from, to string) error, remove func(path string) error) error {
+	tmp := tempName(path)
+	if err := write(tmp, r); err != nil {
+		remove(tmp) // #nosec G104
+		return err
+	}
+	if err := rename(tmp, path); err != nil {
+		remove(tmp) // #nosec G104
+		return err
+	}
+	return nil
+}
+
+// tempName returns a unique temporary sibling of path.
+func tempName(path string) string {
+	var b [8]byte
+	rand.Read(b[:]) // #nosec G104 -- never fails
+	return path + ".tmp-" + hex.EncodeToString(b[:])
+}
@@ -5,33 +5,26 @@ import (
 	"errors"
 	"fmt"
 	"os"
-	"os/signal"
-	"syscall"
 
 	"github.com/lupppig/dbackup/cmd"
 	apperrors "github.com/lupppig/dbackup/internal/errors"
 	"github.com/lupppig/dbackup/internal/logger"
 )
 
-const (
-	EXIT_SUCCESS = iota
-	EXIT_FAILURE
-)
-
 func main() {
-	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	ctx, stop := cmd.NewContext(context.Background())
 	defer stop()
 
 	if err := cmd.ExecuteContext(ctx); err != nil {
-		exitOnError(err)
+		stop()
+		os.Exit(exitOnError(err))
 	}
-
-	os.Exit(EXIT_SUCCESS)
 }
 
-func exitOnError(err error) {
+// exitOnError reports err and returns the exit code for its type.
+func exitOnError(err error) int {
 	if err == nil {
-		return
+		return apperrors.ExitSuccess
 	}
 
 	l := logger.New(logger.Config{})
@@ -46,5 +39,5 @@ func exitOnError(err error) {
 		l.Error("Command failed", "error", err)
 	}
 
-	os.Exit(EXIT_FAILURE)
+	return apperrors.ExitCode(err)
 }
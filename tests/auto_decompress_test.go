@@ -1,6 +1,7 @@
 package tests
 
 import (
+	"compress/gzip"
 	"context"
 	"io"
 	"os"
@@ -72,3 +73,29 @@ func TestAutoDecompression(t *testing.T) {
 
 	assert.Equal(t, rawData, mock.RestoredData, "Restored data should match raw data after auto-decompression")
 }
+
+func TestAutoDecompression_ConcatenatedGzip(t *testing.T) {
+	tempDir := t.TempDir()
+
+	// Two gzip members in one file, as left by `cat part1.gz part2.gz`
+	f, err := os.Create(filepath.Join(tempDir, "joined.sql.gz"))
+	require.NoError(t, err)
+	for _, part := range []string{"CREATE TABLE users (id int);\n", "INSERT INTO users VALUES (1);\n"} {
+		gz := gzip.NewWriter(f)
+		_, err = gz.Write([]byte(part))
+		require.NoError(t, err)
+		require.NoError(t, gz.Close())
+	}
+	require.NoError(t, f.Close())
+
+	mock := &MockAdapter{}
+	rmgr, err := backup.NewRestoreManager(backup.BackupOptions{
+		StorageURI:     "local://" + tempDir,
+		FileName:       "joined.sql.gz",
+		ConfirmRestore: true,
+	})
+	require.NoError(t, err)
+	require.NoError(t, rmgr.Run(context.Background(), mock, db.ConnectionParams{DBType: "mock"}))
+
+	assert.Equal(t, "CREATE TABLE users (id int);\nINSERT INTO users VALUES (1);\n", string(mock.RestoredData))
+}
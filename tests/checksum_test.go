@@ -0,0 +1,70 @@
+package tests
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lupppig/dbackup/internal/backup"
+	"github.com/lupppig/dbackup/internal/db"
+	"github.com/lupppig/dbackup/internal/logger"
+	"github.com/lupppig/dbackup/internal/manifest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChecksumAlgorithms_RoundTrip(t *testing.T) {
+	rawData := []byte("CREATE TABLE audit (id int); INSERT INTO audit VALUES (42);")
+
+	for _, algo := range []string{manifest.ChecksumSHA256, manifest.ChecksumSHA512, manifest.ChecksumBlake3} {
+		t.Run(algo, func(t *testing.T) {
+			tempDir := t.TempDir()
+
+			opts := backup.BackupOptions{
+				StorageURI:     "local://" + tempDir,
+				FileName:       "checksum.sql",
+				ChecksumAlgo:   algo,
+				ConfirmRestore: true,
+				Logger:         logger.New(logger.Config{}),
+			}
+
+			mgr, err := backup.NewBackupManager(opts)
+			require.NoError(t, err)
+			err = mgr.Run(context.Background(), &DummyBackupAdapter{Data: rawData}, db.ConnectionParams{DBType: "mock"})
+			require.NoError(t, err)
+
+			manBytes, err := os.ReadFile(filepath.Join(tempDir, "checksum.sql.manifest"))
+			require.NoError(t, err)
+			man, err := manifest.Deserialize(manBytes)
+			require.NoError(t, err)
+			assert.Equal(t, algo, man.ChecksumAlgo)
+
+			rmgr, err := backup.NewRestoreManager(opts)
+			require.NoError(t, err)
+			restored := &MockAdapter{}
+			err = rmgr.Run(context.Background(), restored, db.ConnectionParams{DBType: "mock"})
+			require.NoError(t, err)
+			assert.Equal(t, rawData, restored.RestoredData)
+
+			// Tampering with the blob must be caught by the recorded algorithm
+			err = os.WriteFile(filepath.Join(tempDir, "checksum.sql"), []byte("tampered"), 0644)
+			require.NoError(t, err)
+			err = rmgr.Run(context.Background(), &MockAdapter{}, db.ConnectionParams{DBType: "mock"})
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestChecksumAlgorithms_Invalid(t *testing.T) {
+	mgr, err := backup.NewBackupManager(backup.BackupOptions{
+		StorageURI:   "local://" + t.TempDir(),
+		FileName:     "bad.sql",
+		ChecksumAlgo: "md5",
+		Logger:       logger.New(logger.Config{}),
+	})
+	require.NoError(t, err)
+
+	err = mgr.Run(context.Background(), &DummyBackupAdapter{Data: []byte("x")}, db.ConnectionParams{DBType: "mock"})
+	assert.Error(t, err)
+}
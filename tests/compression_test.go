@@ -1,12 +1,17 @@
 package tests
 
 import (
+	"bytes"
+	"context"
 	"io"
 	"os"
 	"path/filepath"
 	"testing"
 
+	"github.com/lupppig/dbackup/internal/backup"
 	"github.com/lupppig/dbackup/internal/compress"
+	"github.com/lupppig/dbackup/internal/db"
+	"github.com/lupppig/dbackup/internal/notify"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -92,3 +97,29 @@ func TestCompressionIntegration(t *testing.T) {
 		assert.NotEqual(t, testData, content)
 	})
 }
+
+type statsRecorder struct{ got notify.Stats }
+
+func (s *statsRecorder) Notify(ctx context.Context, stats notify.Stats) error {
+	s.got = stats
+	return nil
+}
+
+func TestBackupReportsCompressionStats(t *testing.T) {
+	rec := &statsRecorder{}
+	mgr, err := backup.NewBackupManager(backup.BackupOptions{
+		StorageURI: "local://" + t.TempDir(),
+		Compress:   true,
+		Algorithm:  "gzip",
+		Notifier:   rec,
+	})
+	require.NoError(t, err)
+
+	data := bytes.Repeat([]byte("INSERT INTO t VALUES (1);\n"), 4096)
+	require.NoError(t, mgr.Run(context.Background(), &DummyBackupAdapter{Data: data}, db.ConnectionParams{DBType: "mock", DBName: "stats"}))
+
+	assert.Equal(t, int64(len(data)), rec.got.LogicalSize)
+	assert.Greater(t, rec.got.Size, int64(0))
+	assert.Greater(t, rec.got.Ratio(), 10.0, "repetitive dump should compress well")
+	assert.Greater(t, rec.got.Throughput(), 0.0)
+}
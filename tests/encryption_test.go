@@ -8,8 +8,10 @@ import (
 	"testing"
 
 	"github.com/lupppig/dbackup/internal/backup"
+	"github.com/lupppig/dbackup/internal/crypto"
 	"github.com/lupppig/dbackup/internal/db"
 	"github.com/lupppig/dbackup/internal/logger"
+	"github.com/lupppig/dbackup/internal/manifest"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -57,6 +59,80 @@ func TestEncryptedBackupAndRestore(t *testing.T) {
 	assert.Equal(t, rawData, restoredMock.RestoredData, "Restored data should match raw data")
 }
 
+func TestEncryptedBackup_KDF(t *testing.T) {
+	rawData := []byte("CREATE TABLE users (id int);")
+	for _, kdf := range []string{"scrypt", "argon2id"} {
+		t.Run(kdf, func(t *testing.T) {
+			tempDir := t.TempDir()
+			opts := backup.BackupOptions{
+				StorageURI:           "local://" + tempDir,
+				FileName:             "encrypted.sql",
+				Encrypt:              true,
+				EncryptionPassphrase: "test-secret-key",
+				KDF:                  kdf,
+				ConfirmRestore:       true,
+				Logger:               logger.New(logger.Config{}),
+			}
+			mgr, err := backup.NewBackupManager(opts)
+			require.NoError(t, err)
+			require.NoError(t, mgr.Run(context.Background(), &DummyBackupAdapter{Data: rawData}, db.ConnectionParams{DBType: "mock"}))
+
+			// The restore needs no KDF settings; the header records them
+			ropts := opts
+			ropts.KDF = ""
+			rmgr, err := backup.NewRestoreManager(ropts)
+			require.NoError(t, err)
+			restored := &MockAdapter{}
+			require.NoError(t, rmgr.Run(context.Background(), restored, db.ConnectionParams{DBType: "mock"}))
+			assert.Equal(t, rawData, restored.RestoredData)
+		})
+	}
+
+	_, err := backup.NewBackupManager(backup.BackupOptions{StorageURI: "local://" + t.TempDir(), KDF: "bcrypt"})
+	assert.ErrorContains(t, err, "invalid key derivation settings")
+}
+
+func TestEncryptedChunksBackupAndRestore(t *testing.T) {
+	tempDir := t.TempDir()
+	rawData := []byte("CREATE TABLE users (id int); INSERT INTO users VALUES (1);")
+
+	opts := backup.BackupOptions{
+		StorageURI:           "local://" + tempDir,
+		FileName:             "chunked.sql",
+		Dedupe:               true,
+		Encrypt:              true,
+		EncryptChunks:        true,
+		EncryptionPassphrase: "test-secret-key",
+		ConfirmRestore:       true,
+		Logger:               logger.New(logger.Config{}),
+	}
+
+	mgr, err := backup.NewBackupManager(opts)
+	require.NoError(t, err)
+	err = mgr.Run(context.Background(), &DummyBackupAdapter{Data: rawData}, db.ConnectionParams{DBType: "mock"})
+	require.NoError(t, err)
+
+	manBytes, err := os.ReadFile(filepath.Join(tempDir, "chunked.sql.manifest"))
+	require.NoError(t, err)
+	man, err := manifest.Deserialize(manBytes)
+	require.NoError(t, err)
+	assert.Equal(t, crypto.ConvergentScheme, man.ChunkEncryption)
+	assert.Equal(t, "none", man.Encryption, "stream must not be encrypted twice")
+
+	chunk, err := os.ReadFile(filepath.Join(tempDir, "chunks", man.Chunks[0]))
+	require.NoError(t, err)
+	assert.NotContains(t, string(chunk), "CREATE TABLE", "chunks should be encrypted at rest")
+
+	restoreOpts := opts
+	restoreOpts.EncryptChunks = false // restores follow the manifest
+	rmgr, err := backup.NewRestoreManager(restoreOpts)
+	require.NoError(t, err)
+	restored := &MockAdapter{}
+	err = rmgr.Run(context.Background(), restored, db.ConnectionParams{DBType: "mock"})
+	require.NoError(t, err)
+	assert.Equal(t, rawData, restored.RestoredData)
+}
+
 type DummyBackupAdapter struct {
 	db.DBAdapter
 	Data []byte
@@ -68,3 +144,6 @@ func (d *DummyBackupAdapter) RunBackup(ctx context.Context, conn db.ConnectionPa
 	return err
 }
 func (d *DummyBackupAdapter) SetLogger(l *logger.Logger) {}
+func (d *DummyBackupAdapter) ChangeSignal(ctx context.Context, conn db.ConnectionParams) (string, error) {
+	return "", nil
+}
@@ -0,0 +1,90 @@
+package tests
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lupppig/dbackup/internal/backup"
+	"github.com/lupppig/dbackup/internal/db"
+	apperrors "github.com/lupppig/dbackup/internal/errors"
+	"github.com/lupppig/dbackup/internal/logger"
+	"github.com/lupppig/dbackup/internal/manifest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLatestPerDatabase(t *testing.T) {
+	tempDir := t.TempDir()
+	ctx := context.Background()
+	opts := backup.BackupOptions{
+		StorageURI: "local://" + tempDir,
+		Logger:     logger.New(logger.Config{}),
+	}
+
+	dumps := map[string][]byte{
+		"alpha": []byte("CREATE TABLE alpha (id int);"),
+		"beta":  []byte("CREATE TABLE beta (id int);"),
+	}
+	for _, name := range []string{"alpha", "beta"} {
+		mgr, err := backup.NewBackupManager(opts)
+		require.NoError(t, err)
+		require.NoError(t, mgr.Run(ctx, &DummyBackupAdapter{Data: dumps[name]}, db.ConnectionParams{DBType: "mock", DBName: name}))
+	}
+	assert.FileExists(t, filepath.Join(tempDir, "latest-mock-alpha.manifest"))
+	assert.FileExists(t, filepath.Join(tempDir, "latest-mock-beta.manifest"))
+	assert.FileExists(t, filepath.Join(tempDir, "latest.manifest"))
+
+	// The pointers name the backups' manifests rather than copying them
+	data, err := os.ReadFile(filepath.Join(tempDir, "latest.manifest"))
+	require.NoError(t, err)
+	target, ok := manifest.ParsePointer(data)
+	require.True(t, ok)
+	data, err = os.ReadFile(filepath.Join(tempDir, target))
+	require.NoError(t, err)
+	man, err := manifest.Deserialize(data)
+	require.NoError(t, err)
+	host, _ := os.Hostname()
+	assert.Equal(t, host+":"+tempDir, man.Origin)
+
+	// Each database resolves its own latest even though beta was written last
+	for _, name := range []string{"alpha", "beta"} {
+		ropts := opts
+		ropts.ConfirmRestore = true
+		rmgr, err := backup.NewRestoreManager(ropts)
+		require.NoError(t, err)
+		adapter := &MockAdapter{}
+		require.NoError(t, rmgr.Run(ctx, adapter, db.ConnectionParams{DBType: "mock", DBName: name}))
+		assert.Equal(t, dumps[name], adapter.RestoredData, name)
+	}
+
+	// Without a per-database pointer the target-wide latest.manifest is
+	// not used for another database's backup
+	require.NoError(t, os.Remove(filepath.Join(tempDir, "latest-mock-alpha.manifest")))
+	ropts := opts
+	ropts.ConfirmRestore = true
+	rmgr, err := backup.NewRestoreManager(ropts)
+	require.NoError(t, err)
+	adapter := &MockAdapter{}
+	err = rmgr.Run(ctx, adapter, db.ConnectionParams{DBType: "mock", DBName: "alpha"})
+	assert.True(t, apperrors.IsType(err, apperrors.TypeConfig), "got %v", err)
+	assert.Nil(t, adapter.RestoredData)
+
+	// Pointers written by older versions are full copies of the manifest
+	ptr, err := os.ReadFile(filepath.Join(tempDir, "latest-mock-beta.manifest"))
+	require.NoError(t, err)
+	target, _ = manifest.ParsePointer(ptr)
+	data, err = os.ReadFile(filepath.Join(tempDir, target))
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(tempDir, "latest-mock-beta.manifest"), data, 0644))
+	adapter = &MockAdapter{}
+	require.NoError(t, rmgr.Run(ctx, adapter, db.ConnectionParams{DBType: "mock", DBName: "beta"}))
+	assert.Equal(t, dumps["beta"], adapter.RestoredData)
+
+	// ... but is for its own database's
+	require.NoError(t, os.Remove(filepath.Join(tempDir, "latest-mock-beta.manifest")))
+	adapter = &MockAdapter{}
+	require.NoError(t, rmgr.Run(ctx, adapter, db.ConnectionParams{DBType: "mock", DBName: "beta"}))
+	assert.Equal(t, dumps["beta"], adapter.RestoredData)
+}
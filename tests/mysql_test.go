@@ -126,7 +126,7 @@ wMl9
 				DBName: "db",
 				TLS:    db.TLSConfig{Enabled: true},
 			},
-			wantDSN: "user:@tcp(localhost:3306)/db?tls=true",
+			wantDSN: "user:@tcp(localhost:3306)/db?tls=dbackup_",
 		},
 		{
 			name: "TLS with CA Cert",
@@ -139,7 +139,7 @@ wMl9
 					CACert:  caFile,
 				},
 			},
-			wantDSN: "user:@tcp(localhost:3306)/db?tls=custom_true_false",
+			wantDSN: "user:@tcp(localhost:3306)/db?tls=dbackup_",
 		},
 		{
 			name: "TLS with Missing CA Cert",
@@ -165,7 +165,7 @@ wMl9
 					Mode:    "skip-verify",
 				},
 			},
-			wantDSN: "user:@tcp(localhost:3306)/db?tls=custom_false_false",
+			wantDSN: "user:@tcp(localhost:3306)/db?tls=dbackup_",
 		},
 	}
 
@@ -176,7 +176,8 @@ wMl9
 				assert.Error(t, err)
 			} else {
 				assert.NoError(t, err)
-				assert.Equal(t, tt.wantDSN, got)
+				// Every TLS mode gets its own registered config
+				assert.True(t, strings.HasPrefix(got, tt.wantDSN), got)
 			}
 		})
 	}
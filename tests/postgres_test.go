@@ -1,10 +1,12 @@
 package tests
 
 import (
+	"bytes"
 	"context"
 	"log/slog"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -151,4 +153,26 @@ func TestPostgresIntegration(t *testing.T) {
 		assert.Contains(t, string(content), "PostgreSQL database dump")
 	})
 
+	t.Run("RestoreIntoNewDB", func(t *testing.T) {
+		seed := "CREATE TABLE items (id int); INSERT INTO items VALUES (1), (2);"
+		require.NoError(t, pa.RunRestore(ctx, connParams, &db.LocalRunner{}, strings.NewReader(seed)))
+
+		// --create makes the dump switch to its own database by name
+		var dump bytes.Buffer
+		withCreate := connParams
+		withCreate.ExtraArgs = []string{"--create"}
+		require.NoError(t, pa.RunBackup(ctx, withCreate, &db.LocalRunner{}, &dump))
+		assert.Contains(t, dump.String(), `\connect`)
+
+		into := connParams
+		into.IntoNewDB = "staging"
+		into.PostRestoreCheck = "SELECT count(*) FROM items"
+		into.PostRestoreExpect = "2"
+		require.NoError(t, pa.RunRestore(ctx, into, &db.LocalRunner{}, &dump))
+
+		// Restoring again reuses the database created the first time
+		into.OnConflict = db.ConflictClean
+		require.NoError(t, pa.RunBackup(ctx, withCreate, &db.LocalRunner{}, &dump))
+		require.NoError(t, pa.RunRestore(ctx, into, &db.LocalRunner{}, &dump))
+	})
 }
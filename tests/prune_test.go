@@ -0,0 +1,55 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/lupppig/dbackup/internal/backup"
+	"github.com/lupppig/dbackup/internal/db"
+	"github.com/lupppig/dbackup/internal/logger"
+	"github.com/lupppig/dbackup/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetentionPruneAndChunkGC(t *testing.T) {
+	uri := "mem://" + t.Name()
+	ctx := context.Background()
+	conn := db.ConnectionParams{DBType: "mock", DBName: "app"}
+
+	var pruned []string
+	for i := 0; i < 3; i++ {
+		mgr, err := backup.NewBackupManager(backup.BackupOptions{
+			StorageURI: uri,
+			FileName:   fmt.Sprintf("app-%d.sql", i),
+			Dedupe:     true,
+			Keep:       1,
+			Logger:     logger.New(logger.Config{}),
+		})
+		require.NoError(t, err)
+		data := []byte(fmt.Sprintf("CREATE TABLE app_%d (id int);", i))
+		require.NoError(t, mgr.Run(ctx, &DummyBackupAdapter{Data: data}, conn))
+		pruned = append(pruned, mgr.Pruned()...)
+	}
+	assert.ElementsMatch(t, []string{"app-0.sql", "app-1.sql"}, pruned)
+
+	ds := storage.NewDedupeStorage(storage.NewMemStorage(t.Name()))
+	_, err := ds.GC(ctx)
+	require.NoError(t, err)
+	missing, err := ds.Verify(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, missing)
+
+	// The surviving backup is intact after the sweep
+	rmgr, err := backup.NewRestoreManager(backup.BackupOptions{
+		StorageURI:     uri,
+		Dedupe:         true,
+		ConfirmRestore: true,
+		Logger:         logger.New(logger.Config{}),
+	})
+	require.NoError(t, err)
+	adapter := &MockAdapter{}
+	require.NoError(t, rmgr.Run(ctx, adapter, conn))
+	assert.Equal(t, "CREATE TABLE app_2 (id int);", string(adapter.RestoredData))
+}
@@ -0,0 +1,59 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lupppig/dbackup/internal/backup"
+	"github.com/lupppig/dbackup/internal/db"
+	apperrors "github.com/lupppig/dbackup/internal/errors"
+	"github.com/lupppig/dbackup/internal/logger"
+	"github.com/lupppig/dbackup/internal/manifest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFreeSpacePreflight(t *testing.T) {
+	tempDir := t.TempDir()
+	ctx := context.Background()
+	conn := db.ConnectionParams{DBType: "mock", DBName: "huge"}
+
+	// A previous backup far larger than any disk makes the next one fail fast
+	man := manifest.New("prev", "mock", "none", "none")
+	man.DBName = "huge"
+	man.FileName = "prev.sql"
+	man.Size = 1 << 62
+	data, err := man.Serialize()
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(tempDir, manifest.LatestNameFor("mock", "huge")), data, 0644))
+
+	opts := backup.BackupOptions{
+		StorageURI: "local://" + tempDir,
+		FileName:   "next.sql",
+		Logger:     logger.New(logger.Config{}),
+	}
+	mgr, err := backup.NewBackupManager(opts)
+	require.NoError(t, err)
+	err = mgr.Run(ctx, &DummyBackupAdapter{Data: []byte("SELECT 1;")}, conn)
+	var appErr *apperrors.AppError
+	require.True(t, errors.As(err, &appErr), "got %v", err)
+	assert.Equal(t, apperrors.TypeResource, appErr.Type)
+	assert.NoFileExists(t, filepath.Join(tempDir, "next.sql"))
+
+	// The restore workspace is checked the same way
+	opts.ConfirmRestore = true
+	opts.FileName = ""
+	rmgr, err := backup.NewRestoreManager(opts)
+	require.NoError(t, err)
+	err = rmgr.Run(ctx, &MockAdapter{}, conn)
+	require.True(t, errors.As(err, &appErr), "got %v", err)
+	assert.Equal(t, apperrors.TypeResource, appErr.Type)
+
+	// Unknown sizes skip the check
+	mgr, err = backup.NewBackupManager(opts)
+	require.NoError(t, err)
+	require.NoError(t, mgr.Run(ctx, &DummyBackupAdapter{Data: []byte("SELECT 1;")}, db.ConnectionParams{DBType: "mock", DBName: "fresh"}))
+}
@@ -33,9 +33,10 @@ func TestSqliteAdapter_BuildConnection(t *testing.T) {
 }
 
 func TestSqliteIntegration(t *testing.T) {
-	tempDir, err := os.MkdirTemp("", "dbackup-sqlite-test-*")
-	require.NoError(t, err)
-	defer os.RemoveAll(tempDir)
+	// Run inside the temp dir, so nothing SQLite creates from a relative or
+	// empty path lands in the repository
+	tempDir := t.TempDir()
+	t.Chdir(tempDir)
 
 	dbPath := filepath.Join(tempDir, "source.db")
 
@@ -0,0 +1,89 @@
+package tests
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lupppig/dbackup/internal/backup"
+	"github.com/lupppig/dbackup/internal/db"
+	"github.com/lupppig/dbackup/internal/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRestoreVerifyOnly(t *testing.T) {
+	tempDir := t.TempDir()
+	rawData := []byte("CREATE TABLE drill (id int); INSERT INTO drill VALUES (7);")
+
+	opts := backup.BackupOptions{
+		StorageURI:           "local://" + tempDir,
+		FileName:             "drill.sql",
+		Compress:             true,
+		Algorithm:            "zstd",
+		Encrypt:              true,
+		EncryptionPassphrase: "drill-secret",
+		Logger:               logger.New(logger.Config{}),
+	}
+
+	mgr, err := backup.NewBackupManager(opts)
+	require.NoError(t, err)
+	err = mgr.Run(context.Background(), &DummyBackupAdapter{Data: rawData}, db.ConnectionParams{DBType: "mock"})
+	require.NoError(t, err)
+
+	// No --confirm-restore needed, and nothing reaches the adapter
+	verifyOpts := opts
+	verifyOpts.VerifyOnly = true
+	verifyOpts.FileName = "drill.sql.zst"
+	rmgr, err := backup.NewRestoreManager(verifyOpts)
+	require.NoError(t, err)
+	adapter := &MockAdapter{}
+	err = rmgr.Run(context.Background(), adapter, db.ConnectionParams{DBType: "mock"})
+	require.NoError(t, err)
+	assert.Empty(t, adapter.RestoredData)
+
+	// A wrong key is caught even though nothing is applied
+	verifyOpts.EncryptionPassphrase = "wrong"
+	rmgr, err = backup.NewRestoreManager(verifyOpts)
+	require.NoError(t, err)
+	err = rmgr.Run(context.Background(), &MockAdapter{}, db.ConnectionParams{DBType: "mock"})
+	assert.Error(t, err)
+
+	// So is a corrupted blob
+	require.NoError(t, os.WriteFile(filepath.Join(tempDir, "drill.sql.zst"), []byte("garbage"), 0644))
+	verifyOpts.EncryptionPassphrase = "drill-secret"
+	rmgr, err = backup.NewRestoreManager(verifyOpts)
+	require.NoError(t, err)
+	err = rmgr.Run(context.Background(), &MockAdapter{}, db.ConnectionParams{DBType: "mock"})
+	assert.Error(t, err)
+}
+
+func TestRestoreUsesTmpDir(t *testing.T) {
+	tempDir := t.TempDir()
+	opts := backup.BackupOptions{
+		StorageURI: "local://" + tempDir,
+		FileName:   "tmp.sql",
+		Logger:     logger.New(logger.Config{}),
+	}
+	mgr, err := backup.NewBackupManager(opts)
+	require.NoError(t, err)
+	require.NoError(t, mgr.Run(context.Background(), &DummyBackupAdapter{Data: []byte("SELECT 1;")}, db.ConnectionParams{DBType: "mock"}))
+
+	// The workspace goes where --tmp-dir says, not to $TMPDIR
+	opts.VerifyOnly = true
+	opts.TmpDir = filepath.Join(tempDir, "missing")
+	rmgr, err := backup.NewRestoreManager(opts)
+	require.NoError(t, err)
+	err = rmgr.Run(context.Background(), &MockAdapter{}, db.ConnectionParams{DBType: "mock"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "temporary workspace")
+
+	require.NoError(t, os.Mkdir(opts.TmpDir, 0755))
+	rmgr, err = backup.NewRestoreManager(opts)
+	require.NoError(t, err)
+	require.NoError(t, rmgr.Run(context.Background(), &MockAdapter{}, db.ConnectionParams{DBType: "mock"}))
+	entries, err := os.ReadDir(opts.TmpDir)
+	require.NoError(t, err)
+	assert.Empty(t, entries, "workspace is cleaned up")
+}
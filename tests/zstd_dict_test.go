@@ -0,0 +1,75 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lupppig/dbackup/internal/backup"
+	"github.com/lupppig/dbackup/internal/compress"
+	"github.com/lupppig/dbackup/internal/db"
+	"github.com/lupppig/dbackup/internal/logger"
+	"github.com/lupppig/dbackup/internal/manifest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestZstdDictBackupAndRestore(t *testing.T) {
+	tempDir := t.TempDir()
+	ctx := context.Background()
+	conn := db.ConnectionParams{DBType: "mock", DBName: "metrics"}
+
+	dump := func(hour int) []byte {
+		var b bytes.Buffer
+		for i := 0; i < 500; i++ {
+			fmt.Fprintf(&b, "INSERT INTO metrics VALUES (%d, 'web-%02d', '2024-01-01 %02d:00');\n", i, i%8, hour)
+		}
+		return b.Bytes()
+	}
+
+	opts := backup.BackupOptions{
+		StorageURI: "local://" + tempDir,
+		Compress:   true,
+		Algorithm:  "zstd",
+		ZstdDict:   true,
+		Logger:     logger.New(logger.Config{}),
+	}
+
+	// The first backup has no dictionary yet and trains one
+	opts.FileName = "h1.sql"
+	mgr, err := backup.NewBackupManager(opts)
+	require.NoError(t, err)
+	require.NoError(t, mgr.Run(ctx, &DummyBackupAdapter{Data: dump(1)}, conn))
+	require.FileExists(t, filepath.Join(tempDir, compress.DictFile))
+
+	opts.FileName = "h2.sql"
+	mgr, err = backup.NewBackupManager(opts)
+	require.NoError(t, err)
+	require.NoError(t, mgr.Run(ctx, &DummyBackupAdapter{Data: dump(2)}, conn))
+
+	data, err := os.ReadFile(filepath.Join(tempDir, "h2.sql.zst.manifest"))
+	require.NoError(t, err)
+	man, err := manifest.Deserialize(data)
+	require.NoError(t, err)
+	assert.NotZero(t, man.CompressionDict)
+
+	ropts := opts
+	ropts.ConfirmRestore = true
+	ropts.FileName = "h2.sql.zst"
+	rmgr, err := backup.NewRestoreManager(ropts)
+	require.NoError(t, err)
+	adapter := &MockAdapter{}
+	require.NoError(t, rmgr.Run(ctx, adapter, conn))
+	assert.Equal(t, dump(2), adapter.RestoredData)
+
+	// Without the dictionary the restore fails clearly
+	require.NoError(t, os.Remove(filepath.Join(tempDir, compress.DictFile)))
+	rmgr, err = backup.NewRestoreManager(ropts)
+	require.NoError(t, err)
+	err = rmgr.Run(ctx, &MockAdapter{}, conn)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "dictionary")
+}